@@ -90,6 +90,9 @@ Flatten Settings Map using "." syntax
     {{- if not (kindIs "invalid" $val) -}}
       {{- $sublabel | quote | nindent 2 }}: {{ $val | toJson | quote }}
     {{- end -}}
+  {{/* Lists are joined into comma separated strings */}}
+  {{- else if kindIs "slice" $val -}}
+    {{- $sublabel | quote | nindent 2 }}: {{ $val | join "," | quote }}
   {{- else if kindOf $val | eq "map" -}}
     {{- list $val $sublabel | include "flattenSettings" -}}
   {{- else -}}
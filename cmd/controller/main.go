@@ -43,6 +43,7 @@ func main() {
 	})
 	awsCloudProvider := cloudprovider.New(awsCtx)
 	lo.Must0(operator.AddHealthzCheck("cloud-provider", awsCloudProvider.LivenessProbe))
+	lo.Must0(operator.AddReadyzCheck("cloud-provider", awsCloudProvider.ReadinessProbe))
 	cloudProvider := metrics.Decorate(awsCloudProvider)
 
 	operator.
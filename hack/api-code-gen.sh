@@ -18,6 +18,23 @@ pricing() {
   checkForUpdates "${GIT_DIFF}" "${NO_UPDATE}" "${SUBJECT} beside timestamps since last update" "${GENERATED_FILE}"
 }
 
+# The prices of the China regions can only be retrieved with the credentials of the aws-cn partition, which are read
+# from the AWS_CN_PROFILE profile
+pricingCN() {
+  if [ -z ${AWS_CN_PROFILE+x} ];then
+    echo "Skipping China pricing, AWS_CN_PROFILE isn't set"
+    return
+  fi
+  GENERATED_FILE="pkg/cloudprovider/zz_generated.pricing_aws_cn.go"
+  NO_UPDATE=$' pkg/cloudprovider/zz_generated.pricing_aws_cn.go | 4 ++--\n 1 file changed, 2 insertions(+), 2 deletions(-)'
+  SUBJECT="China Pricing"
+
+  AWS_PROFILE="${AWS_CN_PROFILE}" go run hack/code/prices_gen.go -region cn-northwest-1 -- "${GENERATED_FILE}"
+
+  GIT_DIFF=$(git diff --stat "${GENERATED_FILE}")
+  checkForUpdates "${GIT_DIFF}" "${NO_UPDATE}" "${SUBJECT} beside timestamps since last update" "${GENERATED_FILE}"
+}
+
 vpcLimits() {
   GENERATED_FILE="pkg/cloudprovider/zz_generated.vpclimits.go"
   NO_UPDATE=''
@@ -83,5 +100,6 @@ if [[ $ENABLE_GIT_PUSH == true ]]; then
 fi
 
 pricing
+pricingCN
 vpcLimits
 instanceTypeTestData
\ No newline at end of file
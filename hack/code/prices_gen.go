@@ -17,17 +17,20 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"go/format"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	ec22 "github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/samber/lo"
@@ -35,10 +38,20 @@ import (
 	awscloudprovider "github.com/aws/karpenter/pkg/cloudprovider"
 )
 
+// main generates the compiled in prices of the partition of a region, or a price file of any region that's loaded in
+// isolated VPCs when the output is a .json file, e.g.
+//
+//	go run hack/code/prices_gen.go -- pkg/cloudprovider/zz_generated.pricing.go
+//	go run hack/code/prices_gen.go -region cn-north-1 -- pkg/cloudprovider/zz_generated.pricing_aws_cn.go
+//	go run hack/code/prices_gen.go -region eu-west-1 -- prices.json
+//
+// Prices can only be retrieved with the credentials of the partition, and partitions without a pricing endpoint, such
+// as GovCloud, use the compiled in prices of the aws partition.
 func main() {
+	region := flag.String("region", "us-east-1", "the region to retrieve prices for")
 	flag.Parse()
 	if flag.NArg() != 1 {
-		log.Fatalf("Usage: %s pkg/cloudprovider/zz_generated.pricing.go", os.Args[0])
+		log.Fatalf("Usage: %s [-region us-east-1] pkg/cloudprovider/zz_generated.pricing[_<partition>].go|prices.json", os.Args[0])
 	}
 
 	f, err := os.Create("pricing.heapprofile")
@@ -47,14 +60,17 @@ func main() {
 	}
 	defer f.Close() // error handling omitted for example
 
-	const region = "us-east-1"
 	os.Setenv("AWS_SDK_LOAD_CONFIG", "true")
-	os.Setenv("AWS_REGION", region)
+	os.Setenv("AWS_REGION", *region)
 	ctx := context.Background()
 	sess := session.Must(session.NewSession())
 	ec2 := ec22.New(sess)
+	pricingAPI := awscloudprovider.NewPricingAPI(sess, *region)
+	if pricingAPI == nil {
+		log.Fatalf("no pricing endpoint for region %s", *region)
+	}
 	updateStarted := time.Now()
-	pricingProvider := awscloudprovider.NewPricingProvider(ctx, awscloudprovider.NewPricingAPI(sess, region), ec2, region, false, make(chan struct{}))
+	pricingProvider := awscloudprovider.NewPricingProvider(ctx, pricingAPI, ec2, *region, false, awscloudprovider.PricingOptions{}, make(chan struct{}))
 
 	for {
 		if pricingProvider.OnDemandLastUpdated().After(updateStarted) && pricingProvider.SpotLastUpdated().After(updateStarted) {
@@ -64,6 +80,38 @@ func main() {
 		time.Sleep(1 * time.Second)
 	}
 
+	if filepath.Ext(flag.Arg(0)) == ".json" {
+		writePriceFile(flag.Arg(0), pricingProvider.PriceFile())
+	} else {
+		writeGoSource(flag.Arg(0), *region, pricingProvider)
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		log.Fatal("could not write memory profile: ", err)
+	}
+}
+
+// writePriceFile writes the on-demand and spot prices as a JSON price file
+func writePriceFile(path string, priceFile *awscloudprovider.PriceFile) {
+	data, err := json.MarshalIndent(priceFile, "", "  ")
+	if err != nil {
+		log.Fatalf("encoding price file, %s", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Fatalf("writing output, %s", err)
+	}
+}
+
+// writeGoSource writes the on-demand prices as the Go source of the compiled in prices of the partition of the region
+func writeGoSource(path string, region string, pricingProvider *awscloudprovider.PricingProvider) {
+	partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+	if !ok {
+		log.Fatalf("unknown partition of region %s", region)
+	}
+	// the prices of the aws partition keep their unsuffixed names, e.g. initialOnDemandPrices and initialOnDemandPricesCN
+	suffix := strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(partition.ID(), endpoints.AwsPartitionID), "-", ""))
+	updateVarName, pricesVarName := "initialPriceUpdate"+suffix, "initialOnDemandPrices"+suffix
+
 	src := &bytes.Buffer{}
 	fmt.Fprintln(src, "//go:build !ignore_autogenerated")
 	license := lo.Must(os.ReadFile("hack/boilerplate.go.txt"))
@@ -72,25 +120,25 @@ func main() {
 	fmt.Fprintln(src, `import "time"`)
 	now := time.Now().UTC().Format(time.RFC3339)
 	fmt.Fprintf(src, "// generated at %s for %s\n\n\n", now, region)
-	fmt.Fprintf(src, "var initialPriceUpdate, _ = time.Parse(time.RFC3339, \"%s\")\n", now)
+	fmt.Fprintln(src, "func init() {")
+	fmt.Fprintf(src, "initialPrices[%q] = staticPrices{region: %q, updateTime: %s, onDemand: %s}\n", partition.ID(), region, updateVarName, pricesVarName)
+	fmt.Fprintln(src, "}")
+	fmt.Fprintln(src)
+	fmt.Fprintf(src, "var %s, _ = time.Parse(time.RFC3339, \"%s\")\n", updateVarName, now)
 
 	instanceTypes := pricingProvider.InstanceTypes()
 	sort.Strings(instanceTypes)
 
-	writePricing(src, instanceTypes, "initialOnDemandPrices", pricingProvider.OnDemandPrice)
+	writePricing(src, instanceTypes, pricesVarName, pricingProvider.OnDemandListPrice)
 
 	formatted, err := format.Source(src.Bytes())
 	if err != nil {
 		log.Fatalf("formatting generated source, %s", err)
 	}
 
-	if err := os.WriteFile(flag.Arg(0), formatted, 0644); err != nil {
+	if err := os.WriteFile(path, formatted, 0644); err != nil {
 		log.Fatalf("writing output, %s", err)
 	}
-	runtime.GC()
-	if err := pprof.WriteHeapProfile(f); err != nil {
-		log.Fatal("could not write memory profile: ", err)
-	}
 }
 
 func writePricing(src *bytes.Buffer, instanceNames []string, varName string, getPrice func(instanceType string) (float64, bool)) {
@@ -256,10 +256,15 @@ func getIdentMapping(identName string) (string, error) {
 		"metrics.Namespace": metrics.Namespace,
 		"Namespace":         metrics.Namespace,
 
-		"nodeSubsystem":           "nodes",
-		"interruptionSubsystem":   "interruption",
-		"nodeTemplateSubsystem":   "nodetemplate",
-		"deprovisioningSubsystem": "deprovisioning",
+		"nodeSubsystem":                 "nodes",
+		"interruptionSubsystem":         "interruption",
+		"nodeTemplateSubsystem":         "nodetemplate",
+		"deprovisioningSubsystem":       "deprovisioning",
+		"cloudProviderSubsystem":        "cloudprovider",
+		"quotaSubsystem":                "service_quotas",
+		"unavailableOfferingsSubsystem": "unavailable_offerings",
+		"warmPoolSubsystem":             "warm_pool",
+		"garbageCollectionSubsystem":    "garbage_collection",
 	}
 	if v, ok := identMapping[identName]; ok {
 		return v, nil
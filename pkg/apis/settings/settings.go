@@ -19,11 +19,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"knative.dev/pkg/configmap"
+
+	coresettings "github.com/aws/karpenter-core/pkg/apis/settings"
 )
 
 type NodeNameConvention string
@@ -33,35 +40,109 @@ const (
 	ResourceName NodeNameConvention = "resource-name"
 )
 
+// SpotPriceStatistic is how the spot prices of the spot price history window are combined into the spot price of an
+// offering
+type SpotPriceStatistic string
+
+const (
+	SpotPriceLatest SpotPriceStatistic = "latest"
+	SpotPriceMedian SpotPriceStatistic = "median"
+	SpotPriceP90    SpotPriceStatistic = "p90"
+	SpotPriceEWMA   SpotPriceStatistic = "ewma"
+)
+
+// ExoticInstanceTypes is how instance types with accelerators, and metal instance types, are treated when other
+// instance types can be launched instead
+type ExoticInstanceTypes string
+
+const (
+	// ExoticInstanceTypesDeprioritize only launches exotic instance types when no other instance types can be launched
+	ExoticInstanceTypesDeprioritize ExoticInstanceTypes = "deprioritize"
+	// ExoticInstanceTypesAllow launches exotic instance types like any other instance types
+	ExoticInstanceTypesAllow ExoticInstanceTypes = "allow"
+	// ExoticInstanceTypesExclude never launches exotic instance types
+	ExoticInstanceTypesExclude ExoticInstanceTypes = "exclude"
+)
+
 type settingsKeyType struct{}
 
 var ContextKey = settingsKeyType{}
 
 var defaultSettings = &Settings{
-	ClusterName:                "",
-	ClusterEndpoint:            "",
-	DefaultInstanceProfile:     "",
-	EnablePodENI:               false,
-	EnableENILimitedPodDensity: true,
-	IsolatedVPC:                false,
-	NodeNameConvention:         IPName,
-	VMMemoryOverheadPercent:    0.075,
-	InterruptionQueueName:      "",
-	Tags:                       map[string]string{},
+	ClusterName:                   "",
+	ClusterEndpoint:               "",
+	DefaultInstanceProfile:        "",
+	EnablePodENI:                  false,
+	EnableENILimitedPodDensity:    true,
+	EnablePrefixDelegation:        false,
+	EnableCustomNetworking:        false,
+	IsolatedVPC:                   false,
+	NodeNameConvention:            IPName,
+	VMMemoryOverheadPercent:       0.075,
+	InterruptionQueueName:         "",
+	Tags:                          map[string]string{},
+	MaxInstanceTypes:              60,
+	PersistUnavailableOfferings:   false,
+	MinSpotPlacementScore:         0,
+	SpotInterruptionRateThreshold: 0,
+	GarbageCollectionDryRun:       true,
+	GarbageCollectionGracePeriod:  metav1.Duration{Duration: 5 * time.Minute},
+	EnableLaunchTemplateVersions:  false,
+	PricingFile:                   "",
+	PricingUpdatePeriod:           metav1.Duration{Duration: 12 * time.Hour},
+	SpotPricingUpdatePeriod:       metav1.Duration{Duration: time.Hour},
+	SpotPriceHistoryWindow:        metav1.Duration{},
+	SpotPriceStatistic:            SpotPriceLatest,
+	PricingStalenessThreshold:     metav1.Duration{},
+	PersistInstanceTypes:          false,
+	AllowedInstanceFamilies:       []string{},
+	DeniedInstanceFamilies:        []string{},
+	AllowedInstanceCategories:     []string{},
+	DeniedInstanceCategories:      []string{},
+	AllowedInstanceGenerations:    []string{},
+	DeniedInstanceGenerations:     []string{},
+	ExcludePreviousGeneration:     false,
+	ExoticInstanceTypes:           ExoticInstanceTypesDeprioritize,
+	LearnVMMemoryOverhead:         false,
 }
 
 // +k8s:deepcopy-gen=true
 type Settings struct {
-	ClusterName                string `validate:"required"`
-	ClusterEndpoint            string `validate:"required"`
-	DefaultInstanceProfile     string
-	EnablePodENI               bool
-	EnableENILimitedPodDensity bool
-	IsolatedVPC                bool
-	NodeNameConvention         NodeNameConvention `validate:"required"`
-	VMMemoryOverheadPercent    float64            `validate:"min=0"`
-	InterruptionQueueName      string
-	Tags                       map[string]string
+	ClusterName                   string `validate:"required"`
+	ClusterEndpoint               string `validate:"required"`
+	DefaultInstanceProfile        string
+	EnablePodENI                  bool
+	EnableENILimitedPodDensity    bool
+	EnablePrefixDelegation        bool
+	EnableCustomNetworking        bool
+	IsolatedVPC                   bool
+	NodeNameConvention            NodeNameConvention `validate:"required"`
+	VMMemoryOverheadPercent       float64            `validate:"min=0"`
+	InterruptionQueueName         string
+	Tags                          map[string]string
+	MaxInstanceTypes              int `validate:"min=1"`
+	PersistUnavailableOfferings   bool
+	MinSpotPlacementScore         int64   `validate:"min=0,max=10"`
+	SpotInterruptionRateThreshold float64 `validate:"min=0,max=1"`
+	GarbageCollectionDryRun       bool
+	GarbageCollectionGracePeriod  metav1.Duration
+	EnableLaunchTemplateVersions  bool
+	PricingFile                   string
+	PricingUpdatePeriod           metav1.Duration
+	SpotPricingUpdatePeriod       metav1.Duration
+	SpotPriceHistoryWindow        metav1.Duration
+	SpotPriceStatistic            SpotPriceStatistic `validate:"oneof=latest median p90 ewma"`
+	PricingStalenessThreshold     metav1.Duration
+	PersistInstanceTypes          bool
+	AllowedInstanceFamilies       []string
+	DeniedInstanceFamilies        []string
+	AllowedInstanceCategories     []string
+	DeniedInstanceCategories      []string
+	AllowedInstanceGenerations    []string
+	DeniedInstanceGenerations     []string
+	ExcludePreviousGeneration     bool
+	ExoticInstanceTypes           ExoticInstanceTypes `validate:"oneof=deprioritize allow exclude"`
+	LearnVMMemoryOverhead         bool
 }
 
 func (*Settings) ConfigMap() string {
@@ -78,11 +159,36 @@ func (*Settings) Inject(ctx context.Context, cm *v1.ConfigMap) (context.Context,
 		configmap.AsString("aws.defaultInstanceProfile", &s.DefaultInstanceProfile),
 		configmap.AsBool("aws.enablePodENI", &s.EnablePodENI),
 		configmap.AsBool("aws.enableENILimitedPodDensity", &s.EnableENILimitedPodDensity),
+		configmap.AsBool("aws.enablePrefixDelegation", &s.EnablePrefixDelegation),
+		configmap.AsBool("aws.enableCustomNetworking", &s.EnableCustomNetworking),
 		configmap.AsBool("aws.isolatedVPC", &s.IsolatedVPC),
 		AsTypedString("aws.nodeNameConvention", &s.NodeNameConvention),
 		configmap.AsFloat64("aws.vmMemoryOverheadPercent", &s.VMMemoryOverheadPercent),
 		configmap.AsString("aws.interruptionQueueName", &s.InterruptionQueueName),
 		AsStringMap("aws.tags", &s.Tags),
+		configmap.AsInt("aws.maxInstanceTypes", &s.MaxInstanceTypes),
+		configmap.AsBool("aws.persistUnavailableOfferings", &s.PersistUnavailableOfferings),
+		configmap.AsInt64("aws.minSpotPlacementScore", &s.MinSpotPlacementScore),
+		configmap.AsFloat64("aws.spotInterruptionRateThreshold", &s.SpotInterruptionRateThreshold),
+		configmap.AsBool("aws.garbageCollectionDryRun", &s.GarbageCollectionDryRun),
+		coresettings.AsMetaDuration("aws.garbageCollectionGracePeriod", &s.GarbageCollectionGracePeriod),
+		configmap.AsBool("aws.enableLaunchTemplateVersions", &s.EnableLaunchTemplateVersions),
+		configmap.AsString("aws.pricingFile", &s.PricingFile),
+		coresettings.AsMetaDuration("aws.pricingUpdatePeriod", &s.PricingUpdatePeriod),
+		coresettings.AsMetaDuration("aws.spotPricingUpdatePeriod", &s.SpotPricingUpdatePeriod),
+		coresettings.AsMetaDuration("aws.spotPriceHistoryWindow", &s.SpotPriceHistoryWindow),
+		AsTypedString("aws.spotPriceStatistic", &s.SpotPriceStatistic),
+		coresettings.AsMetaDuration("aws.pricingStalenessThreshold", &s.PricingStalenessThreshold),
+		configmap.AsBool("aws.persistInstanceTypes", &s.PersistInstanceTypes),
+		AsStringSlice("aws.allowedInstanceFamilies", &s.AllowedInstanceFamilies),
+		AsStringSlice("aws.deniedInstanceFamilies", &s.DeniedInstanceFamilies),
+		AsStringSlice("aws.allowedInstanceCategories", &s.AllowedInstanceCategories),
+		AsStringSlice("aws.deniedInstanceCategories", &s.DeniedInstanceCategories),
+		AsStringSlice("aws.allowedInstanceGenerations", &s.AllowedInstanceGenerations),
+		AsStringSlice("aws.deniedInstanceGenerations", &s.DeniedInstanceGenerations),
+		configmap.AsBool("aws.excludePreviousGeneration", &s.ExcludePreviousGeneration),
+		AsTypedString("aws.exoticInstanceTypes", &s.ExoticInstanceTypes),
+		configmap.AsBool("aws.learnVMMemoryOverhead", &s.LearnVMMemoryOverhead),
 	); err != nil {
 		return ctx, fmt.Errorf("parsing settings, %w", err)
 	}
@@ -101,10 +207,64 @@ func (*Settings) Inject(ctx context.Context, cm *v1.ConfigMap) (context.Context,
 func (s Settings) Validate() error {
 	return multierr.Combine(
 		s.validateEndpoint(),
+		s.validateTags(),
+		s.validatePricingPeriods(),
+		s.validateGarbageCollectionGracePeriod(),
+		s.validateInstanceGenerations(),
 		validator.New().Struct(s),
 	)
 }
 
+// validatePricingPeriods validates that prices are updated periodically over a history window and staleness threshold
+// that aren't negative
+func (s Settings) validatePricingPeriods() (err error) {
+	if s.PricingUpdatePeriod.Duration <= 0 {
+		err = multierr.Append(err, fmt.Errorf("pricingUpdatePeriod must be positive"))
+	}
+	if s.SpotPricingUpdatePeriod.Duration <= 0 {
+		err = multierr.Append(err, fmt.Errorf("spotPricingUpdatePeriod must be positive"))
+	}
+	if s.SpotPriceHistoryWindow.Duration < 0 {
+		err = multierr.Append(err, fmt.Errorf("spotPriceHistoryWindow cannot be negative"))
+	}
+	if s.PricingStalenessThreshold.Duration < 0 {
+		err = multierr.Append(err, fmt.Errorf("pricingStalenessThreshold cannot be negative"))
+	}
+	return err
+}
+
+// validateGarbageCollectionGracePeriod validates that instances are given time for their machines or nodes to be
+// created before they're garbage collected
+func (s Settings) validateGarbageCollectionGracePeriod() error {
+	if s.GarbageCollectionGracePeriod.Duration <= 0 {
+		return fmt.Errorf("garbageCollectionGracePeriod must be positive")
+	}
+	return nil
+}
+
+// validateInstanceGenerations validates that the allowed and denied instance generations are numbers
+func (s Settings) validateInstanceGenerations() (err error) {
+	for _, generation := range append(append([]string{}, s.AllowedInstanceGenerations...), s.DeniedInstanceGenerations...) {
+		if _, e := strconv.Atoi(generation); e != nil {
+			err = multierr.Append(err, fmt.Errorf("instance generation %q isn't a number", generation))
+		}
+	}
+	return err
+}
+
+// validateTags validates that the tag values that are templates parse
+func (s Settings) validateTags() (err error) {
+	for key, value := range s.Tags {
+		if !strings.Contains(value, "{{") {
+			continue
+		}
+		if _, e := template.New(key).Parse(value); e != nil {
+			err = multierr.Append(err, fmt.Errorf("tag %q isn't a valid template, %w", key, e))
+		}
+	}
+	return err
+}
+
 func (s Settings) validateEndpoint() error {
 	endpoint, err := url.Parse(s.ClusterEndpoint)
 	// url.Parse() will accept a lot of input without error; make
@@ -151,3 +311,19 @@ func AsStringMap(key string, target *map[string]string) configmap.ParseFunc {
 		return nil
 	}
 }
+
+// AsStringSlice parses a value as a comma separated list of strings, ignoring whitespace and empty values.
+func AsStringSlice(key string, target *[]string) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		if raw, ok := data[key]; ok {
+			values := []string{}
+			for _, value := range strings.Split(raw, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					values = append(values, value)
+				}
+			}
+			*target = values
+		}
+		return nil
+	}
+}
@@ -17,6 +17,7 @@ package settings_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -48,23 +49,73 @@ var _ = Describe("Validation", func() {
 		Expect(s.DefaultInstanceProfile).To(Equal(""))
 		Expect(s.EnablePodENI).To(BeFalse())
 		Expect(s.EnableENILimitedPodDensity).To(BeTrue())
+		Expect(s.EnablePrefixDelegation).To(BeFalse())
+		Expect(s.EnableCustomNetworking).To(BeFalse())
 		Expect(s.IsolatedVPC).To(BeFalse())
 		Expect(s.NodeNameConvention).To(Equal(settings.IPName))
 		Expect(s.VMMemoryOverheadPercent).To(Equal(0.075))
 		Expect(len(s.Tags)).To(BeZero())
+		Expect(s.MaxInstanceTypes).To(Equal(60))
+		Expect(s.PersistUnavailableOfferings).To(BeFalse())
+		Expect(s.MinSpotPlacementScore).To(BeZero())
+		Expect(s.SpotInterruptionRateThreshold).To(BeZero())
+		Expect(s.GarbageCollectionDryRun).To(BeTrue())
+		Expect(s.GarbageCollectionGracePeriod.Duration).To(Equal(5 * time.Minute))
+		Expect(s.EnableLaunchTemplateVersions).To(BeFalse())
+		Expect(s.PricingFile).To(BeEmpty())
+		Expect(s.PricingUpdatePeriod.Duration).To(Equal(12 * time.Hour))
+		Expect(s.SpotPricingUpdatePeriod.Duration).To(Equal(time.Hour))
+		Expect(s.SpotPriceHistoryWindow.Duration).To(BeZero())
+		Expect(s.SpotPriceStatistic).To(Equal(settings.SpotPriceLatest))
+		Expect(s.PricingStalenessThreshold.Duration).To(BeZero())
+		Expect(s.PersistInstanceTypes).To(BeFalse())
+		Expect(s.AllowedInstanceFamilies).To(BeEmpty())
+		Expect(s.DeniedInstanceFamilies).To(BeEmpty())
+		Expect(s.AllowedInstanceCategories).To(BeEmpty())
+		Expect(s.DeniedInstanceCategories).To(BeEmpty())
+		Expect(s.AllowedInstanceGenerations).To(BeEmpty())
+		Expect(s.DeniedInstanceGenerations).To(BeEmpty())
+		Expect(s.ExcludePreviousGeneration).To(BeFalse())
+		Expect(s.ExoticInstanceTypes).To(Equal(settings.ExoticInstanceTypesDeprioritize))
+		Expect(s.LearnVMMemoryOverhead).To(BeFalse())
 	})
 	It("should succeed to set custom values", func() {
 		cm := &v1.ConfigMap{
 			Data: map[string]string{
-				"aws.clusterEndpoint":            "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
-				"aws.clusterName":                "my-cluster",
-				"aws.defaultInstanceProfile":     "karpenter",
-				"aws.enablePodENI":               "true",
-				"aws.enableENILimitedPodDensity": "false",
-				"aws.isolatedVPC":                "true",
-				"aws.nodeNameConvention":         "resource-name",
-				"aws.vmMemoryOverheadPercent":    "0.1",
-				"aws.tags":                       `{"tag1": "value1", "tag2": "value2", "example.com/tag": "my-value"}`,
+				"aws.clusterEndpoint":               "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":                   "my-cluster",
+				"aws.defaultInstanceProfile":        "karpenter",
+				"aws.enablePodENI":                  "true",
+				"aws.enableENILimitedPodDensity":    "false",
+				"aws.enablePrefixDelegation":        "true",
+				"aws.enableCustomNetworking":        "true",
+				"aws.isolatedVPC":                   "true",
+				"aws.nodeNameConvention":            "resource-name",
+				"aws.vmMemoryOverheadPercent":       "0.1",
+				"aws.tags":                          `{"tag1": "value1", "tag2": "value2", "example.com/tag": "my-value"}`,
+				"aws.maxInstanceTypes":              "20",
+				"aws.persistUnavailableOfferings":   "true",
+				"aws.minSpotPlacementScore":         "3",
+				"aws.spotInterruptionRateThreshold": "0.2",
+				"aws.garbageCollectionDryRun":       "false",
+				"aws.garbageCollectionGracePeriod":  "15m",
+				"aws.enableLaunchTemplateVersions":  "true",
+				"aws.pricingFile":                   "/etc/karpenter/pricing/prices.json",
+				"aws.pricingUpdatePeriod":           "6h",
+				"aws.spotPricingUpdatePeriod":       "30m",
+				"aws.spotPriceHistoryWindow":        "24h",
+				"aws.spotPriceStatistic":            "median",
+				"aws.pricingStalenessThreshold":     "36h",
+				"aws.persistInstanceTypes":          "true",
+				"aws.allowedInstanceFamilies":       "m5, c5,r5",
+				"aws.deniedInstanceFamilies":        "m5a",
+				"aws.allowedInstanceCategories":     "c,m,r",
+				"aws.deniedInstanceCategories":      "t",
+				"aws.allowedInstanceGenerations":    "5,6",
+				"aws.deniedInstanceGenerations":     "4",
+				"aws.excludePreviousGeneration":     "true",
+				"aws.exoticInstanceTypes":           "exclude",
+				"aws.learnVMMemoryOverhead":         "true",
 			},
 		}
 		ctx, err := (&settings.Settings{}).Inject(ctx, cm)
@@ -73,6 +124,8 @@ var _ = Describe("Validation", func() {
 		Expect(s.DefaultInstanceProfile).To(Equal("karpenter"))
 		Expect(s.EnablePodENI).To(BeTrue())
 		Expect(s.EnableENILimitedPodDensity).To(BeFalse())
+		Expect(s.EnablePrefixDelegation).To(BeTrue())
+		Expect(s.EnableCustomNetworking).To(BeTrue())
 		Expect(s.IsolatedVPC).To(BeTrue())
 		Expect(s.NodeNameConvention).To(Equal(settings.ResourceName))
 		Expect(s.VMMemoryOverheadPercent).To(Equal(0.1))
@@ -80,6 +133,29 @@ var _ = Describe("Validation", func() {
 		Expect(s.Tags).To(HaveKeyWithValue("tag1", "value1"))
 		Expect(s.Tags).To(HaveKeyWithValue("tag2", "value2"))
 		Expect(s.Tags).To(HaveKeyWithValue("example.com/tag", "my-value"))
+		Expect(s.MaxInstanceTypes).To(Equal(20))
+		Expect(s.PersistUnavailableOfferings).To(BeTrue())
+		Expect(s.MinSpotPlacementScore).To(BeNumerically("==", 3))
+		Expect(s.SpotInterruptionRateThreshold).To(Equal(0.2))
+		Expect(s.GarbageCollectionDryRun).To(BeFalse())
+		Expect(s.GarbageCollectionGracePeriod.Duration).To(Equal(15 * time.Minute))
+		Expect(s.EnableLaunchTemplateVersions).To(BeTrue())
+		Expect(s.PricingFile).To(Equal("/etc/karpenter/pricing/prices.json"))
+		Expect(s.PricingUpdatePeriod.Duration).To(Equal(6 * time.Hour))
+		Expect(s.SpotPricingUpdatePeriod.Duration).To(Equal(30 * time.Minute))
+		Expect(s.SpotPriceHistoryWindow.Duration).To(Equal(24 * time.Hour))
+		Expect(s.SpotPriceStatistic).To(Equal(settings.SpotPriceMedian))
+		Expect(s.PricingStalenessThreshold.Duration).To(Equal(36 * time.Hour))
+		Expect(s.PersistInstanceTypes).To(BeTrue())
+		Expect(s.AllowedInstanceFamilies).To(Equal([]string{"m5", "c5", "r5"}))
+		Expect(s.DeniedInstanceFamilies).To(Equal([]string{"m5a"}))
+		Expect(s.AllowedInstanceCategories).To(Equal([]string{"c", "m", "r"}))
+		Expect(s.DeniedInstanceCategories).To(Equal([]string{"t"}))
+		Expect(s.AllowedInstanceGenerations).To(Equal([]string{"5", "6"}))
+		Expect(s.DeniedInstanceGenerations).To(Equal([]string{"4"}))
+		Expect(s.ExcludePreviousGeneration).To(BeTrue())
+		Expect(s.ExoticInstanceTypes).To(Equal(settings.ExoticInstanceTypesExclude))
+		Expect(s.LearnVMMemoryOverhead).To(BeTrue())
 	})
 	It("should fail validation with panic when clusterName not included", func() {
 		cm := &v1.ConfigMap{
@@ -120,4 +196,114 @@ var _ = Describe("Validation", func() {
 		_, err := (&settings.Settings{}).Inject(ctx, cm)
 		Expect(err).To(HaveOccurred())
 	})
+	It("should fail validation when maxInstanceTypes is less than one", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":  "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":      "my-cluster",
+				"aws.maxInstanceTypes": "0",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when minSpotPlacementScore is greater than ten", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":       "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":           "my-cluster",
+				"aws.minSpotPlacementScore": "11",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when spotInterruptionRateThreshold is greater than one", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":               "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":                   "my-cluster",
+				"aws.spotInterruptionRateThreshold": "1.5",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when a tag value is an invalid template", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint": "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":     "my-cluster",
+				"aws.tags":            `{"team": "{{ .Labels.team "}`,
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when spotPricingUpdatePeriod is not positive", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":         "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":             "my-cluster",
+				"aws.spotPricingUpdatePeriod": "0s",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when garbageCollectionGracePeriod is not positive", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":              "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":                  "my-cluster",
+				"aws.garbageCollectionGracePeriod": "0s",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when pricingStalenessThreshold is negative", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":           "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":               "my-cluster",
+				"aws.pricingStalenessThreshold": "-1h",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when spotPriceStatistic is unknown", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":    "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":        "my-cluster",
+				"aws.spotPriceStatistic": "mean",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when an instance generation isn't a number", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":           "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":               "my-cluster",
+				"aws.deniedInstanceGenerations": "5,six",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should fail validation when exoticInstanceTypes is unknown", func() {
+		cm := &v1.ConfigMap{
+			Data: map[string]string{
+				"aws.clusterEndpoint":     "https://00000000000000000000000.gr7.us-west-2.eks.amazonaws.com",
+				"aws.clusterName":         "my-cluster",
+				"aws.exoticInstanceTypes": "prefer",
+			},
+		}
+		_, err := (&settings.Settings{}).Inject(ctx, cm)
+		Expect(err).To(HaveOccurred())
+	})
 })
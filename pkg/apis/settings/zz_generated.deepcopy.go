@@ -31,6 +31,36 @@ func (in *Settings) DeepCopyInto(out *Settings) {
 			(*out)[key] = val
 		}
 	}
+	if in.AllowedInstanceFamilies != nil {
+		in, out := &in.AllowedInstanceFamilies, &out.AllowedInstanceFamilies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedInstanceFamilies != nil {
+		in, out := &in.DeniedInstanceFamilies, &out.DeniedInstanceFamilies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedInstanceCategories != nil {
+		in, out := &in.AllowedInstanceCategories, &out.AllowedInstanceCategories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedInstanceCategories != nil {
+		in, out := &in.DeniedInstanceCategories, &out.DeniedInstanceCategories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedInstanceGenerations != nil {
+		in, out := &in.AllowedInstanceGenerations, &out.AllowedInstanceGenerations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedInstanceGenerations != nil {
+		in, out := &in.DeniedInstanceGenerations, &out.DeniedInstanceGenerations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Settings.
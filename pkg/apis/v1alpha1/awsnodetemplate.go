@@ -62,6 +62,18 @@ type AWSNodeTemplateSpec struct {
 	// DetailedMonitoring controls if detailed monitoring is enabled for instances that are launched
 	// +optional
 	DetailedMonitoring *bool `json:"detailedMonitoring,omitempty"`
+	// WarmPool keeps stopped on-demand instances that were initialized from the node template's launch templates,
+	// which are started instead of launching new instances so that nodes become ready sooner
+	// +optional
+	WarmPool *WarmPool `json:"warmPool,omitempty"`
+}
+
+// WarmPool configures the stopped instances that are kept for an AWSNodeTemplate
+type WarmPool struct {
+	// Size is the number of stopped instances to keep in the warm pool. The pool is refilled after its instances
+	// are started, with the instance types of the launch that depleted it.
+	// +kubebuilder:validation:Minimum=1
+	Size int32 `json:"size"`
 }
 
 // AWSNodeTemplate is the Schema for the AWSNodeTemplate API
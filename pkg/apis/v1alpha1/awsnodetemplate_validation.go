@@ -27,6 +27,7 @@ import (
 const (
 	userDataPath    = "userData"
 	amiSelectorPath = "amiSelector"
+	warmPoolPath    = "warmPool"
 )
 
 var (
@@ -46,6 +47,7 @@ func (a *AWSNodeTemplateSpec) validate(_ context.Context) (errs *apis.FieldError
 		a.validateUserData(),
 		a.validateAMISelector(),
 		a.validateAMIFamily(),
+		a.validateWarmPool(),
 	)
 }
 
@@ -92,3 +94,13 @@ func (a *AWSNodeTemplateSpec) validateAMISelector() (errs *apis.FieldError) {
 	}
 	return errs
 }
+
+func (a *AWSNodeTemplateSpec) validateWarmPool() (errs *apis.FieldError) {
+	if a.WarmPool == nil {
+		return nil
+	}
+	if a.WarmPool.Size < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(a.WarmPool.Size, fmt.Sprintf("%s.size", warmPoolPath), "must be at least 1"))
+	}
+	return errs
+}
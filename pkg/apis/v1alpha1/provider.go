@@ -41,9 +41,15 @@ type AWS struct {
 	// SecurityGroups specify the names of the security groups.
 	// +optional
 	SecurityGroupSelector map[string]string `json:"securityGroupSelector,omitempty"`
-	// Tags to be applied on ec2 resources like instances and launch templates.
+	// Tags to be applied on ec2 resources like instances and launch templates. Tag values may be Go templates that
+	// reference the machine that an instance is launched for, such as "{{ .Labels.team }}", which are only applied
+	// to instances and their volumes.
 	// +optional
 	Tags map[string]string `json:"tags,omitempty"`
+	// PropagateLabelsAsTags are the keys of the labels of machines that are applied as tags to their instances, with
+	// the values of the labels as the values of the tags
+	// +optional
+	PropagateLabelsAsTags []string `json:"propagateLabelsAsTags,omitempty"`
 	// LaunchTemplate parameters to use when generating an LT
 	LaunchTemplate `json:",inline,omitempty"`
 }
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"text/template"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -136,6 +137,20 @@ func (a *AWS) validateTags() (errs *apis.FieldError) {
 			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf(
 				"the tag with key : '' and value : '%s' is invalid because empty tag keys aren't supported", tagValue), "tags"))
 		}
+		if IsTagTemplate(tagValue) {
+			if _, err := template.New(tagKey).Parse(tagValue); err != nil {
+				errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf(
+					"the tag with key : '%s' and value : '%s' is invalid because it isn't a valid template, %s", tagKey, tagValue, err), "tags"))
+			}
+		}
+	}
+	for i, labelKey := range a.PropagateLabelsAsTags {
+		if labelKey == "" {
+			errs = errs.Also(apis.ErrInvalidValue("empty label keys aren't supported", "propagateLabelsAsTags").ViaIndex(i))
+		}
+		if IsReservedTagKey(labelKey) {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("label key '%s' has a reserved tag key prefix", labelKey), "propagateLabelsAsTags").ViaIndex(i))
+		}
 	}
 	return errs
 }
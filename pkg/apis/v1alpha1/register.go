@@ -76,6 +76,20 @@ var (
 	LabelInstanceAMIID                        = LabelDomain + "/instance-ami-id"
 
 	InterruptionInfrastructureFinalizer = Group + "/interruption-infrastructure"
+
+	// WarmPoolTagKey tags the instances in the warm pool of an AWSNodeTemplate with the AWSNodeTemplate's name. The tag
+	// is removed when a warm instance is started for a machine.
+	WarmPoolTagKey = Group + "/warm-pool"
+	// WarmPoolLaunchTemplateTagKey tags the instances in a warm pool with the launch template that they were launched from
+	WarmPoolLaunchTemplateTagKey = Group + "/warm-pool-launch-template"
+	// WarmPoolTaintKey taints the nodes that warm instances register with their user data, so that pods aren't scheduled
+	// to them while they're initialized. The taint is removed from nodes whose instances were started for machines.
+	WarmPoolTaintKey = Group + "/warm-pool"
+	// TaggedKeysAnnotationKey annotates machines with the comma separated keys of the tags that were reconciled on their
+	// instances, so that the tags that are removed from their node templates or the global tags can be deleted
+	TaggedKeysAnnotationKey = Group + "/tagged-keys"
+	// HourlyCostAnnotationKey annotates machines with the hourly price of the offering that they were launched with
+	HourlyCostAnnotationKey = Group + "/hourly-cost"
 )
 
 var (
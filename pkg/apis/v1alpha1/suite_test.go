@@ -59,4 +59,92 @@ var _ = Describe("Validation", func() {
 			Expect(ant.Validate(ctx)).To(Not(Succeed()))
 		})
 	})
+	Context("WarmPool", func() {
+		BeforeEach(func() {
+			ant.Spec.SubnetSelector = map[string]string{"foo": "bar"}
+			ant.Spec.SecurityGroupSelector = map[string]string{"foo": "bar"}
+		})
+		It("should succeed if the warm pool has instances", func() {
+			ant.Spec.WarmPool = &WarmPool{Size: 3}
+			Expect(ant.Validate(ctx)).To(Succeed())
+		})
+		It("should fail if the warm pool is empty", func() {
+			ant.Spec.WarmPool = &WarmPool{Size: 0}
+			Expect(ant.Validate(ctx)).To(Not(Succeed()))
+		})
+	})
+	Context("Tags", func() {
+		BeforeEach(func() {
+			ant.Spec.SubnetSelector = map[string]string{"foo": "bar"}
+			ant.Spec.SecurityGroupSelector = map[string]string{"foo": "bar"}
+		})
+		It("should succeed if tag values are templates", func() {
+			ant.Spec.Tags = map[string]string{"team": "{{ .Labels.team }}", "machine-name": "{{ .Name }}"}
+			ant.Spec.PropagateLabelsAsTags = []string{"team"}
+			Expect(ant.Validate(ctx)).To(Succeed())
+		})
+		It("should fail if a tag value is an invalid template", func() {
+			ant.Spec.Tags = map[string]string{"team": "{{ .Labels.team"}
+			Expect(ant.Validate(ctx)).To(Not(Succeed()))
+		})
+		It("should fail if a propagated label key is empty", func() {
+			ant.Spec.PropagateLabelsAsTags = []string{""}
+			Expect(ant.Validate(ctx)).To(Not(Succeed()))
+		})
+		It("should fail if a propagated label key is a reserved tag key", func() {
+			for _, labelKey := range []string{"aws:team", "karpenter.sh/provisioner-name", "karpenter.k8s.aws/instance-family"} {
+				ant.Spec.PropagateLabelsAsTags = []string{labelKey}
+				Expect(ant.Validate(ctx)).To(Not(Succeed()))
+			}
+		})
+	})
+})
+
+var _ = Describe("Tags", func() {
+	It("should render tag templates with the data of the machine", func() {
+		tags, err := RenderTags(TagData{
+			Name:            "machine",
+			ProvisionerName: "default",
+			Labels:          map[string]string{"team": "team-a"},
+			Zone:            "test-zone-1a",
+			CapacityType:    "spot",
+		}, nil, map[string]string{
+			"static":       "value",
+			"team":         "{{ .Labels.team }}",
+			"placement":    "{{ .Zone }}/{{ .CapacityType }}",
+			"machine-name": "{{ .ProvisionerName }}/{{ .Name }}",
+			"missing":      "{{ .Labels.missing }}",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tags).To(Equal(map[string]string{
+			"static":       "value",
+			"team":         "team-a",
+			"placement":    "test-zone-1a/spot",
+			"machine-name": "default/machine",
+			"missing":      "",
+		}))
+	})
+	It("should propagate labels as tags", func() {
+		tags, err := RenderTags(TagData{Labels: map[string]string{"team": "team-a", "other": "value"}}, []string{"team", "missing"},
+			map[string]string{"team": "overridden"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tags).To(Equal(map[string]string{"team": "team-a"}))
+	})
+	It("should not propagate labels with reserved tag keys", func() {
+		tags, err := RenderTags(TagData{Labels: map[string]string{"team": "team-a", "karpenter.sh/provisioner-name": "default"}},
+			[]string{"team", "karpenter.sh/provisioner-name"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tags).To(Equal(map[string]string{"team": "team-a"}))
+	})
+	It("should only keep static tags", func() {
+		Expect(StaticTags(map[string]string{"static": "value", "team": "{{ .Labels.team }}"})).To(Equal(map[string]string{"static": "value"}))
+	})
+	It("should reserve the keys of AWS, the cluster and Karpenter", func() {
+		Expect(IsReservedTagKey("aws:autoscaling:groupName")).To(BeTrue())
+		Expect(IsReservedTagKey("kubernetes.io/cluster/test-cluster")).To(BeTrue())
+		Expect(IsReservedTagKey("karpenter.sh/machine-name")).To(BeTrue())
+		Expect(IsReservedTagKey(WarmPoolTagKey)).To(BeTrue())
+		Expect(IsReservedTagKey("Name")).To(BeFalse())
+		Expect(IsReservedTagKey("kubernetes.io/role")).To(BeFalse())
+	})
 })
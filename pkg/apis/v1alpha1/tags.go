@@ -17,15 +17,93 @@ package v1alpha1
 import (
 	"context"
 	"fmt"
+	"strings"
+	"text/template"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
 
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/operator/injection"
 )
 
+// TagData is the data of a machine that the values of tags can reference as Go templates, such as
+// "{{ .ProvisionerName }}" or "{{ .Labels.team }}"
+type TagData struct {
+	Name            string
+	ProvisionerName string
+	Labels          map[string]string
+	Zone            string
+	CapacityType    string
+}
+
+// NewTagData returns the tag data of the machine. The labels of the machine include the requirements that it can
+// only satisfy with a single value, such as the zone of a machine that's pinned to a zone.
+func NewTagData(machine *v1alpha5.Machine) TagData {
+	labels := lo.Assign(machine.Labels)
+	for _, requirement := range machine.Spec.Requirements {
+		if requirement.Operator == v1.NodeSelectorOpIn && len(requirement.Values) == 1 {
+			labels[requirement.Key] = requirement.Values[0]
+		}
+	}
+	return TagData{
+		Name:            machine.Name,
+		ProvisionerName: labels[v1alpha5.ProvisionerNameLabelKey],
+		Labels:          labels,
+		Zone:            labels[v1.LabelTopologyZone],
+		CapacityType:    labels[v1alpha5.LabelCapacityType],
+	}
+}
+
+// ReservedTagKeyPrefixes are the prefixes of the keys of tags that AWS, the cluster and Karpenter reserve, which are
+// never changed when tags are reconciled
+var ReservedTagKeyPrefixes = []string{"aws:", "kubernetes.io/cluster/", v1alpha5.Group + "/", Group + "/"}
+
+// IsReservedTagKey returns whether the key of a tag has a reserved prefix
+func IsReservedTagKey(key string) bool {
+	return lo.SomeBy(ReservedTagKeyPrefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// IsTagTemplate returns whether the value of a tag is a template that's rendered for each machine
+func IsTagTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// StaticTags returns the tags that aren't templates, which are the only tags of resources that aren't launched for a
+// single machine, such as launch templates
+func StaticTags(tags map[string]string) map[string]string {
+	return lo.OmitBy(tags, func(_ string, value string) bool { return IsTagTemplate(value) })
+}
+
+// RenderTags merges the tags, renders the values that are templates with the data, and adds the labels of the data
+// with the keys in propagateLabelsAsTags as tags, other than those with reserved keys
+func RenderTags(data TagData, propagateLabelsAsTags []string, custom ...map[string]string) (map[string]string, error) {
+	tags := map[string]string{}
+	for key, value := range lo.Assign(custom...) {
+		if !IsTagTemplate(value) {
+			tags[key] = value
+			continue
+		}
+		tmpl, err := template.New(key).Option("missingkey=zero").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing tag %s, %w", key, err)
+		}
+		rendered := &strings.Builder{}
+		if err := tmpl.Execute(rendered, data); err != nil {
+			return nil, fmt.Errorf("rendering tag %s, %w", key, err)
+		}
+		tags[key] = rendered.String()
+	}
+	for _, key := range propagateLabelsAsTags {
+		if value, ok := data.Labels[key]; ok && !IsReservedTagKey(key) {
+			tags[key] = value
+		}
+	}
+	return tags, nil
+}
+
 func MergeTags(ctx context.Context, custom ...map[string]string) (result []*ec2.Tag) {
 	tags := map[string]string{
 		v1alpha5.ProvisionerNameLabelKey: injection.GetNamespacedName(ctx).Name,
@@ -63,6 +63,11 @@ func (in *AWS) DeepCopyInto(out *AWS) {
 			(*out)[key] = val
 		}
 	}
+	if in.PropagateLabelsAsTags != nil {
+		in, out := &in.PropagateLabelsAsTags, &out.PropagateLabelsAsTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	in.LaunchTemplate.DeepCopyInto(&out.LaunchTemplate)
 }
 
@@ -164,6 +169,11 @@ func (in *AWSNodeTemplateSpec) DeepCopyInto(out *AWSNodeTemplateSpec) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.WarmPool != nil {
+		in, out := &in.WarmPool, &out.WarmPool
+		*out = new(WarmPool)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSNodeTemplateSpec.
@@ -381,3 +391,18 @@ func (in *SubnetStatus) DeepCopy() *SubnetStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WarmPool) DeepCopyInto(out *WarmPool) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WarmPool.
+func (in *WarmPool) DeepCopy() *WarmPool {
+	if in == nil {
+		return nil
+	}
+	out := new(WarmPool)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -27,8 +27,32 @@ const (
 	// UnavailableOfferingsTTL is the time before offerings that were marked as unavailable
 	// are removed from the cache and are available for launch again
 	UnavailableOfferingsTTL = 3 * time.Minute
+	// UnavailableOfferingsMaxTTL caps the time that offerings are unavailable for after repeated
+	// insufficient capacity errors, which grows exponentially from the TTL of the first error
+	UnavailableOfferingsMaxTTL = time.Hour
+	// UnavailableOfferingsFailureHistoryTTL is the time without further insufficient capacity errors
+	// before the backoff of offerings starts over
+	UnavailableOfferingsFailureHistoryTTL = 2 * UnavailableOfferingsMaxTTL
 	// InstanceTypesAndZonesTTL is the time before we refresh instance types and zones at EC2
 	InstanceTypesAndZonesTTL = 5 * time.Minute
+	// ServiceQuotasTTL is the time before we refresh the EC2 vCPU quotas at Service Quotas
+	ServiceQuotasTTL = 10 * time.Minute
+	// SpotPlacementScoresTTL is the time before we refresh the spot placement scores of a provisioner at EC2
+	SpotPlacementScoresTTL = 30 * time.Minute
+	// SpotPlacementScoresTrackingTTL is the time after which provisioners that no longer launch spot instances stop
+	// being scored
+	SpotPlacementScoresTrackingTTL = time.Hour
+	// SpotPlacementScoresMaxBackoff caps the time that spot placement scores aren't retrieved for after the API is
+	// throttled or too many distinct request configurations were scored, which grows exponentially
+	SpotPlacementScoresMaxBackoff = time.Hour
+	// SpotInterruptionHistoryWindow is the time over which the interruption rates of spot pools are learned
+	SpotInterruptionHistoryWindow = 24 * time.Hour
+	// LaunchTemplateGracePeriod is the time after a launch template was created or last resolved for a launch before
+	// it's garbage collected
+	LaunchTemplateGracePeriod = 10 * time.Minute
+	// LaunchedHourlyCostTTL is the time that the hourly cost that an instance was launched at is kept for until its
+	// machine is hydrated from its node, which is created right after the launch
+	LaunchedHourlyCostTTL = 15 * time.Minute
 )
 
 const (
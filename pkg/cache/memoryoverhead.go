@@ -0,0 +1,105 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// MemoryOverhead is the VM memory overhead of instance types that's learned from the memory capacity of their nodes,
+// which is the memory of the instance type less the memory that the hypervisor and the kernel reserve. The overhead
+// depends on the instance type and the AMI family, so a global percentage over-reserves the memory of large instance
+// types and under-reserves the memory of others.
+type MemoryOverhead struct {
+	mu sync.RWMutex
+	// key: <instance type>:<ami family>, value: the overhead in MiB
+	entries map[string]int64
+	// SeqNum is a monotonically increasing change counter used to invalidate instance types and to persist the
+	// overhead when it changes
+	SeqNum uint64
+}
+
+func NewMemoryOverhead() *MemoryOverhead {
+	return &MemoryOverhead{entries: map[string]int64{}}
+}
+
+// Get returns the learned memory overhead, in MiB, of the instance type with the AMI family
+func (m *MemoryOverhead) Get(instanceType, amiFamily string) (int64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	overhead, ok := m.entries[MemoryOverheadKey(instanceType, amiFamily)]
+	return overhead, ok
+}
+
+// Update sets the memory overhead that was observed for instance types and AMI families, keeping the overhead of
+// others, and returns whether anything changed
+func (m *MemoryOverhead) Update(observed map[string]int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for key, overhead := range observed {
+		if current, ok := m.entries[key]; !ok || current != overhead {
+			m.entries[key] = overhead
+			changed = true
+		}
+	}
+	if changed {
+		atomic.AddUint64(&m.SeqNum, 1)
+	}
+	return changed
+}
+
+// Restore sets the persisted memory overhead of instance types and AMI families whose overhead isn't known yet, and
+// returns whether anything changed
+func (m *MemoryOverhead) Restore(entries map[string]int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for key, overhead := range entries {
+		if _, ok := m.entries[key]; !ok {
+			m.entries[key] = overhead
+			changed = true
+		}
+	}
+	if changed {
+		atomic.AddUint64(&m.SeqNum, 1)
+	}
+	return changed
+}
+
+// Entries returns a copy of the learned memory overhead
+func (m *MemoryOverhead) Entries() map[string]int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make(map[string]int64, len(m.entries))
+	for key, overhead := range m.entries {
+		entries[key] = overhead
+	}
+	return entries
+}
+
+// Flush removes the learned memory overhead of every instance type
+func (m *MemoryOverhead) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = map[string]int64{}
+	atomic.AddUint64(&m.SeqNum, 1)
+}
+
+func MemoryOverheadKey(instanceType, amiFamily string) string {
+	return fmt.Sprintf("%s:%s", instanceType, amiFamily)
+}
@@ -0,0 +1,56 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter-core/pkg/metrics"
+)
+
+const (
+	unavailableOfferingsSubsystem = "unavailable_offerings"
+	interruptionSubsystem         = "interruption"
+	scopeLabel                    = "scope"
+	capacityTypeLabel             = "capacity_type"
+	instanceTypeLabel             = "instance_type"
+	zoneLabel                     = "zone"
+)
+
+var (
+	offeringBackoff = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: unavailableOfferingsSubsystem,
+			Name:      "backoff_seconds",
+			Help:      "The time that offerings are unavailable for after their most recent insufficient capacity error, which grows with repeated errors. Labeled by the scope of the error. Labels outside of the scope are empty, and the family scope reports the instance family class as the instance type.",
+		},
+		[]string{scopeLabel, capacityTypeLabel, instanceTypeLabel, zoneLabel},
+	)
+	spotInterruptionRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: interruptionSubsystem,
+			Name:      "spot_interruption_rate",
+			Help:      "The fraction of spot launches that were interrupted within the last 24 hours, by instance type and zone. Only reported for pools that were interrupted more than once within the window.",
+		},
+		[]string{instanceTypeLabel, zoneLabel},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(offeringBackoff, spotInterruptionRate)
+}
@@ -0,0 +1,248 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// minSpotInterruptions is the number of interruptions of a spot pool within the window before its interruption rate
+// is reported, so that a single interruption doesn't condemn a pool that has seen few launches
+const minSpotInterruptions = 2
+
+// SpotInterruptionBucket counts the launches of a spot pool within an hour, and how many of the instances that were
+// launched within the hour were interrupted
+type SpotInterruptionBucket struct {
+	Hour          time.Time `json:"hour"`
+	Launches      int       `json:"launches,omitempty"`
+	Interruptions int       `json:"interruptions,omitempty"`
+}
+
+// SpotInterruptionRecord is the history of a spot pool within the window, by the hour that instances were launched in
+type SpotInterruptionRecord struct {
+	Buckets []SpotInterruptionBucket `json:"buckets,omitempty"`
+}
+
+// SpotInterruptionHistory keeps a rolling history of the launches and interruptions of spot pools, which are
+// (instance type, zone) pairs, to learn how often each of them is interrupted. Unlike the unavailable offerings
+// cache, which forgets an interruption after a few minutes, the history spans SpotInterruptionHistoryWindow.
+// Interruptions are counted in the bucket of the hour that the interrupted instance was launched in, so that launches
+// and their interruptions leave the window together, and only once the launch has been counted, so that a pool is
+// never interrupted more often than it was launched into.
+type SpotInterruptionHistory struct {
+	mu sync.RWMutex
+	// key: <instance type>:<zone>
+	records map[string]*SpotInterruptionRecord
+	// launchesCountedUntil is the time up to which launches have been counted
+	launchesCountedUntil time.Time
+	// SeqNum is a monotonically increasing change counter used to persist the history when it changes
+	SeqNum uint64
+}
+
+func NewSpotInterruptionHistory() *SpotInterruptionHistory {
+	return &SpotInterruptionHistory{records: map[string]*SpotInterruptionRecord{}}
+}
+
+// LaunchesCountedUntil returns the time up to which launches have been counted, so that instances that were launched
+// since are counted by the next call to RecordLaunches
+func (s *SpotInterruptionHistory) LaunchesCountedUntil() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.launchesCountedUntil
+}
+
+// RecordLaunches records the spot instances that were launched since LaunchesCountedUntil, by their pools and launch
+// times, and that launches have been counted until the provided time
+func (s *SpotInterruptionHistory) RecordLaunches(launches map[string][]time.Time, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := time.Now().Add(-SpotInterruptionHistoryWindow)
+	for key, launchTimes := range launches {
+		instanceType, zone, ok := strings.Cut(key, ":")
+		if !ok {
+			continue
+		}
+		record := s.record(key, since)
+		for _, launchTime := range launchTimes {
+			if launchTime.After(since) {
+				record.bucket(launchTime).Launches++
+			}
+		}
+		s.updateMetric(instanceType, zone, record, since)
+	}
+	s.launchesCountedUntil = until
+	atomic.AddUint64(&s.SeqNum, 1)
+}
+
+// RecordInterruption records the interruption of a spot instance in the pool that was launched at the provided time.
+// Interruptions of instances that were launched before the window, or whose launch hasn't been counted, are ignored.
+func (s *SpotInterruptionHistory) RecordInterruption(instanceType, zone string, launchTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := time.Now().Add(-SpotInterruptionHistoryWindow)
+	if !launchTime.After(since) || launchTime.After(s.launchesCountedUntil) {
+		return
+	}
+	record := s.record(SpotPoolKey(instanceType, zone), since)
+	bucket := record.bucket(launchTime)
+	if bucket.Interruptions >= bucket.Launches {
+		return
+	}
+	bucket.Interruptions++
+	s.updateMetric(instanceType, zone, record, since)
+	atomic.AddUint64(&s.SeqNum, 1)
+}
+
+// Rate returns the fraction of the launches in the pool within the window that were interrupted. The rate isn't known
+// until the pool has been interrupted at least minSpotInterruptions times within the window.
+func (s *SpotInterruptionHistory) Rate(instanceType, zone string) (float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.records[SpotPoolKey(instanceType, zone)]
+	if !ok {
+		return 0, false
+	}
+	return record.rate(time.Now().Add(-SpotInterruptionHistoryWindow))
+}
+
+// Entries returns a copy of the history of the spot pools within the window
+func (s *SpotInterruptionHistory) Entries() map[string]SpotInterruptionRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	since := time.Now().Add(-SpotInterruptionHistoryWindow)
+	entries := map[string]SpotInterruptionRecord{}
+	for key, record := range s.records {
+		if buckets := record.after(since); len(buckets) > 0 {
+			entries[key] = SpotInterruptionRecord{Buckets: append([]SpotInterruptionBucket{}, buckets...)}
+		}
+	}
+	return entries
+}
+
+// Restore merges persisted history into the history, keeping the higher counts of each bucket and the later time that
+// launches were counted until, and returns whether anything changed
+func (s *SpotInterruptionHistory) Restore(entries map[string]SpotInterruptionRecord, launchesCountedUntil time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := time.Now().Add(-SpotInterruptionHistoryWindow)
+	changed := false
+	for key, entry := range entries {
+		instanceType, zone, ok := strings.Cut(key, ":")
+		if !ok {
+			continue
+		}
+		restored := entry.after(since)
+		if len(restored) == 0 {
+			continue
+		}
+		record := s.record(key, since)
+		for _, b := range restored {
+			bucket := record.bucket(b.Hour)
+			if b.Launches > bucket.Launches {
+				bucket.Launches = b.Launches
+				changed = true
+			}
+			if b.Interruptions > bucket.Interruptions {
+				bucket.Interruptions = b.Interruptions
+				changed = true
+			}
+		}
+		s.updateMetric(instanceType, zone, record, since)
+	}
+	if launchesCountedUntil.After(s.launchesCountedUntil) {
+		s.launchesCountedUntil = launchesCountedUntil
+		changed = true
+	}
+	if changed {
+		atomic.AddUint64(&s.SeqNum, 1)
+	}
+	return changed
+}
+
+// Flush removes the history of every spot pool
+func (s *SpotInterruptionHistory) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = map[string]*SpotInterruptionRecord{}
+	s.launchesCountedUntil = time.Time{}
+	spotInterruptionRate.Reset()
+	atomic.AddUint64(&s.SeqNum, 1)
+}
+
+// record returns the record of the pool, pruned so that it doesn't grow beyond the window
+func (s *SpotInterruptionHistory) record(key string, since time.Time) *SpotInterruptionRecord {
+	record, ok := s.records[key]
+	if !ok {
+		record = &SpotInterruptionRecord{}
+		s.records[key] = record
+	}
+	record.Buckets = record.after(since)
+	return record
+}
+
+func (s *SpotInterruptionHistory) updateMetric(instanceType, zone string, record *SpotInterruptionRecord, since time.Time) {
+	labels := prometheus.Labels{instanceTypeLabel: instanceType, zoneLabel: zone}
+	if rate, ok := record.rate(since); ok {
+		spotInterruptionRate.With(labels).Set(rate)
+	} else {
+		spotInterruptionRate.Delete(labels)
+	}
+}
+
+func (r *SpotInterruptionRecord) rate(since time.Time) (float64, bool) {
+	launches, interruptions := 0, 0
+	for _, bucket := range r.after(since) {
+		launches += bucket.Launches
+		interruptions += bucket.Interruptions
+	}
+	if interruptions < minSpotInterruptions {
+		return 0, false
+	}
+	return float64(interruptions) / float64(launches), true
+}
+
+// bucket returns the bucket of the hour of the time, keeping the buckets in ascending order
+func (r *SpotInterruptionRecord) bucket(t time.Time) *SpotInterruptionBucket {
+	hour := t.UTC().Truncate(time.Hour)
+	i := sort.Search(len(r.Buckets), func(i int) bool { return !r.Buckets[i].Hour.Before(hour) })
+	if i == len(r.Buckets) || !r.Buckets[i].Hour.Equal(hour) {
+		r.Buckets = append(r.Buckets, SpotInterruptionBucket{})
+		copy(r.Buckets[i+1:], r.Buckets[i:])
+		r.Buckets[i] = SpotInterruptionBucket{Hour: hour}
+	}
+	return &r.Buckets[i]
+}
+
+// after returns the buckets of the hours that end after since, assuming that buckets are in ascending order
+func (r *SpotInterruptionRecord) after(since time.Time) []SpotInterruptionBucket {
+	for i, bucket := range r.Buckets {
+		if bucket.Hour.Add(time.Hour).After(since) {
+			return r.Buckets[i:]
+		}
+	}
+	return nil
+}
+
+// SpotPoolKey returns the key of the history of the spot pool
+func SpotPoolKey(instanceType, zone string) string {
+	return fmt.Sprintf("%s:%s", instanceType, zone)
+}
@@ -0,0 +1,233 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+
+	awserrors "github.com/aws/karpenter/pkg/errors"
+)
+
+var ctx context.Context
+var unavailableOfferings *UnavailableOfferings
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cache")
+}
+
+var _ = BeforeEach(func() {
+	unavailableOfferings = NewUnavailableOfferings()
+	unavailableOfferings.Flush()
+})
+
+// expectUnavailableFor expects the only entry in the cache to expire after the ttl, allowing for jitter
+func expectUnavailableFor(minTTL, maxTTL time.Duration) {
+	entries := unavailableOfferings.Entries()
+	ExpectWithOffset(1, entries).To(HaveLen(1))
+	for _, expiration := range entries {
+		ExpectWithOffset(1, time.Until(expiration)).To(And(BeNumerically(">", minTTL-time.Second), BeNumerically("<=", maxTTL)))
+	}
+}
+
+// expire simulates the expiration of the unavailable offerings without resetting their failure history
+func expire() {
+	unavailableOfferings.cache.Flush()
+}
+
+var _ = Describe("UnavailableOfferings", func() {
+	It("should use the ttl of the first insufficient capacity error", func() {
+		unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expectUnavailableFor(UnavailableOfferingsTTL, UnavailableOfferingsTTL)
+	})
+	It("should back off exponentially with jitter for repeated insufficient capacity errors", func() {
+		for i, ttl := range []time.Duration{3 * time.Minute, 6 * time.Minute, 12 * time.Minute, 24 * time.Minute} {
+			unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+			expectUnavailableFor(ttl, time.Duration(float64(ttl)*1.2)+time.Second)
+			metric, ok := FindMetricWithLabelValues("karpenter_unavailable_offerings_backoff_seconds", map[string]string{
+				"scope":         "offering",
+				"instance_type": "m5.large",
+				"zone":          "test-zone-1a",
+				"capacity_type": v1alpha5.CapacityTypeSpot,
+			})
+			Expect(ok).To(BeTrue(), "failure %d", i+1)
+			Expect(metric.GetGauge().GetValue()).To(BeNumerically(">=", ttl.Seconds()))
+			expire()
+		}
+	})
+	It("should cap the backoff", func() {
+		for i := 0; i < 10; i++ {
+			unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+			expire()
+		}
+		unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expectUnavailableFor(UnavailableOfferingsMaxTTL, UnavailableOfferingsMaxTTL)
+	})
+	It("should not back off for errors in a scope that is already unavailable", func() {
+		unavailableOfferings.MarkScopeUnavailable(ctx, "VcpuLimitExceeded", awserrors.FamilyScope, time.Minute, "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		unavailableOfferings.MarkScopeUnavailable(ctx, "VcpuLimitExceeded", awserrors.FamilyScope, time.Minute, "c5.large", "test-zone-1b", v1alpha5.CapacityTypeSpot)
+		expectUnavailableFor(time.Minute, time.Minute)
+	})
+	It("should reset the backoff after a successful launch", func() {
+		unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expire()
+		unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expectUnavailableFor(2*UnavailableOfferingsTTL, time.Duration(float64(2*UnavailableOfferingsTTL)*1.2)+time.Second)
+		expire()
+
+		unavailableOfferings.MarkAvailable("m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		_, ok := FindMetricWithLabelValues("karpenter_unavailable_offerings_backoff_seconds", map[string]string{
+			"scope":         "offering",
+			"instance_type": "m5.large",
+			"zone":          "test-zone-1a",
+			"capacity_type": v1alpha5.CapacityTypeSpot,
+		})
+		Expect(ok).To(BeFalse())
+		unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expectUnavailableFor(UnavailableOfferingsTTL, UnavailableOfferingsTTL)
+	})
+	It("should back off scopes independently", func() {
+		unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expire()
+		unavailableOfferings.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1b", v1alpha5.CapacityTypeSpot)
+		expectUnavailableFor(UnavailableOfferingsTTL, UnavailableOfferingsTTL)
+	})
+})
+
+var _ = Describe("SpotInterruptionHistory", func() {
+	var spotInterruptions *SpotInterruptionHistory
+	var launched time.Time
+	launches := func(key string, n int) map[string][]time.Time {
+		return map[string][]time.Time{key: lo.Times(n, func(int) time.Time { return launched })}
+	}
+	BeforeEach(func() {
+		spotInterruptions = NewSpotInterruptionHistory()
+		spotInterruptions.Flush()
+		launched = time.Now().Add(-time.Minute)
+	})
+	It("should not know the rate of a pool that was interrupted once", func() {
+		spotInterruptions.RecordLaunches(launches("m5.large:test-zone-1a", 1), time.Now())
+		spotInterruptions.RecordInterruption("m5.large", "test-zone-1a", launched)
+		_, ok := spotInterruptions.Rate("m5.large", "test-zone-1a")
+		Expect(ok).To(BeFalse())
+	})
+	It("should learn the interruption rate of each pool", func() {
+		spotInterruptions.RecordLaunches(lo.Assign(launches("m5.large:test-zone-1a", 4), launches("m5.large:test-zone-1b", 4)), time.Now())
+		spotInterruptions.RecordInterruption("m5.large", "test-zone-1a", launched)
+		spotInterruptions.RecordInterruption("m5.large", "test-zone-1a", launched)
+		rate, ok := spotInterruptions.Rate("m5.large", "test-zone-1a")
+		Expect(ok).To(BeTrue())
+		Expect(rate).To(Equal(0.5))
+		_, ok = spotInterruptions.Rate("m5.large", "test-zone-1b")
+		Expect(ok).To(BeFalse())
+
+		metric, ok := FindMetricWithLabelValues("karpenter_interruption_spot_interruption_rate", map[string]string{
+			"instance_type": "m5.large",
+			"zone":          "test-zone-1a",
+		})
+		Expect(ok).To(BeTrue())
+		Expect(metric.GetGauge().GetValue()).To(Equal(0.5))
+	})
+	It("should count launches and interruptions in hourly buckets", func() {
+		spotInterruptions.RecordLaunches(launches("m5.large:test-zone-1a", 100), time.Now())
+		Expect(spotInterruptions.Entries()["m5.large:test-zone-1a"].Buckets).To(ConsistOf(SpotInterruptionBucket{
+			Hour:     launched.UTC().Truncate(time.Hour),
+			Launches: 100,
+		}))
+	})
+	It("should ignore interruptions of instances whose launch wasn't counted", func() {
+		spotInterruptions.RecordLaunches(launches("m5.large:test-zone-1a", 1), launched)
+		spotInterruptions.RecordInterruption("m5.large", "test-zone-1a", time.Now())
+		spotInterruptions.RecordInterruption("m5.large", "test-zone-1a", time.Now())
+		spotInterruptions.RecordInterruption("m5.large", "test-zone-1b", launched)
+		spotInterruptions.RecordInterruption("m5.large", "test-zone-1b", launched)
+		_, ok := spotInterruptions.Rate("m5.large", "test-zone-1a")
+		Expect(ok).To(BeFalse())
+		_, ok = spotInterruptions.Rate("m5.large", "test-zone-1b")
+		Expect(ok).To(BeFalse())
+	})
+	It("should forget launches and interruptions outside of the window", func() {
+		old := time.Now().Add(-SpotInterruptionHistoryWindow - time.Hour).UTC().Truncate(time.Hour)
+		recent := time.Now().UTC().Truncate(time.Hour)
+		Expect(spotInterruptions.Restore(map[string]SpotInterruptionRecord{
+			"m5.large:test-zone-1a": {Buckets: []SpotInterruptionBucket{{Hour: old, Launches: 2, Interruptions: 2}}},
+			"m5.large:test-zone-1b": {Buckets: []SpotInterruptionBucket{{Hour: old, Launches: 2, Interruptions: 2}, {Hour: recent, Launches: 1}}},
+		}, time.Now())).To(BeTrue())
+		_, ok := spotInterruptions.Rate("m5.large", "test-zone-1a")
+		Expect(ok).To(BeFalse())
+		Expect(spotInterruptions.Entries()).To(HaveLen(1))
+		Expect(spotInterruptions.Entries()["m5.large:test-zone-1b"].Buckets).To(ConsistOf(SpotInterruptionBucket{Hour: recent, Launches: 1}))
+	})
+	It("should not restore less history than it has seen", func() {
+		spotInterruptions.RecordLaunches(launches("m5.large:test-zone-1a", 3), time.Now())
+		Expect(spotInterruptions.Restore(map[string]SpotInterruptionRecord{
+			"m5.large:test-zone-1a": {Buckets: []SpotInterruptionBucket{{Hour: launched.UTC().Truncate(time.Hour), Launches: 1}}},
+		}, launched)).To(BeFalse())
+		Expect(spotInterruptions.Entries()["m5.large:test-zone-1a"].Buckets[0].Launches).To(Equal(3))
+		Expect(spotInterruptions.LaunchesCountedUntil()).To(BeTemporally(">", launched))
+	})
+})
+
+var _ = Describe("MemoryOverhead", func() {
+	var memoryOverhead *MemoryOverhead
+	BeforeEach(func() {
+		memoryOverhead = NewMemoryOverhead()
+	})
+	It("should learn the memory overhead of each instance type and AMI family", func() {
+		Expect(memoryOverhead.Update(map[string]int64{
+			MemoryOverheadKey("m5.large", "AL2"):          300,
+			MemoryOverheadKey("m5.large", "Bottlerocket"): 250,
+		})).To(BeTrue())
+		overhead, ok := memoryOverhead.Get("m5.large", "AL2")
+		Expect(ok).To(BeTrue())
+		Expect(overhead).To(BeNumerically("==", 300))
+		overhead, ok = memoryOverhead.Get("m5.large", "Bottlerocket")
+		Expect(ok).To(BeTrue())
+		Expect(overhead).To(BeNumerically("==", 250))
+		_, ok = memoryOverhead.Get("m5.xlarge", "AL2")
+		Expect(ok).To(BeFalse())
+	})
+	It("should only change when the observed memory overhead changes", func() {
+		Expect(memoryOverhead.Update(map[string]int64{MemoryOverheadKey("m5.large", "AL2"): 300})).To(BeTrue())
+		seqNum := memoryOverhead.SeqNum
+		Expect(memoryOverhead.Update(map[string]int64{MemoryOverheadKey("m5.large", "AL2"): 300})).To(BeFalse())
+		Expect(memoryOverhead.SeqNum).To(Equal(seqNum))
+		Expect(memoryOverhead.Update(map[string]int64{MemoryOverheadKey("m5.large", "AL2"): 280})).To(BeTrue())
+		Expect(memoryOverhead.SeqNum).To(BeNumerically(">", seqNum))
+	})
+	It("should not restore the memory overhead of instance types that it has observed", func() {
+		memoryOverhead.Update(map[string]int64{MemoryOverheadKey("m5.large", "AL2"): 300})
+		Expect(memoryOverhead.Restore(map[string]int64{
+			MemoryOverheadKey("m5.large", "AL2"):  400,
+			MemoryOverheadKey("m5.xlarge", "AL2"): 500,
+		})).To(BeTrue())
+		Expect(memoryOverhead.Entries()).To(Equal(map[string]int64{
+			MemoryOverheadKey("m5.large", "AL2"):  300,
+			MemoryOverheadKey("m5.xlarge", "AL2"): 500,
+		}))
+	})
+})
@@ -18,52 +18,154 @@ import (
 	"context"
 	"fmt"
 	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"knative.dev/pkg/logging"
+
+	awserrors "github.com/aws/karpenter/pkg/errors"
+	"github.com/aws/karpenter/pkg/utils"
 )
 
 // UnavailableOfferings stores any offerings that return ICE (insufficient capacity errors) when
 // attempting to launch the capacity. These offerings are ignored as long as they are in the cache on
-// GetInstanceTypes responses
+// GetInstanceTypes responses. Capacity shortages that affect more than a single offering, such as
+// exceeded vCPU quotas, are stored once for their scope and apply to every offering in that scope. Offerings that
+// repeatedly return errors are unavailable for exponentially longer, until a launch in the offering succeeds.
 type UnavailableOfferings struct {
-	// key: <capacityType>:<instanceType>:<zone>, value: struct{}{}
-	cache  *cache.Cache
-	SeqNum uint64
+	// key: <capacityType>:<instanceType>:<zone> for offerings, or <scope>:<...> for wider scopes, value: struct{}{}
+	cache *cache.Cache
+	// key: same as cache, value: failureHistory
+	failures *cache.Cache
+	SeqNum   uint64
+}
+
+// failureHistory tracks the consecutive insufficient capacity errors of a scope
+type failureHistory struct {
+	count  int
+	labels prometheus.Labels
 }
 
+const backoffJitter = 0.2
+
 func NewUnavailableOfferings() *UnavailableOfferings {
+	failures := cache.New(UnavailableOfferingsFailureHistoryTTL, DefaultCleanupInterval)
+	failures.OnEvicted(func(_ string, history interface{}) {
+		offeringBackoff.Delete(history.(failureHistory).labels)
+	})
 	return &UnavailableOfferings{
-		cache:  cache.New(UnavailableOfferingsTTL, DefaultCleanupInterval),
-		SeqNum: 0,
+		cache:    cache.New(UnavailableOfferingsTTL, DefaultCleanupInterval),
+		failures: failures,
+		SeqNum:   0,
 	}
 }
 
-// IsUnavailable returns true if the offering appears in the cache
+// IsUnavailable returns true if the offering, or any scope that contains it, appears in the cache
 func (u *UnavailableOfferings) IsUnavailable(instanceType, zone, capacityType string) bool {
-	_, found := u.cache.Get(u.key(instanceType, zone, capacityType))
-	return found
+	for _, scope := range []awserrors.UnavailabilityScope{
+		awserrors.OfferingScope,
+		awserrors.FamilyScope,
+		awserrors.CapacityTypeScope,
+		awserrors.AccountScope,
+	} {
+		if _, found := u.cache.Get(u.scopedKey(scope, instanceType, zone, capacityType)); found {
+			return true
+		}
+	}
+	return false
 }
 
 // MarkUnavailable communicates recently observed temporary capacity shortages in the provided offerings
 func (u *UnavailableOfferings) MarkUnavailable(ctx context.Context, unavailableReason, instanceType, zone, capacityType string) {
+	u.MarkScopeUnavailable(ctx, unavailableReason, awserrors.OfferingScope, UnavailableOfferingsTTL, instanceType, zone, capacityType)
+}
+
+// MarkScopeUnavailable communicates recently observed temporary capacity shortages in every offering of the scope
+// that contains the provided offering. The ttl is doubled for each consecutive shortage in the scope.
+func (u *UnavailableOfferings) MarkScopeUnavailable(ctx context.Context, unavailableReason string, scope awserrors.UnavailabilityScope,
+	ttl time.Duration, instanceType, zone, capacityType string) {
+	key := u.scopedKey(scope, instanceType, zone, capacityType)
+	history := failureHistory{labels: u.scopedLabels(scope, instanceType, zone, capacityType)}
+	if cached, ok := u.failures.Get(key); ok {
+		history = cached.(failureHistory)
+	}
+	// errors for a scope that is already unavailable, e.g. from several offerings of a single launch, are not retries
+	if _, unavailable := u.cache.Get(key); !unavailable || history.count == 0 {
+		history.count++
+	}
+	u.failures.SetDefault(key, history)
+	ttl = backoff(ttl, history.count)
+	offeringBackoff.With(history.labels).Set(ttl.Seconds())
+
 	// even if the key is already in the cache, we still need to call Set to extend the cached entry's TTL
 	logging.FromContext(ctx).With(
 		"reason", unavailableReason,
+		"scope", scope,
 		"instance-type", instanceType,
 		"zone", zone,
 		"capacity-type", capacityType,
-		"ttl", UnavailableOfferingsTTL).Debugf("removing offering from offerings")
-	u.cache.SetDefault(u.key(instanceType, zone, capacityType), struct{}{})
+		"failures", history.count,
+		"ttl", ttl).Debugf("marking offerings unavailable")
+	u.cache.Set(key, struct{}{}, ttl)
 	atomic.AddUint64(&u.SeqNum, 1)
 }
 
+// MarkAvailable communicates a successful launch in the provided offering, which resets the backoff of every scope
+// that contains it
+func (u *UnavailableOfferings) MarkAvailable(instanceType, zone, capacityType string) {
+	for _, scope := range []awserrors.UnavailabilityScope{
+		awserrors.OfferingScope,
+		awserrors.FamilyScope,
+		awserrors.CapacityTypeScope,
+		awserrors.AccountScope,
+	} {
+		u.failures.Delete(u.scopedKey(scope, instanceType, zone, capacityType))
+	}
+}
+
 func (u *UnavailableOfferings) MarkUnavailableForFleetErr(ctx context.Context, fleetErr *ec2.CreateFleetError, capacityType string) {
+	classification, ok := awserrors.ClassifyFleetError(fleetErr)
+	// subnet shortages are tracked by the subnets rather than the offerings that were launched into them
+	if !ok || classification.Scope == awserrors.SubnetScope {
+		return
+	}
 	instanceType := aws.StringValue(fleetErr.LaunchTemplateAndOverrides.Overrides.InstanceType)
 	zone := aws.StringValue(fleetErr.LaunchTemplateAndOverrides.Overrides.AvailabilityZone)
-	u.MarkUnavailable(ctx, aws.StringValue(fleetErr.ErrorCode), instanceType, zone, capacityType)
+	u.MarkScopeUnavailable(ctx, aws.StringValue(fleetErr.ErrorCode), classification.Scope, classification.TTL, instanceType, zone, capacityType)
+}
+
+// Entries returns the keys of the unexpired entries with their expiration times
+func (u *UnavailableOfferings) Entries() map[string]time.Time {
+	entries := map[string]time.Time{}
+	for key, item := range u.cache.Items() {
+		entries[key] = time.Unix(0, item.Expiration)
+	}
+	return entries
+}
+
+// Restore adds entries that were previously returned by Entries, keeping their remaining TTLs. Entries that have
+// expired or that are already cached with a later expiration are ignored. It returns true if any entry was added.
+func (u *UnavailableOfferings) Restore(entries map[string]time.Time) bool {
+	now := time.Now()
+	restored := false
+	for key, expiration := range entries {
+		if !expiration.After(now) {
+			continue
+		}
+		if _, cachedExpiration, found := u.cache.GetWithExpiration(key); found && !cachedExpiration.Before(expiration) {
+			continue
+		}
+		u.cache.Set(key, struct{}{}, expiration.Sub(now))
+		restored = true
+	}
+	if restored {
+		atomic.AddUint64(&u.SeqNum, 1)
+	}
+	return restored
 }
 
 func (u *UnavailableOfferings) Delete(instanceType string, zone string, capacityType string) {
@@ -72,9 +174,58 @@ func (u *UnavailableOfferings) Delete(instanceType string, zone string, capacity
 
 func (u *UnavailableOfferings) Flush() {
 	u.cache.Flush()
+	u.failures.Flush()
+	offeringBackoff.Reset()
+}
+
+// backoff grows the ttl exponentially with the number of consecutive failures, adding jitter so that offerings that
+// failed together aren't retried together, up to UnavailableOfferingsMaxTTL
+func backoff(ttl time.Duration, failures int) time.Duration {
+	for i := 1; i < failures && ttl < UnavailableOfferingsMaxTTL; i++ {
+		ttl *= 2
+	}
+	if failures > 1 {
+		ttl = wait.Jitter(ttl, backoffJitter)
+	}
+	if ttl > UnavailableOfferingsMaxTTL {
+		return UnavailableOfferingsMaxTTL
+	}
+	return ttl
 }
 
 // key returns the cache key for all offerings in the cache
 func (u *UnavailableOfferings) key(instanceType string, zone string, capacityType string) string {
 	return fmt.Sprintf("%s:%s:%s", capacityType, instanceType, zone)
 }
+
+// scopedLabels returns the metric labels for the scope that contains the offering
+func (u *UnavailableOfferings) scopedLabels(scope awserrors.UnavailabilityScope, instanceType string, zone string, capacityType string) prometheus.Labels {
+	labels := prometheus.Labels{scopeLabel: string(scope), capacityTypeLabel: "", instanceTypeLabel: "", zoneLabel: ""}
+	switch scope {
+	case awserrors.FamilyScope:
+		labels[capacityTypeLabel] = capacityType
+		labels[instanceTypeLabel] = utils.InstanceFamilyClass(instanceType)
+	case awserrors.CapacityTypeScope:
+		labels[capacityTypeLabel] = capacityType
+	case awserrors.AccountScope:
+	default:
+		labels[capacityTypeLabel] = capacityType
+		labels[instanceTypeLabel] = instanceType
+		labels[zoneLabel] = zone
+	}
+	return labels
+}
+
+// scopedKey returns the cache key for the scope that contains the offering
+func (u *UnavailableOfferings) scopedKey(scope awserrors.UnavailabilityScope, instanceType string, zone string, capacityType string) string {
+	switch scope {
+	case awserrors.FamilyScope:
+		return fmt.Sprintf("%s:%s:%s", scope, capacityType, utils.InstanceFamilyClass(instanceType))
+	case awserrors.CapacityTypeScope:
+		return fmt.Sprintf("%s:%s", scope, capacityType)
+	case awserrors.AccountScope:
+		return string(scope)
+	default:
+		return u.key(instanceType, zone, capacityType)
+	}
+}
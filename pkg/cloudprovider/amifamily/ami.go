@@ -62,6 +62,8 @@ type AMI struct {
 
 const (
 	kubernetesVersionCacheKey = "kubernetesVersion"
+	// LinuxPlatformDetails are the EC2 platform details of Linux AMIs
+	LinuxPlatformDetails = "Linux/UNIX"
 )
 
 func NewAMIProvider(kubeClient client.Client, kubernetesInterface kubernetes.Interface, ssm ssmiface.SSMAPI, ec2api ec2iface.EC2API,
@@ -132,6 +134,28 @@ func (p *AMIProvider) Get(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTem
 	return amiIDs, nil
 }
 
+// PlatformDetails returns the EC2 platform details of the AMIs that instances of the node template are launched with,
+// such as "Linux/UNIX" or "Windows", which instances are priced by. If AMI overrides are specified in the
+// AWSNodeTemplate, then the platform details of the newest AMI are returned.
+func (p *AMIProvider) PlatformDetails(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, amiFamily AMIFamily) (string, error) {
+	if len(nodeTemplate.Spec.AMISelector) == 0 {
+		return amiFamily.PlatformDetails(), nil
+	}
+	images, err := p.fetchAMIsFromEC2(ctx, nodeTemplate.Spec.AMISelector)
+	if err != nil {
+		return "", err
+	}
+	if len(images) == 0 {
+		return "", fmt.Errorf("no amis exist given constraints")
+	}
+	newest := lo.MaxBy(images, func(a *ec2.Image, b *ec2.Image) bool {
+		atime, _ := time.Parse(time.RFC3339, aws.StringValue(a.CreationDate))
+		btime, _ := time.Parse(time.RFC3339, aws.StringValue(b.CreationDate))
+		return atime.After(btime)
+	})
+	return lo.Ternary(aws.StringValue(newest.PlatformDetails) != "", aws.StringValue(newest.PlatformDetails), LinuxPlatformDetails), nil
+}
+
 func (p *AMIProvider) getDefaultAMIFromSSM(ctx context.Context, ssmQuery string) (string, error) {
 	if id, ok := p.ssmCache.Get(ssmQuery); ok {
 		return id.(string), nil
@@ -23,6 +23,7 @@ import (
 	"github.com/samber/lo"
 	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/aws/karpenter/pkg/apis/v1alpha1"
@@ -48,6 +49,8 @@ type Options struct {
 	ClusterName             string
 	ClusterEndpoint         string
 	AWSENILimitedPodDensity bool
+	AWSENIPrefixDelegation  bool
+	AWSENICustomNetworking  bool
 	InstanceProfile         string
 	CABundle                *string `hash:"ignore"`
 	// Level-triggered fields that may change out of sync.
@@ -76,6 +79,7 @@ type AMIFamily interface {
 	DefaultMetadataOptions() *v1alpha1.MetadataOptions
 	EphemeralBlockDevice() *string
 	FeatureFlags() FeatureFlags
+	PlatformDetails() string
 }
 
 // FeatureFlags describes whether the features below are enabled for a given AMIFamily
@@ -88,6 +92,11 @@ type FeatureFlags struct {
 // DefaultFamily provides default values for AMIFamilies that compose it
 type DefaultFamily struct{}
 
+// PlatformDetails returns the EC2 platform details of the AMI family's AMIs, which instances are priced by
+func (d DefaultFamily) PlatformDetails() string {
+	return LinuxPlatformDetails
+}
+
 func (d DefaultFamily) FeatureFlags() FeatureFlags {
 	return FeatureFlags{
 		UsesENILimitedMemoryOverhead: true,
@@ -104,7 +113,8 @@ func New(kubeClient client.Client, amiProvider *AMIProvider) *Resolver {
 }
 
 // Resolve generates launch templates using the static options and dynamically generates launch template parameters.
-// Multiple ResolvedTemplates are returned based on the instanceTypes passed in to support special AMIs for certain instance types like GPUs.
+// Multiple ResolvedTemplates are returned based on the instanceTypes passed in to support special AMIs for certain instance types like GPUs,
+// and to set the max-pods of instance types whose ENI-limited pod density isn't computed correctly by the bootstrap script.
 func (r Resolver) Resolve(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine, instanceTypes []*cloudprovider.InstanceType, options *Options) ([]*LaunchTemplate, error) {
 	amiFamily := GetAMIFamily(nodeTemplate.Spec.AMIFamily, options)
 	amiIDs, err := r.amiProvider.Get(ctx, nodeTemplate, instanceTypes, amiFamily)
@@ -113,33 +123,56 @@ func (r Resolver) Resolve(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTem
 	}
 	var resolvedTemplates []*LaunchTemplate
 	for amiID, instanceTypes := range amiIDs {
-		resolved := &LaunchTemplate{
-			Options: options,
-			UserData: amiFamily.UserData(
-				machine.Spec.Kubelet,
-				append(machine.Spec.Taints, machine.Spec.StartupTaints...),
-				options.Labels,
-				options.CABundle,
-				instanceTypes,
-				nodeTemplate.Spec.UserData,
-			),
-			BlockDeviceMappings: nodeTemplate.Spec.BlockDeviceMappings,
-			MetadataOptions:     nodeTemplate.Spec.MetadataOptions,
-			DetailedMonitoring:  aws.BoolValue(nodeTemplate.Spec.DetailedMonitoring),
-			AMIID:               amiID,
-			InstanceTypes:       instanceTypes,
-		}
-		if resolved.BlockDeviceMappings == nil {
-			resolved.BlockDeviceMappings = amiFamily.DefaultBlockDeviceMappings()
+		for kubeletConfig, instanceTypes := range kubeletConfigurations(machine.Spec.Kubelet, instanceTypes, options) {
+			resolved := &LaunchTemplate{
+				Options: options,
+				UserData: amiFamily.UserData(
+					kubeletConfig,
+					append(machine.Spec.Taints, machine.Spec.StartupTaints...),
+					options.Labels,
+					options.CABundle,
+					instanceTypes,
+					nodeTemplate.Spec.UserData,
+				),
+				BlockDeviceMappings: nodeTemplate.Spec.BlockDeviceMappings,
+				MetadataOptions:     nodeTemplate.Spec.MetadataOptions,
+				DetailedMonitoring:  aws.BoolValue(nodeTemplate.Spec.DetailedMonitoring),
+				AMIID:               amiID,
+				InstanceTypes:       instanceTypes,
+			}
+			if resolved.BlockDeviceMappings == nil {
+				resolved.BlockDeviceMappings = amiFamily.DefaultBlockDeviceMappings()
+			}
+			if resolved.MetadataOptions == nil {
+				resolved.MetadataOptions = amiFamily.DefaultMetadataOptions()
+			}
+			resolvedTemplates = append(resolvedTemplates, resolved)
 		}
-		if resolved.MetadataOptions == nil {
-			resolved.MetadataOptions = amiFamily.DefaultMetadataOptions()
-		}
-		resolvedTemplates = append(resolvedTemplates, resolved)
 	}
 	return resolvedTemplates, nil
 }
 
+// kubeletConfigurations groups the instance types by the kubelet configuration that they're bootstrapped with. The
+// bootstrap scripts compute ENI-limited max-pods assuming that pods are assigned secondary IPv4 addresses on every ENI, so
+// when using prefix delegation or custom networking, max-pods is set explicitly to the pod capacity of each instance type.
+func kubeletConfigurations(kubeletConfig *v1alpha5.KubeletConfiguration, instanceTypes []*cloudprovider.InstanceType,
+	options *Options) map[*v1alpha5.KubeletConfiguration][]*cloudprovider.InstanceType {
+	if !options.AWSENILimitedPodDensity || !(options.AWSENIPrefixDelegation || options.AWSENICustomNetworking) ||
+		(kubeletConfig != nil && kubeletConfig.MaxPods != nil) {
+		return map[*v1alpha5.KubeletConfiguration][]*cloudprovider.InstanceType{kubeletConfig: instanceTypes}
+	}
+	result := map[*v1alpha5.KubeletConfiguration][]*cloudprovider.InstanceType{}
+	for maxPods, instanceTypes := range lo.GroupBy(instanceTypes, func(it *cloudprovider.InstanceType) int64 { return it.Capacity.Pods().Value() }) {
+		kc := &v1alpha5.KubeletConfiguration{}
+		if kubeletConfig != nil {
+			kc = kubeletConfig.DeepCopy()
+		}
+		kc.MaxPods = ptr.Int32(int32(maxPods))
+		result[kc] = instanceTypes
+	}
+	return result
+}
+
 func GetAMIFamily(amiFamily *string, options *Options) AMIFamily {
 	switch aws.StringValue(amiFamily) {
 	case v1alpha1.AMIFamilyBottlerocket:
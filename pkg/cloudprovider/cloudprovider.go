@@ -17,6 +17,7 @@ package cloudprovider
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -27,6 +28,7 @@ import (
 	"github.com/aws/karpenter/pkg/apis/v1alpha1"
 	"github.com/aws/karpenter/pkg/utils"
 
+	provisioningscheduling "github.com/aws/karpenter-core/pkg/controllers/provisioning/scheduling"
 	"github.com/aws/karpenter-core/pkg/scheduling"
 	"github.com/aws/karpenter-core/pkg/utils/resources"
 
@@ -38,6 +40,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/transport"
@@ -52,11 +55,7 @@ import (
 	coreapis "github.com/aws/karpenter-core/pkg/apis"
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/cloudprovider"
-)
-
-const (
-	// MaxInstanceTypes defines the number of instance type options to pass to CreateFleet
-	MaxInstanceTypes = 60
+	"github.com/aws/karpenter-core/pkg/operator/injection"
 )
 
 func init() {
@@ -71,6 +70,9 @@ type CloudProvider struct {
 	instanceProvider     *InstanceProvider
 	kubeClient           k8sClient.Client
 	amiProvider          *amifamily.AMIProvider
+	// launchedHourlyCosts are the hourly costs that instances were launched at by their IDs, until their machines are
+	// hydrated with them
+	launchedHourlyCosts *cache.Cache
 }
 
 func New(ctx awscontext.Context) *CloudProvider {
@@ -80,28 +82,30 @@ func New(ctx awscontext.Context) *CloudProvider {
 	} else {
 		logging.FromContext(ctx).With("kube-dns-ip", kubeDNSIP).Debugf("discovered kube dns")
 	}
-	instanceTypeProvider := NewInstanceTypeProvider(ctx, ctx.Session, ctx.EC2API, ctx.SubnetProvider, ctx.UnavailableOfferingsCache, ctx.StartAsync)
 	amiProvider := amifamily.NewAMIProvider(ctx.KubeClient, ctx.KubernetesInterface, ssm.New(ctx.Session), ctx.EC2API,
 		cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval), cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval), cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval))
+	instanceTypeProvider := NewInstanceTypeProvider(ctx, ctx.Session, ctx.EC2API, ctx.SubnetProvider, amiProvider, ctx.UnavailableOfferingsCache, ctx.MemoryOverhead, ctx.QuotaProvider, ctx.EventRecorder, ctx.StartAsync)
 	amiResolver := amifamily.New(ctx.KubeClient, amiProvider)
 	return &CloudProvider{
 		kubeClient:           ctx.KubeClient,
 		instanceTypeProvider: instanceTypeProvider,
 		amiProvider:          amiProvider,
+		launchedHourlyCosts:  cache.New(awscache.LaunchedHourlyCostTTL, awscache.DefaultCleanupInterval),
 		instanceProvider: NewInstanceProvider(
 			ctx,
 			aws.StringValue(ctx.Session.Config.Region),
 			ctx.EC2API,
 			ctx.UnavailableOfferingsCache,
+			ctx.SpotInterruptionHistory,
 			instanceTypeProvider,
 			ctx.SubnetProvider,
+			ctx.QuotaProvider,
+			ctx.PlacementScoreProvider,
 			NewLaunchTemplateProvider(
-				ctx,
 				ctx.EC2API,
 				amiResolver,
 				ctx.SecurityGroupProvider,
 				lo.Must(getCABundle(ctx.RESTConfig)),
-				ctx.StartAsync,
 				kubeDNSIP,
 			),
 		),
@@ -132,9 +136,84 @@ func (c *CloudProvider) Create(ctx context.Context, machine *v1alpha5.Machine) (
 	if err != nil {
 		return nil, fmt.Errorf("resolving instance details into machine, %w", err)
 	}
+	// The price that the instance was launched at is recorded, as only the current prices are known afterwards, and is
+	// persisted when the machine is hydrated from its node
+	if cost, ok := created.Annotations[v1alpha1.HourlyCostAnnotationKey]; ok {
+		c.launchedHourlyCosts.SetDefault(aws.StringValue(instance.InstanceId), cost)
+	}
 	return created, nil
 }
 
+// FillWarmPool launches the instances that are missing from the warm pool of the node template from the launch
+// templates and instance types of the on-demand machines of the provisioner, so that warm instances are available
+// before the first machine of the node template is launched. Warm instances are tagged with the provisioner, like the
+// instances that are launched for its machines.
+func (c *CloudProvider) FillWarmPool(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, provisioner *v1alpha5.Provisioner) error {
+	ctx = injection.WithNamespacedName(ctx, types.NamespacedName{Name: provisioner.Name})
+	machineTemplate := provisioningscheduling.NewMachineTemplate(provisioner)
+	if !machineTemplate.Requirements.Get(v1alpha5.LabelCapacityType).Has(v1alpha5.CapacityTypeOnDemand) {
+		return nil
+	}
+	machineTemplate.Requirements.Add(scheduling.NewRequirement(v1alpha5.LabelCapacityType, v1.NodeSelectorOpIn, v1alpha5.CapacityTypeOnDemand))
+	instanceTypes, err := c.GetInstanceTypes(ctx, provisioner)
+	if err != nil {
+		return fmt.Errorf("getting instance types, %w", err)
+	}
+	machineTemplate.InstanceTypeOptions = lo.Filter(instanceTypes, func(i *cloudprovider.InstanceType, _ int) bool {
+		return machineTemplate.Requirements.Compatible(i.Requirements) == nil &&
+			len(i.Offerings.Requirements(machineTemplate.Requirements).Available()) > 0
+	})
+	if len(machineTemplate.InstanceTypeOptions) == 0 {
+		return fmt.Errorf("no on-demand instance types of provisioner %s are available", provisioner.Name)
+	}
+	return c.instanceProvider.fillWarmPool(ctx, nodeTemplate, machineTemplate.ToMachine(provisioner), machineTemplate.InstanceTypeOptions)
+}
+
+// ResolveLaunchTemplates returns the names of the launch templates, and the versions of versioned launch templates, that
+// the machines of the provisioner would be launched from now for each capacity type that it allows, including the
+// launch templates of the warm instances of its node template. Machines whose pods add labels to the provisioner's may
+// resolve other launch templates, which are kept while they're resolved for launches.
+func (c *CloudProvider) ResolveLaunchTemplates(ctx context.Context, provisioner *v1alpha5.Provisioner) (sets.String, error) {
+	instanceTypes, err := c.GetInstanceTypes(ctx, provisioner)
+	if err != nil {
+		return nil, fmt.Errorf("getting instance types, %w", err)
+	}
+	names := sets.NewString()
+	for _, capacityType := range []string{v1alpha5.CapacityTypeSpot, v1alpha5.CapacityTypeOnDemand} {
+		machineTemplate := provisioningscheduling.NewMachineTemplate(provisioner)
+		if !machineTemplate.Requirements.Get(v1alpha5.LabelCapacityType).Has(capacityType) {
+			continue
+		}
+		machineTemplate.Requirements.Add(scheduling.NewRequirement(v1alpha5.LabelCapacityType, v1.NodeSelectorOpIn, capacityType))
+		machineTemplate.InstanceTypeOptions = lo.Filter(instanceTypes, func(i *cloudprovider.InstanceType, _ int) bool {
+			return machineTemplate.Requirements.Compatible(i.Requirements) == nil &&
+				len(i.Offerings.Requirements(machineTemplate.Requirements).Available()) > 0
+		})
+		if len(machineTemplate.InstanceTypeOptions) == 0 {
+			continue
+		}
+		machine := machineTemplate.ToMachine(provisioner)
+		nodeTemplate, err := c.resolveNodeTemplate(ctx, []byte(machine.
+			Annotations[v1alpha5.ProviderCompatabilityAnnotationKey]), machine.
+			Spec.MachineTemplateRef)
+		if err != nil {
+			return nil, fmt.Errorf("resolving node template, %w", err)
+		}
+		machines := []*v1alpha5.Machine{machine}
+		if capacityType == v1alpha5.CapacityTypeOnDemand && nodeTemplate.Spec.WarmPool != nil {
+			machines = append(machines, warmMachine(machine))
+		}
+		for _, m := range machines {
+			resolved, err := c.LaunchTemplateProvider().Resolve(ctx, nodeTemplate, m, machineTemplate.InstanceTypeOptions, map[string]string{v1alpha5.LabelCapacityType: capacityType})
+			if err != nil {
+				return nil, fmt.Errorf("resolving launch templates, %w", err)
+			}
+			names.Insert(resolved.UnsortedList()...)
+		}
+	}
+	return names, nil
+}
+
 // TODO @joinnis: Remove provisionerName from this call signature once we decouple provisioner from GetInstanceTypes
 func (c *CloudProvider) Get(ctx context.Context, machineName, provisionerName string) (*v1alpha5.Machine, error) {
 	provisioner := &v1alpha5.Provisioner{}
@@ -167,6 +246,11 @@ func (c *CloudProvider) LivenessProbe(req *http.Request) error {
 	return nil
 }
 
+// ReadinessProbe fails if the prices are stale, which is opt-in with aws.pricingStalenessThreshold
+func (c *CloudProvider) ReadinessProbe(req *http.Request) error {
+	return c.instanceTypeProvider.ReadinessProbe(req)
+}
+
 // GetInstanceTypes returns all available InstanceTypes
 func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provisioner *v1alpha5.Provisioner) ([]*cloudprovider.InstanceType, error) {
 	var rawProvider []byte
@@ -217,7 +301,7 @@ func (c *CloudProvider) IsMachineDrifted(ctx context.Context, machine *v1alpha5.
 }
 
 // Hydrate updates an existing instance by making sure that the machine associated with the instance
-// has the corresponding tag value for that machine
+// has the corresponding tag value for that machine, and that the instance has the tags of its node template
 func (c *CloudProvider) Hydrate(ctx context.Context, machine *v1alpha5.Machine) error {
 	instanceID, err := utils.ParseInstanceID(machine.Status.ProviderID)
 	if err != nil {
@@ -227,6 +311,10 @@ func (c *CloudProvider) Hydrate(ctx context.Context, machine *v1alpha5.Machine)
 	if err != nil {
 		return fmt.Errorf("getting instance for instanceID '%s', %w", instanceID, err)
 	}
+	// Nodes that warm instances register while they're initialized don't belong to machines
+	if IsWarmInstance(instance) {
+		return cloudprovider.NewMachineNotFoundError(fmt.Errorf("instance %s is in a warm pool", instanceID))
+	}
 	// Update the machine with the name stored in the tag value of the instance if it exists
 	// We do this so that when we create the Machine after hydration, the machine-controller is aware
 	// that the instance already exists, so it doesn't create another one
@@ -234,19 +322,89 @@ func (c *CloudProvider) Hydrate(ctx context.Context, machine *v1alpha5.Machine)
 		return aws.StringValue(tag.Key) == v1alpha5.MachineNameLabelKey
 	}); ok {
 		machine.Name = aws.StringValue(tag.Value)
-		// If the instance has both machine-name and cluster-name, no need to update
-		if _, ok = lo.Find(instance.Tags, func(tag *ec2.Tag) bool {
-			return aws.StringValue(tag.Key) == fmt.Sprintf("kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName)
-		}); ok {
-			return nil
+	}
+	c.annotateHourlyCost(machine, instance)
+	return c.updateInstanceTags(ctx, machine, instance)
+}
+
+// annotateHourlyCost annotates the machine with the hourly cost that its instance was launched at, as the machine that
+// is returned when an instance is launched isn't persisted. Instances that were launched before a restart are priced
+// with the current price of their offering.
+func (c *CloudProvider) annotateHourlyCost(machine *v1alpha5.Machine, instance *ec2.Instance) {
+	if _, ok := machine.Annotations[v1alpha1.HourlyCostAnnotationKey]; ok {
+		return
+	}
+	var annotation string
+	if launched, ok := c.launchedHourlyCosts.Get(aws.StringValue(instance.InstanceId)); ok {
+		annotation = launched.(string)
+	} else if instance.Placement != nil {
+		cost, ok := c.PricingProvider().HourlyCost(aws.StringValue(instance.InstanceType), aws.StringValue(instance.Placement.AvailabilityZone), getCapacityType(instance))
+		if !ok {
+			return
 		}
+		annotation = string(lo.Must(json.Marshal(cost)))
+	} else {
+		return
+	}
+	machine.Annotations = lo.Assign(machine.Annotations, map[string]string{v1alpha1.HourlyCostAnnotationKey: annotation})
+}
+
+// UpdateInstanceTags tags the instance of an existing machine with the tags of its node template that are rendered for
+// the machine or propagated from its labels, which may have changed since the instance was launched
+func (c *CloudProvider) UpdateInstanceTags(ctx context.Context, machine *v1alpha5.Machine) error {
+	instanceID, err := utils.ParseInstanceID(machine.Status.ProviderID)
+	if err != nil {
+		return fmt.Errorf("parsing instance id, %w", err)
 	}
-	if _, err = c.instanceProvider.Update(ctx, machine); err != nil {
+	instance, err := c.instanceProvider.GetByID(ctx, instanceID)
+	if err != nil {
+		return fmt.Errorf("getting instance for instanceID '%s', %w", instanceID, err)
+	}
+	return c.updateInstanceTags(ctx, machine, instance)
+}
+
+func (c *CloudProvider) updateInstanceTags(ctx context.Context, machine *v1alpha5.Machine, instance *ec2.Instance) error {
+	// Instances of node templates that were deleted are still tagged with the machine name and the global tags
+	nodeTemplate, err := NodeTemplate(ctx, c.kubeClient, machine)
+	if k8sClient.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("resolving node template, %w", err)
+	}
+	if err != nil {
+		nodeTemplate = &v1alpha1.AWSNodeTemplate{}
+	}
+	tags, err := c.instanceProvider.updateTags(ctx, nodeTemplate, machine, instance)
+	if err != nil {
+		return err
+	}
+	// If the instance already has all of the tags, such as the machine-name and cluster-name, no need to update
+	if lo.EveryBy(tags, func(tag *ec2.Tag) bool {
+		return lo.ContainsBy(instance.Tags, func(t *ec2.Tag) bool {
+			return aws.StringValue(t.Key) == aws.StringValue(tag.Key) && aws.StringValue(t.Value) == aws.StringValue(tag.Value)
+		})
+	}) {
+		return nil
+	}
+	if _, err = c.instanceProvider.Update(ctx, nodeTemplate, machine, instance); err != nil {
 		return fmt.Errorf("updating instance, %w", err)
 	}
 	return nil
 }
 
+// LaunchTemplateProvider returns the provider of the launch templates that instances are launched from
+func (c *CloudProvider) LaunchTemplateProvider() *LaunchTemplateProvider {
+	return c.instanceProvider.launchTemplateProvider
+}
+
+// InstanceTypeProvider returns the provider of the instance types and their offerings
+func (c *CloudProvider) InstanceTypeProvider() *InstanceTypeProvider {
+	return c.instanceTypeProvider
+}
+
+// PricingProvider returns the provider of the prices of the offerings of instance types
+func (c *CloudProvider) PricingProvider() *PricingProvider {
+	return c.instanceTypeProvider.pricingProvider
+}
+
 // Name returns the CloudProvider implementation name.
 func (c *CloudProvider) Name() string {
 	return "aws"
@@ -299,6 +457,50 @@ func (c *CloudProvider) resolveNodeTemplate(ctx context.Context, raw []byte, obj
 	return nodeTemplate, nil
 }
 
+// NodeTemplate returns the node template of an existing machine, which the machine references or which is serialized
+// in its annotations for provisioners with inline providers. Machines that were hydrated from nodes may have neither,
+// in which case the node template of their provisioner is returned, or an empty node template if they have no
+// provisioner.
+func NodeTemplate(ctx context.Context, kubeClient k8sClient.Client, machine *v1alpha5.Machine) (*v1alpha1.AWSNodeTemplate, error) {
+	nodeTemplate := &v1alpha1.AWSNodeTemplate{}
+	if machine.Spec.MachineTemplateRef != nil {
+		if err := kubeClient.Get(ctx, types.NamespacedName{Name: machine.Spec.MachineTemplateRef.Name}, nodeTemplate); err != nil {
+			return nil, fmt.Errorf("getting providerRef, %w", err)
+		}
+		return nodeTemplate, nil
+	}
+	if raw, ok := machine.Annotations[v1alpha5.ProviderCompatabilityAnnotationKey]; ok {
+		aws, err := v1alpha1.DeserializeProvider([]byte(raw))
+		if err != nil {
+			return nil, err
+		}
+		nodeTemplate.Spec.AWS = lo.FromPtr(aws)
+		return nodeTemplate, nil
+	}
+	provisionerName, ok := machine.Labels[v1alpha5.ProvisionerNameLabelKey]
+	if !ok {
+		return nodeTemplate, nil
+	}
+	provisioner := &v1alpha5.Provisioner{}
+	if err := kubeClient.Get(ctx, types.NamespacedName{Name: provisionerName}, provisioner); err != nil {
+		return nil, fmt.Errorf("getting provisioner, %w", err)
+	}
+	if provisioner.Spec.ProviderRef != nil {
+		if err := kubeClient.Get(ctx, types.NamespacedName{Name: provisioner.Spec.ProviderRef.Name}, nodeTemplate); err != nil {
+			return nil, fmt.Errorf("getting providerRef, %w", err)
+		}
+		return nodeTemplate, nil
+	}
+	if provisioner.Spec.Provider != nil {
+		aws, err := v1alpha1.DeserializeProvider(provisioner.Spec.Provider.Raw)
+		if err != nil {
+			return nil, err
+		}
+		nodeTemplate.Spec.AWS = lo.FromPtr(aws)
+	}
+	return nodeTemplate, nil
+}
+
 func (c *CloudProvider) resolveInstanceTypes(ctx context.Context, machine *v1alpha5.Machine) ([]*cloudprovider.InstanceType, error) {
 	provisionerName, ok := machine.Labels[v1alpha5.ProvisionerNameLabelKey]
 	if !ok {
@@ -347,6 +549,14 @@ func (c *CloudProvider) instanceToMachine(ctx context.Context, instance *ec2.Ins
 		strings.ToLower(aws.StringValue(instance.PrivateDnsName)),
 	)
 	machine.Labels = labels
+	if offering, ok := instanceType.Offerings.Get(labels[v1alpha5.LabelCapacityType], labels[v1.LabelTopologyZone]); ok {
+		machine.Annotations = lo.Assign(machine.Annotations, map[string]string{v1alpha1.HourlyCostAnnotationKey: string(lo.Must(json.Marshal(HourlyCost{
+			Price:        offering.Price,
+			CapacityType: offering.CapacityType,
+			Zone:         offering.Zone,
+			PricedAt:     c.PricingProvider().LastUpdated(offering.CapacityType),
+		})))})
+	}
 	machine.Status.ProviderID = fmt.Sprintf("aws:///%s/%s", aws.StringValue(instance.Placement.AvailabilityZone), aws.StringValue(instance.InstanceId))
 
 	machine.Status.Capacity = v1.ResourceList{}
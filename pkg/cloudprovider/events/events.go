@@ -0,0 +1,35 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter-core/pkg/events"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+)
+
+func VCPUQuotaExceeded(nodeTemplate *v1alpha1.AWSNodeTemplate, capacityType, instanceFamilyClass string, headroom int64) events.Event {
+	return events.Event{
+		InvolvedObject: nodeTemplate,
+		Type:           v1.EventTypeWarning,
+		Reason:         "VCPUQuotaExceeded",
+		Message: fmt.Sprintf("AWSNodeTemplate %s event: Instance types of the %s instance family class with more than %d vCPUs are unavailable for %s capacity, as they would exceed the vCPU quota",
+			nodeTemplate.Name, instanceFamilyClass, headroom, capacityType),
+		DedupeValues: []string{nodeTemplate.Name, capacityType, instanceFamilyClass},
+	}
+}
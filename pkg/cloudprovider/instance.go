@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/avast/retry-go"
@@ -30,6 +31,7 @@ import (
 	"github.com/samber/lo"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
 
@@ -38,19 +40,23 @@ import (
 	"github.com/aws/karpenter/pkg/batcher"
 	"github.com/aws/karpenter/pkg/cache"
 	awserrors "github.com/aws/karpenter/pkg/errors"
+	"github.com/aws/karpenter/pkg/providers/placementscore"
+	"github.com/aws/karpenter/pkg/providers/quota"
 	"github.com/aws/karpenter/pkg/providers/subnet"
 	"github.com/aws/karpenter/pkg/utils"
 
-	"github.com/aws/karpenter-core/pkg/utils/resources"
-
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/operator/injection"
 	"github.com/aws/karpenter-core/pkg/scheduling"
 )
 
 var (
 	instanceTypeFlexibilityThreshold = 5 // falling back to on-demand without flexibility risks insufficient capacity errors
 
+	maxSubnetsPerZone = 4   // bounds the subnets that each offering is launched into
+	maxFleetOverrides = 300 // bounds the overrides of a request, as CreateFleet limits request and maintain fleets to 300
+
 	instanceStateFilter = &ec2.Filter{
 		Name:   aws.String("instance-state-name"),
 		Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped}),
@@ -61,29 +67,47 @@ type InstanceProvider struct {
 	region                 string
 	ec2api                 ec2iface.EC2API
 	unavailableOfferings   *cache.UnavailableOfferings
+	spotInterruptions      *cache.SpotInterruptionHistory
 	instanceTypeProvider   *InstanceTypeProvider
 	subnetProvider         *subnet.Provider
+	quotaProvider          *quota.Provider
+	placementScoreProvider *placementscore.Provider
 	launchTemplateProvider *LaunchTemplateProvider
 	ec2Batcher             *batcher.EC2API
+	// key: warm instance id, value: struct{}{}
+	claimedWarmInstances sync.Map
+	// key: node template name, value: struct{}{}
+	warmPoolRefills sync.Map
 }
 
-func NewInstanceProvider(ctx context.Context, region string, ec2api ec2iface.EC2API, unavailableOfferings *cache.UnavailableOfferings, instanceTypeProvider *InstanceTypeProvider, subnetProvider *subnet.Provider, launchTemplateProvider *LaunchTemplateProvider) *InstanceProvider {
+func NewInstanceProvider(ctx context.Context, region string, ec2api ec2iface.EC2API, unavailableOfferings *cache.UnavailableOfferings, spotInterruptions *cache.SpotInterruptionHistory,
+	instanceTypeProvider *InstanceTypeProvider, subnetProvider *subnet.Provider, quotaProvider *quota.Provider, placementScoreProvider *placementscore.Provider, launchTemplateProvider *LaunchTemplateProvider) *InstanceProvider {
 	return &InstanceProvider{
 		region:                 region,
 		ec2api:                 ec2api,
 		unavailableOfferings:   unavailableOfferings,
+		spotInterruptions:      spotInterruptions,
 		instanceTypeProvider:   instanceTypeProvider,
 		subnetProvider:         subnetProvider,
+		quotaProvider:          quotaProvider,
+		placementScoreProvider: placementScoreProvider,
 		launchTemplateProvider: launchTemplateProvider,
 		ec2Batcher:             batcher.EC2(ctx, ec2api),
 	}
 }
 
 func (p *InstanceProvider) Create(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine, instanceTypes []*cloudprovider.InstanceType) (*ec2.Instance, error) {
-	instanceTypes = p.filterInstanceTypes(machine, instanceTypes)
-	instanceTypes = orderInstanceTypesByPrice(instanceTypes, scheduling.NewNodeSelectorRequirements(machine.Spec.Requirements...))
-	if len(instanceTypes) > MaxInstanceTypes {
-		instanceTypes = instanceTypes[0:MaxInstanceTypes]
+	instanceTypes = p.candidateInstanceTypes(ctx, machine, instanceTypes)
+	if nodeTemplate.Spec.WarmPool != nil {
+		instance, err := p.startWarmInstance(ctx, nodeTemplate, machine, instanceTypes)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("starting warm instance, %s", err)
+		}
+		if instance != nil {
+			// The warm pool is only refilled once one of its instances was used
+			p.refillWarmPool(ctx, nodeTemplate, machine, instanceTypes)
+			return instance, nil
+		}
 	}
 
 	id, err := p.launchInstance(ctx, nodeTemplate, machine, instanceTypes)
@@ -105,6 +129,7 @@ func (p *InstanceProvider) Create(ctx context.Context, nodeTemplate *v1alpha1.AW
 	); err != nil {
 		return nil, fmt.Errorf("retrieving node name for instance %s, %w", aws.StringValue(id), err)
 	}
+	p.tagLaunchedInstance(ctx, nodeTemplate, machine, instance)
 	logging.FromContext(ctx).With(
 		"id", aws.StringValue(instance.InstanceId),
 		"hostname", aws.StringValue(instance.PrivateDnsName),
@@ -220,7 +245,14 @@ func (p *InstanceProvider) launchInstance(ctx context.Context, nodeTemplate *v1a
 		logging.FromContext(ctx).Warn(err.Error())
 	}
 	// Create fleet
-	tags := v1alpha1.MergeTags(ctx, settings.FromContext(ctx).Tags, nodeTemplate.Spec.Tags, map[string]string{
+	data := v1alpha1.NewTagData(machine)
+	data.CapacityType = capacityType
+	data.Labels[v1alpha5.LabelCapacityType] = capacityType
+	instanceTags, err := renderInstanceTags(ctx, nodeTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+	tags := v1alpha1.MergeTags(ctx, instanceTags, map[string]string{
 		fmt.Sprintf("kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName): "owned",
 	})
 	createFleetInput := &ec2.CreateFleetInput{
@@ -261,9 +293,45 @@ func (p *InstanceProvider) launchInstance(ctx context.Context, nodeTemplate *v1a
 	if len(createFleetOutput.Instances) == 0 || len(createFleetOutput.Instances[0].InstanceIds) == 0 {
 		return nil, combineFleetErrors(createFleetOutput.Errors)
 	}
+	p.reserveIPs(ctx, instanceTypes, createFleetOutput.Instances[0])
+	p.reserveQuota(capacityType, instanceTypes, createFleetOutput.Instances[0])
+	p.resetUnavailableOfferingsBackoff(capacityType, createFleetOutput.Instances[0])
 	return createFleetOutput.Instances[0].InstanceIds[0], nil
 }
 
+// resetUnavailableOfferingsBackoff resets the backoff of the offering that was launched, as it evidently has capacity
+func (p *InstanceProvider) resetUnavailableOfferingsBackoff(capacityType string, instance *ec2.CreateFleetInstance) {
+	if instance.LaunchTemplateAndOverrides == nil || instance.LaunchTemplateAndOverrides.Overrides == nil {
+		return
+	}
+	overrides := instance.LaunchTemplateAndOverrides.Overrides
+	p.unavailableOfferings.MarkAvailable(aws.StringValue(overrides.InstanceType), aws.StringValue(overrides.AvailabilityZone), capacityType)
+}
+
+// reserveIPs reserves the IP addresses that the launched instance is predicted to consume in its subnet so that
+// subsequent launches account for them before the subnet's available IP addresses are described again
+func (p *InstanceProvider) reserveIPs(ctx context.Context, instanceTypes []*cloudprovider.InstanceType, instance *ec2.CreateFleetInstance) {
+	if instance.LaunchTemplateAndOverrides == nil || instance.LaunchTemplateAndOverrides.Overrides == nil {
+		return
+	}
+	overrides := instance.LaunchTemplateAndOverrides.Overrides
+	if instanceType, ok := lo.Find(instanceTypes, func(it *cloudprovider.InstanceType) bool { return it.Name == aws.StringValue(overrides.InstanceType) }); ok {
+		p.subnetProvider.ReserveIPs(aws.StringValue(overrides.SubnetId), launchIPs(ctx, instanceType))
+	}
+}
+
+// reserveQuota reserves the vCPUs of the launched instance in its vCPU quota so that subsequent launches account for
+// them before the running instances are described again
+func (p *InstanceProvider) reserveQuota(capacityType string, instanceTypes []*cloudprovider.InstanceType, instance *ec2.CreateFleetInstance) {
+	if instance.LaunchTemplateAndOverrides == nil || instance.LaunchTemplateAndOverrides.Overrides == nil {
+		return
+	}
+	instanceTypeName := aws.StringValue(instance.LaunchTemplateAndOverrides.Overrides.InstanceType)
+	if instanceType, ok := lo.Find(instanceTypes, func(it *cloudprovider.InstanceType) bool { return it.Name == instanceTypeName }); ok {
+		p.quotaProvider.Reserve(capacityType, instanceTypeName, instanceType.Capacity.Cpu().Value())
+	}
+}
+
 func (p *InstanceProvider) checkODFallback(machine *v1alpha5.Machine, instanceTypes []*cloudprovider.InstanceType, launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest) error {
 	// only evaluate for on-demand fallback if the capacity type for the request is OD and both OD and spot are allowed in requirements
 	if p.getCapacityType(machine, instanceTypes) != v1alpha5.CapacityTypeOnDemand || !scheduling.NewNodeSelectorRequirements(machine.Spec.Requirements...).Get(v1alpha5.LabelCapacityType).Has(v1alpha5.CapacityTypeSpot) {
@@ -290,30 +358,44 @@ func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, nodeTem
 	instanceTypes []*cloudprovider.InstanceType, capacityType string) ([]*ec2.FleetLaunchTemplateConfigRequest, error) {
 
 	// Get subnets given the constraints
-	subnets, err := p.subnetProvider.List(ctx, nodeTemplate)
+	zonalSubnets, err := p.subnetProvider.ZonalSubnets(ctx, nodeTemplate)
 	if err != nil {
 		return nil, fmt.Errorf("getting subnets, %w", err)
 	}
-	if len(subnets) == 0 {
+	if len(zonalSubnets) == 0 {
 		return nil, fmt.Errorf("no subnets matched selector %v", nodeTemplate.Spec.SubnetSelector)
 	}
-	var launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest
 	launchTemplates, err := p.launchTemplateProvider.EnsureAll(ctx, nodeTemplate, machine, instanceTypes, map[string]string{v1alpha5.LabelCapacityType: capacityType})
 	if err != nil {
 		return nil, fmt.Errorf("getting launch templates, %w", err)
 	}
-	for launchTemplateName, instanceTypes := range launchTemplates {
-		launchTemplateConfig := &ec2.FleetLaunchTemplateConfigRequest{
-			Overrides: p.getOverrides(instanceTypes, subnets, scheduling.NewNodeSelectorRequirements(machine.Spec.Requirements...).Get(v1.LabelTopologyZone), capacityType),
-			LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
-				LaunchTemplateName: aws.String(launchTemplateName),
-				Version:            aws.String("$Latest"),
-			},
+	zones := scheduling.NewNodeSelectorRequirements(machine.Spec.Requirements...).Get(v1.LabelTopologyZone)
+	var placementScores map[string]int64
+	if capacityType == v1alpha5.CapacityTypeSpot && settings.FromContext(ctx).MinSpotPlacementScore > 0 {
+		placementScores = p.placementScoreProvider.Scores(machine.Labels[v1alpha5.ProvisionerNameLabelKey],
+			lo.Map(instanceTypes, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })...)
+	}
+	// Offerings are launched into fewer subnets of their zones while the overrides exceed the bound of a request
+	var launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest
+	for subnetsPerZone := maxSubnetsPerZone; subnetsPerZone > 0; subnetsPerZone-- {
+		launchTemplateConfigs = nil
+		for launchTemplate, instanceTypes := range launchTemplates {
+			launchTemplateConfig := &ec2.FleetLaunchTemplateConfigRequest{
+				Overrides: p.getOverrides(ctx, instanceTypes, zonalSubnets, zones, capacityType, subnetsPerZone, placementScores),
+				LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+					LaunchTemplateName: aws.String(launchTemplate.Name),
+					Version:            aws.String(launchTemplate.Version),
+				},
+			}
+			if len(launchTemplateConfig.Overrides) > 0 {
+				launchTemplateConfigs = append(launchTemplateConfigs, launchTemplateConfig)
+			}
 		}
-		if len(launchTemplateConfig.Overrides) > 0 {
-			launchTemplateConfigs = append(launchTemplateConfigs, launchTemplateConfig)
+		if countOverrides(launchTemplateConfigs) <= maxFleetOverrides {
+			break
 		}
 	}
+	launchTemplateConfigs = truncateOverrides(launchTemplateConfigs, maxFleetOverrides)
 	if len(launchTemplateConfigs) == 0 {
 		return nil, fmt.Errorf("no capacity offerings are currently available given the constraints")
 	}
@@ -321,21 +403,19 @@ func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, nodeTem
 }
 
 // getOverrides creates and returns launch template overrides for the cross product of InstanceTypes and subnets (with subnets being constrained by
-// zones and the offerings in InstanceTypes)
-func (p *InstanceProvider) getOverrides(instanceTypes []*cloudprovider.InstanceType, subnets []*ec2.Subnet, zones *scheduling.Requirement, capacityType string) []*ec2.FleetLaunchTemplateOverridesRequest {
-	// sort subnets in ascending order of available IP addresses and populate map with most available subnet per AZ
-	zonalSubnets := map[string]*ec2.Subnet{}
-	sort.Slice(subnets, func(i, j int) bool {
-		return aws.Int64Value(subnets[i].AvailableIpAddressCount) < aws.Int64Value(subnets[j].AvailableIpAddressCount)
-	})
-	for _, subnet := range subnets {
-		zonalSubnets[*subnet.AvailabilityZone] = subnet
-	}
-
+// zones and the offerings in InstanceTypes). Each offering is launched into any of the subnets of its zone with the most available IP addresses,
+// up to subnetsPerZone of them, that aren't predicted to run out of IP addresses for the instance type. Spot offerings in zones where the spot placement score
+// of the provisioner is below the configured minimum, or with an interruption rate above the configured threshold, are excluded as well.
+// Unlike weighting subnets with override priorities derived from their available IP addresses, no priorities are set. CreateFleet ignores
+// priorities under the price-capacity-optimized spot and lowest-price on-demand allocation strategies, and the prioritized strategies that
+// honor them would rank the overrides by subnet rather than by price. Instead, every subnet that can fit the instance is offered, and zonal
+// subnets are sorted by available IP addresses in descending order so that those with the most are offered when there are more than the bound.
+func (p *InstanceProvider) getOverrides(ctx context.Context, instanceTypes []*cloudprovider.InstanceType, zonalSubnets map[string][]*ec2.Subnet, zones *scheduling.Requirement, capacityType string, subnetsPerZone int, placementScores map[string]int64) []*ec2.FleetLaunchTemplateOverridesRequest {
 	// Unwrap all the offerings to a flat slice that includes a pointer
 	// to the parent instance type name
 	type offeringWithParentName struct {
 		cloudprovider.Offering
+		parentInstanceType     *cloudprovider.InstanceType
 		parentInstanceTypeName string
 	}
 	var unwrappedOfferings []offeringWithParentName
@@ -343,63 +423,102 @@ func (p *InstanceProvider) getOverrides(instanceTypes []*cloudprovider.InstanceT
 		ofs := lo.Map(it.Offerings.Available(), func(of cloudprovider.Offering, _ int) offeringWithParentName {
 			return offeringWithParentName{
 				Offering:               of,
+				parentInstanceType:     it,
 				parentInstanceTypeName: it.Name,
 			}
 		})
 		unwrappedOfferings = append(unwrappedOfferings, ofs...)
 	}
+	unwrappedOfferings = lo.Filter(unwrappedOfferings, func(offering offeringWithParentName, _ int) bool {
+		return capacityType == offering.CapacityType && zones.Has(offering.Zone)
+	})
+	// Spot offerings that are unlikely to be fulfilled are excluded, unless no other offerings remain
+	if minScore := settings.FromContext(ctx).MinSpotPlacementScore; capacityType == v1alpha5.CapacityTypeSpot && minScore > 0 {
+		if scored := lo.Filter(unwrappedOfferings, func(offering offeringWithParentName, _ int) bool {
+			score, ok := placementScores[offering.Zone]
+			return !ok || score >= minScore
+		}); len(scored) > 0 {
+			unwrappedOfferings = scored
+		}
+	}
+	// Spot offerings that were frequently interrupted are excluded, unless no other offerings remain
+	if maxRate := settings.FromContext(ctx).SpotInterruptionRateThreshold; capacityType == v1alpha5.CapacityTypeSpot && maxRate > 0 {
+		if reliable := lo.Filter(unwrappedOfferings, func(offering offeringWithParentName, _ int) bool {
+			rate, ok := p.spotInterruptions.Rate(offering.parentInstanceTypeName, offering.Zone)
+			return !ok || rate <= maxRate
+		}); len(reliable) > 0 {
+			unwrappedOfferings = reliable
+		}
+	}
 
 	var overrides []*ec2.FleetLaunchTemplateOverridesRequest
 	for _, offering := range unwrappedOfferings {
-		if capacityType != offering.CapacityType {
-			continue
-		}
-		if !zones.Has(offering.Zone) {
-			continue
+		subnets := lo.Filter(zonalSubnets[offering.Zone], func(subnet *ec2.Subnet, _ int) bool {
+			return p.subnetProvider.AvailableIPAddressCount(subnet) >= launchIPs(ctx, offering.parentInstanceType)
+		})
+		if len(subnets) > subnetsPerZone {
+			subnets = subnets[:subnetsPerZone]
 		}
-		subnet, ok := zonalSubnets[offering.Zone]
-		if !ok {
-			continue
+		for _, subnet := range subnets {
+			overrides = append(overrides, &ec2.FleetLaunchTemplateOverridesRequest{
+				InstanceType: aws.String(offering.parentInstanceTypeName),
+				SubnetId:     subnet.SubnetId,
+				// This is technically redundant, but is useful if we have to parse insufficient capacity errors from
+				// CreateFleet so that we can figure out the zone rather than additional API calls to look up the subnet
+				AvailabilityZone: subnet.AvailabilityZone,
+			})
 		}
-		overrides = append(overrides, &ec2.FleetLaunchTemplateOverridesRequest{
-			InstanceType: aws.String(offering.parentInstanceTypeName),
-			SubnetId:     subnet.SubnetId,
-			// This is technically redundant, but is useful if we have to parse insufficient capacity errors from
-			// CreateFleet so that we can figure out the zone rather than additional API calls to look up the subnet
-			AvailabilityZone: subnet.AvailabilityZone,
-		})
 	}
 	return overrides
 }
 
-// Update receives a machine and updates the EC2 instance with tags linking it to the machine
+func countOverrides(launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest) int {
+	return lo.SumBy(launchTemplateConfigs, func(launchTemplateConfig *ec2.FleetLaunchTemplateConfigRequest) int {
+		return len(launchTemplateConfig.Overrides)
+	})
+}
+
+// truncateOverrides limits the overrides of the launch template configs to maxOverrides, keeping the same share of
+// the overrides of each launch template config. The overrides of the last instance types are dropped, which are the
+// most expensive ones as instance types are ordered by price.
+func truncateOverrides(launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest, maxOverrides int) []*ec2.FleetLaunchTemplateConfigRequest {
+	total := countOverrides(launchTemplateConfigs)
+	if total <= maxOverrides {
+		return launchTemplateConfigs
+	}
+	for _, launchTemplateConfig := range launchTemplateConfigs {
+		launchTemplateConfig.Overrides = launchTemplateConfig.Overrides[:len(launchTemplateConfig.Overrides)*maxOverrides/total]
+	}
+	return lo.Filter(launchTemplateConfigs, func(launchTemplateConfig *ec2.FleetLaunchTemplateConfigRequest, _ int) bool {
+		return len(launchTemplateConfig.Overrides) > 0
+	})
+}
+
+// Update receives a machine and updates the EC2 instance with tags linking it to the machine, and with the tags of the
+// node template that are rendered for the machine or propagated from its labels, which may have changed since the
+// instance was launched
 // Deprecated: This function can be removed when v1alpha6/v1beta1 migration has completed.
-func (p *InstanceProvider) Update(ctx context.Context, machine *v1alpha5.Machine) (*ec2.Instance, error) {
-	_, err := p.ec2api.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+func (p *InstanceProvider) Update(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine, instance *ec2.Instance) (*ec2.Instance, error) {
+	tags, err := p.updateTags(ctx, nodeTemplate, machine, instance)
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.ec2api.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
 		Resources: aws.StringSlice([]string{lo.Must(utils.ParseInstanceID(machine.Status.ProviderID))}),
-		Tags: []*ec2.Tag{
-			{
-				Key:   aws.String(v1alpha5.MachineNameLabelKey),
-				Value: aws.String(machine.Name),
-			},
-			{
-				Key:   aws.String(fmt.Sprintf("kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName)),
-				Value: aws.String("owned"),
-			},
-		},
+		Tags:      tags,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("updating tags for instance, %w", err)
 	}
 	// Get Instance with backoff retry since EC2 is eventually consistent
-	var instance *ec2.Instance
+	var updated *ec2.Instance
 	if err = retry.Do(
 		func() error {
-			instance, err = p.GetByID(ctx, lo.Must(utils.ParseInstanceID(machine.Status.ProviderID)))
+			updated, err = p.GetByID(ctx, lo.Must(utils.ParseInstanceID(machine.Status.ProviderID)))
 			if err != nil {
 				return fmt.Errorf("getting instance, %w", err)
 			}
-			if _, ok := lo.Find(instance.Tags, func(tag *ec2.Tag) bool {
+			if _, ok := lo.Find(updated.Tags, func(tag *ec2.Tag) bool {
 				return aws.StringValue(tag.Key) == v1alpha5.MachineNameLabelKey &&
 					aws.StringValue(tag.Value) == machine.Name
 			}); !ok {
@@ -413,14 +532,101 @@ func (p *InstanceProvider) Update(ctx context.Context, machine *v1alpha5.Machine
 	); err != nil {
 		return nil, fmt.Errorf("updating instance %s, %w", lo.Must(utils.ParseInstanceID(machine.Status.ProviderID)), err)
 	}
-	return instance, nil
+	return updated, nil
+}
+
+// updateTags returns the tags that Update applies to the instance of the machine
+func (p *InstanceProvider) updateTags(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine, instance *ec2.Instance) ([]*ec2.Tag, error) {
+	instanceTags, err := renderInstanceTags(ctx, nodeTemplate, instanceTagData(machine, instance))
+	if err != nil {
+		return nil, err
+	}
+	return lo.MapToSlice(lo.Assign(instanceTags, map[string]string{
+		v1alpha5.MachineNameLabelKey: machine.Name,
+		fmt.Sprintf("kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName): "owned",
+	}), func(key string, value string) *ec2.Tag {
+		return &ec2.Tag{Key: aws.String(key), Value: aws.String(value)}
+	}), nil
+}
+
+// InstanceTags returns the tags that the instance of a machine is expected to have, which are the tags that it would be
+// launched and hydrated with if it was launched for the machine with the node template now
+func InstanceTags(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine, instance *ec2.Instance) (map[string]string, error) {
+	instanceTags, err := renderInstanceTags(ctx, nodeTemplate, instanceTagData(machine, instance))
+	if err != nil {
+		return nil, err
+	}
+	ctx = injection.WithNamespacedName(ctx, types.NamespacedName{Name: machine.Labels[v1alpha5.ProvisionerNameLabelKey]})
+	return lo.SliceToMap(v1alpha1.MergeTags(ctx, instanceTags, map[string]string{
+		v1alpha5.MachineNameLabelKey: machine.Name,
+		fmt.Sprintf("kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName): "owned",
+	}), func(tag *ec2.Tag) (string, string) {
+		return aws.StringValue(tag.Key), aws.StringValue(tag.Value)
+	}), nil
+}
+
+// tagLaunchedInstance renders the tags of the instance again with its zone and capacity type, which may not have been
+// known when it was launched, and applies the tags that changed. The launch doesn't fail if the instance can't be
+// tagged, as it's tagged again if it's hydrated.
+func (p *InstanceProvider) tagLaunchedInstance(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine, instance *ec2.Instance) {
+	tags, err := renderInstanceTags(ctx, nodeTemplate, instanceTagData(machine, instance))
+	if err != nil {
+		logging.FromContext(ctx).With("id", aws.StringValue(instance.InstanceId)).Errorf("tagging instance, %s", err)
+		return
+	}
+	changed := lo.OmitBy(tags, func(key string, value string) bool {
+		return lo.ContainsBy(instance.Tags, func(tag *ec2.Tag) bool { return aws.StringValue(tag.Key) == key && aws.StringValue(tag.Value) == value })
+	})
+	if len(changed) == 0 {
+		return
+	}
+	if _, err := p.ec2api.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{instance.InstanceId},
+		Tags: lo.MapToSlice(changed, func(key string, value string) *ec2.Tag {
+			return &ec2.Tag{Key: aws.String(key), Value: aws.String(value)}
+		}),
+	}); err != nil {
+		logging.FromContext(ctx).With("id", aws.StringValue(instance.InstanceId)).Errorf("tagging instance, %s", err)
+	}
+}
+
+// instanceTagData returns the tag data of the machine that the instance was launched for, with the zone, capacity
+// type and instance type of the instance, which the requirements of the machine may not have determined
+func instanceTagData(machine *v1alpha5.Machine, instance *ec2.Instance) v1alpha1.TagData {
+	data := v1alpha1.NewTagData(machine)
+	if instance.Placement != nil {
+		data.Zone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+	data.CapacityType = getCapacityType(instance)
+	data.Labels = lo.Assign(data.Labels, map[string]string{
+		v1.LabelTopologyZone:       data.Zone,
+		v1alpha5.LabelCapacityType: data.CapacityType,
+		v1.LabelInstanceTypeStable: aws.StringValue(instance.InstanceType),
+	})
+	return data
+}
+
+// renderInstanceTags returns the global and node template tags of an instance, with their templates rendered with the
+// data of its machine, and the labels of the machine that the node template propagates as tags
+func renderInstanceTags(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, data v1alpha1.TagData) (map[string]string, error) {
+	tags, err := v1alpha1.RenderTags(data, nodeTemplate.Spec.PropagateLabelsAsTags, settings.FromContext(ctx).Tags, nodeTemplate.Spec.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("rendering tags, %w", err)
+	}
+	return tags, nil
 }
 
 func (p *InstanceProvider) updateUnavailableOfferingsCache(ctx context.Context, errors []*ec2.CreateFleetError, capacityType string) {
 	for _, err := range errors {
-		if awserrors.IsUnfulfillableCapacity(err) {
-			p.unavailableOfferings.MarkUnavailableForFleetErr(ctx, err, capacityType)
+		classification, ok := awserrors.ClassifyFleetError(err)
+		if !ok {
+			continue
 		}
+		if classification.Scope == awserrors.SubnetScope {
+			p.subnetProvider.MarkExhausted(ctx, aws.StringValue(err.LaunchTemplateAndOverrides.Overrides.SubnetId), classification.TTL)
+			continue
+		}
+		p.unavailableOfferings.MarkUnavailableForFleetErr(ctx, err, capacityType)
 	}
 }
 
@@ -461,10 +667,80 @@ func orderInstanceTypesByPrice(instanceTypes []*cloudprovider.InstanceType, requ
 	return instanceTypes
 }
 
+// truncateInstanceTypes limits the price ordered instance types to maxInstanceTypes. Taking the cheapest instance types
+// outright tends to select many sizes of the same few families in a single zone, which leaves CreateFleet with highly
+// correlated capacity pools. Instead, we first select the cheapest instance type that covers each zone and generation,
+// then fill the remaining slots in rounds that select at most one more instance type per family in each round. The
+// returned instance types retain their price ordering.
+func truncateInstanceTypes(instanceTypes []*cloudprovider.InstanceType, requirements scheduling.Requirements, maxInstanceTypes int) []*cloudprovider.InstanceType {
+	if len(instanceTypes) <= maxInstanceTypes {
+		return instanceTypes
+	}
+	selected := make([]bool, len(instanceTypes))
+	familyCounts := map[string]int{}
+	count := 0
+	selectInstanceType := func(i int) {
+		selected[i] = true
+		familyCounts[instanceTypeLabelValue(instanceTypes[i], v1alpha1.LabelInstanceFamily)]++
+		count++
+	}
+
+	zones, generations := sets.NewString(), sets.NewString()
+	for i, it := range instanceTypes {
+		if count == maxInstanceTypes {
+			break
+		}
+		itZones := sets.NewString(lo.Map(it.Offerings.Available().Requirements(requirements), func(o cloudprovider.Offering, _ int) string {
+			return o.Zone
+		})...)
+		generation := instanceTypeLabelValue(it, v1alpha1.LabelInstanceGeneration)
+		if zones.IsSuperset(itZones) && generations.Has(generation) {
+			continue
+		}
+		zones.Insert(itZones.UnsortedList()...)
+		generations.Insert(generation)
+		selectInstanceType(i)
+	}
+	for round := 1; count < maxInstanceTypes; round++ {
+		for i, it := range instanceTypes {
+			if count == maxInstanceTypes {
+				break
+			}
+			if selected[i] || familyCounts[instanceTypeLabelValue(it, v1alpha1.LabelInstanceFamily)] >= round {
+				continue
+			}
+			selectInstanceType(i)
+		}
+	}
+	return lo.Filter(instanceTypes, func(_ *cloudprovider.InstanceType, i int) bool { return selected[i] })
+}
+
+// instanceTypeLabelValue returns the single value of the label key in the instance type's requirements, or the empty
+// string if the instance type doesn't define exactly one value for the label
+func instanceTypeLabelValue(instanceType *cloudprovider.InstanceType, key string) string {
+	if values := instanceType.Requirements.Get(key).Values(); len(values) == 1 {
+		return values[0]
+	}
+	return ""
+}
+
+// candidateInstanceTypes returns the instance types that the machine is launched with, ordered by price and truncated
+// to the maximum number of instance types of a launch
+func (p *InstanceProvider) candidateInstanceTypes(ctx context.Context, machine *v1alpha5.Machine, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
+	instanceTypes = p.filterInstanceTypes(ctx, machine, instanceTypes)
+	requirements := scheduling.NewNodeSelectorRequirements(machine.Spec.Requirements...)
+	instanceTypes = orderInstanceTypesByPrice(instanceTypes, requirements)
+	if maxInstanceTypes := settings.FromContext(ctx).MaxInstanceTypes; len(instanceTypes) > maxInstanceTypes {
+		instanceTypeCandidatesDropped.WithLabelValues(machine.Labels[v1alpha5.ProvisionerNameLabelKey]).Add(float64(len(instanceTypes) - maxInstanceTypes))
+		instanceTypes = truncateInstanceTypes(instanceTypes, requirements, maxInstanceTypes)
+	}
+	return instanceTypes
+}
+
 // filterInstanceTypes is used to provide filtering on the list of potential instance types to further limit it to those
 // that make the most sense given our specific AWS cloudprovider.
-func (p *InstanceProvider) filterInstanceTypes(machine *v1alpha5.Machine, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
-	instanceTypes = filterExoticInstanceTypes(instanceTypes)
+func (p *InstanceProvider) filterInstanceTypes(ctx context.Context, machine *v1alpha5.Machine, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
+	instanceTypes = filterExoticInstanceTypes(ctx, instanceTypes)
 	// If we could potentially launch either a spot or on-demand node, we want to filter out the spot instance types that
 	// are more expensive than the cheapest on-demand type.
 	if p.isMixedCapacityLaunch(machine, instanceTypes) {
@@ -528,23 +804,13 @@ func filterUnwantedSpot(instanceTypes []*cloudprovider.InstanceType) []*cloudpro
 
 // filterExoticInstanceTypes is used to eliminate less desirable instance types (like GPUs) from the list of possible instance types when
 // a set of more appropriate instance types would work. If a set of more desirable instance types is not found, then the original slice
-// of instance types are returned.
-func filterExoticInstanceTypes(instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
-	var genericInstanceTypes []*cloudprovider.InstanceType
-	for _, it := range instanceTypes {
-		// deprioritize metal even if our opinionated filter isn't applied due to something like an instance family
-		// requirement
-		if it.Requirements.Get(v1alpha1.LabelInstanceSize).Has("metal") {
-			continue
-		}
-		if !resources.IsZero(it.Capacity[v1alpha1.ResourceAWSNeuron]) ||
-			!resources.IsZero(it.Capacity[v1alpha1.ResourceAMDGPU]) ||
-			!resources.IsZero(it.Capacity[v1alpha1.ResourceNVIDIAGPU]) ||
-			!resources.IsZero(it.Capacity[v1alpha1.ResourceHabanaGaudi]) {
-			continue
-		}
-		genericInstanceTypes = append(genericInstanceTypes, it)
+// of instance types are returned. Exotic instance types are only deprioritized when the instance type policy deprioritizes them, as
+// they're otherwise either allowed like any other instance types or already excluded.
+func filterExoticInstanceTypes(ctx context.Context, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
+	if settings.FromContext(ctx).ExoticInstanceTypes != settings.ExoticInstanceTypesDeprioritize {
+		return instanceTypes
 	}
+	genericInstanceTypes := lo.Reject(instanceTypes, func(it *cloudprovider.InstanceType, _ int) bool { return isExoticInstanceType(it) })
 	// if we got some subset of instance types, then prefer to use those
 	if len(genericInstanceTypes) != 0 {
 		return genericInstanceTypes
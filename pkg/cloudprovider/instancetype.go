@@ -31,6 +31,7 @@ import (
 
 	awssettings "github.com/aws/karpenter/pkg/apis/settings"
 	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	awscache "github.com/aws/karpenter/pkg/cache"
 	"github.com/aws/karpenter/pkg/cloudprovider/amifamily"
 
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
@@ -48,18 +49,19 @@ var (
 )
 
 func NewInstanceType(ctx context.Context, info *ec2.InstanceTypeInfo, kc *v1alpha5.KubeletConfiguration,
-	region string, nodeTemplate *v1alpha1.AWSNodeTemplate, offerings cloudprovider.Offerings) *cloudprovider.InstanceType {
+	region string, nodeTemplate *v1alpha1.AWSNodeTemplate, offerings cloudprovider.Offerings, memoryOverhead *awscache.MemoryOverhead) *cloudprovider.InstanceType {
 
 	amiFamily := amifamily.GetAMIFamily(nodeTemplate.Spec.AMIFamily, &amifamily.Options{})
+	mem := memory(ctx, info, nodeTemplate, memoryOverhead)
 	return &cloudprovider.InstanceType{
 		Name:         aws.StringValue(info.InstanceType),
 		Requirements: computeRequirements(ctx, info, offerings, region, amiFamily, kc),
 		Offerings:    offerings,
-		Capacity:     computeCapacity(ctx, info, amiFamily, nodeTemplate.Spec.BlockDeviceMappings, kc),
+		Capacity:     computeCapacity(ctx, info, mem, amiFamily, nodeTemplate.Spec.BlockDeviceMappings, kc),
 		Overhead: &cloudprovider.InstanceTypeOverhead{
-			KubeReserved:      kubeReservedResources(cpu(info), pods(ctx, info, amiFamily, kc), eniLimitedPods(info), amiFamily, kc),
+			KubeReserved:      kubeReservedResources(cpu(info), pods(ctx, info, amiFamily, kc), eniLimitedPods(ctx, info), amiFamily, kc),
 			SystemReserved:    systemReservedResources(kc),
-			EvictionThreshold: evictionThreshold(memory(ctx, info), amiFamily, kc),
+			EvictionThreshold: evictionThreshold(mem, amiFamily, kc),
 		},
 	}
 }
@@ -125,12 +127,12 @@ func getArchitecture(info *ec2.InstanceTypeInfo) string {
 	return fmt.Sprint(aws.StringValueSlice(info.ProcessorInfo.SupportedArchitectures)) // Unrecognized, but used for error printing
 }
 
-func computeCapacity(ctx context.Context, info *ec2.InstanceTypeInfo, amiFamily amifamily.AMIFamily,
+func computeCapacity(ctx context.Context, info *ec2.InstanceTypeInfo, memory *resource.Quantity, amiFamily amifamily.AMIFamily,
 	blockDeviceMappings []*v1alpha1.BlockDeviceMapping, kc *v1alpha5.KubeletConfiguration) v1.ResourceList {
 
 	return v1.ResourceList{
 		v1.ResourceCPU:               *cpu(info),
-		v1.ResourceMemory:            *memory(ctx, info),
+		v1.ResourceMemory:            *memory,
 		v1.ResourceEphemeralStorage:  *ephemeralStorage(amiFamily, blockDeviceMappings),
 		v1.ResourcePods:              *pods(ctx, info, amiFamily, kc),
 		v1alpha1.ResourceAWSPodENI:   *awsPodENI(ctx, aws.StringValue(info.InstanceType)),
@@ -145,13 +147,34 @@ func cpu(info *ec2.InstanceTypeInfo) *resource.Quantity {
 	return resources.Quantity(fmt.Sprint(*info.VCpuInfo.DefaultVCpus))
 }
 
-func memory(ctx context.Context, info *ec2.InstanceTypeInfo) *resource.Quantity {
+// memory returns the memory capacity of the instance type, which is its memory less the VM overhead that was learned from
+// its nodes with the node template's AMI family, or otherwise less the configured percentage of VM overhead
+func memory(ctx context.Context, info *ec2.InstanceTypeInfo, nodeTemplate *v1alpha1.AWSNodeTemplate, memoryOverhead *awscache.MemoryOverhead) *resource.Quantity {
 	mem := resources.Quantity(fmt.Sprintf("%dMi", *info.MemoryInfo.SizeInMiB))
+	if amiFamily, ok := MemoryOverheadAMIFamily(nodeTemplate); ok && memoryOverhead != nil {
+		if overhead, ok := memoryOverhead.Get(aws.StringValue(info.InstanceType), amiFamily); ok {
+			mem.Sub(resource.MustParse(fmt.Sprintf("%dMi", overhead)))
+			return mem
+		}
+	}
 	// Account for VM overhead in calculation
 	mem.Sub(resource.MustParse(fmt.Sprintf("%dMi", int64(math.Ceil(float64(mem.Value())*awssettings.FromContext(ctx).VMMemoryOverheadPercent/1024/1024)))))
 	return mem
 }
 
+// MemoryOverheadAMIFamily returns the AMI family that the VM memory overhead of the node template's instance types is
+// learned for. The overhead isn't learned for custom AMIs, as it can differ between the AMIs of node templates.
+func MemoryOverheadAMIFamily(nodeTemplate *v1alpha1.AWSNodeTemplate) (string, bool) {
+	switch amiFamily := aws.StringValue(nodeTemplate.Spec.AMIFamily); amiFamily {
+	case v1alpha1.AMIFamilyBottlerocket, v1alpha1.AMIFamilyUbuntu:
+		return amiFamily, true
+	case v1alpha1.AMIFamilyCustom:
+		return "", false
+	default:
+		return v1alpha1.AMIFamilyAL2, true
+	}
+}
+
 // Setting ephemeral-storage to be either the default value or what is defined in blockDeviceMappings
 func ephemeralStorage(amiFamily amifamily.AMIFamily, blockDeviceMappings []*v1alpha1.BlockDeviceMapping) *resource.Quantity {
 	if len(blockDeviceMappings) != 0 {
@@ -227,10 +250,52 @@ func habanaGaudis(info *ec2.InstanceTypeInfo) *resource.Quantity {
 }
 
 // The number of pods per node is calculated using the formula:
-// max number of ENIs * (IPv4 Addresses per ENI -1) + 2
+// number of ENIs available to pods * (IPv4 Addresses per ENI -1) * IPv4 Addresses per slot + 2
+// where the primary ENI isn't available to pods when using custom networking, and each address slot holds a /28 prefix
+// (16 addresses) when using prefix delegation on a Nitro instance. With prefix delegation, the result is capped at 110
+// for instances with fewer than 30 vCPUs and 250 for all others.
 // https://github.com/awslabs/amazon-eks-ami/blob/master/files/eni-max-pods.txt#L20
-func eniLimitedPods(info *ec2.InstanceTypeInfo) *resource.Quantity {
-	return resources.Quantity(fmt.Sprint(*info.NetworkInfo.MaximumNetworkInterfaces*(*info.NetworkInfo.Ipv4AddressesPerInterface-1) + 2))
+// https://github.com/awslabs/amazon-eks-ami/blob/master/files/max-pods-calculator.sh
+func eniLimitedPods(ctx context.Context, info *ec2.InstanceTypeInfo) *resource.Quantity {
+	enis := *info.NetworkInfo.MaximumNetworkInterfaces
+	if awssettings.FromContext(ctx).EnableCustomNetworking {
+		enis = lo.Max([]int64{enis - 1, 0})
+	}
+	count := enis*(*info.NetworkInfo.Ipv4AddressesPerInterface-1)*ipv4AddressesPerSlot(ctx, info) + 2
+	if usesPrefixDelegation(ctx, info) {
+		count = lo.Min([]int64{count, lo.Ternary[int64](aws.Int64Value(info.VCpuInfo.DefaultVCpus) < 30, 110, 250)})
+	}
+	return resources.Quantity(fmt.Sprint(count))
+}
+
+// usesPrefixDelegation returns whether the VPC CNI assigns /28 IPv4 prefixes rather than individual secondary IPv4
+// addresses to the ENIs of the instance type. Prefixes can only be assigned to the ENIs of Nitro instances.
+func usesPrefixDelegation(ctx context.Context, info *ec2.InstanceTypeInfo) bool {
+	return awssettings.FromContext(ctx).EnablePrefixDelegation && aws.StringValue(info.Hypervisor) == ec2.InstanceTypeHypervisorNitro
+}
+
+func ipv4AddressesPerSlot(ctx context.Context, info *ec2.InstanceTypeInfo) int64 {
+	if usesPrefixDelegation(ctx, info) {
+		return 16
+	}
+	return 1
+}
+
+// launchIPs returns the number of IPv4 addresses that an instance is predicted to consume from its subnet at launch. The
+// VPC CNI allocates a full ENI's worth of IPv4 addresses to the primary network interface when the node starts, or the
+// primary address and a single /28 prefix when using prefix delegation on a Nitro instance. With custom networking, pod
+// addresses are taken from the subnets of secondary ENIs, so only the primary address is consumed.
+func launchIPs(ctx context.Context, instanceType *cloudprovider.InstanceType) int64 {
+	switch {
+	case awssettings.FromContext(ctx).EnableCustomNetworking:
+		return 1
+	case awssettings.FromContext(ctx).EnablePrefixDelegation && instanceType.Requirements.Get(v1alpha1.LabelInstanceHypervisor).Has(ec2.InstanceTypeHypervisorNitro):
+		return 1 + 16
+	}
+	if limits, ok := Limits[instanceType.Name]; ok {
+		return int64(limits.IPv4PerInterface)
+	}
+	return 1
 }
 
 func systemReservedResources(kc *v1alpha5.KubeletConfiguration) v1.ResourceList {
@@ -326,7 +391,7 @@ func pods(ctx context.Context, info *ec2.InstanceTypeInfo, amiFamily amifamily.A
 	case !awssettings.FromContext(ctx).EnableENILimitedPodDensity:
 		count = 110
 	default:
-		count = eniLimitedPods(info).Value()
+		count = eniLimitedPods(ctx, info).Value()
 	}
 	if kc != nil && ptr.Int32Value(kc.PodsPerCore) > 0 && amiFamily.FeatureFlags().PodsPerCoreEnabled {
 		count = lo.Min([]int64{int64(ptr.Int32Value(kc.PodsPerCore)) * ptr.Int64Value(info.VCpuInfo.DefaultVCpus), count})
@@ -0,0 +1,104 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/utils/resources"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+)
+
+// InstanceTypePolicy is the cluster-wide policy of the instance types that can be launched, which applies to the
+// instance types of every provisioner in addition to their requirements
+type InstanceTypePolicy struct {
+	AllowedFamilies           sets.String
+	DeniedFamilies            sets.String
+	AllowedCategories         sets.String
+	DeniedCategories          sets.String
+	AllowedGenerations        sets.String
+	DeniedGenerations         sets.String
+	ExcludePreviousGeneration bool
+	ExoticInstanceTypes       settings.ExoticInstanceTypes
+}
+
+func InstanceTypePolicyFromSettings(s *settings.Settings) InstanceTypePolicy {
+	return InstanceTypePolicy{
+		AllowedFamilies:           sets.NewString(s.AllowedInstanceFamilies...),
+		DeniedFamilies:            sets.NewString(s.DeniedInstanceFamilies...),
+		AllowedCategories:         sets.NewString(s.AllowedInstanceCategories...),
+		DeniedCategories:          sets.NewString(s.DeniedInstanceCategories...),
+		AllowedGenerations:        sets.NewString(s.AllowedInstanceGenerations...),
+		DeniedGenerations:         sets.NewString(s.DeniedInstanceGenerations...),
+		ExcludePreviousGeneration: s.ExcludePreviousGeneration,
+		ExoticInstanceTypes:       s.ExoticInstanceTypes,
+	}
+}
+
+// IsEmpty returns whether the policy allows every instance type
+func (p InstanceTypePolicy) IsEmpty() bool {
+	return p.AllowedFamilies.Len() == 0 && p.DeniedFamilies.Len() == 0 &&
+		p.AllowedCategories.Len() == 0 && p.DeniedCategories.Len() == 0 &&
+		p.AllowedGenerations.Len() == 0 && p.DeniedGenerations.Len() == 0 &&
+		!p.ExcludePreviousGeneration && p.ExoticInstanceTypes != settings.ExoticInstanceTypesExclude
+}
+
+// Allows returns whether the policy allows the instance type. Instance types whose family, category or generation
+// can't be determined aren't allowed when they're restricted to an allowlist.
+func (p InstanceTypePolicy) Allows(info *ec2.InstanceTypeInfo) bool {
+	if p.ExcludePreviousGeneration && !aws.BoolValue(info.CurrentGeneration) {
+		return false
+	}
+	if p.ExoticInstanceTypes == settings.ExoticInstanceTypesExclude && isExoticInstanceTypeInfo(info) {
+		return false
+	}
+	var family, category, generation string
+	if parts := strings.Split(aws.StringValue(info.InstanceType), "."); len(parts) == 2 {
+		family = parts[0]
+	}
+	if parts := instanceTypeScheme.FindStringSubmatch(aws.StringValue(info.InstanceType)); len(parts) == 4 {
+		category, generation = parts[1], parts[3]
+	}
+	return allowed(p.AllowedFamilies, p.DeniedFamilies, family) &&
+		allowed(p.AllowedCategories, p.DeniedCategories, category) &&
+		allowed(p.AllowedGenerations, p.DeniedGenerations, generation)
+}
+
+func allowed(allowlist sets.String, denylist sets.String, value string) bool {
+	return !denylist.Has(value) && (allowlist.Len() == 0 || allowlist.Has(value))
+}
+
+// isExoticInstanceType returns whether the instance type is a metal instance type or has accelerators, which are
+// less desirable than generic instance types
+func isExoticInstanceType(instanceType *cloudprovider.InstanceType) bool {
+	return instanceType.Requirements.Get(v1alpha1.LabelInstanceSize).Has("metal") ||
+		!resources.IsZero(instanceType.Capacity[v1alpha1.ResourceAWSNeuron]) ||
+		!resources.IsZero(instanceType.Capacity[v1alpha1.ResourceAMDGPU]) ||
+		!resources.IsZero(instanceType.Capacity[v1alpha1.ResourceNVIDIAGPU]) ||
+		!resources.IsZero(instanceType.Capacity[v1alpha1.ResourceHabanaGaudi])
+}
+
+// isExoticInstanceTypeInfo is isExoticInstanceType for instance types that haven't been resolved yet
+func isExoticInstanceTypeInfo(info *ec2.InstanceTypeInfo) bool {
+	return strings.HasSuffix(aws.StringValue(info.InstanceType), ".metal") ||
+		!awsNeurons(info).IsZero() || !amdGPUs(info).IsZero() || !nvidiaGPUs(info).IsZero() || !habanaGaudis(info).IsZero()
+}
@@ -18,7 +18,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 
@@ -35,16 +37,28 @@ import (
 	"knative.dev/pkg/logging"
 
 	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider/amifamily"
+	cloudproviderevents "github.com/aws/karpenter/pkg/cloudprovider/events"
+	"github.com/aws/karpenter/pkg/providers/quota"
 	"github.com/aws/karpenter/pkg/providers/subnet"
+	"github.com/aws/karpenter/pkg/utils"
 
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/events"
 	"github.com/aws/karpenter-core/pkg/utils/pretty"
 )
 
 const (
 	InstanceTypesCacheKey           = "types"
+	InstanceTypeOfferingsCacheKey   = "offerings"
 	InstanceTypeZonesCacheKeyPrefix = "zones:"
+	// AllowedInstanceTypesCacheKeyPrefix is the prefix of the instance types that the instance type policy allows (key:
+	// AllowedInstanceTypesCacheKeyPrefix:<instance_types_seq_num>-<hash_of_policy>)
+	AllowedInstanceTypesCacheKeyPrefix = "allowed:"
+
+	// refreshTimeout is the timeout of asynchronous refreshes of the instance types and offerings
+	refreshTimeout = time.Minute
 )
 
 type InstanceTypeProvider struct {
@@ -52,6 +66,7 @@ type InstanceTypeProvider struct {
 	ec2api          ec2iface.EC2API
 	subnetProvider  *subnet.Provider
 	pricingProvider *PricingProvider
+	amiProvider     *amifamily.AMIProvider
 	// Has one cache entry for all the instance types (key: InstanceTypesCacheKey)
 	// Has one cache entry for all the zones for each subnet selector (key: InstanceTypesZonesCacheKeyPrefix:<hash_of_selector>)
 	// Values cached *before* considering insufficient capacity errors from the unavailableOfferings cache.
@@ -59,27 +74,46 @@ type InstanceTypeProvider struct {
 	// node template, and kubelet configuration from the provisioner
 	cache                *cache.Cache
 	unavailableOfferings *awscache.UnavailableOfferings
+	memoryOverhead       *awscache.MemoryOverhead
+	quotaProvider        *quota.Provider
+	recorder             events.Recorder
 	cm                   *pretty.ChangeMonitor
 	// instanceTypesSeqNum is a monotonically increasing change counter used to avoid the expensive hashing operation on instance types
 	instanceTypesSeqNum uint64
+
+	// the last good instance types and offerings, which are served while they're refreshed when instance types are
+	// persisted, and whenever the EC2 APIs fail
+	mu                  sync.RWMutex
+	lastInstanceTypes   map[string]*ec2.InstanceTypeInfo
+	lastOfferings       map[string]sets.String
+	lastUpdated         time.Time
+	refreshingTypes     atomic.Bool
+	refreshingOfferings atomic.Bool
+	// SnapshotSeqNum is incremented when the last good instance types or offerings change
+	SnapshotSeqNum uint64
 }
 
-func NewInstanceTypeProvider(ctx context.Context, sess *session.Session, ec2api ec2iface.EC2API, subnetProvider *subnet.Provider,
-	unavailableOfferingsCache *awscache.UnavailableOfferings, startAsync <-chan struct{}) *InstanceTypeProvider {
+func NewInstanceTypeProvider(ctx context.Context, sess *session.Session, ec2api ec2iface.EC2API, subnetProvider *subnet.Provider, amiProvider *amifamily.AMIProvider,
+	unavailableOfferingsCache *awscache.UnavailableOfferings, memoryOverhead *awscache.MemoryOverhead, quotaProvider *quota.Provider, recorder events.Recorder, startAsync <-chan struct{}) *InstanceTypeProvider {
 	return &InstanceTypeProvider{
 		ec2api:         ec2api,
 		region:         *sess.Config.Region,
 		subnetProvider: subnetProvider,
+		amiProvider:    amiProvider,
 		pricingProvider: NewPricingProvider(
 			ctx,
 			NewPricingAPI(sess, *sess.Config.Region),
 			ec2api,
 			*sess.Config.Region,
 			awssettings.FromContext(ctx).IsolatedVPC,
+			PricingOptionsFromSettings(awssettings.FromContext(ctx)),
 			startAsync,
 		),
 		cache:                cache.New(awscache.InstanceTypesAndZonesTTL, awscache.DefaultCleanupInterval),
 		unavailableOfferings: unavailableOfferingsCache,
+		memoryOverhead:       memoryOverhead,
+		quotaProvider:        quotaProvider,
+		recorder:             recorder,
 		cm:                   pretty.NewChangeMonitor(),
 		instanceTypesSeqNum:  0,
 	}
@@ -97,19 +131,23 @@ func (p *InstanceTypeProvider) List(ctx context.Context, kc *v1alpha5.KubeletCon
 		return nil, err
 	}
 
+	operatingSystem := p.operatingSystem(ctx, nodeTemplate)
+
 	// Compute fully initialized instance types hash key
 	instanceTypeZonesHash, _ := hashstructure.Hash(instanceTypeZones, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
 	kcHash, _ := hashstructure.Hash(kc, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
-	key := fmt.Sprintf("%d-%d-%s-%016x-%016x", p.instanceTypesSeqNum, p.unavailableOfferings.SeqNum, nodeTemplate.UID, instanceTypeZonesHash, kcHash)
+	policyHash, _ := hashstructure.Hash(InstanceTypePolicyFromSettings(awssettings.FromContext(ctx)), hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
+	key := fmt.Sprintf("%d-%d-%d-%d-%d-%s-%s-%016x-%016x-%016x", atomic.LoadUint64(&p.instanceTypesSeqNum), atomic.LoadUint64(&p.unavailableOfferings.SeqNum), atomic.LoadUint64(&p.quotaProvider.SeqNum), atomic.LoadUint64(&p.pricingProvider.SeqNum), atomic.LoadUint64(&p.memoryOverhead.SeqNum), nodeTemplate.UID, operatingSystem, instanceTypeZonesHash, kcHash, policyHash)
 
 	if item, ok := p.cache.Get(key); ok {
 		return item.([]*cloudprovider.InstanceType), nil
 	}
 
+	p.pricingProvider.updateUnpricedMetrics(instanceTypes)
 	var result []*cloudprovider.InstanceType
 	for _, i := range instanceTypes {
 		instanceTypeName := aws.StringValue(i.InstanceType)
-		instanceType := NewInstanceType(ctx, i, kc, p.region, nodeTemplate, p.createOfferings(ctx, i, instanceTypeZones[instanceTypeName]))
+		instanceType := NewInstanceType(ctx, i, kc, p.region, nodeTemplate, p.createOfferings(ctx, nodeTemplate, operatingSystem, i, instanceTypeZones[instanceTypeName]), p.memoryOverhead)
 		result = append(result, instanceType)
 	}
 	p.cache.SetDefault(key, result)
@@ -126,20 +164,26 @@ func (p *InstanceTypeProvider) LivenessProbe(req *http.Request) error {
 	return nil
 }
 
-func (p *InstanceTypeProvider) createOfferings(ctx context.Context, instanceType *ec2.InstanceTypeInfo, zones sets.String) []cloudprovider.Offering {
+func (p *InstanceTypeProvider) ReadinessProbe(req *http.Request) error {
+	return p.pricingProvider.ReadinessProbe(req)
+}
+
+func (p *InstanceTypeProvider) createOfferings(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, operatingSystem OperatingSystem, instanceType *ec2.InstanceTypeInfo, zones sets.String) []cloudprovider.Offering {
 	var offerings []cloudprovider.Offering
 	for zone := range zones {
 		// while usage classes should be a distinct set, there's no guarantee of that
 		for capacityType := range sets.NewString(aws.StringValueSlice(instanceType.SupportedUsageClasses)...) {
 			// exclude any offerings that have recently seen an insufficient capacity error from EC2
-			isUnavailable := p.unavailableOfferings.IsUnavailable(*instanceType.InstanceType, zone, capacityType)
+			// or that would exceed the vCPU quota of the capacity type
+			isUnavailable := p.unavailableOfferings.IsUnavailable(*instanceType.InstanceType, zone, capacityType) ||
+				p.exceedsQuota(nodeTemplate, instanceType, capacityType)
 			var price float64
 			var ok bool
 			switch capacityType {
 			case ec2.UsageClassTypeSpot:
-				price, ok = p.pricingProvider.SpotPrice(*instanceType.InstanceType, zone)
+				price, ok = p.pricingProvider.OperatingSystemSpotPrice(operatingSystem, *instanceType.InstanceType, zone)
 			case ec2.UsageClassTypeOnDemand:
-				price, ok = p.pricingProvider.OnDemandPrice(*instanceType.InstanceType)
+				price, ok = p.pricingProvider.OperatingSystemOnDemandPrice(operatingSystem, *instanceType.InstanceType)
 			default:
 				logging.FromContext(ctx).Errorf("Received unknown capacity type %s for instance type %s", capacityType, *instanceType.InstanceType)
 				continue
@@ -156,6 +200,29 @@ func (p *InstanceTypeProvider) createOfferings(ctx context.Context, instanceType
 	return offerings
 }
 
+// operatingSystem returns the operating system that the instances of the node template are priced by, which is
+// determined by the platform of its AMIs. Instances are priced as Linux if the platform can't be determined.
+func (p *InstanceTypeProvider) operatingSystem(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate) OperatingSystem {
+	platformDetails, err := p.amiProvider.PlatformDetails(ctx, nodeTemplate, amifamily.GetAMIFamily(nodeTemplate.Spec.AMIFamily, &amifamily.Options{}))
+	if err != nil {
+		if p.cm.HasChanged(fmt.Sprintf("platform-details-error-%s", nodeTemplate.Name), err.Error()) {
+			logging.FromContext(ctx).Errorf("resolving the platform of the amis of %s, pricing instances as linux, %s", nodeTemplate.Name, err)
+		}
+		return OperatingSystemLinux
+	}
+	return OperatingSystemFromPlatformDetails(platformDetails)
+}
+
+// exceedsQuota returns true if launching the instance type would exceed the vCPU quota of its capacity type
+func (p *InstanceTypeProvider) exceedsQuota(nodeTemplate *v1alpha1.AWSNodeTemplate, instanceType *ec2.InstanceTypeInfo, capacityType string) bool {
+	headroom, exceeded := p.quotaProvider.Exceeds(capacityType, aws.StringValue(instanceType.InstanceType), aws.Int64Value(instanceType.VCpuInfo.DefaultVCpus))
+	if !exceeded {
+		return false
+	}
+	p.recorder.Publish(cloudproviderevents.VCPUQuotaExceeded(nodeTemplate, capacityType, utils.InstanceFamilyClass(aws.StringValue(instanceType.InstanceType)), headroom))
+	return true
+}
+
 func (p *InstanceTypeProvider) getInstanceTypeZones(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate) (map[string]sets.String, error) {
 	subnetSelectorHash, err := hashstructure.Hash(nodeTemplate.Spec.SubnetSelector, hashstructure.FormatV2, nil)
 	if err != nil {
@@ -179,20 +246,15 @@ func (p *InstanceTypeProvider) getInstanceTypeZones(ctx context.Context, nodeTem
 	})...)
 
 	// Get offerings from EC2
+	offerings, err := p.getInstanceTypeOfferings(ctx)
+	if err != nil {
+		return nil, err
+	}
 	instanceTypeZones := map[string]sets.String{}
-	if err := p.ec2api.DescribeInstanceTypeOfferingsPagesWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{LocationType: aws.String("availability-zone")},
-		func(output *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
-			for _, offering := range output.InstanceTypeOfferings {
-				if zones.Has(aws.StringValue(offering.Location)) {
-					if _, ok := instanceTypeZones[aws.StringValue(offering.InstanceType)]; !ok {
-						instanceTypeZones[aws.StringValue(offering.InstanceType)] = sets.NewString()
-					}
-					instanceTypeZones[aws.StringValue(offering.InstanceType)].Insert(aws.StringValue(offering.Location))
-				}
-			}
-			return true
-		}); err != nil {
-		return nil, fmt.Errorf("describing instance type zone offerings, %w", err)
+	for instanceType, offeringZones := range offerings {
+		if z := offeringZones.Intersection(zones); z.Len() > 0 {
+			instanceTypeZones[instanceType] = z
+		}
 	}
 	if p.cm.HasChanged("zonal-offerings", nodeTemplate.Spec.SubnetSelector) {
 		logging.FromContext(ctx).With("subnet-selector", pretty.Concise(nodeTemplate.Spec.SubnetSelector)).Debugf("discovered EC2 instance types zonal offerings for subnets")
@@ -201,11 +263,64 @@ func (p *InstanceTypeProvider) getInstanceTypeZones(ctx context.Context, nodeTem
 	return instanceTypeZones, nil
 }
 
-// getInstanceTypes retrieves all instance types from the ec2 DescribeInstanceTypes API using some opinionated filters
+// getInstanceTypes retrieves the instance types that the instance type policy allows. The allowed instance types are
+// cached for the instance types and policy that they were filtered from, so that the instance types aren't filtered on
+// every request.
 func (p *InstanceTypeProvider) getInstanceTypes(ctx context.Context) (map[string]*ec2.InstanceTypeInfo, error) {
+	instanceTypes, err := p.describeInstanceTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policy := InstanceTypePolicyFromSettings(awssettings.FromContext(ctx))
+	if policy.IsEmpty() {
+		return instanceTypes, nil
+	}
+	policyHash, _ := hashstructure.Hash(policy, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
+	key := fmt.Sprintf("%s%d-%016x", AllowedInstanceTypesCacheKeyPrefix, atomic.LoadUint64(&p.instanceTypesSeqNum), policyHash)
+	if cached, ok := p.cache.Get(key); ok {
+		return cached.(map[string]*ec2.InstanceTypeInfo), nil
+	}
+	allowed := lo.PickBy(instanceTypes, func(_ string, info *ec2.InstanceTypeInfo) bool { return policy.Allows(info) })
+	if p.cm.HasChanged("instance-type-policy", fmt.Sprintf("%016x-%d", policyHash, len(allowed))) {
+		logging.FromContext(ctx).With(
+			"instance-type-count", len(allowed),
+			"excluded-instance-type-count", len(instanceTypes)-len(allowed)).Debugf("applied instance type policy")
+	}
+	p.cache.SetDefault(key, allowed)
+	return allowed, nil
+}
+
+// describeInstanceTypes retrieves all instance types from the ec2 DescribeInstanceTypes API using some opinionated filters.
+// When instance types are persisted, the last good instance types are served while they're refreshed asynchronously.
+// The last good instance types are also served if the API fails.
+func (p *InstanceTypeProvider) describeInstanceTypes(ctx context.Context) (map[string]*ec2.InstanceTypeInfo, error) {
 	if cached, ok := p.cache.Get(InstanceTypesCacheKey); ok {
 		return cached.(map[string]*ec2.InstanceTypeInfo), nil
 	}
+	p.mu.RLock()
+	last := p.lastInstanceTypes
+	p.mu.RUnlock()
+	if last != nil && awssettings.FromContext(ctx).PersistInstanceTypes {
+		p.refresh(ctx, &p.refreshingTypes, func(ctx context.Context) error {
+			_, err := p.fetchInstanceTypes(ctx)
+			return err
+		})
+		return last, nil
+	}
+	instanceTypes, err := p.fetchInstanceTypes(ctx)
+	if err != nil {
+		if last == nil {
+			return nil, err
+		}
+		logging.FromContext(ctx).Errorf("%s, using the last good instance types", err)
+		// retry once the cache expires rather than on every request
+		p.cache.SetDefault(InstanceTypesCacheKey, last)
+		return last, nil
+	}
+	return instanceTypes, nil
+}
+
+func (p *InstanceTypeProvider) fetchInstanceTypes(ctx context.Context) (map[string]*ec2.InstanceTypeInfo, error) {
 	instanceTypes := map[string]*ec2.InstanceTypeInfo{}
 	if err := p.ec2api.DescribeInstanceTypesPagesWithContext(ctx, &ec2.DescribeInstanceTypesInput{
 		Filters: []*ec2.Filter{
@@ -229,8 +344,120 @@ func (p *InstanceTypeProvider) getInstanceTypes(ctx context.Context) (map[string
 	if p.cm.HasChanged("instance-types", instanceTypes) {
 		logging.FromContext(ctx).With(
 			"instance-type-count", len(instanceTypes)).Debugf("discovered EC2 instance types")
+		atomic.AddUint64(&p.SnapshotSeqNum, 1)
 	}
 	atomic.AddUint64(&p.instanceTypesSeqNum, 1)
 	p.cache.SetDefault(InstanceTypesCacheKey, instanceTypes)
+	p.mu.Lock()
+	p.lastInstanceTypes, p.lastUpdated = instanceTypes, time.Now()
+	p.mu.Unlock()
 	return instanceTypes, nil
 }
+
+// getInstanceTypeOfferings retrieves the zones that each instance type is offered in from the ec2
+// DescribeInstanceTypeOfferings API. Like the instance types, the last good offerings are served while they're
+// refreshed when instance types are persisted, and if the API fails.
+func (p *InstanceTypeProvider) getInstanceTypeOfferings(ctx context.Context) (map[string]sets.String, error) {
+	if cached, ok := p.cache.Get(InstanceTypeOfferingsCacheKey); ok {
+		return cached.(map[string]sets.String), nil
+	}
+	p.mu.RLock()
+	last := p.lastOfferings
+	p.mu.RUnlock()
+	if last != nil && awssettings.FromContext(ctx).PersistInstanceTypes {
+		p.refresh(ctx, &p.refreshingOfferings, func(ctx context.Context) error {
+			_, err := p.fetchInstanceTypeOfferings(ctx)
+			return err
+		})
+		return last, nil
+	}
+	offerings, err := p.fetchInstanceTypeOfferings(ctx)
+	if err != nil {
+		if last == nil {
+			return nil, err
+		}
+		logging.FromContext(ctx).Errorf("%s, using the last good offerings", err)
+		p.cache.SetDefault(InstanceTypeOfferingsCacheKey, last)
+		return last, nil
+	}
+	return offerings, nil
+}
+
+func (p *InstanceTypeProvider) fetchInstanceTypeOfferings(ctx context.Context) (map[string]sets.String, error) {
+	offerings := map[string]sets.String{}
+	if err := p.ec2api.DescribeInstanceTypeOfferingsPagesWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{LocationType: aws.String("availability-zone")},
+		func(output *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
+			for _, offering := range output.InstanceTypeOfferings {
+				if _, ok := offerings[aws.StringValue(offering.InstanceType)]; !ok {
+					offerings[aws.StringValue(offering.InstanceType)] = sets.NewString()
+				}
+				offerings[aws.StringValue(offering.InstanceType)].Insert(aws.StringValue(offering.Location))
+			}
+			return true
+		}); err != nil {
+		return nil, fmt.Errorf("describing instance type zone offerings, %w", err)
+	}
+	if p.cm.HasChanged("instance-type-offerings", offerings) {
+		atomic.AddUint64(&p.SnapshotSeqNum, 1)
+	}
+	p.cache.SetDefault(InstanceTypeOfferingsCacheKey, offerings)
+	p.mu.Lock()
+	p.lastOfferings, p.lastUpdated = offerings, time.Now()
+	p.mu.Unlock()
+	return offerings, nil
+}
+
+// refresh runs an update asynchronously unless it's already running
+func (p *InstanceTypeProvider) refresh(ctx context.Context, refreshing *atomic.Bool, update func(context.Context) error) {
+	if !refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	// the refresh outlives the request that started it
+	ctx, cancel := context.WithTimeout(logging.WithLogger(context.Background(), logging.FromContext(ctx)), refreshTimeout)
+	go func() {
+		defer cancel()
+		defer refreshing.Store(false)
+		if err := update(ctx); err != nil {
+			logging.FromContext(ctx).Errorf("refreshing instance types, %s", err)
+		}
+	}()
+}
+
+// Snapshot returns the last good instance types and offerings, if both are known
+func (p *InstanceTypeProvider) Snapshot() (*InstanceTypeSnapshot, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.lastInstanceTypes == nil || p.lastOfferings == nil {
+		return nil, false
+	}
+	return &InstanceTypeSnapshot{
+		Region:        p.region,
+		GeneratedAt:   p.lastUpdated,
+		InstanceTypes: lo.Values(p.lastInstanceTypes),
+		Offerings: lo.MapValues(p.lastOfferings, func(zones sets.String, _ string) []string {
+			return zones.List()
+		}),
+	}, true
+}
+
+// RestoreSnapshot restores the last good instance types and offerings of a snapshot of the provider's region, unless
+// they're already known, and returns whether they were restored
+func (p *InstanceTypeProvider) RestoreSnapshot(snapshot *InstanceTypeSnapshot) bool {
+	if snapshot.Region != p.region {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastInstanceTypes != nil || p.lastOfferings != nil {
+		return false
+	}
+	p.lastInstanceTypes = lo.SliceToMap(snapshot.InstanceTypes, func(instanceType *ec2.InstanceTypeInfo) (string, *ec2.InstanceTypeInfo) {
+		return aws.StringValue(instanceType.InstanceType), instanceType
+	})
+	p.lastOfferings = lo.MapValues(snapshot.Offerings, func(zones []string, _ string) sets.String {
+		return sets.NewString(zones...)
+	})
+	p.lastUpdated = snapshot.GeneratedAt
+	atomic.AddUint64(&p.instanceTypesSeqNum, 1)
+	return true
+}
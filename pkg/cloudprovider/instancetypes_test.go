@@ -17,6 +17,7 @@ package cloudprovider
 import (
 	"fmt"
 	"math"
+	"reflect"
 	"sort"
 	"strings"
 	"time"
@@ -39,6 +40,8 @@ import (
 	"github.com/aws/karpenter-core/pkg/scheduling"
 	coretest "github.com/aws/karpenter-core/pkg/test"
 	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/cloudprovider/amifamily"
 	"github.com/aws/karpenter/pkg/fake"
 	"github.com/aws/karpenter/pkg/test"
 )
@@ -84,7 +87,7 @@ var _ = Describe("Instance Types", func() {
 		ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
 		ExpectNotScheduled(ctx, env.Client, pod)
 	})
-	It("should order the instance types by price and only consider the cheapest ones", func() {
+	It("should order the instance types by price and consider the cheapest instance type of each family", func() {
 		instances := makeFakeInstances()
 		fakeEC2API.DescribeInstanceTypesOutput.Set(&ec2.DescribeInstanceTypesOutput{
 			InstanceTypes: makeFakeInstances(),
@@ -114,18 +117,44 @@ var _ = Describe("Instance Types", func() {
 			}
 			return iPrice < jPrice
 		})
-		// Expect that the launch template overrides gives the 60 cheapest instance types
-		expected := sets.NewString(lo.Map(its[:MaxInstanceTypes], func(i *cloudprovider.InstanceType, _ int) string {
-			return i.Name
-		})...)
+		// Expect that the launch template overrides gives the cheapest instance type of each of the cheapest families
+		families := sets.NewString()
+		expected := sets.NewString()
+		for _, it := range its {
+			if family := instanceTypeLabelValue(it, v1alpha1.LabelInstanceFamily); !families.Has(family) && families.Len() < settings.FromContext(ctx).MaxInstanceTypes {
+				families.Insert(family)
+				expected.Insert(it.Name)
+			}
+		}
 		Expect(fakeEC2API.CreateFleetBehavior.CalledWithInput.Len()).To(Equal(1))
 		call := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
 		Expect(call.LaunchTemplateConfigs).To(HaveLen(1))
 
-		Expect(call.LaunchTemplateConfigs[0].Overrides).To(HaveLen(MaxInstanceTypes))
-		for _, override := range call.LaunchTemplateConfigs[0].Overrides {
-			Expect(expected.Has(aws.StringValue(override.InstanceType))).To(BeTrue(), fmt.Sprintf("expected %s to exist in set", aws.StringValue(override.InstanceType)))
-		}
+		Expect(call.LaunchTemplateConfigs[0].Overrides).To(HaveLen(settings.FromContext(ctx).MaxInstanceTypes))
+		overrides := sets.NewString(lo.Map(call.LaunchTemplateConfigs[0].Overrides, func(o *ec2.FleetLaunchTemplateOverridesRequest, _ int) string {
+			return aws.StringValue(o.InstanceType)
+		})...)
+		Expect(overrides.IsSuperset(expected)).To(BeTrue(), fmt.Sprintf("expected %v to be included in overrides", expected.Difference(overrides).List()))
+	})
+	It("should respect the configured maximum number of instance types", func() {
+		ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+			MaxInstanceTypes: lo.ToPtr(10),
+		}))
+		instances := makeFakeInstances()
+		fakeEC2API.DescribeInstanceTypesOutput.Set(&ec2.DescribeInstanceTypesOutput{
+			InstanceTypes: makeFakeInstances(),
+		})
+		fakeEC2API.DescribeInstanceTypeOfferingsOutput.Set(&ec2.DescribeInstanceTypeOfferingsOutput{
+			InstanceTypeOfferings: makeFakeInstanceOfferings(instances),
+		})
+		ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+		pod := coretest.UnschedulablePod()
+		ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+		ExpectScheduled(ctx, env.Client, pod)
+		Expect(fakeEC2API.CreateFleetBehavior.CalledWithInput.Len()).To(Equal(1))
+		call := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+		Expect(call.LaunchTemplateConfigs).To(HaveLen(1))
+		Expect(call.LaunchTemplateConfigs[0].Overrides).To(HaveLen(10))
 	})
 	It("should order the instance types by price and only consider the spot types that are cheaper than the cheapest on-demand", func() {
 		instances := makeFakeInstances()
@@ -250,6 +279,96 @@ var _ = Describe("Instance Types", func() {
 		ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
 		ExpectScheduled(ctx, env.Client, pod)
 	})
+	Context("Instance Type Policy", func() {
+		It("should only launch the instance types that the instance type policy allows", func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				AllowedInstanceCategories: []string{"m", "t"},
+				DeniedInstanceFamilies:    []string{"t3"},
+			}))
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+
+			Expect(fakeEC2API.CreateFleetBehavior.CalledWithInput.Len()).To(Equal(1))
+			call := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			for _, ltc := range call.LaunchTemplateConfigs {
+				for _, ovr := range ltc.Overrides {
+					Expect(aws.StringValue(ovr.InstanceType)).To(HavePrefix("m5."))
+				}
+			}
+		})
+		It("should not launch instance types that the instance type policy denies even if they're required", func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				DeniedInstanceGenerations: []string{"5"},
+			}))
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{
+				NodeSelector: map[string]string{v1alpha1.LabelInstanceFamily: "m5"},
+			})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectNotScheduled(ctx, env.Client, pod)
+		})
+		It("should exclude previous generation instance types", func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				ExcludePreviousGeneration: lo.ToPtr(true),
+			}))
+			fakeEC2API.DescribeInstanceTypesOutput.Set(&ec2.DescribeInstanceTypesOutput{
+				InstanceTypes: []*ec2.InstanceTypeInfo{
+					{InstanceType: aws.String("m5.large"), CurrentGeneration: aws.Bool(true)},
+					{InstanceType: aws.String("m4.large"), CurrentGeneration: aws.Bool(false)},
+				},
+			})
+			instanceInfo, err := instanceTypeProvider.getInstanceTypes(ctx)
+			Expect(err).To(BeNil())
+			Expect(lo.Keys(instanceInfo)).To(ConsistOf("m5.large"))
+		})
+		It("should filter the instance types again only when the instance types or the policy change", func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				AllowedInstanceFamilies: []string{"m5"},
+			}))
+			first, err := instanceTypeProvider.getInstanceTypes(ctx)
+			Expect(err).To(BeNil())
+			second, err := instanceTypeProvider.getInstanceTypes(ctx)
+			Expect(err).To(BeNil())
+			Expect(reflect.ValueOf(second).Pointer()).To(Equal(reflect.ValueOf(first).Pointer()))
+
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				AllowedInstanceFamilies: []string{"c6g"},
+			}))
+			third, err := instanceTypeProvider.getInstanceTypes(ctx)
+			Expect(err).To(BeNil())
+			Expect(lo.Keys(third)).To(ConsistOf("c6g.large"))
+		})
+		It("should not de-prioritize metal when exotic instance types are allowed", func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				AllowedInstanceFamilies: []string{"m5"},
+				ExoticInstanceTypes:     lo.ToPtr(settings.ExoticInstanceTypesAllow),
+			}))
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+
+			Expect(fakeEC2API.CreateFleetBehavior.CalledWithInput.Len()).To(Equal(1))
+			call := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(call.LaunchTemplateConfigs).To(HaveLen(1))
+			Expect(lo.Map(call.LaunchTemplateConfigs[0].Overrides, func(o *ec2.FleetLaunchTemplateOverridesRequest, _ int) string {
+				return aws.StringValue(o.InstanceType)
+			})).To(ContainElement("m5.metal"))
+		})
+		It("should not launch on metal when exotic instance types are excluded", func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				ExoticInstanceTypes: lo.ToPtr(settings.ExoticInstanceTypesExclude),
+			}))
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{
+				NodeSelector: map[string]string{v1alpha1.LabelInstanceSize: "metal"},
+			})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectNotScheduled(ctx, env.Client, pod)
+		})
+	})
 	It("should fail to launch AWS Pod ENI if the command line option enabling it isn't set", func() {
 		ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
 			EnablePodENI: lo.ToPtr(false),
@@ -385,15 +504,27 @@ var _ = Describe("Instance Types", func() {
 		instanceInfo, err := instanceTypeProvider.getInstanceTypes(ctx)
 		Expect(err).To(BeNil())
 		for _, info := range instanceInfo {
-			it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+			it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 			Expect(it.Capacity.Pods().Value()).To(BeNumerically("==", 110))
 		}
 	})
+	It("should subtract the learned memory overhead of the instance type and AMI family from its memory", func() {
+		memoryOverhead.Update(map[string]int64{awscache.MemoryOverheadKey("m5.xlarge", v1alpha1.AMIFamilyAL2): 400})
+		instanceInfo, err := instanceTypeProvider.getInstanceTypes(ctx)
+		Expect(err).To(BeNil())
+		it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, memoryOverhead)
+		Expect(it.Capacity.Memory().Value()).To(BeNumerically("==", (16384-400)*1024*1024))
+
+		// the percentage of memory overhead is subtracted for AMI families whose overhead wasn't learned
+		nodeTemplate.Spec.AMIFamily = lo.ToPtr(v1alpha1.AMIFamilyBottlerocket)
+		it = NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, memoryOverhead)
+		Expect(it.Capacity.Memory().Value()).To(BeNumerically("==", (16384-int64(math.Ceil(16384*0.075)))*1024*1024))
+	})
 	It("should not set pods to 110 if using ENI-based pod density", func() {
 		instanceInfo, err := instanceTypeProvider.getInstanceTypes(ctx)
 		Expect(err).To(BeNil())
 		for _, info := range instanceInfo {
-			it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+			it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 			Expect(it.Capacity.Pods().Value()).ToNot(BeNumerically("==", 110))
 		}
 	})
@@ -415,7 +546,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.SystemReserved.Cpu().String()).To(Equal("2"))
 			})
 			It("should override system reserved memory when specified", func() {
@@ -428,7 +559,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.SystemReserved.Memory().String()).To(Equal("20Gi"))
 			})
 			It("should override kube reserved when specified", func() {
@@ -448,7 +579,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.KubeReserved.Cpu().String()).To(Equal("2"))
 				Expect(it.Overhead.KubeReserved.Memory().String()).To(Equal("10Gi"))
 				Expect(it.Overhead.KubeReserved.StorageEphemeral().String()).To(Equal("2Gi"))
@@ -476,7 +607,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().String()).To(Equal("500Mi"))
 			})
 			It("should override eviction threshold (hard) when specified as a percentage value", func() {
@@ -495,7 +626,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().Value()).To(BeNumerically("~", float64(it.Capacity.Memory().Value())*0.1, 10))
 			})
 			It("should consider the eviction threshold (hard) disabled when specified as 100%", func() {
@@ -514,7 +645,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().String()).To(Equal("0"))
 			})
 			It("should used default eviction threshold (hard) for memory when evictionHard not specified", func() {
@@ -533,7 +664,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().String()).To(Equal("50Mi"))
 			})
 			It("should override eviction threshold (soft) when specified as a quantity", func() {
@@ -552,7 +683,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().String()).To(Equal("500Mi"))
 			})
 			It("should override eviction threshold (soft) when specified as a percentage value", func() {
@@ -574,7 +705,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().Value()).To(BeNumerically("~", float64(it.Capacity.Memory().Value())*0.1, 10))
 			})
 			It("should consider the eviction threshold (soft) disabled when specified as 100%", func() {
@@ -593,7 +724,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().String()).To(Equal("0"))
 			})
 			It("should ignore eviction threshold (soft) when using Bottlerocket AMI", func() {
@@ -616,7 +747,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().String()).To(Equal("1Gi"))
 			})
 			It("should take the greater of evictionHard and evictionSoft for overhead as a value", func() {
@@ -638,7 +769,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().String()).To(Equal("3Gi"))
 			})
 			It("should take the greater of evictionHard and evictionSoft for overhead as a value", func() {
@@ -660,7 +791,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().Value()).To(BeNumerically("~", float64(it.Capacity.Memory().Value())*0.05, 10))
 			})
 			It("should take the greater of evictionHard and evictionSoft for overhead with mixed percentage/value", func() {
@@ -682,7 +813,7 @@ var _ = Describe("Instance Types", func() {
 						},
 					},
 				})
-				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Overhead.EvictionThreshold.Memory().Value()).To(BeNumerically("~", float64(it.Capacity.Memory().Value())*0.1, 10))
 			})
 		})
@@ -691,7 +822,7 @@ var _ = Describe("Instance Types", func() {
 			Expect(err).To(BeNil())
 			provisioner = test.Provisioner(coretest.ProvisionerOptions{Kubelet: &v1alpha5.KubeletConfiguration{MaxPods: ptr.Int32(10)}})
 			for _, info := range instanceInfo {
-				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Capacity.Pods().Value()).To(BeNumerically("==", 10))
 			}
 		})
@@ -704,7 +835,7 @@ var _ = Describe("Instance Types", func() {
 			Expect(err).To(BeNil())
 			provisioner = test.Provisioner(coretest.ProvisionerOptions{Kubelet: &v1alpha5.KubeletConfiguration{MaxPods: ptr.Int32(10)}})
 			for _, info := range instanceInfo {
-				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Capacity.Pods().Value()).To(BeNumerically("==", 10))
 			}
 		})
@@ -713,7 +844,7 @@ var _ = Describe("Instance Types", func() {
 			Expect(err).To(BeNil())
 			provisioner = test.Provisioner(coretest.ProvisionerOptions{Kubelet: &v1alpha5.KubeletConfiguration{PodsPerCore: ptr.Int32(1)}})
 			for _, info := range instanceInfo {
-				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Capacity.Pods().Value()).To(BeNumerically("==", ptr.Int64Value(info.VCpuInfo.DefaultVCpus)))
 			}
 		})
@@ -722,7 +853,7 @@ var _ = Describe("Instance Types", func() {
 			Expect(err).To(BeNil())
 			provisioner = test.Provisioner(coretest.ProvisionerOptions{Kubelet: &v1alpha5.KubeletConfiguration{PodsPerCore: ptr.Int32(4), MaxPods: ptr.Int32(20)}})
 			for _, info := range instanceInfo {
-				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Capacity.Pods().Value()).To(BeNumerically("==", lo.Min([]int64{20, ptr.Int64Value(info.VCpuInfo.DefaultVCpus) * 4})))
 			}
 		})
@@ -732,8 +863,8 @@ var _ = Describe("Instance Types", func() {
 			nodeTemplate.Spec.AMIFamily = &v1alpha1.AMIFamilyBottlerocket
 			provisioner = test.Provisioner(coretest.ProvisionerOptions{Kubelet: &v1alpha5.KubeletConfiguration{PodsPerCore: ptr.Int32(1)}})
 			for _, info := range instanceInfo {
-				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
-				Expect(it.Capacity.Pods().Value()).To(BeNumerically("==", eniLimitedPods(info).Value()))
+				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
+				Expect(it.Capacity.Pods().Value()).To(BeNumerically("==", eniLimitedPods(ctx, info).Value()))
 			}
 		})
 		It("should take 110 to be the default pods number when pods-per-core is 0 and AWSENILimitedPodDensity is unset", func() {
@@ -745,11 +876,137 @@ var _ = Describe("Instance Types", func() {
 			Expect(err).To(BeNil())
 			provisioner = test.Provisioner(coretest.ProvisionerOptions{Kubelet: &v1alpha5.KubeletConfiguration{PodsPerCore: ptr.Int32(0)}})
 			for _, info := range instanceInfo {
-				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+				it := NewInstanceType(ctx, info, provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 				Expect(it.Capacity.Pods().Value()).To(BeNumerically("==", 110))
 			}
 		})
 	})
+	Context("Pod Density", func() {
+		instanceTypeInfo := func(name string, vcpus int64, hypervisor string) *ec2.InstanceTypeInfo {
+			limits, ok := Limits[name]
+			Expect(ok).To(BeTrue())
+			return &ec2.InstanceTypeInfo{
+				InstanceType: aws.String(name),
+				Hypervisor:   aws.String(hypervisor),
+				VCpuInfo:     &ec2.VCpuInfo{DefaultVCpus: aws.Int64(vcpus)},
+				NetworkInfo: &ec2.NetworkInfo{
+					MaximumNetworkInterfaces:  aws.Int64(int64(limits.Interface)),
+					Ipv4AddressesPerInterface: aws.Int64(int64(limits.IPv4PerInterface)),
+				},
+			}
+		}
+		DescribeTable("should compute ENI-limited pods for each VPC CNI mode",
+			func(name string, vcpus int64, hypervisor string, prefixDelegation, customNetworking bool, expected int) {
+				ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+					EnablePrefixDelegation: lo.ToPtr(prefixDelegation),
+					EnableCustomNetworking: lo.ToPtr(customNetworking),
+				}))
+				info := instanceTypeInfo(name, vcpus, hypervisor)
+				Expect(eniLimitedPods(ctx, info).Value()).To(BeNumerically("==", expected))
+				Expect(pods(ctx, info, &amifamily.AL2{}, nil).Value()).To(BeNumerically("==", expected))
+				kubeReserved := kubeReservedResources(cpu(info), pods(ctx, info, &amifamily.AL2{}, nil), eniLimitedPods(ctx, info), &amifamily.AL2{}, nil)
+				Expect(kubeReserved.Memory().String()).To(Equal(fmt.Sprintf("%dMi", 11*expected+255)))
+			},
+			Entry("secondary IPs on t3.nano", "t3.nano", int64(2), ec2.InstanceTypeHypervisorNitro, false, false, 4),
+			Entry("secondary IPs on m5.large", "m5.large", int64(2), ec2.InstanceTypeHypervisorNitro, false, false, 29),
+			Entry("secondary IPs on m5.24xlarge", "m5.24xlarge", int64(96), ec2.InstanceTypeHypervisorNitro, false, false, 737),
+			Entry("custom networking on t3.nano", "t3.nano", int64(2), ec2.InstanceTypeHypervisorNitro, false, true, 3),
+			Entry("custom networking on m5.large", "m5.large", int64(2), ec2.InstanceTypeHypervisorNitro, false, true, 20),
+			Entry("custom networking on m5.24xlarge", "m5.24xlarge", int64(96), ec2.InstanceTypeHypervisorNitro, false, true, 688),
+			Entry("prefix delegation on t3.nano", "t3.nano", int64(2), ec2.InstanceTypeHypervisorNitro, true, false, 34),
+			Entry("prefix delegation capped at 110 on m5.large", "m5.large", int64(2), ec2.InstanceTypeHypervisorNitro, true, false, 110),
+			Entry("prefix delegation capped at 250 on m5.24xlarge", "m5.24xlarge", int64(96), ec2.InstanceTypeHypervisorNitro, true, false, 250),
+			Entry("prefix delegation falls back to secondary IPs on c4.large", "c4.large", int64(2), ec2.InstanceTypeHypervisorXen, true, false, 29),
+			Entry("prefix delegation and custom networking on t3.nano", "t3.nano", int64(2), ec2.InstanceTypeHypervisorNitro, true, true, 18),
+			Entry("prefix delegation and custom networking on m5.24xlarge", "m5.24xlarge", int64(96), ec2.InstanceTypeHypervisorNitro, true, true, 250),
+		)
+		It("should compute ENI-limited pods for every instance type in the VPC limits", func() {
+			for name, limits := range Limits {
+				info := instanceTypeInfo(name, 2, ec2.InstanceTypeHypervisorNitro)
+				ctx = settings.ToContext(ctx, test.Settings())
+				secondaryIPs := eniLimitedPods(ctx, info).Value()
+				Expect(secondaryIPs).To(BeNumerically("==", limits.Interface*(limits.IPv4PerInterface-1)+2), name)
+
+				ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{EnableCustomNetworking: lo.ToPtr(true)}))
+				Expect(eniLimitedPods(ctx, info).Value()).To(BeNumerically("==", (limits.Interface-1)*(limits.IPv4PerInterface-1)+2), name)
+
+				ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{EnablePrefixDelegation: lo.ToPtr(true)}))
+				Expect(eniLimitedPods(ctx, info).Value()).To(BeNumerically("==", lo.Min([]int{limits.Interface*(limits.IPv4PerInterface-1)*16 + 2, 110})), name)
+				Expect(eniLimitedPods(ctx, info).Value()).To(BeNumerically(">=", lo.Min([]int64{secondaryIPs, 110})), name)
+			}
+		})
+		It("should predict the IPs consumed from the subnet at launch for each VPC CNI mode", func() {
+			instanceType := func(name, hypervisor string) *cloudprovider.InstanceType {
+				return &cloudprovider.InstanceType{
+					Name:         name,
+					Requirements: scheduling.NewRequirements(scheduling.NewRequirement(v1alpha1.LabelInstanceHypervisor, v1.NodeSelectorOpIn, hypervisor)),
+				}
+			}
+			nitro, xen := instanceType("m5.large", ec2.InstanceTypeHypervisorNitro), instanceType("c4.large", ec2.InstanceTypeHypervisorXen)
+			Expect(launchIPs(ctx, nitro)).To(BeNumerically("==", 10))
+			Expect(launchIPs(ctx, xen)).To(BeNumerically("==", 10))
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{EnablePrefixDelegation: lo.ToPtr(true)}))
+			Expect(launchIPs(ctx, nitro)).To(BeNumerically("==", 17))
+			Expect(launchIPs(ctx, xen)).To(BeNumerically("==", 10))
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{EnableCustomNetworking: lo.ToPtr(true)}))
+			Expect(launchIPs(ctx, nitro)).To(BeNumerically("==", 1))
+			Expect(launchIPs(ctx, xen)).To(BeNumerically("==", 1))
+		})
+	})
+	Context("Truncation", func() {
+		newInstanceType := func(name, zone string, price float64) *cloudprovider.InstanceType {
+			family, size, _ := strings.Cut(name, ".")
+			return &cloudprovider.InstanceType{
+				Name: name,
+				Requirements: scheduling.NewRequirements(
+					scheduling.NewRequirement(v1alpha1.LabelInstanceFamily, v1.NodeSelectorOpIn, family),
+					scheduling.NewRequirement(v1alpha1.LabelInstanceGeneration, v1.NodeSelectorOpIn, family[len(family)-1:]),
+					scheduling.NewRequirement(v1alpha1.LabelInstanceSize, v1.NodeSelectorOpIn, size),
+				),
+				Offerings: []cloudprovider.Offering{{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: zone, Price: price, Available: true}},
+			}
+		}
+		names := func(instanceTypes []*cloudprovider.InstanceType) []string {
+			return lo.Map(instanceTypes, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+		}
+		It("should not truncate when under the maximum", func() {
+			instanceTypes := []*cloudprovider.InstanceType{
+				newInstanceType("m5.large", "test-zone-1a", 1),
+				newInstanceType("m5.xlarge", "test-zone-1a", 2),
+			}
+			Expect(names(truncateInstanceTypes(instanceTypes, scheduling.NewRequirements(), 2))).To(Equal([]string{"m5.large", "m5.xlarge"}))
+		})
+		It("should select across families before selecting more sizes of the same family", func() {
+			instanceTypes := []*cloudprovider.InstanceType{
+				newInstanceType("m5.large", "test-zone-1a", 1),
+				newInstanceType("m5.xlarge", "test-zone-1a", 2),
+				newInstanceType("m5.2xlarge", "test-zone-1a", 3),
+				newInstanceType("c5.large", "test-zone-1a", 4),
+				newInstanceType("r5.large", "test-zone-1a", 5),
+				newInstanceType("c5.xlarge", "test-zone-1a", 6),
+			}
+			Expect(names(truncateInstanceTypes(instanceTypes, scheduling.NewRequirements(), 4))).To(Equal([]string{"m5.large", "m5.xlarge", "c5.large", "r5.large"}))
+		})
+		It("should select the cheapest instance type in each zone and generation", func() {
+			instanceTypes := []*cloudprovider.InstanceType{
+				newInstanceType("m5.large", "test-zone-1a", 1),
+				newInstanceType("c5.large", "test-zone-1a", 2),
+				newInstanceType("r5.large", "test-zone-1a", 3),
+				newInstanceType("m6.large", "test-zone-1a", 4),
+				newInstanceType("m5.xlarge", "test-zone-1b", 5),
+			}
+			Expect(names(truncateInstanceTypes(instanceTypes, scheduling.NewRequirements(), 3))).To(Equal([]string{"m5.large", "m6.large", "m5.xlarge"}))
+		})
+		It("should only consider zones that are compatible with the requirements", func() {
+			instanceTypes := []*cloudprovider.InstanceType{
+				newInstanceType("m5.large", "test-zone-1a", 1),
+				newInstanceType("c5.large", "test-zone-1a", 2),
+				newInstanceType("m5.xlarge", "test-zone-1b", 3),
+			}
+			requirements := scheduling.NewRequirements(scheduling.NewRequirement(v1.LabelTopologyZone, v1.NodeSelectorOpIn, "test-zone-1a"))
+			Expect(names(truncateInstanceTypes(instanceTypes, requirements, 2))).To(Equal([]string{"m5.large", "c5.large"}))
+		})
+	})
 	Context("Insufficient Capacity Error Cache", func() {
 		It("should launch instances of different type on second reconciliation attempt with Insufficient Capacity Error Cache fallback", func() {
 			fakeEC2API.InsufficientCapacityPools.Set([]fake.CapacityPool{{CapacityType: v1alpha5.CapacityTypeOnDemand, InstanceType: "inf1.6xlarge", Zone: "test-zone-1a"}})
@@ -959,6 +1216,126 @@ var _ = Describe("Instance Types", func() {
 			}
 			Expect(instanceTypeNames.Has("m5.xlarge"))
 		})
+		It("should mark the instance family class unavailable in every zone when the vCPU limit is exceeded", func() {
+			fakeEC2API.InsufficientCapacityPools.Set([]fake.CapacityPool{
+				{CapacityType: v1alpha5.CapacityTypeOnDemand, InstanceType: "p3.8xlarge", Zone: "test-zone-1a", ErrorCode: "VcpuLimitExceeded"},
+			})
+			pod := coretest.UnschedulablePod(coretest.PodOptions{
+				NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "p3.8xlarge", v1.LabelTopologyZone: "test-zone-1a"},
+			})
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectNotScheduled(ctx, env.Client, pod)
+
+			for _, zone := range []string{"test-zone-1a", "test-zone-1b", "test-zone-1c"} {
+				Expect(unavailableOfferingsCache.IsUnavailable("p3.8xlarge", zone, v1alpha5.CapacityTypeOnDemand)).To(BeTrue())
+				Expect(unavailableOfferingsCache.IsUnavailable("p4d.24xlarge", zone, v1alpha5.CapacityTypeOnDemand)).To(BeTrue())
+				Expect(unavailableOfferingsCache.IsUnavailable("p3.8xlarge", zone, v1alpha5.CapacityTypeSpot)).To(BeFalse())
+				Expect(unavailableOfferingsCache.IsUnavailable("m5.large", zone, v1alpha5.CapacityTypeOnDemand)).To(BeFalse())
+				Expect(unavailableOfferingsCache.IsUnavailable("g4dn.8xlarge", zone, v1alpha5.CapacityTypeOnDemand)).To(BeFalse())
+			}
+		})
+		It("should mark every spot offering unavailable when the spot instance count limit is exceeded", func() {
+			fakeEC2API.InsufficientCapacityPools.Set([]fake.CapacityPool{
+				{CapacityType: v1alpha5.CapacityTypeSpot, InstanceType: "m5.large", Zone: "test-zone-1a", ErrorCode: "MaxSpotInstanceCountExceeded"},
+			})
+			provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeSpot, v1alpha5.CapacityTypeOnDemand}},
+			}
+			pod := coretest.UnschedulablePod(coretest.PodOptions{
+				NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large", v1.LabelTopologyZone: "test-zone-1a"},
+			})
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(unavailableOfferingsCache.IsUnavailable("c5.xlarge", "test-zone-1b", v1alpha5.CapacityTypeSpot)).To(BeTrue())
+			Expect(unavailableOfferingsCache.IsUnavailable("m5.large", "test-zone-1a", v1alpha5.CapacityTypeOnDemand)).To(BeFalse())
+
+			// Fallback to OD
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha5.CapacityTypeOnDemand))
+		})
+		It("should exclude a subnet that is out of addresses without making the offerings in its zone unavailable", func() {
+			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(50),
+					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-1")}}},
+				{SubnetId: aws.String("test-subnet-2"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(100),
+					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-2")}}},
+			}})
+			fakeEC2API.InsufficientCapacityPools.Set([]fake.CapacityPool{
+				{CapacityType: v1alpha5.CapacityTypeOnDemand, InstanceType: "m5.large", Zone: "test-zone-1a", ErrorCode: "InsufficientFreeAddressesInSubnet"},
+			})
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1a", v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(fake.SubnetsFromFleetRequest(fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop())).To(ConsistOf("test-subnet-2"))
+			Expect(unavailableOfferingsCache.IsUnavailable("m5.large", "test-zone-1a", v1alpha5.CapacityTypeOnDemand)).To(BeFalse())
+			Expect(unavailableOfferingsCache.IsUnavailable("c5.xlarge", "test-zone-1a", v1alpha5.CapacityTypeOnDemand)).To(BeFalse())
+
+			fakeEC2API.InsufficientCapacityPools.Reset()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fake.SubnetsFromFleetRequest(fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop())).To(ConsistOf("test-subnet-1"))
+		})
+	})
+	Context("vCPU Quotas", func() {
+		It("should mark offerings unavailable when launching them would exceed the vCPU quota", func() {
+			// Running On-Demand Standard (A, C, D, H, I, M, R, T, Z) instances
+			fakeServiceQuotasAPI.AppliedQuotas.Store("L-1216C47A", float64(2))
+			Expect(quotaProvider.Update(ctx)).To(Succeed())
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, provisioner)
+			Expect(err).ToNot(HaveOccurred())
+			for _, it := range instanceTypes {
+				switch it.Name {
+				case "m5.large":
+					Expect(it.Offerings.Requirements(scheduling.NewRequirements(scheduling.NewRequirement(v1alpha5.LabelCapacityType, v1.NodeSelectorOpIn, v1alpha5.CapacityTypeOnDemand))).Available()).ToNot(BeEmpty())
+				case "m5.xlarge":
+					Expect(it.Offerings.Requirements(scheduling.NewRequirements(scheduling.NewRequirement(v1alpha5.LabelCapacityType, v1.NodeSelectorOpIn, v1alpha5.CapacityTypeOnDemand))).Available()).To(BeEmpty())
+					Expect(it.Offerings.Requirements(scheduling.NewRequirements(scheduling.NewRequirement(v1alpha5.LabelCapacityType, v1.NodeSelectorOpIn, v1alpha5.CapacityTypeSpot))).Available()).ToNot(BeEmpty())
+				}
+			}
+			pod := coretest.UnschedulablePod(coretest.PodOptions{
+				NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.xlarge"},
+			})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectNotScheduled(ctx, env.Client, pod)
+		})
+		It("should fall back to the default vCPU quota when it hasn't been applied to the account", func() {
+			fakeServiceQuotasAPI.DefaultQuotas.Store("L-1216C47A", float64(2))
+			Expect(quotaProvider.Update(ctx)).To(Succeed())
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{
+				NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.xlarge"},
+			})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectNotScheduled(ctx, env.Client, pod)
+		})
+		It("should reserve the vCPUs of launched instances in the quota", func() {
+			fakeServiceQuotasAPI.AppliedQuotas.Store("L-1216C47A", float64(4))
+			Expect(quotaProvider.Update(ctx)).To(Succeed())
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{
+				NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"},
+			})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			headroom, ok := quotaProvider.Headroom(v1alpha5.CapacityTypeOnDemand, "m5.large")
+			Expect(ok).To(BeTrue())
+			Expect(headroom).To(BeNumerically("==", 2))
+		})
+		It("should not limit offerings when the vCPU quotas can't be retrieved", func() {
+			fakeServiceQuotasAPI.NextError.Set(fmt.Errorf("access denied"))
+			Expect(quotaProvider.Update(ctx)).ToNot(Succeed())
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{
+				NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.xlarge"},
+			})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+		})
 	})
 	Context("CapacityType", func() {
 		It("should default to on-demand", func() {
@@ -0,0 +1,70 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// InstanceTypeSnapshot is the last good instance type metadata and zonal offerings of a region, which is persisted so
+// that instance types can be served at startup without waiting for, or failing on, the EC2 APIs
+type InstanceTypeSnapshot struct {
+	Region string `json:"region"`
+	// GeneratedAt is the time that the instance types and offerings were last retrieved
+	GeneratedAt   time.Time               `json:"generatedAt"`
+	InstanceTypes []*ec2.InstanceTypeInfo `json:"instanceTypes"`
+	// Offerings are the zones that each instance type is offered in
+	Offerings map[string][]string `json:"offerings"`
+}
+
+// Encode returns the gzip compressed JSON of the snapshot
+func (s *InstanceTypeSnapshot) Encode() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		return nil, fmt.Errorf("encoding instance type snapshot, %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("compressing instance type snapshot, %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeInstanceTypeSnapshot decodes the gzip compressed JSON of a snapshot
+func DecodeInstanceTypeSnapshot(data []byte) (*InstanceTypeSnapshot, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing instance type snapshot, %w", err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing instance type snapshot, %w", err)
+	}
+	s := &InstanceTypeSnapshot{}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return nil, fmt.Errorf("decoding instance type snapshot, %w", err)
+	}
+	if len(s.InstanceTypes) == 0 || len(s.Offerings) == 0 {
+		return nil, fmt.Errorf("instance type snapshot has no instance types or offerings")
+	}
+	return s, nil
+}
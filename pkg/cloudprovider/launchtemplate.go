@@ -20,8 +20,8 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -30,6 +30,7 @@ import (
 	"github.com/patrickmn/go-cache"
 	"github.com/samber/lo"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/ptr"
 
@@ -49,90 +50,270 @@ import (
 const (
 	launchTemplateNameFormat = "Karpenter-%s-%s"
 	karpenterManagedTagKey   = "karpenter.k8s.aws/cluster"
+	// launchTemplateOwnerTagKey tags launch templates with the node template, or the provisioner with an inline
+	// provider, that they were created for
+	launchTemplateOwnerTagKey = "karpenter.k8s.aws/launch-template-owner"
+	nodeTemplateOwnerFormat   = "awsnodetemplate/%s"
+	provisionerOwnerFormat    = "provisioner/%s"
+	// latestVersion launches instances from the latest version of a launch template. Hash-named launch templates only
+	// have a single version.
+	latestVersion = "$Latest"
+	// maxDeletedLaunchTemplateVersions is the maximum number of versions that can be deleted in a single request
+	maxDeletedLaunchTemplateVersions = 200
 )
 
+// LaunchTemplate is the version of a launch template that instances are launched from
+type LaunchTemplate struct {
+	Name    string
+	Version string
+}
+
+// String returns the name of the launch template, qualified with its version unless instances are launched from its
+// latest version
+func (lt LaunchTemplate) String() string {
+	if lt.Version == latestVersion {
+		return lt.Name
+	}
+	return fmt.Sprintf("%s:%s", lt.Name, lt.Version)
+}
+
 type LaunchTemplateProvider struct {
 	sync.Mutex
 	ec2api                ec2iface.EC2API
 	amiFamily             *amifamily.Resolver
 	securityGroupProvider *securitygroup.Provider
-	cache                 *cache.Cache
-	caBundle              *string
-	cm                    *pretty.ChangeMonitor
-	kubeDNSIP             net.IP
+	// cache holds hash-named launch templates by name, and the versions of versioned launch templates by their name
+	// and the hash of their options
+	cache *cache.Cache
+	// inUse holds the names of the launch templates, and the versions of versioned launch templates, that were
+	// resolved for a launch within the grace period, so that they aren't deleted while they're launched from
+	inUse     *cache.Cache
+	caBundle  *string
+	cm        *pretty.ChangeMonitor
+	kubeDNSIP net.IP
 }
 
-func NewLaunchTemplateProvider(ctx context.Context, ec2api ec2iface.EC2API, amiFamily *amifamily.Resolver, securityGroupProvider *securitygroup.Provider, caBundle *string, startAsync <-chan struct{}, kubeDNSIP net.IP) *LaunchTemplateProvider {
-	l := &LaunchTemplateProvider{
+func NewLaunchTemplateProvider(ec2api ec2iface.EC2API, amiFamily *amifamily.Resolver, securityGroupProvider *securitygroup.Provider, caBundle *string, kubeDNSIP net.IP) *LaunchTemplateProvider {
+	return &LaunchTemplateProvider{
 		ec2api:                ec2api,
 		amiFamily:             amiFamily,
 		securityGroupProvider: securityGroupProvider,
 		cache:                 cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval),
+		inUse:                 cache.New(awscache.LaunchTemplateGracePeriod, awscache.DefaultCleanupInterval),
 		caBundle:              caBundle,
 		cm:                    pretty.NewChangeMonitor(),
 		kubeDNSIP:             kubeDNSIP,
 	}
-	l.cache.OnEvicted(l.cachedEvictedFunc(ctx))
-	go func() {
-		// only hydrate cache once elected leader
-		select {
-		case <-startAsync:
-		case <-ctx.Done():
-			return
-		}
-		l.hydrateCache(ctx)
-	}()
-	return l
 }
 
 func (p *LaunchTemplateProvider) EnsureAll(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine,
-	instanceTypes []*cloudprovider.InstanceType, additionalLabels map[string]string) (map[string][]*cloudprovider.InstanceType, error) {
+	instanceTypes []*cloudprovider.InstanceType, additionalLabels map[string]string) (map[LaunchTemplate][]*cloudprovider.InstanceType, error) {
 
 	p.Lock()
 	defer p.Unlock()
 	// If Launch Template is directly specified then just use it
 	if nodeTemplate.Spec.LaunchTemplateName != nil {
-		return map[string][]*cloudprovider.InstanceType{ptr.StringValue(nodeTemplate.Spec.LaunchTemplateName): instanceTypes}, nil
-	}
-	options, err := p.createAmiOptions(ctx, nodeTemplate, lo.Assign(machine.Labels, additionalLabels))
-	if err != nil {
-		return nil, err
+		return map[LaunchTemplate][]*cloudprovider.InstanceType{{Name: ptr.StringValue(nodeTemplate.Spec.LaunchTemplateName), Version: latestVersion}: instanceTypes}, nil
 	}
-	resolvedLaunchTemplates, err := p.amiFamily.Resolve(ctx, nodeTemplate, machine, instanceTypes, options)
+	resolvedLaunchTemplates, err := p.resolve(ctx, nodeTemplate, machine, instanceTypes, additionalLabels)
 	if err != nil {
 		return nil, err
 	}
-	launchTemplates := map[string][]*cloudprovider.InstanceType{}
+	launchTemplates := map[LaunchTemplate][]*cloudprovider.InstanceType{}
 	for _, resolvedLaunchTemplate := range resolvedLaunchTemplates {
 		// Ensure the launch template exists, or create it
-		ec2LaunchTemplate, err := p.ensureLaunchTemplate(ctx, resolvedLaunchTemplate)
+		var launchTemplate LaunchTemplate
+		if awssettings.FromContext(ctx).EnableLaunchTemplateVersions {
+			launchTemplate, err = p.ensureLaunchTemplateVersion(ctx, launchTemplateOwner(nodeTemplate, machine), resolvedLaunchTemplate)
+		} else {
+			launchTemplate, err = p.ensureLaunchTemplate(ctx, launchTemplateOwner(nodeTemplate, machine), resolvedLaunchTemplate)
+		}
 		if err != nil {
 			return nil, err
 		}
-		launchTemplates[*ec2LaunchTemplate.LaunchTemplateName] = resolvedLaunchTemplate.InstanceTypes
+		launchTemplates[launchTemplate] = append(launchTemplates[launchTemplate], resolvedLaunchTemplate.InstanceTypes...)
 	}
 	return launchTemplates, nil
 }
 
-// Invalidate deletes a launch template from cache if it exists
+// Resolve returns the names of the launch templates that the instance types would be launched from for the machine,
+// without creating them. When launch templates are versioned, it also returns the versions that they would be launched
+// from, by the name of the launch template and the hash of their options.
+func (p *LaunchTemplateProvider) Resolve(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine,
+	instanceTypes []*cloudprovider.InstanceType, additionalLabels map[string]string) (sets.String, error) {
+
+	if nodeTemplate.Spec.LaunchTemplateName != nil {
+		return sets.NewString(ptr.StringValue(nodeTemplate.Spec.LaunchTemplateName)), nil
+	}
+	resolvedLaunchTemplates, err := p.resolve(ctx, nodeTemplate, machine, instanceTypes, additionalLabels)
+	if err != nil {
+		return nil, err
+	}
+	names := sets.NewString()
+	for _, resolvedLaunchTemplate := range resolvedLaunchTemplates {
+		if awssettings.FromContext(ctx).EnableLaunchTemplateVersions {
+			name := versionedLaunchTemplateName(launchTemplateOwner(nodeTemplate, machine), resolvedLaunchTemplate)
+			names.Insert(name, fmt.Sprintf("%s/%s", name, launchTemplateHash(resolvedLaunchTemplate)))
+		} else {
+			names.Insert(launchTemplateName(resolvedLaunchTemplate))
+		}
+	}
+	return names, nil
+}
+
+func (p *LaunchTemplateProvider) resolve(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine,
+	instanceTypes []*cloudprovider.InstanceType, additionalLabels map[string]string) ([]*amifamily.LaunchTemplate, error) {
+
+	options, err := p.createAmiOptions(ctx, nodeTemplate, lo.Assign(machine.Labels, additionalLabels))
+	if err != nil {
+		return nil, err
+	}
+	return p.amiFamily.Resolve(ctx, nodeTemplate, machine, instanceTypes, options)
+}
+
+// Invalidate deletes a launch template, and any of its versions, from cache if it exists
 func (p *LaunchTemplateProvider) Invalidate(ctx context.Context, ltName string, ltID string) {
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("launch-template-name", ltName, "launch-template-id", ltID))
 	p.Lock()
 	defer p.Unlock()
-	defer p.cache.OnEvicted(p.cachedEvictedFunc(ctx))
-	p.cache.OnEvicted(nil)
 	logging.FromContext(ctx).Debugf("invalidating launch template in the cache because it no longer exists")
-	p.cache.Delete(ltName)
+	p.invalidate(ltName)
 }
 
-func launchTemplateName(options *amifamily.LaunchTemplate) string {
+func (p *LaunchTemplateProvider) invalidate(name string) {
+	p.cache.Delete(name)
+	for key := range p.cache.Items() {
+		if strings.HasPrefix(key, name+"/") {
+			p.cache.Delete(key)
+		}
+	}
+}
+
+// List returns the launch templates that Karpenter created for the cluster
+func (p *LaunchTemplateProvider) List(ctx context.Context) ([]*ec2.LaunchTemplate, error) {
+	var launchTemplates []*ec2.LaunchTemplate
+	if err := p.ec2api.DescribeLaunchTemplatesPagesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{
+		Filters: []*ec2.Filter{{Name: aws.String(fmt.Sprintf("tag:%s", karpenterManagedTagKey)), Values: []*string{aws.String(awssettings.FromContext(ctx).ClusterName)}}},
+	}, func(output *ec2.DescribeLaunchTemplatesOutput, _ bool) bool {
+		launchTemplates = append(launchTemplates, output.LaunchTemplates...)
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing launch templates, %w", err)
+	}
+	return launchTemplates, nil
+}
+
+// DeleteUnused deletes the launch template unless it's one of the launch templates that are still resolved or it was
+// resolved for a launch within the grace period, and returns whether it was deleted. Launch templates whose owner still
+// exists are deleted once they're superseded, such as by an AMI or user data change, as they're created again if
+// they're resolved for a later launch. Deletions are serialized with launches so that a launch template isn't deleted
+// while it's resolved.
+func (p *LaunchTemplateProvider) DeleteUnused(ctx context.Context, launchTemplate *ec2.LaunchTemplate, resolved sets.String) (bool, error) {
+	p.Lock()
+	defer p.Unlock()
+	name := aws.StringValue(launchTemplate.LaunchTemplateName)
+	if resolved.Has(name) {
+		return false, nil
+	}
+	if _, ok := p.inUse.Get(name); ok {
+		return false, nil
+	}
+	if _, err := p.ec2api.DeleteLaunchTemplateWithContext(ctx, &ec2.DeleteLaunchTemplateInput{LaunchTemplateId: launchTemplate.LaunchTemplateId}); err != nil {
+		if awserrors.IsNotFound(err) {
+			p.invalidate(name)
+			return false, nil
+		}
+		return false, fmt.Errorf("deleting launch template, %w", err)
+	}
+	p.invalidate(name)
+	return true, nil
+}
+
+// ListVersions returns the versions of the launch template
+func (p *LaunchTemplateProvider) ListVersions(ctx context.Context, launchTemplate *ec2.LaunchTemplate) ([]*ec2.LaunchTemplateVersion, error) {
+	var versions []*ec2.LaunchTemplateVersion
+	if err := p.ec2api.DescribeLaunchTemplateVersionsPagesWithContext(ctx, &ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateName: launchTemplate.LaunchTemplateName,
+	}, func(output *ec2.DescribeLaunchTemplateVersionsOutput, _ bool) bool {
+		versions = append(versions, output.LaunchTemplateVersions...)
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing launch template versions, %w", err)
+	}
+	return versions, nil
+}
+
+// DeleteUnusedVersions deletes the versions of the launch template that were superseded by its latest version, unless
+// they're still resolved or were resolved for a launch within the grace period, other than its default version which
+// can't be deleted, and returns the number of versions that were deleted. Resolved versions are kept so that
+// provisioners with different labels that share the launch template don't churn each other's versions.
+func (p *LaunchTemplateProvider) DeleteUnusedVersions(ctx context.Context, launchTemplate *ec2.LaunchTemplate, versions []*ec2.LaunchTemplateVersion, resolved sets.String) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+	name := aws.StringValue(launchTemplate.LaunchTemplateName)
+	latest := lo.Max(lo.Map(versions, func(version *ec2.LaunchTemplateVersion, _ int) int64 { return aws.Int64Value(version.VersionNumber) }))
+	unused := lo.Filter(versions, func(version *ec2.LaunchTemplateVersion, _ int) bool {
+		if resolved.Has(fmt.Sprintf("%s/%s", name, aws.StringValue(version.VersionDescription))) {
+			return false
+		}
+		_, ok := p.inUse.Get(LaunchTemplate{Name: name, Version: fmt.Sprint(aws.Int64Value(version.VersionNumber))}.String())
+		return !ok && !aws.BoolValue(version.DefaultVersion) && aws.Int64Value(version.VersionNumber) != latest
+	})
+	deleted := 0
+	for _, chunk := range lo.Chunk(unused, maxDeletedLaunchTemplateVersions) {
+		if _, err := p.ec2api.DeleteLaunchTemplateVersionsWithContext(ctx, &ec2.DeleteLaunchTemplateVersionsInput{
+			LaunchTemplateName: launchTemplate.LaunchTemplateName,
+			Versions: lo.Map(chunk, func(version *ec2.LaunchTemplateVersion, _ int) *string {
+				return aws.String(fmt.Sprint(aws.Int64Value(version.VersionNumber)))
+			}),
+		}); err != nil {
+			if awserrors.IsNotFound(err) {
+				p.invalidate(name)
+				return deleted, nil
+			}
+			return deleted, fmt.Errorf("deleting launch template versions, %w", err)
+		}
+		for _, version := range chunk {
+			p.cache.Delete(fmt.Sprintf("%s/%s", name, aws.StringValue(version.VersionDescription)))
+		}
+		deleted += len(chunk)
+	}
+	return deleted, nil
+}
+
+// launchTemplateHash hashes the options of a launch template, so that launch templates are only reused for launches
+// with the same options
+func launchTemplateHash(options *amifamily.LaunchTemplate) string {
 	hash, err := hashstructure.Hash(options, hashstructure.FormatV2, nil)
 	if err != nil {
 		panic(fmt.Sprintf("hashing launch template, %s", err))
 	}
+	return fmt.Sprint(hash)
+}
+
+func launchTemplateName(options *amifamily.LaunchTemplate) string {
+	return fmt.Sprintf(launchTemplateNameFormat, options.ClusterName, launchTemplateHash(options))
+}
+
+// versionedLaunchTemplateName returns the name of the launch template that the owner launches instances of the AMI
+// from when launch templates are versioned
+func versionedLaunchTemplateName(owner string, options *amifamily.LaunchTemplate) string {
+	hash, err := hashstructure.Hash([]string{owner, options.AMIID}, hashstructure.FormatV2, nil)
+	if err != nil {
+		panic(fmt.Sprintf("hashing launch template, %s", err))
+	}
 	return fmt.Sprintf(launchTemplateNameFormat, options.ClusterName, fmt.Sprint(hash))
 }
 
+// launchTemplateOwner returns the node template that launch templates are created for, or the provisioner of the
+// machine when its node template is specified inline
+func launchTemplateOwner(nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine) string {
+	if nodeTemplate.Name != "" {
+		return fmt.Sprintf(nodeTemplateOwnerFormat, nodeTemplate.Name)
+	}
+	return fmt.Sprintf(provisionerOwnerFormat, machine.Labels[v1alpha5.ProvisionerNameLabelKey])
+}
+
 func (p *LaunchTemplateProvider) createAmiOptions(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, labels map[string]string) (*amifamily.Options, error) {
 	instanceProfile, err := p.getInstanceProfile(ctx, nodeTemplate)
 	if err != nil {
@@ -150,23 +331,26 @@ func (p *LaunchTemplateProvider) createAmiOptions(ctx context.Context, nodeTempl
 		ClusterName:             awssettings.FromContext(ctx).ClusterName,
 		ClusterEndpoint:         awssettings.FromContext(ctx).ClusterEndpoint,
 		AWSENILimitedPodDensity: awssettings.FromContext(ctx).EnableENILimitedPodDensity,
+		AWSENIPrefixDelegation:  awssettings.FromContext(ctx).EnablePrefixDelegation,
+		AWSENICustomNetworking:  awssettings.FromContext(ctx).EnableCustomNetworking,
 		InstanceProfile:         instanceProfile,
 		SecurityGroupsIDs:       securityGroupsIDs,
-		Tags:                    lo.Assign(awssettings.FromContext(ctx).Tags, nodeTemplate.Spec.Tags),
+		Tags:                    v1alpha1.StaticTags(lo.Assign(awssettings.FromContext(ctx).Tags, nodeTemplate.Spec.Tags)),
 		Labels:                  labels,
 		CABundle:                p.caBundle,
 		KubeDNSIP:               p.kubeDNSIP,
 	}, nil
 }
 
-func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, options *amifamily.LaunchTemplate) (*ec2.LaunchTemplate, error) {
+func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, owner string, options *amifamily.LaunchTemplate) (LaunchTemplate, error) {
 	var launchTemplate *ec2.LaunchTemplate
 	name := launchTemplateName(options)
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("launch-template-name", name))
+	p.inUse.SetDefault(name, struct{}{})
 	// Read from cache
 	if launchTemplate, ok := p.cache.Get(name); ok {
 		p.cache.SetDefault(name, launchTemplate)
-		return launchTemplate.(*ec2.LaunchTemplate), nil
+		return LaunchTemplate{Name: name, Version: latestVersion}, nil
 	}
 	// Attempt to find an existing LT.
 	output, err := p.ec2api.DescribeLaunchTemplatesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{
@@ -174,14 +358,14 @@ func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, optio
 	})
 	// Create LT if one doesn't exist
 	if awserrors.IsNotFound(err) {
-		launchTemplate, err = p.createLaunchTemplate(ctx, options)
+		launchTemplate, err = p.createLaunchTemplate(ctx, name, owner, nil, options)
 		if err != nil {
-			return nil, fmt.Errorf("creating launch template, %w", err)
+			return LaunchTemplate{}, fmt.Errorf("creating launch template, %w", err)
 		}
 	} else if err != nil {
-		return nil, fmt.Errorf("describing launch templates, %w", err)
+		return LaunchTemplate{}, fmt.Errorf("describing launch templates, %w", err)
 	} else if len(output.LaunchTemplates) != 1 {
-		return nil, fmt.Errorf("expected to find one launch template, but found %d", len(output.LaunchTemplates))
+		return LaunchTemplate{}, fmt.Errorf("expected to find one launch template, but found %d", len(output.LaunchTemplates))
 	} else {
 		if p.cm.HasChanged("launchtemplate-"+name, name) {
 			logging.FromContext(ctx).Debugf("discovered launch template")
@@ -189,41 +373,62 @@ func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, optio
 		launchTemplate = output.LaunchTemplates[0]
 	}
 	p.cache.SetDefault(name, launchTemplate)
+	return LaunchTemplate{Name: name, Version: latestVersion}, nil
+}
+
+// ensureLaunchTemplateVersion ensures that the owner's launch template for the AMI has a version with the options, and
+// returns that version. Versions are identified by the hash of their options, which is their description.
+func (p *LaunchTemplateProvider) ensureLaunchTemplateVersion(ctx context.Context, owner string, options *amifamily.LaunchTemplate) (LaunchTemplate, error) {
+	name := versionedLaunchTemplateName(owner, options)
+	hash := launchTemplateHash(options)
+	key := fmt.Sprintf("%s/%s", name, hash)
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("launch-template-name", name))
+	p.inUse.SetDefault(name, struct{}{})
+	// Read from cache
+	if version, ok := p.cache.Get(key); ok {
+		p.cache.SetDefault(key, version)
+		launchTemplate := LaunchTemplate{Name: name, Version: version.(string)}
+		p.inUse.SetDefault(launchTemplate.String(), struct{}{})
+		return launchTemplate, nil
+	}
+	// Attempt to find an existing version
+	versions, err := p.ListVersions(ctx, &ec2.LaunchTemplate{LaunchTemplateName: aws.String(name)})
+	var version string
+	// Create the LT with the version if it doesn't exist, or add the version to the LT
+	if awserrors.IsNotFound(err) {
+		if _, err = p.createLaunchTemplate(ctx, name, owner, aws.String(hash), options); err != nil {
+			return LaunchTemplate{}, fmt.Errorf("creating launch template, %w", err)
+		}
+		version = "1"
+	} else if err != nil {
+		return LaunchTemplate{}, err
+	} else if existing, ok := lo.Find(versions, func(v *ec2.LaunchTemplateVersion) bool { return aws.StringValue(v.VersionDescription) == hash }); ok {
+		version = fmt.Sprint(aws.Int64Value(existing.VersionNumber))
+		if p.cm.HasChanged("launchtemplate-"+key, version) {
+			logging.FromContext(ctx).With("version", version).Debugf("discovered launch template version")
+		}
+	} else if version, err = p.createLaunchTemplateVersion(ctx, name, hash, options); err != nil {
+		return LaunchTemplate{}, fmt.Errorf("creating launch template version, %w", err)
+	}
+	p.cache.SetDefault(key, version)
+	launchTemplate := LaunchTemplate{Name: name, Version: version}
+	p.inUse.SetDefault(launchTemplate.String(), struct{}{})
 	return launchTemplate, nil
 }
 
-func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, options *amifamily.LaunchTemplate) (*ec2.LaunchTemplate, error) {
-	userData, err := options.UserData.Script()
+func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, name string, owner string, description *string, options *amifamily.LaunchTemplate) (*ec2.LaunchTemplate, error) {
+	launchTemplateData, err := p.launchTemplateData(ctx, options)
 	if err != nil {
 		return nil, err
 	}
 	output, err := p.ec2api.CreateLaunchTemplateWithContext(ctx, &ec2.CreateLaunchTemplateInput{
-		LaunchTemplateName: aws.String(launchTemplateName(options)),
-		LaunchTemplateData: &ec2.RequestLaunchTemplateData{
-			BlockDeviceMappings: p.blockDeviceMappings(options.BlockDeviceMappings),
-			IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileSpecificationRequest{
-				Name: aws.String(options.InstanceProfile),
-			},
-			Monitoring: &ec2.LaunchTemplatesMonitoringRequest{
-				Enabled: aws.Bool(options.DetailedMonitoring),
-			},
-			SecurityGroupIds: aws.StringSlice(options.SecurityGroupsIDs),
-			UserData:         aws.String(userData),
-			ImageId:          aws.String(options.AMIID),
-			MetadataOptions: &ec2.LaunchTemplateInstanceMetadataOptionsRequest{
-				HttpEndpoint:            options.MetadataOptions.HTTPEndpoint,
-				HttpProtocolIpv6:        options.MetadataOptions.HTTPProtocolIPv6,
-				HttpPutResponseHopLimit: options.MetadataOptions.HTTPPutResponseHopLimit,
-				HttpTokens:              options.MetadataOptions.HTTPTokens,
-			},
-			TagSpecifications: []*ec2.LaunchTemplateTagSpecificationRequest{
-				{ResourceType: aws.String(ec2.ResourceTypeNetworkInterface), Tags: v1alpha1.MergeTags(ctx, options.Tags)},
-			},
-		},
+		LaunchTemplateName: aws.String(name),
+		VersionDescription: description,
+		LaunchTemplateData: launchTemplateData,
 		TagSpecifications: []*ec2.TagSpecification{
 			{
 				ResourceType: aws.String(ec2.ResourceTypeLaunchTemplate),
-				Tags:         v1alpha1.MergeTags(ctx, options.Tags, map[string]string{karpenterManagedTagKey: options.ClusterName}),
+				Tags:         v1alpha1.MergeTags(ctx, options.Tags, map[string]string{karpenterManagedTagKey: options.ClusterName, launchTemplateOwnerTagKey: owner}),
 			},
 		},
 	})
@@ -234,6 +439,52 @@ func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, optio
 	return output.LaunchTemplate, nil
 }
 
+func (p *LaunchTemplateProvider) createLaunchTemplateVersion(ctx context.Context, name string, description string, options *amifamily.LaunchTemplate) (string, error) {
+	launchTemplateData, err := p.launchTemplateData(ctx, options)
+	if err != nil {
+		return "", err
+	}
+	output, err := p.ec2api.CreateLaunchTemplateVersionWithContext(ctx, &ec2.CreateLaunchTemplateVersionInput{
+		LaunchTemplateName: aws.String(name),
+		VersionDescription: aws.String(description),
+		LaunchTemplateData: launchTemplateData,
+	})
+	if err != nil {
+		return "", err
+	}
+	version := fmt.Sprint(aws.Int64Value(output.LaunchTemplateVersion.VersionNumber))
+	logging.FromContext(ctx).With("version", version).Debugf("created launch template version")
+	return version, nil
+}
+
+func (p *LaunchTemplateProvider) launchTemplateData(ctx context.Context, options *amifamily.LaunchTemplate) (*ec2.RequestLaunchTemplateData, error) {
+	userData, err := options.UserData.Script()
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.RequestLaunchTemplateData{
+		BlockDeviceMappings: p.blockDeviceMappings(options.BlockDeviceMappings),
+		IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileSpecificationRequest{
+			Name: aws.String(options.InstanceProfile),
+		},
+		Monitoring: &ec2.LaunchTemplatesMonitoringRequest{
+			Enabled: aws.Bool(options.DetailedMonitoring),
+		},
+		SecurityGroupIds: aws.StringSlice(options.SecurityGroupsIDs),
+		UserData:         aws.String(userData),
+		ImageId:          aws.String(options.AMIID),
+		MetadataOptions: &ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpEndpoint:            options.MetadataOptions.HTTPEndpoint,
+			HttpProtocolIpv6:        options.MetadataOptions.HTTPProtocolIPv6,
+			HttpPutResponseHopLimit: options.MetadataOptions.HTTPPutResponseHopLimit,
+			HttpTokens:              options.MetadataOptions.HTTPTokens,
+		},
+		TagSpecifications: []*ec2.LaunchTemplateTagSpecificationRequest{
+			{ResourceType: aws.String(ec2.ResourceTypeNetworkInterface), Tags: v1alpha1.MergeTags(ctx, options.Tags)},
+		},
+	}, nil
+}
+
 func (p *LaunchTemplateProvider) blockDeviceMappings(blockDeviceMappings []*v1alpha1.BlockDeviceMapping) []*ec2.LaunchTemplateBlockDeviceMappingRequest {
 	if len(blockDeviceMappings) == 0 {
 		// The EC2 API fails with empty slices and expects nil.
@@ -267,41 +518,6 @@ func (p *LaunchTemplateProvider) volumeSize(quantity *resource.Quantity) *int64
 	return aws.Int64(int64(math.Ceil(quantity.AsApproximateFloat64() / math.Pow(2, 30))))
 }
 
-// hydrateCache queries for existing Launch Templates created by Karpenter for the current cluster and adds to the LT cache.
-// Any error during hydration will result in a panic
-func (p *LaunchTemplateProvider) hydrateCache(ctx context.Context) {
-	clusterName := awssettings.FromContext(ctx).ClusterName
-	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("tag-key", karpenterManagedTagKey, "tag-value", clusterName))
-	logging.FromContext(ctx).Debugf("hydrating the launch template cache")
-	if err := p.ec2api.DescribeLaunchTemplatesPagesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{
-		Filters: []*ec2.Filter{{Name: aws.String(fmt.Sprintf("tag:%s", karpenterManagedTagKey)), Values: []*string{aws.String(clusterName)}}},
-	}, func(output *ec2.DescribeLaunchTemplatesOutput, _ bool) bool {
-		for _, lt := range output.LaunchTemplates {
-			p.cache.SetDefault(*lt.LaunchTemplateName, lt)
-		}
-		return true
-	}); err != nil {
-		logging.FromContext(ctx).Errorf(fmt.Sprintf("Unable to hydrate the AWS launch template cache, %s", err))
-	}
-	logging.FromContext(ctx).With("item-count", p.cache.ItemCount()).Debugf("finished hydrating the launch template cache")
-}
-
-func (p *LaunchTemplateProvider) cachedEvictedFunc(ctx context.Context) func(string, interface{}) {
-	return func(key string, lt interface{}) {
-		p.Lock()
-		defer p.Unlock()
-		if _, expiration, _ := p.cache.GetWithExpiration(key); expiration.After(time.Now()) {
-			return
-		}
-		launchTemplate := lt.(*ec2.LaunchTemplate)
-		if _, err := p.ec2api.DeleteLaunchTemplate(&ec2.DeleteLaunchTemplateInput{LaunchTemplateId: launchTemplate.LaunchTemplateId}); err != nil {
-			logging.FromContext(ctx).Errorf("Unable to delete launch template, %v", err)
-			return
-		}
-		logging.FromContext(ctx).Debugf("deleted launch template")
-	}
-}
-
 func (p *LaunchTemplateProvider) getInstanceProfile(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate) (string, error) {
 	if nodeTemplate.Spec.InstanceProfile != nil {
 		return aws.StringValue(nodeTemplate.Spec.InstanceProfile), nil
@@ -41,6 +41,7 @@ import (
 	"github.com/aws/karpenter/pkg/apis/v1alpha1"
 	"github.com/aws/karpenter/pkg/cloudprovider/amifamily/bootstrap"
 	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils"
 
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	coretest "github.com/aws/karpenter-core/pkg/test"
@@ -196,6 +197,236 @@ var _ = Describe("LaunchTemplates", func() {
 			ExpectScheduled(ctx, env.Client, pod)
 		})
 	})
+	Context("Garbage Collection", func() {
+		It("should tag launch templates with the node template that they were created for", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+			Expect(input.TagSpecifications[0].Tags).To(ContainElement(&ec2.Tag{
+				Key:   aws.String(launchTemplateOwnerTagKey),
+				Value: aws.String(fmt.Sprintf("awsnodetemplate/%s", nodeTemplate.Name)),
+			}))
+		})
+		It("should not delete launch templates that were resolved for a launch within the grace period", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+
+			launchTemplates, err := launchTemplateProvider.List(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(launchTemplates).To(HaveLen(1))
+			deleted, err := launchTemplateProvider.DeleteUnused(ctx, launchTemplates[0], sets.NewString())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deleted).To(BeFalse())
+			Expect(fakeEC2API.DeleteLaunchTemplateBehavior.Calls()).To(BeZero())
+		})
+		It("should not delete the launch templates that the provisioner resolves", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			launchTemplates, err := launchTemplateProvider.List(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(launchTemplates).To(HaveLen(1))
+
+			launchTemplateProvider.inUse.Flush()
+			resolved, err := cloudProvider.ResolveLaunchTemplates(ctx, provisioner)
+			Expect(err).ToNot(HaveOccurred())
+			deleted, err := launchTemplateProvider.DeleteUnused(ctx, launchTemplates[0], resolved)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deleted).To(BeFalse())
+			Expect(fakeEC2API.DeleteLaunchTemplateBehavior.Calls()).To(BeZero())
+		})
+		It("should not delete the launch templates that provisioners with inline providers resolve", func() {
+			provisioner = test.Provisioner(coretest.ProvisionerOptions{Provider: &v1alpha1.AWS{
+				SubnetSelector:        map[string]string{"*": "*"},
+				SecurityGroupSelector: map[string]string{"*": "*"},
+			}})
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			launchTemplates, err := launchTemplateProvider.List(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(launchTemplates).To(HaveLen(1))
+
+			launchTemplateProvider.inUse.Flush()
+			resolved, err := cloudProvider.ResolveLaunchTemplates(ctx, provisioner)
+			Expect(err).ToNot(HaveOccurred())
+			deleted, err := launchTemplateProvider.DeleteUnused(ctx, launchTemplates[0], resolved)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deleted).To(BeFalse())
+		})
+		It("should delete the superseded launch templates of a node template that still exists", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			superseded := aws.StringValue(fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().LaunchTemplateName)
+
+			// Changing the options of the launch template creates a launch template with a new hash
+			nodeTemplate.Spec.Tags = map[string]string{"test-key": "test-value"}
+			ExpectApplied(ctx, env.Client, nodeTemplate)
+			pod = coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			current := aws.StringValue(fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().LaunchTemplateName)
+			Expect(current).ToNot(Equal(superseded))
+			launchTemplates, err := launchTemplateProvider.List(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(launchTemplates).To(HaveLen(2))
+
+			launchTemplateProvider.inUse.Flush()
+			resolved, err := cloudProvider.ResolveLaunchTemplates(ctx, provisioner)
+			Expect(err).ToNot(HaveOccurred())
+			for _, launchTemplate := range launchTemplates {
+				deleted, err := launchTemplateProvider.DeleteUnused(ctx, launchTemplate, resolved)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(deleted).To(Equal(aws.StringValue(launchTemplate.LaunchTemplateName) == superseded))
+			}
+			Expect(fakeEC2API.DeleteLaunchTemplateBehavior.Calls()).To(Equal(1))
+			_, ok := fakeEC2API.LaunchTemplates.Load(current)
+			Expect(ok).To(BeTrue())
+		})
+		It("should delete launch templates of node templates that no longer exist", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			launchTemplates, err := launchTemplateProvider.List(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(launchTemplates).To(HaveLen(1))
+
+			launchTemplateProvider.inUse.Flush()
+			deleted, err := launchTemplateProvider.DeleteUnused(ctx, launchTemplates[0], sets.NewString())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deleted).To(BeTrue())
+			_, ok := launchTemplateCache.Get(aws.StringValue(launchTemplates[0].LaunchTemplateName))
+			Expect(ok).To(BeFalse())
+
+			// The launch template is created again for the next launch
+			pod = coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(Equal(2))
+		})
+	})
+	Context("Versions", func() {
+		BeforeEach(func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{EnableLaunchTemplateVersions: lo.ToPtr(true)}))
+		})
+		It("should launch from explicit versions of a single launch template for the node template and AMI", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(Equal(1))
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().VersionDescription).ToNot(BeNil())
+			spec := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop().LaunchTemplateConfigs[0].LaunchTemplateSpecification
+			Expect(aws.StringValue(spec.Version)).To(Equal("1"))
+
+			// Changing the options of the launch template creates a new version of it
+			nodeTemplate.Spec.Tags = map[string]string{"test-key": "test-value"}
+			ExpectApplied(ctx, env.Client, nodeTemplate)
+			pod = coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(BeZero())
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateVersionInput.Len()).To(Equal(1))
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateVersionInput.Pop().LaunchTemplateName).To(Equal(spec.LaunchTemplateName))
+			spec2 := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop().LaunchTemplateConfigs[0].LaunchTemplateSpecification
+			Expect(spec2.LaunchTemplateName).To(Equal(spec.LaunchTemplateName))
+			Expect(aws.StringValue(spec2.Version)).To(Equal("2"))
+		})
+		It("should reuse the existing version with the same options", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+
+			launchTemplateCache.Flush()
+			pod = coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(BeZero())
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateVersionInput.Len()).To(BeZero())
+			Expect(aws.StringValue(fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop().LaunchTemplateConfigs[0].LaunchTemplateSpecification.Version)).To(Equal("1"))
+		})
+		It("should delete superseded versions that weren't resolved within the grace period other than the default version", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			for _, value := range []string{"test-value-1", "test-value-2"} {
+				nodeTemplate.Spec.Tags = map[string]string{"test-key": value}
+				ExpectApplied(ctx, env.Client, nodeTemplate)
+				pod = coretest.UnschedulablePod()
+				ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+				ExpectScheduled(ctx, env.Client, pod)
+			}
+
+			launchTemplates, err := launchTemplateProvider.List(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(launchTemplates).To(HaveLen(1))
+			versions, err := launchTemplateProvider.ListVersions(ctx, launchTemplates[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(HaveLen(3))
+			// Versions that are in use aren't deleted
+			deleted, err := launchTemplateProvider.DeleteUnusedVersions(ctx, launchTemplates[0], versions, sets.NewString())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deleted).To(BeZero())
+
+			// The latest version isn't deleted, as it's the one that the next launch resolves
+			launchTemplateProvider.inUse.Flush()
+			deleted, err = launchTemplateProvider.DeleteUnusedVersions(ctx, launchTemplates[0], versions, sets.NewString())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deleted).To(Equal(1))
+			Expect(aws.StringValueSlice(fakeEC2API.DeleteLaunchTemplateVersionsBehavior.CalledWithInput.Pop().Versions)).To(ConsistOf("2"))
+
+			// The deleted version is created again for the next launch that needs it
+			nodeTemplate.Spec.Tags = map[string]string{"test-key": "test-value-1"}
+			ExpectApplied(ctx, env.Client, nodeTemplate)
+			pod = coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateVersionInput.Len()).To(Equal(3))
+		})
+		It("should not delete the versions that provisioners sharing the node template resolve", func() {
+			other := test.Provisioner(coretest.ProvisionerOptions{
+				Labels:      map[string]string{"test-key": "test-value"},
+				ProviderRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name},
+			})
+			ExpectApplied(ctx, env.Client, provisioner, other, nodeTemplate)
+			for _, p := range []*v1alpha5.Provisioner{provisioner, other} {
+				pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1alpha5.ProvisionerNameLabelKey: p.Name}})
+				ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+				ExpectScheduled(ctx, env.Client, pod)
+			}
+			launchTemplates, err := launchTemplateProvider.List(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(launchTemplates).To(HaveLen(1))
+			versions, err := launchTemplateProvider.ListVersions(ctx, launchTemplates[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(HaveLen(2))
+
+			launchTemplateProvider.inUse.Flush()
+			resolved := sets.NewString()
+			for _, p := range []*v1alpha5.Provisioner{provisioner, other} {
+				names, err := cloudProvider.ResolveLaunchTemplates(ctx, p)
+				Expect(err).ToNot(HaveOccurred())
+				resolved.Insert(names.UnsortedList()...)
+			}
+			deleted, err := launchTemplateProvider.DeleteUnusedVersions(ctx, launchTemplates[0], versions, resolved)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deleted).To(BeZero())
+			Expect(fakeEC2API.DeleteLaunchTemplateVersionsBehavior.Calls()).To(BeZero())
+		})
+	})
 	Context("Labels", func() {
 		It("should apply labels to the node", func() {
 			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
@@ -367,6 +598,50 @@ var _ = Describe("LaunchTemplates", func() {
 			ExpectTags(createFleetInput.TagSpecifications[2].Tags, nodeTemplate.Spec.Tags)
 			ExpectTagsNotFound(createFleetInput.TagSpecifications[0].Tags, settingsTags)
 		})
+		It("should render tag templates with the machine", func() {
+			nodeTemplate.Spec.Tags = map[string]string{
+				"nodepool": "{{ .ProvisionerName }}",
+				"capacity": "{{ .CapacityType }}",
+				"static":   "value",
+			}
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			ExpectTags(createFleetInput.TagSpecifications[0].Tags, map[string]string{
+				"nodepool": provisioner.Name,
+				"capacity": v1alpha5.CapacityTypeOnDemand,
+				"static":   "value",
+			})
+
+			// Launch templates are shared by machines, so they're only tagged with static tags
+			createLaunchTemplateInput := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+			ExpectTags(createLaunchTemplateInput.TagSpecifications[0].Tags, map[string]string{"static": "value"})
+			ExpectTagsNotFound(createLaunchTemplateInput.TagSpecifications[0].Tags, map[string]string{"nodepool": provisioner.Name})
+		})
+		It("should tag instances with the zone that they were launched into", func() {
+			nodeTemplate.Spec.Tags = map[string]string{"zone": "{{ .Zone }}"}
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+
+			instance, ok := fakeEC2API.Instances.Load(lo.Must(utils.ParseInstanceID(node.Spec.ProviderID)))
+			Expect(ok).To(BeTrue())
+			ExpectTags(instance.(*ec2.Instance).Tags, map[string]string{"zone": node.Labels[v1.LabelTopologyZone]})
+		})
+		It("should propagate labels as tags", func() {
+			provisioner.Spec.Labels = map[string]string{"team": "team-a", "other": "value"}
+			nodeTemplate.Spec.PropagateLabelsAsTags = []string{"team"}
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			ExpectTags(createFleetInput.TagSpecifications[0].Tags, map[string]string{"team": "team-a"})
+			ExpectTagsNotFound(createFleetInput.TagSpecifications[0].Tags, map[string]string{"other": "value"})
+		})
 	})
 	Context("Block Device Mappings", func() {
 		It("should default AL2 block device mappings", func() {
@@ -680,7 +955,7 @@ var _ = Describe("LaunchTemplates", func() {
 			nodeTemplate.Spec.AMIFamily = &v1alpha1.AMIFamilyAL2
 			instanceInfo, err := instanceTypeProvider.getInstanceTypes(ctx)
 			Expect(err).To(BeNil())
-			it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+			it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 			overhead := it.Overhead.Total()
 			Expect(overhead.Memory().String()).To(Equal("1093Mi"))
 		})
@@ -693,7 +968,7 @@ var _ = Describe("LaunchTemplates", func() {
 			nodeTemplate.Spec.AMIFamily = &v1alpha1.AMIFamilyAL2
 			instanceInfo, err := instanceTypeProvider.getInstanceTypes(ctx)
 			Expect(err).To(BeNil())
-			it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+			it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 			overhead := it.Overhead.Total()
 			Expect(overhead.Memory().String()).To(Equal("1093Mi"))
 		})
@@ -708,7 +983,7 @@ var _ = Describe("LaunchTemplates", func() {
 			nodeTemplate.Spec.AMIFamily = &v1alpha1.AMIFamilyBottlerocket
 			instanceInfo, err := instanceTypeProvider.getInstanceTypes(ctx)
 			Expect(err).To(BeNil())
-			it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+			it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 			overhead := it.Overhead.Total()
 			Expect(overhead.Memory().String()).To(Equal("1093Mi"))
 		})
@@ -721,7 +996,7 @@ var _ = Describe("LaunchTemplates", func() {
 			nodeTemplate.Spec.AMIFamily = &v1alpha1.AMIFamilyBottlerocket
 			instanceInfo, err := instanceTypeProvider.getInstanceTypes(ctx)
 			Expect(err).To(BeNil())
-			it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil)
+			it := NewInstanceType(ctx, instanceInfo["m5.xlarge"], provisioner.Spec.KubeletConfiguration, "", nodeTemplate, nil, nil)
 			overhead := it.Overhead.Total()
 			Expect(overhead.Memory().String()).To(Equal("1665Mi"))
 		})
@@ -752,6 +1027,36 @@ var _ = Describe("LaunchTemplates", func() {
 			Expect(string(userData)).To(ContainSubstring("--use-max-pods false"))
 			Expect(string(userData)).To(ContainSubstring("--max-pods=110"))
 		})
+		It("should specify --use-max-pods=false and the ENI-limited --max-pods when using custom networking", func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				EnableCustomNetworking: lo.ToPtr(true),
+			}))
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(Equal(1))
+			input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+			userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+			Expect(string(userData)).To(ContainSubstring("--use-max-pods false"))
+			Expect(string(userData)).To(ContainSubstring("--max-pods=20"))
+		})
+		It("should create a launch template per ENI-limited max-pods value when using prefix delegation", func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+				EnablePrefixDelegation: lo.ToPtr(true),
+			}))
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod()
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(BeNumerically(">", 1))
+			for fakeEC2API.CalledWithCreateLaunchTemplateInput.Len() > 0 {
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("--use-max-pods false"))
+				Expect(string(userData)).To(MatchRegexp(`--max-pods=\d+`))
+			}
+		})
 		It("should specify --use-max-pods=false and --max-pods user value when user specifies maxPods in Provisioner", func() {
 			provisioner.Spec.KubeletConfiguration = &v1alpha5.KubeletConfiguration{MaxPods: aws.Int32(10)}
 			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
@@ -1192,6 +1497,23 @@ var _ = Describe("LaunchTemplates", func() {
 				Expect(config.Settings.Kubernetes.EvictionHard["nodefs.available"]).To(Equal("15%"))
 				Expect(config.Settings.Kubernetes.EvictionHard["nodefs.inodesFree"]).To(Equal("5%"))
 			})
+			It("should specify the ENI-limited max pods value when using custom networking", func() {
+				ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
+					EnableCustomNetworking: lo.ToPtr(true),
+				}))
+				nodeTemplate.Spec.AMIFamily = &v1alpha1.AMIFamilyBottlerocket
+				ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+				pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+				ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				config := &bootstrap.BottlerocketConfig{}
+				Expect(config.UnmarshalTOML(userData)).To(Succeed())
+				Expect(config.Settings.Kubernetes.MaxPods).ToNot(BeNil())
+				Expect(*config.Settings.Kubernetes.MaxPods).To(BeNumerically("==", 20))
+			})
 			It("should specify max pods value when passing maxPods in configuration", func() {
 				nodeTemplate.Spec.AMIFamily = &v1alpha1.AMIFamilyBottlerocket
 				provisioner = test.Provisioner(coretest.ProvisionerOptions{
@@ -0,0 +1,106 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter-core/pkg/metrics"
+)
+
+const (
+	cloudProviderSubsystem = "cloudprovider"
+	provisionerLabel       = "provisioner"
+	instanceTypeLabel      = "instance_type"
+	capacityTypeLabel      = "capacity_type"
+	zoneLabel              = "zone"
+	priceLabel             = "price"
+	regionLabel            = "region"
+	sourceLabel            = "source"
+)
+
+var (
+	instanceTypeCandidatesDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: cloudProviderSubsystem,
+			Name:      "instance_type_candidates_dropped",
+			Help:      "Count of compatible instance types that were not sent to CreateFleet because a launch exceeded the maximum number of instance types. Labeled by provisioner.",
+		},
+		[]string{provisionerLabel},
+	)
+	offeringPrice = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: cloudProviderSubsystem,
+			Name:      "offering_price",
+			Help:      "Hourly price of the offerings whose prices are overridden in the currency of the prices of the region, which is Chinese yuan in the China regions and US dollars elsewhere. Labeled by instance type, capacity type, zone, which is empty for on-demand offerings, and price, which is list or effective.",
+		},
+		[]string{instanceTypeLabel, capacityTypeLabel, zoneLabel, priceLabel},
+	)
+	pricingSource = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: cloudProviderSubsystem,
+			Name:      "pricing_source",
+			Help:      "Whether the prices of a capacity type come from a source, which is 1 for the current source and 0 for the others. Labeled by region, capacity type, and source, which is live, static or file.",
+		},
+		[]string{regionLabel, capacityTypeLabel, sourceLabel},
+	)
+	pricingLastUpdated = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: cloudProviderSubsystem,
+			Name:      "pricing_last_update_time_seconds",
+			Help:      "Unix time that the prices of a capacity type were last updated, which is the time that static or file prices were generated until prices are retrieved. Labeled by region and capacity type.",
+		},
+		[]string{regionLabel, capacityTypeLabel},
+	)
+	pricingUpdateErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: cloudProviderSubsystem,
+			Name:      "pricing_update_errors_total",
+			Help:      "Count of failed updates of the prices of a capacity type, where the previous prices are kept. Labeled by capacity type.",
+		},
+		[]string{capacityTypeLabel},
+	)
+	instanceTypesWithoutPrice = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: cloudProviderSubsystem,
+			Name:      "instance_types_without_price",
+			Help:      "Number of instance types that support a capacity type but have no price for it, so that their offerings of the capacity type are unavailable. Labeled by region and capacity type.",
+		},
+		[]string{regionLabel, capacityTypeLabel},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(instanceTypeCandidatesDropped, offeringPrice, pricingSource, pricingLastUpdated, pricingUpdateErrors, instanceTypesWithoutPrice)
+}
+
+func setOfferingPrice(instanceType string, capacityType string, zone string, listPrice float64, effectivePrice float64) {
+	offeringPrice.With(prometheus.Labels{instanceTypeLabel: instanceType, capacityTypeLabel: capacityType, zoneLabel: zone, priceLabel: "list"}).Set(listPrice)
+	offeringPrice.With(prometheus.Labels{instanceTypeLabel: instanceType, capacityTypeLabel: capacityType, zoneLabel: zone, priceLabel: "effective"}).Set(effectivePrice)
+}
+
+func setPricingSource(region string, capacityType string, source PricingSource) {
+	for _, s := range pricingSources {
+		pricingSource.With(prometheus.Labels{regionLabel: region, capacityTypeLabel: capacityType, sourceLabel: string(s)}).Set(lo.Ternary(s == source, 1.0, 0.0))
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+)
+
+// PriceFile is a snapshot of the on-demand and spot list prices of a region, as generated by hack/code/prices_gen.go.
+// It's loaded in isolated VPCs, where the pricing endpoints can't be reached, in place of the compiled in prices.
+type PriceFile struct {
+	Region string `json:"region"`
+	// GeneratedAt is the time that the prices were retrieved
+	GeneratedAt time.Time `json:"generatedAt"`
+	// OnDemand are the hourly on-demand prices by instance type, in the currency of the prices of the region
+	OnDemand map[string]float64 `json:"onDemand"`
+	// Spot are the hourly spot prices by instance type and zone, in the currency of the prices of the region. Spot offerings are priced at their
+	// on-demand prices if there are no spot prices.
+	Spot map[string]map[string]float64 `json:"spot,omitempty"`
+}
+
+// ParsePriceFile parses a JSON price file
+func ParsePriceFile(data []byte) (*PriceFile, error) {
+	f := &PriceFile{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(f); err != nil {
+		return nil, fmt.Errorf("decoding price file, %w", err)
+	}
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *PriceFile) validate() (errs error) {
+	if f.GeneratedAt.IsZero() {
+		errs = multierr.Append(errs, fmt.Errorf("generatedAt must be set"))
+	}
+	if len(f.OnDemand) == 0 {
+		errs = multierr.Append(errs, fmt.Errorf("onDemand must contain prices"))
+	}
+	for instanceType, price := range f.OnDemand {
+		if price < 0 {
+			errs = multierr.Append(errs, fmt.Errorf("on-demand price %v of %s must not be negative", price, instanceType))
+		}
+	}
+	for instanceType, zonalPrices := range f.Spot {
+		for zone, price := range zonalPrices {
+			if price < 0 {
+				errs = multierr.Append(errs, fmt.Errorf("spot price %v of %s in %s must not be negative", price, instanceType, zone))
+			}
+		}
+	}
+	return errs
+}
+
+// Missing returns the sorted instance types that have no on-demand price
+func (f *PriceFile) Missing(instanceTypes []string) []string {
+	missing := lo.Filter(instanceTypes, func(instanceType string, _ int) bool {
+		_, ok := f.OnDemand[instanceType]
+		return !ok
+	})
+	sort.Strings(missing)
+	return missing
+}
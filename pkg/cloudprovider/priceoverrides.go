@@ -0,0 +1,114 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	"sigs.k8s.io/yaml"
+)
+
+// PriceOverride overrides the list prices of the offerings that it selects with their effective prices, such as the
+// prices of instance types that are discounted by Savings Plans or Reserved Instances. Selectors that are empty select
+// all offerings.
+type PriceOverride struct {
+	InstanceFamily string `json:"instanceFamily,omitempty"`
+	InstanceType   string `json:"instanceType,omitempty"`
+	Region         string `json:"region,omitempty"`
+	CapacityType   string `json:"capacityType,omitempty"`
+	// Discount is the fraction of the list price that's discounted, such as 0.4 for a discount of 40%
+	Discount *float64 `json:"discount,omitempty"`
+	// Price is the absolute hourly price in the currency of the prices of the region
+	Price *float64 `json:"price,omitempty"`
+}
+
+// PriceOverrides are the price overrides of the offerings. An offering's price is overridden by the most specific
+// override that selects it, where instance types are more specific than instance families, which are more specific
+// than capacity types and then regions. Later overrides take precedence over overrides that are as specific.
+type PriceOverrides []PriceOverride
+
+// ParsePriceOverrides parses a YAML or JSON list of price overrides
+func ParsePriceOverrides(data string) (PriceOverrides, error) {
+	overrides := PriceOverrides{}
+	if err := yaml.UnmarshalStrict([]byte(data), &overrides); err != nil {
+		return nil, fmt.Errorf("decoding price overrides, %w", err)
+	}
+	var errs error
+	for i, override := range overrides {
+		if err := override.validate(); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("price override %d, %w", i, err))
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return overrides, nil
+}
+
+func (o PriceOverride) validate() error {
+	if (o.Discount == nil) == (o.Price == nil) {
+		return fmt.Errorf("exactly one of discount or price must be set")
+	}
+	if o.Discount != nil && (*o.Discount < 0 || *o.Discount >= 1) {
+		return fmt.Errorf("discount %v must be at least 0 and less than 1", *o.Discount)
+	}
+	if o.Price != nil && *o.Price < 0 {
+		return fmt.Errorf("price %v must not be negative", *o.Price)
+	}
+	if o.CapacityType != "" && !lo.Contains([]string{ec2.UsageClassTypeSpot, ec2.UsageClassTypeOnDemand}, o.CapacityType) {
+		return fmt.Errorf("capacity type %s must be %s or %s", o.CapacityType, ec2.UsageClassTypeSpot, ec2.UsageClassTypeOnDemand)
+	}
+	return nil
+}
+
+func (o PriceOverride) selects(region string, instanceType string, capacityType string) bool {
+	return (o.InstanceFamily == "" || o.InstanceFamily == strings.Split(instanceType, ".")[0]) &&
+		(o.InstanceType == "" || o.InstanceType == instanceType) &&
+		(o.Region == "" || o.Region == region) &&
+		(o.CapacityType == "" || o.CapacityType == capacityType)
+}
+
+func (o PriceOverride) specificity() int {
+	return lo.Sum(lo.Map([]string{o.Region, o.CapacityType, o.InstanceFamily, o.InstanceType}, func(selector string, i int) int {
+		return lo.Ternary(selector != "", 1<<i, 0)
+	}))
+}
+
+// Apply returns the effective price of an offering with the list price
+func (o PriceOverrides) Apply(listPrice float64, region string, instanceType string, capacityType string) float64 {
+	var selected *PriceOverride
+	for i := range o {
+		if o[i].selects(region, instanceType, capacityType) && (selected == nil || o[i].specificity() >= selected.specificity()) {
+			selected = &o[i]
+		}
+	}
+	switch {
+	case selected == nil:
+		return listPrice
+	case selected.Price != nil:
+		return *selected.Price
+	default:
+		return listPrice * (1 - *selected.Discount)
+	}
+}
+
+// Overrides returns whether the price of an offering is overridden
+func (o PriceOverrides) Overrides(region string, instanceType string, capacityType string) bool {
+	return lo.ContainsBy(o, func(override PriceOverride) bool { return override.selects(region, instanceType, capacityType) })
+}
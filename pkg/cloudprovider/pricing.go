@@ -19,23 +19,31 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/pricing"
 	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/samber/lo"
 	"go.uber.org/multierr"
 	"knative.dev/pkg/logging"
 
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/utils/pretty"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
 )
 
 // PricingProvider provides actual pricing data to the AWS cloud provider to allow it to make more informed decisions
@@ -48,13 +56,84 @@ type PricingProvider struct {
 	ec2     ec2iface.EC2API
 	pricing pricingiface.PricingAPI
 	region  string
+	options PricingOptions
 	cm      *pretty.ChangeMonitor
+	// updating is whether prices are periodically updated from the pricing and EC2 APIs, which is only the case once
+	// the provider is elected leader outside of isolated VPCs
+	updating atomic.Bool
+	// refresh is signaled to update pricing when the prices of another operating system are requested
+	refresh chan struct{}
+
+	mu sync.RWMutex
+	// onDemandUpdateTimes are the times that the on-demand prices of each operating system were last updated, which are
+	// updated independently so that failing to retrieve the prices of one operating system doesn't hold back the others
+	onDemandUpdateTimes map[OperatingSystem]time.Time
+	onDemandPrices      map[OperatingSystem]map[string]float64
+	onDemandSource      PricingSource
+	spotUpdateTime      time.Time
+	spotPrices          map[OperatingSystem]map[string]zonalPricing
+	spotSource          PricingSource
+	// operatingSystems are the operating systems whose prices are retrieved, which are added when their prices are
+	// first requested
+	operatingSystems []OperatingSystem
+	overrides        PriceOverrides
+	// spotDefaulted is whether spot offerings are priced at the default prices of their zonal pricing because no spot
+	// prices are known yet
+	spotDefaulted bool
+	// SeqNum is incremented when the price overrides or price file change, so that the offerings of instance types are
+	// repriced
+	SeqNum uint64
+}
+
+// PricingSource is where the prices of a capacity type come from
+type PricingSource string
+
+const (
+	// PricingSourceLive are prices retrieved from the pricing and EC2 APIs
+	PricingSourceLive PricingSource = "live"
+	// PricingSourceStatic are the compiled in prices of the partition
+	PricingSourceStatic PricingSource = "static"
+	// PricingSourceFile are the prices of a price file
+	PricingSourceFile PricingSource = "file"
+)
+
+var pricingSources = []PricingSource{PricingSourceLive, PricingSourceStatic, PricingSourceFile}
+
+// OperatingSystem is an operating system and license model that instances are priced by
+type OperatingSystem struct {
+	// Name is the operating system of the products of the pricing API
+	Name string
+	// LicenseModel is the license model of the products of the pricing API
+	LicenseModel string
+	// ProductDescription is the product description of the spot price history
+	ProductDescription string
+}
+
+func (o OperatingSystem) String() string {
+	return fmt.Sprintf("%s (%s)", o.Name, o.LicenseModel)
+}
+
+var (
+	OperatingSystemLinux   = OperatingSystem{Name: "Linux", LicenseModel: "No License required", ProductDescription: "Linux/UNIX"}
+	OperatingSystemRHEL    = OperatingSystem{Name: "RHEL", LicenseModel: "No License required", ProductDescription: "Red Hat Enterprise Linux"}
+	OperatingSystemSUSE    = OperatingSystem{Name: "SUSE", LicenseModel: "No License required", ProductDescription: "SUSE Linux"}
+	OperatingSystemWindows = OperatingSystem{Name: "Windows", LicenseModel: "License included", ProductDescription: "Windows"}
+
+	knownOperatingSystems = []OperatingSystem{OperatingSystemLinux, OperatingSystemRHEL, OperatingSystemSUSE, OperatingSystemWindows}
+)
 
-	mu                 sync.RWMutex
-	onDemandUpdateTime time.Time
-	onDemandPrices     map[string]float64
-	spotUpdateTime     time.Time
-	spotPrices         map[string]zonalPricing
+// OperatingSystemFromPlatformDetails returns the operating system that instances of an AMI with the platform details
+// are priced by. AMIs with their own licenses, as well as unknown platforms, are priced as Linux since EC2 doesn't
+// charge for their licenses.
+func OperatingSystemFromPlatformDetails(platformDetails string) OperatingSystem {
+	if operatingSystem, ok := lo.Find(knownOperatingSystems, func(o OperatingSystem) bool { return o.ProductDescription == platformDetails }); ok {
+		return operatingSystem
+	}
+	// Windows AMIs with SQL Server are at least as expensive as Windows AMIs
+	if strings.HasPrefix(platformDetails, OperatingSystemWindows.ProductDescription+" with") {
+		return OperatingSystemWindows
+	}
+	return OperatingSystemLinux
 }
 
 // zonalPricing is used to capture the per-zone price
@@ -66,6 +145,25 @@ type zonalPricing struct {
 	prices       map[string]float64
 }
 
+// HourlyCost is the hourly price of an instance, which machines are annotated with when they're launched
+type HourlyCost struct {
+	Price        float64 `json:"price"`
+	CapacityType string  `json:"capacityType"`
+	Zone         string  `json:"zone"`
+	// PricedAt is the time that the pricing that the price came from was last updated
+	PricedAt time.Time `json:"pricedAt"`
+}
+
+// MachineHourlyCost returns the hourly cost that the machine was annotated with when it was launched
+func MachineHourlyCost(machine *v1alpha5.Machine) (HourlyCost, bool) {
+	cost := HourlyCost{}
+	raw, ok := machine.Annotations[v1alpha1.HourlyCostAnnotationKey]
+	if !ok || json.Unmarshal([]byte(raw), &cost) != nil {
+		return HourlyCost{}, false
+	}
+	return cost, true
+}
+
 type pricingErr struct {
 	error
 	lastUpdateTime time.Time
@@ -79,39 +177,149 @@ func newZonalPricing(defaultPrice float64) zonalPricing {
 	return z
 }
 
-// pricingUpdatePeriod is how often we try to update our pricing information after the initial update on startup
-const pricingUpdatePeriod = 12 * time.Hour
+const (
+	// pricingUpdatePeriod is how often we try to update our on-demand pricing information after the initial update on
+	// startup, unless it's configured
+	pricingUpdatePeriod = 12 * time.Hour
+	// spotPricingUpdatePeriod is how often we try to update our spot pricing information after the initial update on
+	// startup, unless it's configured. Spot prices change more often than on-demand prices.
+	spotPricingUpdatePeriod = time.Hour
+)
+
+// PricingOptions configure how often prices are updated, and how spot prices are computed from the spot price history.
+// Zero values are defaulted.
+type PricingOptions struct {
+	UpdatePeriod     time.Duration
+	SpotUpdatePeriod time.Duration
+	// SpotPriceHistoryWindow is the window of spot price history that spot prices are computed from, where only the
+	// latest spot prices are retrieved if it's zero
+	SpotPriceHistoryWindow time.Duration
+	SpotPriceStatistic     settings.SpotPriceStatistic
+	// StalenessThreshold is the maximum age of the prices that are updated from the pricing and EC2 APIs before the
+	// readiness probe fails, where prices aren't checked if it's zero
+	StalenessThreshold time.Duration
+}
+
+// PricingOptionsFromSettings returns the pricing options of the settings
+func PricingOptionsFromSettings(s *settings.Settings) PricingOptions {
+	return PricingOptions{
+		UpdatePeriod:           s.PricingUpdatePeriod.Duration,
+		SpotUpdatePeriod:       s.SpotPricingUpdatePeriod.Duration,
+		SpotPriceHistoryWindow: s.SpotPriceHistoryWindow.Duration,
+		SpotPriceStatistic:     s.SpotPriceStatistic,
+		StalenessThreshold:     s.PricingStalenessThreshold.Duration,
+	}
+}
 
-// NewPricingAPI returns a pricing API configured based on a particular region
+func (o PricingOptions) withDefaults() PricingOptions {
+	if o.UpdatePeriod <= 0 {
+		o.UpdatePeriod = pricingUpdatePeriod
+	}
+	if o.SpotUpdatePeriod <= 0 {
+		o.SpotUpdatePeriod = spotPricingUpdatePeriod
+	}
+	if o.SpotPriceStatistic == "" {
+		o.SpotPriceStatistic = settings.SpotPriceLatest
+	}
+	return o
+}
+
+// NewPricingAPI returns a pricing API configured based on a particular region, or nil if the partition of the region
+// has no pricing endpoint
 func NewPricingAPI(sess *session.Session, region string) pricingiface.PricingAPI {
 	if sess == nil {
 		return nil
 	}
-	// pricing API doesn't have an endpoint in all regions
-	pricingAPIRegion := "us-east-1"
-	if strings.HasPrefix(region, "ap-") {
-		pricingAPIRegion = "ap-south-1"
+	pricingAPIRegion, ok := PricingAPIRegion(region)
+	if !ok {
+		return nil
 	}
 	return pricing.New(sess, &aws.Config{Region: aws.String(pricingAPIRegion)})
 }
 
-func NewPricingProvider(ctx context.Context, pricing pricingiface.PricingAPI, ec2Api ec2iface.EC2API, region string, isolatedVPC bool, startAsync <-chan struct{}) *PricingProvider {
+// PricingAPIRegion returns the region of the pricing endpoint that serves the prices of a region. The pricing API
+// doesn't have an endpoint in all regions, and only serves the regions of its own partition. GovCloud has no pricing
+// endpoint at all.
+func PricingAPIRegion(region string) (string, bool) {
+	switch partitionID(region) {
+	case endpoints.AwsPartitionID:
+		if strings.HasPrefix(region, "ap-") {
+			return "ap-south-1", true
+		}
+		return "us-east-1", true
+	case endpoints.AwsCnPartitionID:
+		return "cn-northwest-1", true
+	default:
+		return "", false
+	}
+}
+
+// partitionID returns the ID of the partition of a region, assuming that unknown regions are in the aws partition
+func partitionID(region string) string {
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return partition.ID()
+	}
+	return endpoints.AwsPartitionID
+}
+
+// staticPrices are the compiled in on-demand prices of a partition, as generated by hack/code/prices_gen.go
+type staticPrices struct {
+	// region is the region of the partition that the prices were retrieved for
+	region     string
+	updateTime time.Time
+	onDemand   map[string]float64
+}
+
+// initialPrices are the compiled in prices by partition ID, which are registered by the generated pricing files
+var initialPrices = map[string]staticPrices{}
+
+// initialPricesFor returns the compiled in prices of the partition of a region, falling back to the prices of the aws
+// partition, which still provide a relative ordering of the instance types, if the partition has none. The prices of
+// the aws-cn partition are only generated by hack/api-code-gen.sh with the credentials of that partition, and none are
+// checked in. As the live prices of the China regions are in Chinese yuan, they don't fall back to prices in US dollars
+// unless the VPC is isolated and live prices are never loaded, so their offerings are unpriced until prices are updated.
+func initialPricesFor(ctx context.Context, region string, isolatedVPC bool) staticPrices {
+	partition := partitionID(region)
+	if prices, ok := initialPrices[partition]; ok {
+		return prices
+	}
+	if partition == endpoints.AwsCnPartitionID && !isolatedVPC {
+		logging.FromContext(ctx).Debugf("no static pricing for partition %s, offerings are unpriced until prices are updated", partition)
+		return staticPrices{onDemand: map[string]float64{}}
+	}
+	prices := initialPrices[endpoints.AwsPartitionID]
+	logging.FromContext(ctx).Debugf("no static pricing for partition %s, using static pricing of %s in US dollars", partition, prices.region)
+	return prices
+}
+
+func NewPricingProvider(ctx context.Context, pricing pricingiface.PricingAPI, ec2Api ec2iface.EC2API, region string, isolatedVPC bool, options PricingOptions, startAsync <-chan struct{}) *PricingProvider {
+	initial := initialPricesFor(ctx, region, isolatedVPC)
 	p := &PricingProvider{
-		region:             region,
-		onDemandUpdateTime: initialPriceUpdate,
-		onDemandPrices:     initialOnDemandPrices,
-		spotUpdateTime:     initialPriceUpdate,
+		region:              region,
+		options:             options.withDefaults(),
+		onDemandUpdateTimes: map[OperatingSystem]time.Time{OperatingSystemLinux: initial.updateTime},
+		onDemandPrices:      map[OperatingSystem]map[string]float64{OperatingSystemLinux: initial.onDemand},
+		onDemandSource:      PricingSourceStatic,
+		spotUpdateTime:      initial.updateTime,
 		// default our spot pricing to the same as the on-demand pricing until a price update
-		spotPrices: populateInitialSpotPricing(initialOnDemandPrices),
-		ec2:        ec2Api,
-		pricing:    pricing,
-		cm:         pretty.NewChangeMonitor(),
+		spotPrices:       map[OperatingSystem]map[string]zonalPricing{OperatingSystemLinux: populateInitialSpotPricing(initial.onDemand)},
+		spotSource:       PricingSourceStatic,
+		spotDefaulted:    true,
+		operatingSystems: []OperatingSystem{OperatingSystemLinux},
+		ec2:              ec2Api,
+		pricing:          pricing,
+		cm:               pretty.NewChangeMonitor(),
+		refresh:          make(chan struct{}, 1),
 	}
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named("pricing"))
+	p.updatePriceMetrics()
 
 	if isolatedVPC {
 		logging.FromContext(ctx).Infof("assuming isolated VPC, pricing information will not be updated")
 	} else {
+		if pricing == nil {
+			logging.FromContext(ctx).Infof("no pricing endpoint for region %s, on-demand pricing information will not be updated", region)
+		}
 		go func() {
 			// perform an initial price update at startup
 			p.updatePricing(ctx)
@@ -123,17 +331,26 @@ func NewPricingProvider(ctx context.Context, pricing pricingiface.PricingAPI, ec
 			case <-ctx.Done():
 				return
 			}
+			p.updating.Store(true)
 			// if it took many hours to be elected leader, we want to re-fetch pricing before we start our periodic
 			// polling
-			if time.Since(startup) > pricingUpdatePeriod {
+			if time.Since(startup) > p.options.SpotUpdatePeriod {
 				p.updatePricing(ctx)
 			}
 
+			onDemandTicker := time.NewTicker(p.options.UpdatePeriod)
+			defer onDemandTicker.Stop()
+			spotTicker := time.NewTicker(p.options.SpotUpdatePeriod)
+			defer spotTicker.Stop()
 			for {
 				select {
 				case <-ctx.Done():
 					return
-				case <-time.After(pricingUpdatePeriod):
+				case <-onDemandTicker.C:
+					p.updateOnDemand(ctx)
+				case <-spotTicker.C:
+					p.updateSpot(ctx)
+				case <-p.refresh:
 					p.updatePricing(ctx)
 				}
 			}
@@ -142,18 +359,33 @@ func NewPricingProvider(ctx context.Context, pricing pricingiface.PricingAPI, ec
 	return p
 }
 
-// InstanceTypes returns the list of all instance types for which either a spot or on-demand price is known.
+// InstanceTypes returns the list of all instance types for which either a spot or on-demand Linux price is known.
 func (p *PricingProvider) InstanceTypes() []string {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return lo.Union(lo.Keys(p.onDemandPrices), lo.Keys(p.spotPrices))
+	return lo.Union(lo.Keys(p.onDemandPrices[OperatingSystemLinux]), lo.Keys(p.spotPrices[OperatingSystemLinux]))
 }
 
-// OnDemandLastUpdated returns the time that the on-demand pricing was last updated
+// OnDemandLastUpdated returns the time that the on-demand Linux pricing was last updated
 func (p *PricingProvider) OnDemandLastUpdated() time.Time {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return p.onDemandUpdateTime
+	return p.onDemandUpdateTimes[OperatingSystemLinux]
+}
+
+// OperatingSystemOnDemandLastUpdated returns the time that the on-demand pricing of an operating system was last
+// updated. Operating systems other than Linux are priced as Linux until their prices are retrieved.
+func (p *PricingProvider) OperatingSystemOnDemandLastUpdated(operatingSystem OperatingSystem) time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.onDemandLastUpdated(operatingSystem)
+}
+
+func (p *PricingProvider) onDemandLastUpdated(operatingSystem OperatingSystem) time.Time {
+	if updateTime, ok := p.onDemandUpdateTimes[operatingSystem]; ok {
+		return updateTime
+	}
+	return p.onDemandUpdateTimes[OperatingSystemLinux]
 }
 
 // SpotLastUpdated returns the time that the spot pricing was last updated
@@ -163,28 +395,113 @@ func (p *PricingProvider) SpotLastUpdated() time.Time {
 	return p.spotUpdateTime
 }
 
-// OnDemandPrice returns the last known on-demand price for a given instance type, returning an error if there is no
-// known on-demand pricing for the instance type.
+// Source returns where the prices of the capacity type come from
+func (p *PricingProvider) Source(capacityType string) PricingSource {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if capacityType == ec2.UsageClassTypeSpot {
+		return p.spotSource
+	}
+	return p.onDemandSource
+}
+
+// LastUpdated returns the time that the pricing of the capacity type was last updated
+func (p *PricingProvider) LastUpdated(capacityType string) time.Time {
+	if capacityType == ec2.UsageClassTypeSpot {
+		return p.SpotLastUpdated()
+	}
+	return p.OnDemandLastUpdated()
+}
+
+// HourlyCost returns the last known hourly cost of an instance type in a zone with a capacity type
+func (p *PricingProvider) HourlyCost(instanceType string, zone string, capacityType string) (HourlyCost, bool) {
+	var price float64
+	var ok bool
+	switch capacityType {
+	case ec2.UsageClassTypeSpot:
+		price, ok = p.SpotPrice(instanceType, zone)
+	case ec2.UsageClassTypeOnDemand:
+		price, ok = p.OnDemandPrice(instanceType)
+	}
+	return HourlyCost{Price: price, CapacityType: capacityType, Zone: zone, PricedAt: p.LastUpdated(capacityType)}, ok
+}
+
+// OnDemandPrice returns the last known effective on-demand Linux price for a given instance type, which is its list
+// price with the price overrides applied, returning an error if there is no known on-demand pricing for the instance type.
 func (p *PricingProvider) OnDemandPrice(instanceType string) (float64, bool) {
+	return p.OperatingSystemOnDemandPrice(OperatingSystemLinux, instanceType)
+}
+
+// OperatingSystemOnDemandPrice returns the last known effective on-demand price for a given operating system and
+// instance type. Operating systems other than Linux are priced as Linux until their prices are retrieved.
+func (p *PricingProvider) OperatingSystemOnDemandPrice(operatingSystem OperatingSystem, instanceType string) (float64, bool) {
+	p.track(operatingSystem)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	price, ok := p.onDemandListPrice(operatingSystem, instanceType)
+	if !ok {
+		return 0.0, false
+	}
+	return p.overrides.Apply(price, p.region, instanceType, ec2.UsageClassTypeOnDemand), true
+}
+
+// OnDemandListPrice returns the last known public on-demand Linux price for a given instance type, without the price
+// overrides
+func (p *PricingProvider) OnDemandListPrice(instanceType string) (float64, bool) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	price, ok := p.onDemandPrices[instanceType]
+	return p.onDemandListPrice(OperatingSystemLinux, instanceType)
+}
+
+func (p *PricingProvider) onDemandListPrice(operatingSystem OperatingSystem, instanceType string) (float64, bool) {
+	prices, ok := p.onDemandPrices[operatingSystem]
+	if !ok {
+		prices = p.onDemandPrices[OperatingSystemLinux]
+	}
+	price, ok := prices[instanceType]
 	if !ok {
 		return 0.0, false
 	}
 	return price, true
 }
 
-// SpotPrice returns the last known spot price for a given instance type and zone, returning an error
-// if there is no known spot pricing for that instance type or zone
+// SpotPrice returns the last known effective spot Linux price for a given instance type and zone, which is its list
+// price with the price overrides applied, returning an error if there is no known spot pricing for that instance type
+// or zone
 func (p *PricingProvider) SpotPrice(instanceType string, zone string) (float64, bool) {
+	return p.OperatingSystemSpotPrice(OperatingSystemLinux, instanceType, zone)
+}
+
+// OperatingSystemSpotPrice returns the last known effective spot price for a given operating system, instance type
+// and zone. Operating systems other than Linux are priced as Linux until their prices are retrieved.
+func (p *PricingProvider) OperatingSystemSpotPrice(operatingSystem OperatingSystem, instanceType string, zone string) (float64, bool) {
+	p.track(operatingSystem)
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	if val, ok := p.spotPrices[instanceType]; ok {
-		if p.spotUpdateTime.Equal(initialPriceUpdate) {
+	price, ok := p.spotListPrice(operatingSystem, instanceType, zone)
+	if !ok {
+		return 0.0, false
+	}
+	return p.overrides.Apply(price, p.region, instanceType, ec2.UsageClassTypeSpot), true
+}
+
+// SpotListPrice returns the last known spot Linux price for a given instance type and zone, without the price overrides
+func (p *PricingProvider) SpotListPrice(instanceType string, zone string) (float64, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.spotListPrice(OperatingSystemLinux, instanceType, zone)
+}
+
+func (p *PricingProvider) spotListPrice(operatingSystem OperatingSystem, instanceType string, zone string) (float64, bool) {
+	prices, ok := p.spotPrices[operatingSystem]
+	if !ok {
+		operatingSystem, prices = OperatingSystemLinux, p.spotPrices[OperatingSystemLinux]
+	}
+	if val, ok := prices[instanceType]; ok {
+		if operatingSystem == OperatingSystemLinux && p.spotDefaulted {
 			return val.defaultPrice, true
 		}
-		if price, ok := p.spotPrices[instanceType].prices[zone]; ok {
+		if price, ok := val.prices[zone]; ok {
 			return price, true
 		}
 		return 0.0, false
@@ -192,28 +509,200 @@ func (p *PricingProvider) SpotPrice(instanceType string, zone string) (float64,
 	return 0.0, false
 }
 
+// track adds an operating system to the operating systems whose prices are retrieved, and signals that pricing should
+// be updated if it wasn't tracked yet
+func (p *PricingProvider) track(operatingSystem OperatingSystem) {
+	p.mu.RLock()
+	tracked := lo.Contains(p.operatingSystems, operatingSystem)
+	p.mu.RUnlock()
+	if tracked {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if lo.Contains(p.operatingSystems, operatingSystem) {
+		return
+	}
+	p.operatingSystems = append(p.operatingSystems, operatingSystem)
+	select {
+	case p.refresh <- struct{}{}:
+	default:
+	}
+}
+
 func (p *PricingProvider) updatePricing(ctx context.Context) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := p.updateOnDemandPricing(ctx); err != nil {
-			logging.FromContext(ctx).Errorf("updating on-demand pricing, %s, using existing pricing data from %s", err, err.lastUpdateTime.Format(time.RFC3339))
-		}
+		p.updateOnDemand(ctx)
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := p.updateSpotPricing(ctx); err != nil {
-			logging.FromContext(ctx).Errorf("updating spot pricing, %s, using existing pricing data from %s", err, err.lastUpdateTime.Format(time.RFC3339))
-		}
+		p.updateSpot(ctx)
 	}()
 
 	wg.Wait()
 }
 
+func (p *PricingProvider) updateOnDemand(ctx context.Context) {
+	if err := p.updateOnDemandPricing(ctx); err != nil {
+		pricingUpdateErrors.With(prometheus.Labels{capacityTypeLabel: ec2.UsageClassTypeOnDemand}).Inc()
+		logging.FromContext(ctx).Errorf("updating on-demand pricing, %s, using existing pricing data from %s", err, err.lastUpdateTime.Format(time.RFC3339))
+	}
+	p.updatePriceMetrics()
+}
+
+func (p *PricingProvider) updateSpot(ctx context.Context) {
+	if err := p.updateSpotPricing(ctx); err != nil {
+		pricingUpdateErrors.With(prometheus.Labels{capacityTypeLabel: ec2.UsageClassTypeSpot}).Inc()
+		logging.FromContext(ctx).Errorf("updating spot pricing, %s, using existing pricing data from %s", err, err.lastUpdateTime.Format(time.RFC3339))
+	}
+	p.updatePriceMetrics()
+}
+
+// SetPriceOverrides replaces the price overrides that the effective prices are computed with
+func (p *PricingProvider) SetPriceOverrides(ctx context.Context, overrides PriceOverrides) {
+	p.mu.Lock()
+	p.overrides = overrides
+	p.mu.Unlock()
+	if p.cm.HasChanged("price-overrides", overrides) {
+		atomic.AddUint64(&p.SeqNum, 1)
+		logging.FromContext(ctx).With("count", len(overrides)).Infof("updated price overrides")
+	}
+	p.updatePriceMetrics()
+}
+
+// PriceFile returns a snapshot of the last known Linux list prices
+func (p *PricingProvider) PriceFile() *PriceFile {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	f := &PriceFile{
+		Region:      p.region,
+		GeneratedAt: lo.Ternary(p.onDemandUpdateTimes[OperatingSystemLinux].Before(p.spotUpdateTime), p.onDemandUpdateTimes[OperatingSystemLinux], p.spotUpdateTime),
+		OnDemand:    lo.Assign(p.onDemandPrices[OperatingSystemLinux]),
+		Spot:        map[string]map[string]float64{},
+	}
+	if !p.spotDefaulted {
+		for instanceType, zonalPrices := range p.spotPrices[OperatingSystemLinux] {
+			if len(zonalPrices.prices) > 0 {
+				f.Spot[instanceType] = lo.Assign(zonalPrices.prices)
+			}
+		}
+	}
+	return f
+}
+
+// SetPriceFile replaces the Linux list prices with the prices of a price file, which must be for the provider's region
+func (p *PricingProvider) SetPriceFile(ctx context.Context, f *PriceFile) error {
+	if f.Region != p.region {
+		return fmt.Errorf("price file is for region %s instead of %s", f.Region, p.region)
+	}
+	p.mu.Lock()
+	p.onDemandPrices[OperatingSystemLinux] = lo.Assign(f.OnDemand)
+	p.onDemandUpdateTimes[OperatingSystemLinux] = f.GeneratedAt
+	spotPrices := populateInitialSpotPricing(f.OnDemand)
+	for instanceType, zonalPrices := range f.Spot {
+		if _, ok := spotPrices[instanceType]; !ok {
+			spotPrices[instanceType] = newZonalPricing(0)
+		}
+		for zone, price := range zonalPrices {
+			spotPrices[instanceType].prices[zone] = price
+		}
+	}
+	p.spotPrices[OperatingSystemLinux] = spotPrices
+	p.spotDefaulted = len(f.Spot) == 0
+	p.spotUpdateTime = f.GeneratedAt
+	p.onDemandSource, p.spotSource = PricingSourceFile, PricingSourceFile
+	p.mu.Unlock()
+	if p.cm.HasChanged("price-file", f) {
+		atomic.AddUint64(&p.SeqNum, 1)
+		logging.FromContext(ctx).With(
+			"instance-type-count", len(f.OnDemand),
+			"spot-instance-type-count", len(f.Spot),
+			"generated-at", f.GeneratedAt.Format(time.RFC3339)).Infof("loaded prices from price file")
+	}
+	p.updatePriceMetrics()
+	return nil
+}
+
+// updatePriceMetrics reports the pricing sources and update times, and the list and effective prices of the Linux
+// offerings whose prices are overridden
+func (p *PricingProvider) updatePriceMetrics() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	setPricingSource(p.region, ec2.UsageClassTypeOnDemand, p.onDemandSource)
+	setPricingSource(p.region, ec2.UsageClassTypeSpot, p.spotSource)
+	pricingLastUpdated.With(prometheus.Labels{regionLabel: p.region, capacityTypeLabel: ec2.UsageClassTypeOnDemand}).Set(float64(p.onDemandUpdateTimes[OperatingSystemLinux].Unix()))
+	pricingLastUpdated.With(prometheus.Labels{regionLabel: p.region, capacityTypeLabel: ec2.UsageClassTypeSpot}).Set(float64(p.spotUpdateTime.Unix()))
+	offeringPrice.Reset()
+	for instanceType, listPrice := range p.onDemandPrices[OperatingSystemLinux] {
+		if p.overrides.Overrides(p.region, instanceType, ec2.UsageClassTypeOnDemand) {
+			setOfferingPrice(instanceType, ec2.UsageClassTypeOnDemand, "", listPrice, p.overrides.Apply(listPrice, p.region, instanceType, ec2.UsageClassTypeOnDemand))
+		}
+	}
+	for instanceType, zonalPrices := range p.spotPrices[OperatingSystemLinux] {
+		if !p.overrides.Overrides(p.region, instanceType, ec2.UsageClassTypeSpot) {
+			continue
+		}
+		for zone := range zonalPrices.prices {
+			if listPrice, ok := p.spotListPrice(OperatingSystemLinux, instanceType, zone); ok {
+				setOfferingPrice(instanceType, ec2.UsageClassTypeSpot, zone, listPrice, p.overrides.Apply(listPrice, p.region, instanceType, ec2.UsageClassTypeSpot))
+			}
+		}
+	}
+}
+
 func (p *PricingProvider) updateOnDemandPricing(ctx context.Context) *pricingErr {
+	// the static pricing is kept in partitions without a pricing endpoint
+	if p.pricing == nil {
+		return nil
+	}
+	p.mu.RLock()
+	operatingSystems := p.operatingSystems
+	p.mu.RUnlock()
+
+	prices := map[OperatingSystem]map[string]float64{}
+	var failed []OperatingSystem
+	var errs error
+	for _, operatingSystem := range operatingSystems {
+		osPrices, err := p.fetchOperatingSystemOnDemandPricing(ctx, operatingSystem)
+		if err != nil {
+			failed = append(failed, operatingSystem)
+			errs = multierr.Append(errs, fmt.Errorf("%s, %w", operatingSystem, err))
+			continue
+		}
+		prices[operatingSystem] = osPrices
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// the prices of the operating systems that were retrieved are updated even if the prices of others weren't
+	updateTime := time.Now()
+	for operatingSystem, osPrices := range prices {
+		p.onDemandPrices[operatingSystem] = osPrices
+		p.onDemandUpdateTimes[operatingSystem] = updateTime
+	}
+	if _, ok := prices[OperatingSystemLinux]; ok {
+		p.onDemandSource = PricingSourceLive
+	}
+	if len(prices) > 0 && p.cm.HasChanged("on-demand-prices", p.onDemandPrices) {
+		logging.FromContext(ctx).With(
+			"instance-type-count", len(p.onDemandPrices[OperatingSystemLinux]),
+			"operating-systems", lo.Map(lo.Keys(prices), func(o OperatingSystem, _ int) string { return o.String() })).Infof("updated on-demand pricing")
+	}
+	if errs != nil {
+		// the oldest prices of the operating systems that weren't retrieved are reported
+		lastUpdateTime := lo.MinBy(lo.Map(failed, func(o OperatingSystem, _ int) time.Time { return p.onDemandLastUpdated(o) }), func(a, b time.Time) bool { return a.Before(b) })
+		return &pricingErr{error: errs, lastUpdateTime: lastUpdateTime}
+	}
+	return nil
+}
+
+// fetchOperatingSystemOnDemandPricing returns the on-demand prices of an operating system
+func (p *PricingProvider) fetchOperatingSystemOnDemandPricing(ctx context.Context, operatingSystem OperatingSystem) (map[string]float64, error) {
 	// standard on-demand instances
 	var wg sync.WaitGroup
 	var onDemandPrices, onDemandMetalPrices map[string]float64
@@ -222,7 +711,7 @@ func (p *PricingProvider) updateOnDemandPricing(ctx context.Context) *pricingErr
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		onDemandPrices, onDemandErr = p.fetchOnDemandPricing(ctx,
+		onDemandPrices, onDemandErr = p.fetchOnDemandPricing(ctx, operatingSystem,
 			&pricing.Filter{
 				Field: aws.String("tenancy"),
 				Type:  aws.String("TERM_MATCH"),
@@ -239,7 +728,7 @@ func (p *PricingProvider) updateOnDemandPricing(ctx context.Context) *pricingErr
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		onDemandMetalPrices, onDemandMetalErr = p.fetchOnDemandPricing(ctx,
+		onDemandMetalPrices, onDemandMetalErr = p.fetchOnDemandPricing(ctx, operatingSystem,
 			&pricing.Filter{
 				Field: aws.String("tenancy"),
 				Type:  aws.String("TERM_MATCH"),
@@ -254,26 +743,16 @@ func (p *PricingProvider) updateOnDemandPricing(ctx context.Context) *pricingErr
 
 	wg.Wait()
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	err := multierr.Append(onDemandErr, onDemandMetalErr)
-	if err != nil {
-		return &pricingErr{error: err, lastUpdateTime: p.onDemandUpdateTime}
+	if err := multierr.Append(onDemandErr, onDemandMetalErr); err != nil {
+		return nil, err
 	}
-
 	if len(onDemandPrices) == 0 || len(onDemandMetalPrices) == 0 {
-		return &pricingErr{error: errors.New("no on-demand pricing found"), lastUpdateTime: p.onDemandUpdateTime}
-	}
-
-	p.onDemandPrices = lo.Assign(onDemandPrices, onDemandMetalPrices)
-	p.onDemandUpdateTime = time.Now()
-	if p.cm.HasChanged("on-demand-prices", p.onDemandPrices) {
-		logging.FromContext(ctx).With("instance-type-count", len(p.onDemandPrices)).Infof("updated on-demand pricing")
+		return nil, errors.New("no on-demand pricing found")
 	}
-	return nil
+	return lo.Assign(onDemandPrices, onDemandMetalPrices), nil
 }
 
-func (p *PricingProvider) fetchOnDemandPricing(ctx context.Context, additionalFilters ...*pricing.Filter) (map[string]float64, error) {
+func (p *PricingProvider) fetchOnDemandPricing(ctx context.Context, operatingSystem OperatingSystem, additionalFilters ...*pricing.Filter) (map[string]float64, error) {
 	prices := map[string]float64{}
 	filters := append([]*pricing.Filter{
 		{
@@ -294,7 +773,12 @@ func (p *PricingProvider) fetchOnDemandPricing(ctx context.Context, additionalFi
 		{
 			Field: aws.String("operatingSystem"),
 			Type:  aws.String("TERM_MATCH"),
-			Value: aws.String("Linux"),
+			Value: aws.String(operatingSystem.Name),
+		},
+		{
+			Field: aws.String("licenseModel"),
+			Type:  aws.String("TERM_MATCH"),
+			Value: aws.String(operatingSystem.LicenseModel),
 		},
 		{
 			Field: aws.String("capacitystatus"),
@@ -331,6 +815,7 @@ func (p *PricingProvider) onDemandPage(prices map[string]float64) func(output *p
 				PriceDimensions map[string]struct {
 					PricePerUnit struct {
 						USD string
+						CNY string
 					}
 				}
 			}
@@ -354,7 +839,8 @@ func (p *PricingProvider) onDemandPage(prices map[string]float64) func(output *p
 			}
 			for _, term := range pItem.Terms.OnDemand {
 				for _, v := range term.PriceDimensions {
-					price, err := strconv.ParseFloat(v.PricePerUnit.USD, 64)
+					// the pricing endpoint of the aws-cn partition prices in Chinese yuan
+					price, err := strconv.ParseFloat(lo.Ternary(v.PricePerUnit.USD != "", v.PricePerUnit.USD, v.PricePerUnit.CNY), 64)
 					if err != nil || price == 0 {
 						continue
 					}
@@ -370,12 +856,27 @@ func (p *PricingProvider) onDemandPage(prices map[string]float64) func(output *p
 func (p *PricingProvider) updateSpotPricing(ctx context.Context) *pricingErr {
 	totalOfferings := 0
 
-	prices := map[string]map[string]float64{}
-	err := p.ec2.DescribeSpotPriceHistoryPagesWithContext(ctx, &ec2.DescribeSpotPriceHistoryInput{
-		ProductDescriptions: []*string{aws.String("Linux/UNIX"), aws.String("Linux/UNIX (Amazon VPC)")},
+	p.mu.RLock()
+	operatingSystems := p.operatingSystems
+	p.mu.RUnlock()
+	// accounts that support EC2 classic have product descriptions with an (Amazon VPC) suffix for instances in VPCs
+	var productDescriptions []string
+	for _, operatingSystem := range operatingSystems {
+		productDescriptions = append(productDescriptions, operatingSystem.ProductDescription, operatingSystem.ProductDescription+" (Amazon VPC)")
+	}
+
+	now := time.Now()
+	input := &ec2.DescribeSpotPriceHistoryInput{
+		ProductDescriptions: aws.StringSlice(productDescriptions),
 		// get the latest spot price for each instance type
-		StartTime: aws.Time(time.Now()),
-	}, func(output *ec2.DescribeSpotPriceHistoryOutput, b bool) bool {
+		StartTime: aws.Time(now),
+	}
+	if p.options.SpotPriceHistoryWindow > 0 {
+		// get the spot price history of the window for each instance type
+		input.StartTime, input.EndTime = aws.Time(now.Add(-p.options.SpotPriceHistoryWindow)), aws.Time(now)
+	}
+	samples := map[OperatingSystem]map[string]map[string][]spotPriceSample{}
+	err := p.ec2.DescribeSpotPriceHistoryPagesWithContext(ctx, input, func(output *ec2.DescribeSpotPriceHistoryOutput, b bool) bool {
 		for _, sph := range output.SpotPriceHistory {
 			spotPriceStr := aws.StringValue(sph.SpotPrice)
 			spotPrice, err := strconv.ParseFloat(spotPriceStr, 64)
@@ -387,44 +888,116 @@ func (p *PricingProvider) updateSpotPricing(ctx context.Context) *pricingErr {
 			if sph.Timestamp == nil {
 				continue
 			}
+			// records without a product description are assumed to be Linux
+			productDescription := strings.TrimSuffix(lo.Ternary(aws.StringValue(sph.ProductDescription) != "", aws.StringValue(sph.ProductDescription), OperatingSystemLinux.ProductDescription), " (Amazon VPC)")
+			operatingSystem, ok := lo.Find(operatingSystems, func(o OperatingSystem) bool { return o.ProductDescription == productDescription })
+			if !ok {
+				continue
+			}
 			instanceType := aws.StringValue(sph.InstanceType)
 			az := aws.StringValue(sph.AvailabilityZone)
-			_, ok := prices[instanceType]
-			if !ok {
-				prices[instanceType] = map[string]float64{}
+			if _, ok := samples[operatingSystem]; !ok {
+				samples[operatingSystem] = map[string]map[string][]spotPriceSample{}
+			}
+			if _, ok := samples[operatingSystem][instanceType]; !ok {
+				samples[operatingSystem][instanceType] = map[string][]spotPriceSample{}
 			}
-			prices[instanceType][az] = spotPrice
+			samples[operatingSystem][instanceType][az] = append(samples[operatingSystem][instanceType][az], spotPriceSample{price: spotPrice, timestamp: aws.TimeValue(sph.Timestamp)})
 		}
 		return true
 	})
+	prices := map[OperatingSystem]map[string]map[string]float64{}
+	for operatingSystem, osSamples := range samples {
+		prices[operatingSystem] = map[string]map[string]float64{}
+		for instanceType, zonalSamples := range osSamples {
+			prices[operatingSystem][instanceType] = map[string]float64{}
+			for zone, zoneSamples := range zonalSamples {
+				prices[operatingSystem][instanceType][zone] = aggregateSpotPrices(zoneSamples, p.options.SpotPriceStatistic, p.options.SpotPriceHistoryWindow, now)
+			}
+		}
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	if err != nil {
 		return &pricingErr{error: err, lastUpdateTime: p.spotUpdateTime}
 	}
-	if len(prices) == 0 {
+	if len(prices[OperatingSystemLinux]) == 0 {
 		return &pricingErr{error: errors.New("no spot pricing found"), lastUpdateTime: p.spotUpdateTime}
 	}
-	for it, zoneData := range prices {
-		if _, ok := p.spotPrices[it]; !ok {
-			p.spotPrices[it] = newZonalPricing(0)
+	for operatingSystem, osPrices := range prices {
+		if _, ok := p.spotPrices[operatingSystem]; !ok {
+			p.spotPrices[operatingSystem] = map[string]zonalPricing{}
 		}
-		for zone, price := range zoneData {
-			p.spotPrices[it].prices[zone] = price
+		for it, zoneData := range osPrices {
+			if _, ok := p.spotPrices[operatingSystem][it]; !ok {
+				p.spotPrices[operatingSystem][it] = newZonalPricing(0)
+			}
+			for zone, price := range zoneData {
+				p.spotPrices[operatingSystem][it].prices[zone] = price
+			}
+			totalOfferings += len(zoneData)
 		}
-		totalOfferings += len(zoneData)
 	}
 
 	p.spotUpdateTime = time.Now()
+	p.spotSource = PricingSourceLive
+	p.spotDefaulted = false
 	if p.cm.HasChanged("spot-prices", p.spotPrices) {
 		logging.FromContext(ctx).With(
-			"instance-type-count", len(p.onDemandPrices),
+			"instance-type-count", len(p.spotPrices[OperatingSystemLinux]),
 			"offering-count", totalOfferings).Infof("updated spot pricing with instance types and offerings")
 	}
 	return nil
 }
 
+// updateUnpricedMetrics reports the number of instance types that support a capacity type but have no Linux price for
+// it, since their offerings of the capacity type are unavailable
+func (p *PricingProvider) updateUnpricedMetrics(instanceTypes map[string]*ec2.InstanceTypeInfo) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	unpriced := map[string]int{ec2.UsageClassTypeOnDemand: 0, ec2.UsageClassTypeSpot: 0}
+	for name, instanceType := range instanceTypes {
+		for _, capacityType := range aws.StringValueSlice(instanceType.SupportedUsageClasses) {
+			var ok bool
+			switch capacityType {
+			case ec2.UsageClassTypeOnDemand:
+				_, ok = p.onDemandListPrice(OperatingSystemLinux, name)
+			case ec2.UsageClassTypeSpot:
+				_, ok = p.spotPrices[OperatingSystemLinux][name]
+			default:
+				continue
+			}
+			if !ok {
+				unpriced[capacityType]++
+			}
+		}
+	}
+	for capacityType, count := range unpriced {
+		instanceTypesWithoutPrice.With(prometheus.Labels{regionLabel: p.region, capacityTypeLabel: capacityType}).Set(float64(count))
+	}
+}
+
+// ReadinessProbe fails if the prices that are updated from the pricing and EC2 APIs are older than the staleness
+// threshold, e.g. because the permissions to retrieve them were removed. Prices are only checked by the leader, since
+// other replicas don't update them.
+func (p *PricingProvider) ReadinessProbe(req *http.Request) error {
+	if p.options.StalenessThreshold <= 0 || !p.updating.Load() {
+		return nil
+	}
+	var errs error
+	for _, capacityType := range []string{ec2.UsageClassTypeOnDemand, ec2.UsageClassTypeSpot} {
+		// the static on-demand pricing isn't updated in partitions without a pricing endpoint
+		if capacityType == ec2.UsageClassTypeOnDemand && p.pricing == nil {
+			continue
+		}
+		if lastUpdated := p.LastUpdated(capacityType); time.Since(lastUpdated) > p.options.StalenessThreshold {
+			errs = multierr.Append(errs, fmt.Errorf("%s pricing was last updated at %s, more than %s ago", capacityType, lastUpdated.Format(time.RFC3339), p.options.StalenessThreshold))
+		}
+	}
+	return errs
+}
+
 func (p *PricingProvider) LivenessProbe(req *http.Request) error {
 	// ensure we don't deadlock and nolint for the empty critical section
 	p.mu.Lock()
@@ -19,12 +19,14 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/pricing"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/samber/lo"
 
+	"github.com/aws/karpenter/pkg/apis/settings"
 	"github.com/aws/karpenter/pkg/fake"
 )
 
@@ -35,14 +37,14 @@ var _ = Describe("Pricing", func() {
 	})
 	It("should return static on-demand data if pricing API fails", func() {
 		fakePricingAPI.NextError.Set(fmt.Errorf("failed"))
-		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{}))
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
 		price, ok := p.OnDemandPrice("c5.large")
 		Expect(ok).To(BeTrue())
 		Expect(price).To(BeNumerically(">", 0))
 	})
 	It("should return static spot data if EC2 describeSpotPriceHistory API fails", func() {
 		fakePricingAPI.NextError.Set(fmt.Errorf("failed"))
-		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{}))
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
 		price, ok := p.SpotPrice("c5.large", "test-zone-1a")
 		Expect(ok).To(BeTrue())
 		Expect(price).To(BeNumerically(">", 0))
@@ -57,7 +59,7 @@ var _ = Describe("Pricing", func() {
 			},
 		})
 		updateStart := time.Now()
-		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{}))
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
 		Eventually(func() bool { return p.OnDemandLastUpdated().After(updateStart) }).Should(BeTrue())
 
 		price, ok := p.OnDemandPrice("c98.large")
@@ -105,7 +107,7 @@ var _ = Describe("Pricing", func() {
 			},
 		})
 		updateStart := time.Now()
-		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{}))
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
 		Eventually(func() bool { return p.SpotLastUpdated().After(updateStart) }).Should(BeTrue())
 
 		price, ok := p.SpotPrice("c98.large", "test-zone-1b")
@@ -141,7 +143,7 @@ var _ = Describe("Pricing", func() {
 			},
 		})
 		updateStart := time.Now()
-		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{}))
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
 		Eventually(func() bool { return p.SpotLastUpdated().After(updateStart) }).Should(BeTrue())
 
 		price, ok := p.SpotPrice("c98.large", "test-zone-1a")
@@ -170,7 +172,7 @@ var _ = Describe("Pricing", func() {
 			},
 		})
 		updateStart := time.Now()
-		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{}))
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
 		Eventually(func() bool { return p.SpotLastUpdated().After(updateStart) }).Should(BeTrue())
 
 		_, ok := p.SpotPrice("c99.large", "test-zone-1b")
@@ -198,10 +200,226 @@ var _ = Describe("Pricing", func() {
 				fake.NewOnDemandPrice("c99.large", 1.23),
 			},
 		})
-		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{}))
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
 		Eventually(func() bool { return p.SpotLastUpdated().After(updateStart) }, 5*time.Second).Should(BeTrue())
 		inp := fakeEC2API.DescribeSpotPriceHistoryInput.Clone()
 		Expect(lo.Map(inp.ProductDescriptions, func(x *string, _ int) string { return *x })).
 			To(ContainElements("Linux/UNIX", "Linux/UNIX (Amazon VPC)"))
 	})
+	It("should price other operating systems as Linux until their prices are retrieved", func() {
+		now := time.Now()
+		fakeEC2API.DescribeSpotPriceHistoryOutput.Set(&ec2.DescribeSpotPriceHistoryOutput{
+			SpotPriceHistory: []*ec2.SpotPrice{
+				{
+					AvailabilityZone:   aws.String("test-zone-1a"),
+					InstanceType:       aws.String("c99.large"),
+					ProductDescription: aws.String("Linux/UNIX"),
+					SpotPrice:          aws.String("1.23"),
+					Timestamp:          &now,
+				},
+				{
+					AvailabilityZone:   aws.String("test-zone-1a"),
+					InstanceType:       aws.String("c99.large"),
+					ProductDescription: aws.String("Windows (Amazon VPC)"),
+					SpotPrice:          aws.String("2.46"),
+					Timestamp:          &now,
+				},
+			},
+		})
+		fakePricingAPI.GetProductsOutput.Set(&pricing.GetProductsOutput{
+			PriceList: []aws.JSONValue{
+				fake.NewOnDemandPrice("c98.large", 1.20),
+				fake.NewOnDemandPrice("c99.large", 1.23),
+			},
+		})
+		updateStart := time.Now()
+		elected := make(chan struct{})
+		close(elected)
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, elected)
+		Eventually(func() bool { return p.SpotLastUpdated().After(updateStart) }).Should(BeTrue())
+
+		Eventually(func() float64 {
+			price, _ := p.OperatingSystemSpotPrice(OperatingSystemWindows, "c99.large", "test-zone-1a")
+			return price
+		}).Should(BeNumerically("==", 2.46))
+		price, ok := p.SpotPrice("c99.large", "test-zone-1a")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(BeNumerically("==", 1.23))
+		inp := fakeEC2API.DescribeSpotPriceHistoryInput.Clone()
+		Expect(lo.Map(inp.ProductDescriptions, func(x *string, _ int) string { return *x })).
+			To(ContainElements("Linux/UNIX", "Windows", "Windows (Amazon VPC)"))
+	})
+	It("should price other operating systems as Linux in isolated VPCs", func() {
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", true, PricingOptions{}, make(chan struct{}))
+		price, ok := p.OperatingSystemOnDemandPrice(OperatingSystemWindows, "c5.large")
+		Expect(ok).To(BeTrue())
+		linuxPrice, ok := p.OnDemandPrice("c5.large")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(Equal(linuxPrice))
+	})
+	It("should query the on-demand prices of Windows with their license included", func() {
+		fakePricingAPI.GetProductsOutput.Set(&pricing.GetProductsOutput{
+			PriceList: []aws.JSONValue{
+				fake.NewOnDemandPrice("c98.large", 1.20),
+			},
+		})
+		elected := make(chan struct{})
+		close(elected)
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, elected)
+		p.OperatingSystemOnDemandPrice(OperatingSystemWindows, "c98.large")
+		var licenseModels []string
+		Eventually(func() []string {
+			for fakePricingAPI.CalledWithGetProductsInput.Len() > 0 {
+				filters := lo.SliceToMap(fakePricingAPI.CalledWithGetProductsInput.Pop().Filters, func(f *pricing.Filter) (string, string) {
+					return aws.StringValue(f.Field), aws.StringValue(f.Value)
+				})
+				if filters["operatingSystem"] == "Windows" {
+					licenseModels = append(licenseModels, filters["licenseModel"])
+				}
+			}
+			return licenseModels
+		}).Should(ContainElement("License included"))
+	})
+	It("should update the on-demand prices of operating systems independently", func() {
+		fakePricingAPI.GetProductsOutput.Set(&pricing.GetProductsOutput{
+			PriceList: []aws.JSONValue{
+				fake.NewOnDemandPrice("c98.large", 1.20),
+			},
+		})
+		fakePricingAPI.OperatingSystemErrors.Store("Windows", fmt.Errorf("windows pricing unavailable"))
+		updateStart := time.Now()
+		elected := make(chan struct{})
+		close(elected)
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, elected)
+		Eventually(func() bool { return p.OnDemandLastUpdated().After(updateStart) }).Should(BeTrue())
+		p.OperatingSystemOnDemandPrice(OperatingSystemWindows, "c98.large")
+		lastUpdated := p.OnDemandLastUpdated()
+		Eventually(func() bool { return p.OnDemandLastUpdated().After(lastUpdated) }).Should(BeTrue())
+		Expect(p.Source(ec2.UsageClassTypeOnDemand)).To(Equal(PricingSourceLive))
+	})
+	It("should report live pricing sources after the prices are updated", func() {
+		fakePricingAPI.GetProductsOutput.Set(&pricing.GetProductsOutput{
+			PriceList: []aws.JSONValue{
+				fake.NewOnDemandPrice("c98.large", 1.20),
+			},
+		})
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
+		Eventually(func() PricingSource { return p.Source(ec2.UsageClassTypeOnDemand) }).Should(Equal(PricingSourceLive))
+		Eventually(func() PricingSource { return p.Source(ec2.UsageClassTypeSpot) }).Should(Equal(PricingSourceLive))
+	})
+	It("should keep static on-demand pricing in partitions without a pricing endpoint", func() {
+		now := time.Now()
+		fakeEC2API.DescribeSpotPriceHistoryOutput.Set(&ec2.DescribeSpotPriceHistoryOutput{
+			SpotPriceHistory: []*ec2.SpotPrice{
+				{
+					AvailabilityZone: aws.String("test-zone-1a"),
+					InstanceType:     aws.String("c99.large"),
+					SpotPrice:        aws.String("1.23"),
+					Timestamp:        &now,
+				},
+			},
+		})
+		p := NewPricingProvider(ctx, nil, fakeEC2API, "us-gov-west-1", false, PricingOptions{}, make(chan struct{}))
+		Eventually(func() PricingSource { return p.Source(ec2.UsageClassTypeSpot) }).Should(Equal(PricingSourceLive))
+		Expect(p.Source(ec2.UsageClassTypeOnDemand)).To(Equal(PricingSourceStatic))
+		price, ok := p.OnDemandPrice("c5.large")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(BeNumerically(">", 0))
+	})
+	It("should not price the offerings of the China regions in US dollars until their prices are updated", func() {
+		fakePricingAPI.NextError.Set(fmt.Errorf("failed"))
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "cn-north-1", false, PricingOptions{}, make(chan struct{}))
+		_, ok := p.OnDemandPrice("c5.large")
+		Expect(ok).To(BeFalse())
+		_, ok = p.SpotPrice("c5.large", "test-zone-1a")
+		Expect(ok).To(BeFalse())
+	})
+	It("should price the offerings of the China regions in US dollars in isolated VPCs", func() {
+		p := NewPricingProvider(ctx, nil, fakeEC2API, "cn-north-1", true, PricingOptions{}, make(chan struct{}))
+		price, ok := p.OnDemandPrice("c5.large")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(BeNumerically(">", 0))
+	})
+	It("should select the pricing endpoint of the partition of the region", func() {
+		for region, expected := range map[string]string{
+			"us-west-2":      "us-east-1",
+			"eu-central-1":   "us-east-1",
+			"ap-southeast-1": "ap-south-1",
+			"cn-north-1":     "cn-northwest-1",
+			"cn-northwest-1": "cn-northwest-1",
+		} {
+			pricingAPIRegion, ok := PricingAPIRegion(region)
+			Expect(ok).To(BeTrue(), region)
+			Expect(pricingAPIRegion).To(Equal(expected), region)
+		}
+		_, ok := PricingAPIRegion("us-gov-west-1")
+		Expect(ok).To(BeFalse())
+		Expect(NewPricingAPI(session.Must(session.NewSession()), "us-gov-east-1")).To(BeNil())
+	})
+	It("should price spot offerings at the statistic of their spot price history", func() {
+		now := time.Now()
+		history := func(ago time.Duration, price string) *ec2.SpotPrice {
+			return &ec2.SpotPrice{
+				AvailabilityZone: aws.String("test-zone-1a"),
+				InstanceType:     aws.String("c99.large"),
+				SpotPrice:        aws.String(price),
+				Timestamp:        aws.Time(now.Add(-ago)),
+			}
+		}
+		fakeEC2API.DescribeSpotPriceHistoryOutput.Set(&ec2.DescribeSpotPriceHistoryOutput{
+			SpotPriceHistory: []*ec2.SpotPrice{
+				history(50*time.Minute, "1.10"),
+				history(time.Hour, "5.00"),
+				history(23*time.Hour, "1.00"),
+			},
+		})
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{
+			SpotPriceHistoryWindow: 24 * time.Hour,
+			SpotPriceStatistic:     settings.SpotPriceMedian,
+		}, make(chan struct{}))
+		Eventually(func() PricingSource { return p.Source(ec2.UsageClassTypeSpot) }).Should(Equal(PricingSourceLive))
+		price, ok := p.SpotPrice("c99.large", "test-zone-1a")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(BeNumerically("==", 1.00))
+		inp := fakeEC2API.DescribeSpotPriceHistoryInput.Clone()
+		Expect(aws.TimeValue(inp.EndTime).Sub(aws.TimeValue(inp.StartTime))).To(Equal(24 * time.Hour))
+	})
+	It("should aggregate spot prices weighted by how long they were in effect", func() {
+		now := time.Now()
+		samples := []spotPriceSample{
+			{price: 2.0, timestamp: now.Add(-12 * time.Hour)},
+			{price: 1.0, timestamp: now.Add(-24 * time.Hour)},
+			// superseded before the window started
+			{price: 3.0, timestamp: now.Add(-48 * time.Hour)},
+		}
+		Expect(aggregateSpotPrices(samples, settings.SpotPriceLatest, 24*time.Hour, now)).To(BeNumerically("==", 2.0))
+		Expect(aggregateSpotPrices(samples, settings.SpotPriceMedian, 24*time.Hour, now)).To(BeNumerically("==", 1.0))
+		Expect(aggregateSpotPrices(samples, settings.SpotPriceP90, 24*time.Hour, now)).To(BeNumerically("==", 2.0))
+		Expect(aggregateSpotPrices(samples, settings.SpotPriceEWMA, 24*time.Hour, now)).To(BeNumerically("~", 1.8, 1e-9))
+		Expect(aggregateSpotPrices(samples, settings.SpotPriceMedian, 0, now)).To(BeNumerically("==", 2.0))
+	})
+	It("should fail readiness when the prices of the leader are older than the staleness threshold", func() {
+		fakePricingAPI.NextError.Set(fmt.Errorf("failed"))
+		elected := make(chan struct{})
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{StalenessThreshold: time.Hour}, elected)
+		Expect(p.ReadinessProbe(nil)).To(Succeed())
+		close(elected)
+		Eventually(func() error { return p.ReadinessProbe(nil) }).Should(HaveOccurred())
+	})
+	It("should not check the staleness of prices unless a threshold is configured", func() {
+		fakePricingAPI.NextError.Set(fmt.Errorf("failed"))
+		elected := make(chan struct{})
+		close(elected)
+		p := NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, elected)
+		Consistently(func() error { return p.ReadinessProbe(nil) }).Should(Succeed())
+	})
+	It("should price AMIs by the operating system of their platform details", func() {
+		Expect(OperatingSystemFromPlatformDetails("Linux/UNIX")).To(Equal(OperatingSystemLinux))
+		Expect(OperatingSystemFromPlatformDetails("Red Hat Enterprise Linux")).To(Equal(OperatingSystemRHEL))
+		Expect(OperatingSystemFromPlatformDetails("SUSE Linux")).To(Equal(OperatingSystemSUSE))
+		Expect(OperatingSystemFromPlatformDetails("Windows")).To(Equal(OperatingSystemWindows))
+		Expect(OperatingSystemFromPlatformDetails("Windows with SQL Server Standard")).To(Equal(OperatingSystemWindows))
+		Expect(OperatingSystemFromPlatformDetails("Windows BYOL")).To(Equal(OperatingSystemLinux))
+		Expect(OperatingSystemFromPlatformDetails("Red Hat BYOL Linux")).To(Equal(OperatingSystemLinux))
+	})
 })
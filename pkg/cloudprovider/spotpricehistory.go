@@ -0,0 +1,106 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+)
+
+// spotPriceSample is a spot price from the spot price history, which is in effect from its timestamp until the
+// timestamp of the next spot price of the offering
+type spotPriceSample struct {
+	price     float64
+	timestamp time.Time
+}
+
+// weightedSpotPrice is a spot price that's weighted by how long it was in effect during the history window
+type weightedSpotPrice struct {
+	price  float64
+	weight float64
+}
+
+// aggregateSpotPrices returns the statistic of the spot prices of an offering that were in effect during the window
+// ending at now. Prices are weighted by how long they were in effect, so that a short spike in the spot price doesn't
+// count as much as the price that was in effect for the rest of the window.
+func aggregateSpotPrices(samples []spotPriceSample, statistic settings.SpotPriceStatistic, window time.Duration, now time.Time) float64 {
+	sort.Slice(samples, func(i, j int) bool { return samples[i].timestamp.Before(samples[j].timestamp) })
+	latest := samples[len(samples)-1].price
+	if statistic == settings.SpotPriceLatest || window <= 0 {
+		return latest
+	}
+	start := now.Add(-window)
+	var prices []weightedSpotPrice
+	for i, sample := range samples {
+		from, to := sample.timestamp, now
+		if from.Before(start) {
+			from = start
+		}
+		if i+1 < len(samples) {
+			to = samples[i+1].timestamp
+		}
+		if !to.After(from) {
+			continue
+		}
+		weight := to.Sub(from).Seconds()
+		if statistic == settings.SpotPriceEWMA {
+			// the weight of a price halves for each quarter of the window that it's older
+			age := now.Sub(from.Add(to.Sub(from) / 2))
+			weight *= math.Pow(0.5, age.Seconds()/(window.Seconds()/4))
+		}
+		prices = append(prices, weightedSpotPrice{price: sample.price, weight: weight})
+	}
+	if len(prices) == 0 {
+		return latest
+	}
+	switch statistic {
+	case settings.SpotPriceMedian:
+		return weightedQuantile(prices, 0.5)
+	case settings.SpotPriceP90:
+		return weightedQuantile(prices, 0.9)
+	case settings.SpotPriceEWMA:
+		var sum, totalWeight float64
+		for _, p := range prices {
+			sum += p.price * p.weight
+			totalWeight += p.weight
+		}
+		if totalWeight == 0 {
+			return latest
+		}
+		return sum / totalWeight
+	default:
+		return latest
+	}
+}
+
+// weightedQuantile returns the lowest price that at least the quantile of the total weight is priced at or below
+func weightedQuantile(prices []weightedSpotPrice, quantile float64) float64 {
+	sort.Slice(prices, func(i, j int) bool { return prices[i].price < prices[j].price })
+	var totalWeight float64
+	for _, p := range prices {
+		totalWeight += p.weight
+	}
+	var cumulativeWeight float64
+	for _, p := range prices {
+		cumulativeWeight += p.weight
+		if cumulativeWeight >= quantile*totalWeight {
+			return p.price
+		}
+	}
+	return prices[len(prices)-1].price
+}
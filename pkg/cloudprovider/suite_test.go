@@ -16,6 +16,8 @@ package cloudprovider
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"net"
 	"testing"
 	"time"
@@ -64,11 +66,15 @@ import (
 	"github.com/aws/karpenter-core/pkg/operator/injection"
 	"github.com/aws/karpenter-core/pkg/operator/options"
 	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	"github.com/aws/karpenter-core/pkg/scheduling"
 	coretest "github.com/aws/karpenter-core/pkg/test"
 	"github.com/aws/karpenter-core/pkg/utils/pretty"
 
+	"github.com/aws/karpenter/pkg/providers/placementscore"
+	"github.com/aws/karpenter/pkg/providers/quota"
 	"github.com/aws/karpenter/pkg/providers/securitygroup"
 	"github.com/aws/karpenter/pkg/providers/subnet"
+	"github.com/aws/karpenter/pkg/utils"
 )
 
 var ctx context.Context
@@ -80,6 +86,8 @@ var ssmCache *cache.Cache
 var ec2Cache *cache.Cache
 var kubernetesVersionCache *cache.Cache
 var unavailableOfferingsCache *awscache.UnavailableOfferings
+var memoryOverhead *awscache.MemoryOverhead
+var spotInterruptions *awscache.SpotInterruptionHistory
 var instanceTypeCache *cache.Cache
 var instanceTypeProvider *InstanceTypeProvider
 var launchTemplateProvider *LaunchTemplateProvider
@@ -87,6 +95,7 @@ var amiProvider *amifamily.AMIProvider
 var fakeEC2API *fake.EC2API
 var fakeSSMAPI *fake.SSMAPI
 var fakePricingAPI *fake.PricingAPI
+var fakeServiceQuotasAPI *fake.ServiceQuotasAPI
 var prov *provisioning.Provisioner
 var provisioningController controller.Controller
 var cloudProvider *CloudProvider
@@ -96,6 +105,8 @@ var provisioner *v1alpha5.Provisioner
 var nodeTemplate *v1alpha1.AWSNodeTemplate
 var pricingProvider *PricingProvider
 var subnetProvider *subnet.Provider
+var quotaProvider *quota.Provider
+var placementScoreProvider *placementscore.Provider
 var securityGroupProvider *securitygroup.Provider
 
 func TestAWS(t *testing.T) {
@@ -112,6 +123,8 @@ var _ = BeforeSuite(func() {
 
 	launchTemplateCache = cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval)
 	unavailableOfferingsCache = awscache.NewUnavailableOfferings()
+	memoryOverhead = awscache.NewMemoryOverhead()
+	spotInterruptions = awscache.NewSpotInterruptionHistory()
 	ssmCache = cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval)
 	ec2Cache = cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval)
 	kubernetesVersionCache = cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval)
@@ -119,15 +132,22 @@ var _ = BeforeSuite(func() {
 	fakeEC2API = &fake.EC2API{}
 	fakeSSMAPI = &fake.SSMAPI{}
 	fakePricingAPI = &fake.PricingAPI{}
-	pricingProvider = NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{}))
+	fakeServiceQuotasAPI = &fake.ServiceQuotasAPI{}
+	pricingProvider = NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{}))
 	amiProvider = amifamily.NewAMIProvider(env.Client, env.KubernetesInterface, fakeSSMAPI, fakeEC2API, ssmCache, ec2Cache, kubernetesVersionCache)
 	subnetProvider = subnet.NewProvider(fakeEC2API)
+	quotaProvider = quota.NewProvider(ctx, fakeServiceQuotasAPI, fakeEC2API, make(chan struct{}))
+	placementScoreProvider = placementscore.NewProvider(ctx, fakeEC2API, "", make(chan struct{}))
 	instanceTypeProvider = &InstanceTypeProvider{
 		ec2api:               fakeEC2API,
 		subnetProvider:       subnetProvider,
+		amiProvider:          amiProvider,
 		cache:                instanceTypeCache,
 		pricingProvider:      pricingProvider,
 		unavailableOfferings: unavailableOfferingsCache,
+		memoryOverhead:       memoryOverhead,
+		quotaProvider:        quotaProvider,
+		recorder:             events.NewRecorder(&record.FakeRecorder{}),
 		cm:                   pretty.NewChangeMonitor(),
 	}
 	securityGroupProvider = securitygroup.NewProvider(fakeEC2API)
@@ -136,14 +156,16 @@ var _ = BeforeSuite(func() {
 		amiFamily:             amifamily.New(env.Client, amiProvider),
 		securityGroupProvider: securityGroupProvider,
 		cache:                 launchTemplateCache,
+		inUse:                 cache.New(awscache.LaunchTemplateGracePeriod, awscache.DefaultCleanupInterval),
 		caBundle:              ptr.String("ca-bundle"),
 		cm:                    pretty.NewChangeMonitor(),
 	}
 	cloudProvider = &CloudProvider{
 		instanceTypeProvider: instanceTypeProvider,
 		amiProvider:          amiProvider,
-		instanceProvider:     NewInstanceProvider(ctx, "", fakeEC2API, unavailableOfferingsCache, instanceTypeProvider, subnetProvider, launchTemplateProvider),
+		instanceProvider:     NewInstanceProvider(ctx, "", fakeEC2API, unavailableOfferingsCache, spotInterruptions, instanceTypeProvider, subnetProvider, quotaProvider, placementScoreProvider, launchTemplateProvider),
 		kubeClient:           env.Client,
+		launchedHourlyCosts:  cache.New(awscache.LaunchedHourlyCostTTL, awscache.DefaultCleanupInterval),
 	}
 	fakeClock = clock.NewFakeClock(time.Now())
 	cluster = state.NewCluster(fakeClock, env.Client, cloudProvider)
@@ -193,23 +215,34 @@ var _ = BeforeEach(func() {
 	fakeEC2API.Reset()
 	fakeSSMAPI.Reset()
 	fakePricingAPI.Reset()
+	fakeServiceQuotasAPI.Reset()
 	launchTemplateCache.Flush()
 	unavailableOfferingsCache.Flush()
+	memoryOverhead.Flush()
+	spotInterruptions.Flush()
 	ssmCache.Flush()
 	ec2Cache.Flush()
 	kubernetesVersionCache.Flush()
 	instanceTypeCache.Flush()
 	subnetProvider.Reset()
+	quotaProvider.Reset()
+	placementScoreProvider.Reset()
 	securityGroupProvider.Reset()
 	launchTemplateProvider.kubeDNSIP = net.ParseIP("10.0.100.10")
+	launchTemplateProvider.inUse.Flush()
+	cloudProvider.launchedHourlyCosts.Flush()
 
 	// Reset the pricing provider, so we don't cross-pollinate pricing data
 	instanceTypeProvider = &InstanceTypeProvider{
 		ec2api:               fakeEC2API,
 		subnetProvider:       subnetProvider,
+		amiProvider:          amiProvider,
 		cache:                instanceTypeCache,
-		pricingProvider:      NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, make(chan struct{})),
+		pricingProvider:      NewPricingProvider(ctx, fakePricingAPI, fakeEC2API, "", false, PricingOptions{}, make(chan struct{})),
 		unavailableOfferings: unavailableOfferingsCache,
+		memoryOverhead:       memoryOverhead,
+		quotaProvider:        quotaProvider,
+		recorder:             events.NewRecorder(&record.FakeRecorder{}),
 		cm:                   pretty.NewChangeMonitor(),
 	}
 })
@@ -267,6 +300,93 @@ var _ = Describe("Allocation", func() {
 			Expect(createFleetInput.Context).To(BeNil())
 		})
 	})
+	Context("Cost", func() {
+		It("should annotate machines with the price of the offering that they were launched with", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			machine := coretest.Machine(v1alpha5.Machine{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}},
+				Spec: v1alpha5.MachineSpec{
+					MachineTemplateRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name},
+					Requirements: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}},
+						{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeOnDemand}},
+					},
+				},
+			})
+			created, err := cloudProvider.Create(ctx, machine)
+			Expect(err).ToNot(HaveOccurred())
+			cost, ok := MachineHourlyCost(created)
+			Expect(ok).To(BeTrue())
+			Expect(cost.Price).To(BeNumerically(">", 0))
+			Expect(cost.CapacityType).To(Equal(v1alpha5.CapacityTypeOnDemand))
+			Expect(cost.Zone).To(Equal(created.Labels[v1.LabelTopologyZone]))
+			Expect(cost.PricedAt).ToNot(BeZero())
+		})
+		It("should only record the launch price of instances that were launched", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			machine := coretest.Machine(v1alpha5.Machine{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}},
+				Spec: v1alpha5.MachineSpec{
+					MachineTemplateRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name},
+					Requirements: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}},
+						{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeOnDemand}},
+					},
+				},
+			})
+			created, err := cloudProvider.Create(ctx, machine)
+			Expect(err).ToNot(HaveOccurred())
+			instanceID := lo.Must(utils.ParseInstanceID(created.Status.ProviderID))
+			launched, ok := cloudProvider.launchedHourlyCosts.Get(instanceID)
+			Expect(ok).To(BeTrue())
+			Expect(launched).To(Equal(created.Annotations[v1alpha1.HourlyCostAnnotationKey]))
+
+			cloudProvider.launchedHourlyCosts.Flush()
+			instance, ok := fakeEC2API.Instances.Load(instanceID)
+			Expect(ok).To(BeTrue())
+			fakeEC2API.DescribeInstancesBehavior.Output.Set(&ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{instance.(*ec2.Instance)}}},
+			})
+			_, err = cloudProvider.Get(ctx, machine.Name, provisioner.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cloudProvider.launchedHourlyCosts.ItemCount()).To(BeZero())
+		})
+		It("should persist the launch price on the machine that is hydrated from the node of a provisioned pod", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1alpha5.LabelCapacityType: v1alpha5.CapacityTypeOnDemand}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Annotations).ToNot(HaveKey(v1alpha1.HourlyCostAnnotationKey))
+
+			machine := machineutil.New(node, provisioner)
+			Expect(cloudProvider.Hydrate(ctx, machine)).To(Succeed())
+			cost, ok := MachineHourlyCost(machine)
+			Expect(ok).To(BeTrue())
+			Expect(cost.Price).To(BeNumerically(">", 0))
+			Expect(cost.CapacityType).To(Equal(v1alpha5.CapacityTypeOnDemand))
+			Expect(cost.Zone).To(Equal(node.Labels[v1.LabelTopologyZone]))
+			price, ok := cloudProvider.PricingProvider().OnDemandPrice(node.Labels[v1.LabelInstanceTypeStable])
+			Expect(ok).To(BeTrue())
+			Expect(cost.Price).To(Equal(price))
+		})
+		It("should annotate machines hydrated from instances launched before a restart with the current price", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1alpha5.LabelCapacityType: v1alpha5.CapacityTypeOnDemand}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			cloudProvider.launchedHourlyCosts.Flush()
+
+			machine := machineutil.New(node, provisioner)
+			Expect(cloudProvider.Hydrate(ctx, machine)).To(Succeed())
+			cost, ok := MachineHourlyCost(machine)
+			Expect(ok).To(BeTrue())
+			Expect(cost.CapacityType).To(Equal(v1alpha5.CapacityTypeOnDemand))
+			Expect(cost.Zone).To(Equal(node.Labels[v1.LabelTopologyZone]))
+			price, ok := cloudProvider.PricingProvider().OnDemandPrice(node.Labels[v1.LabelInstanceTypeStable])
+			Expect(ok).To(BeTrue())
+			Expect(cost.Price).To(Equal(price))
+		})
+	})
 	Context("Node Drift", func() {
 		var validAMI string
 		var selectedInstanceType *cloudprovider.InstanceType
@@ -523,7 +643,7 @@ var _ = Describe("Allocation", func() {
 			}
 			Expect(foundNonGPULT).To(BeTrue())
 		})
-		It("should launch instances into subnet with the most available IP addresses", func() {
+		It("should launch instances into every subnet in a zone", func() {
 			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
 				{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(10),
 					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-1")}}},
@@ -531,12 +651,89 @@ var _ = Describe("Allocation", func() {
 					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-2")}}},
 			}})
 			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
-			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1a"}})
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1a", v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(createFleetInput.LaunchTemplateConfigs).To(HaveLen(1))
+			Expect(createFleetInput.LaunchTemplateConfigs[0].Overrides).To(ConsistOf(
+				&ec2.FleetLaunchTemplateOverridesRequest{SubnetId: aws.String("test-subnet-1"), InstanceType: aws.String("m5.large"), AvailabilityZone: aws.String("test-zone-1a")},
+				&ec2.FleetLaunchTemplateOverridesRequest{SubnetId: aws.String("test-subnet-2"), InstanceType: aws.String("m5.large"), AvailabilityZone: aws.String("test-zone-1a")},
+			))
+		})
+		It("should launch instances into the subnets with the most available IP addresses when a zone has more subnets than the bound", func() {
+			var subnets []*ec2.Subnet
+			for i := 1; i <= 5; i++ {
+				subnets = append(subnets, &ec2.Subnet{SubnetId: aws.String(fmt.Sprintf("test-subnet-%d", i)), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(int64(i * 100)),
+					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(fmt.Sprintf("test-subnet-%d", i))}}})
+			}
+			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: subnets})
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1a", v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(fake.SubnetsFromFleetRequest(createFleetInput)).To(ConsistOf("test-subnet-2", "test-subnet-3", "test-subnet-4", "test-subnet-5"))
+		})
+		It("should launch instances into fewer subnets of each zone when the overrides exceed the bound of a request", func() {
+			DeferCleanup(func(overrides int) { maxFleetOverrides = overrides }, maxFleetOverrides)
+			maxFleetOverrides = 2
+			var subnets []*ec2.Subnet
+			for i := 1; i <= 5; i++ {
+				subnets = append(subnets, &ec2.Subnet{SubnetId: aws.String(fmt.Sprintf("test-subnet-%d", i)), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(int64(i * 100)),
+					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(fmt.Sprintf("test-subnet-%d", i))}}})
+			}
+			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: subnets})
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1a", v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(fake.SubnetsFromFleetRequest(createFleetInput)).To(ConsistOf("test-subnet-4", "test-subnet-5"))
+		})
+		It("should truncate the overrides of each launch template to its share of the bound", func() {
+			overrides := func(n int) []*ec2.FleetLaunchTemplateOverridesRequest {
+				return lo.Times(n, func(i int) *ec2.FleetLaunchTemplateOverridesRequest {
+					return &ec2.FleetLaunchTemplateOverridesRequest{InstanceType: aws.String(fmt.Sprint(i))}
+				})
+			}
+			launchTemplateConfigs := truncateOverrides([]*ec2.FleetLaunchTemplateConfigRequest{{Overrides: overrides(300)}, {Overrides: overrides(100)}, {Overrides: overrides(1)}}, 200)
+			Expect(launchTemplateConfigs).To(HaveLen(2))
+			Expect(launchTemplateConfigs[0].Overrides).To(Equal(overrides(300)[:149]))
+			Expect(launchTemplateConfigs[1].Overrides).To(Equal(overrides(100)[:49]))
+		})
+		It("should not launch instances into subnets that are predicted to run out of IP addresses", func() {
+			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(5),
+					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-1")}}},
+				{SubnetId: aws.String("test-subnet-2"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(100),
+					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-2")}}},
+			}})
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1a", v1.LabelInstanceTypeStable: "m5.large"}})
 			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
 			ExpectScheduled(ctx, env.Client, pod)
 			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
 			Expect(fake.SubnetsFromFleetRequest(createFleetInput)).To(ConsistOf("test-subnet-2"))
 		})
+		It("should account for IP addresses reserved by in-flight launches", func() {
+			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(15),
+					Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-1")}}},
+			}})
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CreateFleetBehavior.CalledWithInput.Len()).To(Equal(1))
+			fakeEC2API.CreateFleetBehavior.CalledWithInput.Reset()
+
+			// The first launch reserved the IPs of an m5.large ENI, so the subnet can't fit another
+			pod = coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(fakeEC2API.CreateFleetBehavior.CalledWithInput.Len()).To(Equal(0))
+		})
 		It("should launch instances into subnets that are excluded by another provisioner", func() {
 			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
 				{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(10),
@@ -564,4 +761,227 @@ var _ = Describe("Allocation", func() {
 			Expect(fake.SubnetsFromFleetRequest(createFleetInput)).To(ConsistOf("test-subnet-2"))
 		})
 	})
+	Context("Spot Placement Scores", func() {
+		BeforeEach(func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{MinSpotPlacementScore: lo.ToPtr[int64](5)}))
+			provisioner.Spec.Requirements = append(provisioner.Spec.Requirements, v1.NodeSelectorRequirement{
+				Key:      v1alpha5.LabelCapacityType,
+				Operator: v1.NodeSelectorOpIn,
+				Values:   []string{v1alpha5.CapacityTypeSpot},
+			})
+		})
+		It("should not launch spot instances into offerings with a low spot placement score", func() {
+			fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 1, "testzone1b": 9, "testzone1c": 5})
+			placementScoreProvider.Scores(provisioner.Name, "m5.large")
+			Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha5.CapacityTypeSpot))
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(fake.SubnetsFromFleetRequest(createFleetInput)).To(ConsistOf("subnet-test2", "subnet-test3"))
+		})
+		It("should launch spot instances into offerings with a low spot placement score if no other offerings remain", func() {
+			fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 1, "testzone1b": 1, "testzone1c": 1})
+			placementScoreProvider.Scores(provisioner.Name, "m5.large")
+			Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(fake.SubnetsFromFleetRequest(createFleetInput)).To(ConsistOf("subnet-test1", "subnet-test2", "subnet-test3"))
+		})
+		It("should track the provisioners that launch spot instances", func() {
+			fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 1, "testzone1b": 9, "testzone1c": 9})
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+
+			Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+			Expect(placementScoreProvider.Scores(provisioner.Name)).To(HaveKeyWithValue("test-zone-1a", BeNumerically("==", 1)))
+		})
+	})
+	Context("Spot Interruption Rates", func() {
+		BeforeEach(func() {
+			ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{SpotInterruptionRateThreshold: lo.ToPtr(0.2)}))
+			provisioner.Spec.Requirements = append(provisioner.Spec.Requirements, v1.NodeSelectorRequirement{
+				Key:      v1alpha5.LabelCapacityType,
+				Operator: v1.NodeSelectorOpIn,
+				Values:   []string{v1alpha5.CapacityTypeSpot},
+			})
+		})
+		It("should not launch spot instances into pools that are frequently interrupted", func() {
+			launched := time.Now().Add(-time.Minute)
+			spotInterruptions.RecordLaunches(map[string][]time.Time{"m5.large:test-zone-1a": {launched, launched}}, time.Now())
+			spotInterruptions.RecordInterruption("m5.large", "test-zone-1a", launched)
+			spotInterruptions.RecordInterruption("m5.large", "test-zone-1a", launched)
+
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(fake.SubnetsFromFleetRequest(createFleetInput)).To(ConsistOf("subnet-test2", "subnet-test3"))
+		})
+		It("should launch spot instances into pools that are frequently interrupted if no other pools remain", func() {
+			launched := time.Now().Add(-time.Minute)
+			for _, zone := range []string{"test-zone-1a", "test-zone-1b", "test-zone-1c"} {
+				spotInterruptions.RecordLaunches(map[string][]time.Time{awscache.SpotPoolKey("m5.large", zone): {launched, launched}}, time.Now())
+				spotInterruptions.RecordInterruption("m5.large", zone, launched)
+				spotInterruptions.RecordInterruption("m5.large", zone, launched)
+			}
+
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(fake.SubnetsFromFleetRequest(createFleetInput)).To(ConsistOf("subnet-test1", "subnet-test2", "subnet-test3"))
+		})
+	})
+	Context("Warm Pools", func() {
+		var nodeSelector map[string]string
+		BeforeEach(func() {
+			nodeSelector = map[string]string{v1.LabelInstanceTypeStable: "m5.large", v1.LabelTopologyZone: "test-zone-1a"}
+		})
+		It("should not refill the warm pool after launching an instance", func() {
+			nodeTemplate.Spec.WarmPool = &v1alpha1.WarmPool{Size: 2}
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: nodeSelector})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+
+			Consistently(fakeEC2API.CreateFleetBehavior.Calls, time.Second).Should(Equal(1))
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(Equal(1))
+		})
+		It("should launch warm instances that register their nodes with the warm pool taint", func() {
+			nodeTemplate.Spec.WarmPool = &v1alpha1.WarmPool{Size: 2}
+			provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}}}
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			Expect(cloudProvider.FillWarmPool(ctx, nodeTemplate, provisioner)).To(Succeed())
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: nodeSelector})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+
+			Expect(fakeEC2API.CreateFleetBehavior.Calls()).To(Equal(2))
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			warmCreateFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(warmCreateFleetInput.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName).ToNot(Equal(createFleetInput.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName))
+			Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Len()).To(Equal(2))
+			launchTemplateInput := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+			warmLaunchTemplateInput := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+			warmUserData, _ := base64.StdEncoding.DecodeString(aws.StringValue(warmLaunchTemplateInput.LaunchTemplateData.UserData))
+			userData, _ := base64.StdEncoding.DecodeString(aws.StringValue(launchTemplateInput.LaunchTemplateData.UserData))
+			Expect(string(warmUserData)).To(ContainSubstring(fmt.Sprintf("--register-with-taints=%s=:NoSchedule", v1alpha1.WarmPoolTaintKey)))
+			Expect(string(userData)).ToNot(ContainSubstring(v1alpha1.WarmPoolTaintKey))
+		})
+		It("should fill the warm pool of a provisioner's node template before any launch", func() {
+			nodeTemplate.Spec.WarmPool = &v1alpha1.WarmPool{Size: 2}
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			Expect(cloudProvider.FillWarmPool(ctx, nodeTemplate, provisioner)).To(Succeed())
+
+			Expect(fakeEC2API.CreateFleetBehavior.Calls()).To(Equal(1))
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(aws.Int64Value(createFleetInput.TargetCapacitySpecification.TotalTargetCapacity)).To(BeNumerically("==", 2))
+			Expect(aws.StringValue(createFleetInput.TargetCapacitySpecification.DefaultTargetCapacityType)).To(Equal(v1alpha5.CapacityTypeOnDemand))
+			Expect(createFleetInput.TagSpecifications[0].Tags).To(ContainElements(
+				&ec2.Tag{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String(nodeTemplate.Name)},
+				&ec2.Tag{Key: aws.String(v1alpha5.ProvisionerNameLabelKey), Value: aws.String(provisioner.Name)},
+			))
+			input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop()
+			userData, _ := base64.StdEncoding.DecodeString(aws.StringValue(input.LaunchTemplateData.UserData))
+			Expect(string(userData)).To(ContainSubstring(v1alpha1.WarmPoolTaintKey))
+		})
+		It("should not fill the warm pool for a provisioner that doesn't launch on-demand machines", func() {
+			nodeTemplate.Spec.WarmPool = &v1alpha1.WarmPool{Size: 2}
+			provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeSpot}}}
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			Expect(cloudProvider.FillWarmPool(ctx, nodeTemplate, provisioner)).To(Succeed())
+			Expect(fakeEC2API.CreateFleetBehavior.Calls()).To(BeZero())
+		})
+		It("should start a compatible warm instance instead of launching an instance and refill the warm pool", func() {
+			nodeTemplate.Spec.WarmPool = &v1alpha1.WarmPool{Size: 2}
+			provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}}}
+			ExpectApplied(ctx, env.Client, provisioner, nodeTemplate)
+			Expect(cloudProvider.FillWarmPool(ctx, nodeTemplate, provisioner)).To(Succeed())
+			createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+
+			// The warm instance was launched from the warm launch template that the warm pool was filled from
+			instance := &ec2.Instance{
+				ImageId:        aws.String(fake.ImageID()),
+				InstanceId:     aws.String(fake.InstanceID()),
+				InstanceType:   aws.String("m5.large"),
+				PrivateDnsName: aws.String(randomdata.IpV4Address()),
+				Placement:      &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
+				State:          &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)},
+				Tags: []*ec2.Tag{
+					{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String(nodeTemplate.Name)},
+					{Key: aws.String(v1alpha1.WarmPoolLaunchTemplateTagKey), Value: createFleetInput.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName},
+				},
+			}
+			fakeEC2API.Instances.Store(aws.StringValue(instance.InstanceId), instance)
+			fakeEC2API.DescribeInstancesBehavior.Output.Set(&ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{instance}}},
+			})
+
+			pod := coretest.UnschedulablePod(coretest.PodOptions{NodeSelector: nodeSelector})
+			ExpectProvisioned(ctx, env.Client, cluster, prov, pod)
+			ExpectScheduled(ctx, env.Client, pod)
+
+			Expect(fakeEC2API.StartInstancesBehavior.Calls()).To(Equal(1))
+			Expect(IsWarmInstance(instance)).To(BeFalse())
+			Expect(instance.Tags).To(ContainElement(HaveField("Key", HaveValue(Equal(v1alpha5.MachineNameLabelKey)))))
+			// The warm pool is refilled with on-demand instances in the background instead of launching an instance
+			Eventually(fakeEC2API.CreateFleetBehavior.Calls).Should(Equal(2))
+			createFleetInput = fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+			Expect(aws.Int64Value(createFleetInput.TargetCapacitySpecification.TotalTargetCapacity)).To(BeNumerically("==", 1))
+			Expect(aws.StringValue(createFleetInput.TargetCapacitySpecification.DefaultTargetCapacityType)).To(Equal(v1alpha5.CapacityTypeOnDemand))
+			Expect(createFleetInput.TagSpecifications[0].Tags).To(ContainElement(&ec2.Tag{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String(nodeTemplate.Name)}))
+		})
+		It("should only start warm instances of an allowed zone and launch template", func() {
+			instance := &ec2.Instance{
+				InstanceType: aws.String("m5.large"),
+				Placement:    &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
+				Tags:         []*ec2.Tag{{Key: aws.String(v1alpha1.WarmPoolLaunchTemplateTagKey), Value: aws.String("launch-template-1")}},
+			}
+			launchTemplates := map[LaunchTemplate][]*cloudprovider.InstanceType{
+				{Name: "launch-template-1", Version: "$Latest"}: {{Name: "m5.large"}},
+				{Name: "launch-template-2", Version: "$Latest"}: {{Name: "m5.xlarge"}},
+			}
+			zones := func(zones ...string) scheduling.Requirements {
+				return scheduling.NewRequirements(scheduling.NewRequirement(v1.LabelTopologyZone, v1.NodeSelectorOpIn, zones...))
+			}
+			Expect(isCompatibleWarmInstance(instance, launchTemplates, zones("test-zone-1a", "test-zone-1b"))).To(BeTrue())
+			Expect(isCompatibleWarmInstance(instance, launchTemplates, zones("test-zone-1b"))).To(BeFalse())
+			delete(launchTemplates, LaunchTemplate{Name: "launch-template-1", Version: "$Latest"})
+			Expect(isCompatibleWarmInstance(instance, launchTemplates, zones("test-zone-1a"))).To(BeFalse())
+
+			// Warm instances of versioned launch templates are only compatible with the version they were launched from
+			instance.Tags = []*ec2.Tag{{Key: aws.String(v1alpha1.WarmPoolLaunchTemplateTagKey), Value: aws.String("launch-template-3:2")}}
+			launchTemplates[LaunchTemplate{Name: "launch-template-3", Version: "3"}] = []*cloudprovider.InstanceType{{Name: "m5.large"}}
+			Expect(isCompatibleWarmInstance(instance, launchTemplates, zones("test-zone-1a"))).To(BeFalse())
+			launchTemplates[LaunchTemplate{Name: "launch-template-3", Version: "2"}] = []*cloudprovider.InstanceType{{Name: "m5.large"}}
+			Expect(isCompatibleWarmInstance(instance, launchTemplates, zones("test-zone-1a"))).To(BeTrue())
+		})
+		It("should not hydrate machines from warm instances", func() {
+			instance := &ec2.Instance{
+				InstanceId:   aws.String(fake.InstanceID()),
+				InstanceType: aws.String("m5.large"),
+				Placement:    &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
+				State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				Tags:         []*ec2.Tag{{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String(nodeTemplate.Name)}},
+			}
+			fakeEC2API.DescribeInstancesBehavior.Output.Set(&ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{instance}}},
+			})
+			node := coretest.Node(coretest.NodeOptions{ProviderID: fake.ProviderID(aws.StringValue(instance.InstanceId))})
+			err := cloudProvider.Hydrate(ctx, machineutil.NewFromNode(node))
+			Expect(cloudprovider.IsMachineNotFoundError(err)).To(BeTrue())
+		})
+	})
 })
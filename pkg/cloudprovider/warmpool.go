@@ -0,0 +1,275 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils"
+)
+
+// startWarmInstance starts a stopped instance from the node template's warm pool that was launched from the launch
+// template that the machine would be launched from as a warm instance, and returns nil if there is no such instance to
+// start. Warm instances are on-demand, so they're only considered for machines that allow on-demand capacity, and are
+// preferred over spot capacity without comparing prices, since they join the cluster faster.
+func (p *InstanceProvider) startWarmInstance(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine,
+	instanceTypes []*cloudprovider.InstanceType) (*ec2.Instance, error) {
+
+	requirements := scheduling.NewNodeSelectorRequirements(machine.Spec.Requirements...)
+	if !requirements.Get(v1alpha5.LabelCapacityType).Has(v1alpha5.CapacityTypeOnDemand) {
+		return nil, nil
+	}
+	warmInstances, err := p.ListWarmInstances(ctx, nodeTemplate.Name, ec2.InstanceStateNameStopped)
+	if err != nil {
+		return nil, err
+	}
+	// The launch templates are only resolved when there are warm instances that could have been launched from them
+	if len(warmInstances) == 0 {
+		return nil, nil
+	}
+	launchTemplates, err := p.launchTemplateProvider.EnsureAll(ctx, nodeTemplate, warmMachine(machine), instanceTypes, map[string]string{v1alpha5.LabelCapacityType: v1alpha5.CapacityTypeOnDemand})
+	if err != nil {
+		return nil, fmt.Errorf("getting launch templates, %w", err)
+	}
+	for _, instance := range warmInstances {
+		if !isCompatibleWarmInstance(instance, launchTemplates, requirements) {
+			continue
+		}
+		// Claim the instance so that concurrent launches don't start it as well
+		if _, claimed := p.claimedWarmInstances.LoadOrStore(aws.StringValue(instance.InstanceId), struct{}{}); claimed {
+			continue
+		}
+		started, err := p.startWarmInstanceForMachine(ctx, nodeTemplate, machine, instance, instanceTypes)
+		p.claimedWarmInstances.Delete(aws.StringValue(instance.InstanceId))
+		if err != nil {
+			logging.FromContext(ctx).With("id", aws.StringValue(instance.InstanceId)).Errorf("starting warm instance, %s", err)
+			continue
+		}
+		return started, nil
+	}
+	return nil, nil
+}
+
+// startWarmInstanceForMachine removes the instance from its warm pool, starts it, and retags it for the machine
+func (p *InstanceProvider) startWarmInstanceForMachine(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine, instance *ec2.Instance,
+	instanceTypes []*cloudprovider.InstanceType) (*ec2.Instance, error) {
+
+	warmPoolTags := lo.Filter(instance.Tags, func(tag *ec2.Tag, _ int) bool {
+		return aws.StringValue(tag.Key) == v1alpha1.WarmPoolTagKey || aws.StringValue(tag.Key) == v1alpha1.WarmPoolLaunchTemplateTagKey
+	})
+	// The warm pool tags are removed first so that the warm pool controller doesn't stop the instance once it's started
+	if _, err := p.ec2api.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+		Resources: []*string{instance.InstanceId},
+		Tags:      lo.Map(warmPoolTags, func(tag *ec2.Tag, _ int) *ec2.Tag { return &ec2.Tag{Key: tag.Key} }),
+	}); err != nil {
+		return nil, fmt.Errorf("removing instance from warm pool, %w", err)
+	}
+	if _, err := p.ec2api.StartInstancesWithContext(ctx, &ec2.StartInstancesInput{InstanceIds: []*string{instance.InstanceId}}); err != nil {
+		// Return the instance to the warm pool so that it's started by a later launch or cleaned up with the pool
+		if _, e := p.ec2api.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{Resources: []*string{instance.InstanceId}, Tags: warmPoolTags}); e != nil {
+			logging.FromContext(ctx).With("id", aws.StringValue(instance.InstanceId)).Errorf("returning instance to warm pool, %s", e)
+		}
+		return nil, fmt.Errorf("starting instance, %w", err)
+	}
+	if instanceType, ok := lo.Find(instanceTypes, func(it *cloudprovider.InstanceType) bool { return it.Name == aws.StringValue(instance.InstanceType) }); ok {
+		p.quotaProvider.Reserve(v1alpha5.CapacityTypeOnDemand, instanceType.Name, instanceType.Capacity.Cpu().Value())
+	}
+	machine = machine.DeepCopy()
+	machine.Status.ProviderID = fmt.Sprintf("aws:///%s/%s", aws.StringValue(instance.Placement.AvailabilityZone), aws.StringValue(instance.InstanceId))
+	started, err := p.Update(ctx, nodeTemplate, machine, instance)
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).With(
+		"id", aws.StringValue(started.InstanceId),
+		"hostname", aws.StringValue(started.PrivateDnsName),
+		"instance-type", aws.StringValue(started.InstanceType),
+		"zone", aws.StringValue(started.Placement.AvailabilityZone)).Infof("started warm instance")
+	return started, nil
+}
+
+// refillWarmPool launches the instances that are missing from the node template's warm pool in the background, from
+// the launch templates and instance types of the machine that a warm instance was just started for. Only one refill
+// runs at a time for each node template.
+func (p *InstanceProvider) refillWarmPool(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine,
+	instanceTypes []*cloudprovider.InstanceType) {
+
+	if _, refilling := p.warmPoolRefills.LoadOrStore(nodeTemplate.Name, struct{}{}); refilling {
+		return
+	}
+	ctx = utils.WithoutCancel(ctx)
+	go func() {
+		defer p.warmPoolRefills.Delete(nodeTemplate.Name)
+		if err := p.launchWarmInstances(ctx, nodeTemplate, machine, instanceTypes); err != nil {
+			logging.FromContext(ctx).With("node-template", nodeTemplate.Name).Errorf("refilling warm pool, %s", err)
+		}
+	}()
+}
+
+// fillWarmPool launches the instances that are missing from the node template's warm pool from the launch templates
+// and instance types of the machine, unless the warm pool is already being refilled
+func (p *InstanceProvider) fillWarmPool(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine,
+	instanceTypes []*cloudprovider.InstanceType) error {
+
+	if _, refilling := p.warmPoolRefills.LoadOrStore(nodeTemplate.Name, struct{}{}); refilling {
+		return nil
+	}
+	defer p.warmPoolRefills.Delete(nodeTemplate.Name)
+	return p.launchWarmInstances(ctx, nodeTemplate, machine, p.candidateInstanceTypes(ctx, machine, instanceTypes))
+}
+
+func (p *InstanceProvider) launchWarmInstances(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate, machine *v1alpha5.Machine,
+	instanceTypes []*cloudprovider.InstanceType) error {
+
+	warmInstances, err := p.ListWarmInstances(ctx, nodeTemplate.Name, ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped)
+	if err != nil {
+		return err
+	}
+	missing := int64(nodeTemplate.Spec.WarmPool.Size) - int64(len(warmInstances))
+	if missing <= 0 {
+		return nil
+	}
+	launchTemplateConfigs, err := p.getLaunchTemplateConfigs(ctx, nodeTemplate, warmMachine(machine), instanceTypes, v1alpha5.CapacityTypeOnDemand)
+	if err != nil {
+		return fmt.Errorf("getting launch template configs, %w", err)
+	}
+	// Warm instances aren't launched for a machine, so they're tagged for the machine that they're started for instead
+	tags := v1alpha1.MergeTags(ctx, v1alpha1.StaticTags(lo.Assign(settings.FromContext(ctx).Tags, nodeTemplate.Spec.Tags)), map[string]string{
+		fmt.Sprintf("kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName): "owned",
+		v1alpha1.WarmPoolTagKey: nodeTemplate.Name,
+	})
+	// Warm instances are launched without the batcher, which only launches single instances
+	createFleetOutput, err := p.ec2api.CreateFleetWithContext(ctx, &ec2.CreateFleetInput{
+		Type:                  aws.String(ec2.FleetTypeInstant),
+		Context:               nodeTemplate.Spec.Context,
+		LaunchTemplateConfigs: launchTemplateConfigs,
+		TargetCapacitySpecification: &ec2.TargetCapacitySpecificationRequest{
+			DefaultTargetCapacityType: aws.String(v1alpha5.CapacityTypeOnDemand),
+			TotalTargetCapacity:       aws.Int64(missing),
+		},
+		OnDemandOptions: &ec2.OnDemandOptionsRequest{AllocationStrategy: aws.String(ec2.FleetOnDemandAllocationStrategyLowestPrice)},
+		TagSpecifications: []*ec2.TagSpecification{
+			{ResourceType: aws.String(ec2.ResourceTypeInstance), Tags: tags},
+			{ResourceType: aws.String(ec2.ResourceTypeVolume), Tags: tags},
+			{ResourceType: aws.String(ec2.ResourceTypeFleet), Tags: tags},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating fleet %w", err)
+	}
+	p.updateUnavailableOfferingsCache(ctx, createFleetOutput.Errors, v1alpha5.CapacityTypeOnDemand)
+	launched := 0
+	for _, instance := range createFleetOutput.Instances {
+		if len(instance.InstanceIds) == 0 || instance.LaunchTemplateAndOverrides == nil || instance.LaunchTemplateAndOverrides.LaunchTemplateSpecification == nil {
+			continue
+		}
+		p.reserveIPs(ctx, instanceTypes, instance)
+		p.reserveQuota(v1alpha5.CapacityTypeOnDemand, instanceTypes, instance)
+		// Warm instances are only started for machines that would be launched from the same launch template version
+		spec := instance.LaunchTemplateAndOverrides.LaunchTemplateSpecification
+		launchTemplate := LaunchTemplate{Name: aws.StringValue(spec.LaunchTemplateName), Version: lo.Ternary(spec.Version != nil, aws.StringValue(spec.Version), latestVersion)}
+		if _, err := p.ec2api.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: instance.InstanceIds,
+			Tags: []*ec2.Tag{{
+				Key:   aws.String(v1alpha1.WarmPoolLaunchTemplateTagKey),
+				Value: aws.String(launchTemplate.String()),
+			}},
+		}); err != nil {
+			return fmt.Errorf("tagging warm instances, %w", err)
+		}
+		launched += len(instance.InstanceIds)
+	}
+	if launched == 0 {
+		return combineFleetErrors(createFleetOutput.Errors)
+	}
+	logging.FromContext(ctx).With("node-template", nodeTemplate.Name, "count", launched).Infof("launched warm instances")
+	return nil
+}
+
+// ListWarmInstances returns the instances in the node template's warm pool that are in one of the states
+func (p *InstanceProvider) ListWarmInstances(ctx context.Context, nodeTemplateName string, states ...string) ([]*ec2.Instance, error) {
+	var instances []*ec2.Instance
+	if err := p.ec2api.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String(fmt.Sprintf("tag:%s", v1alpha1.WarmPoolTagKey)),
+				Values: aws.StringSlice([]string{nodeTemplateName}),
+			},
+			{
+				Name:   aws.String(fmt.Sprintf("tag:kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName)),
+				Values: aws.StringSlice([]string{"*"}),
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice(states),
+			},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing warm instances, %w", err)
+	}
+	return instances, nil
+}
+
+// warmMachine returns a copy of the machine with the warm pool taint as a startup taint, which warm instances register
+// their nodes with so that pods aren't scheduled to them while they're initialized. The launch templates of warm
+// instances differ from those of the machines that they're started for by this taint.
+func warmMachine(machine *v1alpha5.Machine) *v1alpha5.Machine {
+	machine = machine.DeepCopy()
+	machine.Spec.StartupTaints = append(machine.Spec.StartupTaints, v1.Taint{Key: v1alpha1.WarmPoolTaintKey, Effect: v1.TaintEffectNoSchedule})
+	return machine
+}
+
+// isCompatibleWarmInstance returns whether the warm instance was launched from the launch template version of its
+// instance type, and into a zone, that the machine allows
+func isCompatibleWarmInstance(instance *ec2.Instance, launchTemplates map[LaunchTemplate][]*cloudprovider.InstanceType, requirements scheduling.Requirements) bool {
+	if instance.Placement == nil || !requirements.Get(v1.LabelTopologyZone).Has(aws.StringValue(instance.Placement.AvailabilityZone)) {
+		return false
+	}
+	tag, ok := lo.Find(instance.Tags, func(tag *ec2.Tag) bool { return aws.StringValue(tag.Key) == v1alpha1.WarmPoolLaunchTemplateTagKey })
+	if !ok {
+		return false
+	}
+	for launchTemplate, instanceTypes := range launchTemplates {
+		if launchTemplate.String() == aws.StringValue(tag.Value) && lo.ContainsBy(instanceTypes, func(it *cloudprovider.InstanceType) bool {
+			return it.Name == aws.StringValue(instance.InstanceType)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWarmInstance returns whether the instance is in a warm pool rather than launched for a machine
+func IsWarmInstance(instance *ec2.Instance) bool {
+	return lo.ContainsBy(instance.Tags, func(tag *ec2.Tag) bool { return aws.StringValue(tag.Key) == v1alpha1.WarmPoolTagKey })
+}
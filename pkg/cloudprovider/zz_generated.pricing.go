@@ -20,6 +20,10 @@ import "time"
 
 // generated at 2023-01-30T13:11:11Z for us-east-1
 
+func init() {
+	initialPrices["aws"] = staticPrices{region: "us-east-1", updateTime: initialPriceUpdate, onDemand: initialOnDemandPrices}
+}
+
 var initialPriceUpdate, _ = time.Parse(time.RFC3339, "2023-01-30T13:11:11Z")
 var initialOnDemandPrices = map[string]float64{
 	// a1 family
@@ -28,10 +28,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
 	"github.com/samber/lo"
 	"knative.dev/pkg/logging"
 
 	"github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/providers/placementscore"
+	"github.com/aws/karpenter/pkg/providers/quota"
 	"github.com/aws/karpenter/pkg/providers/securitygroup"
 	"github.com/aws/karpenter/pkg/providers/subnet"
 	"github.com/aws/karpenter/pkg/utils/project"
@@ -45,9 +48,13 @@ type Context struct {
 
 	Session                   *session.Session
 	UnavailableOfferingsCache *cache.UnavailableOfferings
+	SpotInterruptionHistory   *cache.SpotInterruptionHistory
+	MemoryOverhead            *cache.MemoryOverhead
 	EC2API                    ec2iface.EC2API
 	SubnetProvider            *subnet.Provider
 	SecurityGroupProvider     *securitygroup.Provider
+	QuotaProvider             *quota.Provider
+	PlacementScoreProvider    *placementscore.Provider
 }
 
 func NewOrDie(ctx cloudprovider.Context) Context {
@@ -70,13 +77,19 @@ func NewOrDie(ctx cloudprovider.Context) Context {
 	logging.FromContext(ctx).With("region", *sess.Config.Region).Debugf("discovered region")
 	subnetProvider := subnet.NewProvider(ec2api)
 	securityGroupProvider := securitygroup.NewProvider(ec2api)
+	quotaProvider := quota.NewProvider(ctx, servicequotas.New(sess), ec2api, ctx.StartAsync)
+	placementScoreProvider := placementscore.NewProvider(ctx, ec2api, *sess.Config.Region, ctx.StartAsync)
 	return Context{
 		Context:                   ctx,
 		Session:                   sess,
 		UnavailableOfferingsCache: cache.NewUnavailableOfferings(),
+		SpotInterruptionHistory:   cache.NewSpotInterruptionHistory(),
+		MemoryOverhead:            cache.NewMemoryOverhead(),
 		EC2API:                    ec2api,
 		SubnetProvider:            subnetProvider,
 		SecurityGroupProvider:     securityGroupProvider,
+		QuotaProvider:             quotaProvider,
+		PlacementScoreProvider:    placementScoreProvider,
 	}
 }
 
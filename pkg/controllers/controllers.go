@@ -21,8 +21,19 @@ import (
 	"github.com/aws/karpenter/pkg/apis/settings"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	awscontext "github.com/aws/karpenter/pkg/context"
+	"github.com/aws/karpenter/pkg/controllers/cost"
+	"github.com/aws/karpenter/pkg/controllers/garbagecollection"
+	"github.com/aws/karpenter/pkg/controllers/instancetypesnapshot"
 	"github.com/aws/karpenter/pkg/controllers/interruption"
+	"github.com/aws/karpenter/pkg/controllers/launchtemplate"
+	"github.com/aws/karpenter/pkg/controllers/machinelabels"
+	"github.com/aws/karpenter/pkg/controllers/memoryoverhead"
 	"github.com/aws/karpenter/pkg/controllers/nodetemplate"
+	"github.com/aws/karpenter/pkg/controllers/pricefile"
+	"github.com/aws/karpenter/pkg/controllers/priceoverrides"
+	"github.com/aws/karpenter/pkg/controllers/tagging"
+	"github.com/aws/karpenter/pkg/controllers/unavailableofferings"
+	"github.com/aws/karpenter/pkg/controllers/warmpool"
 	"github.com/aws/karpenter/pkg/utils/project"
 
 	"github.com/aws/karpenter-core/pkg/operator/controller"
@@ -32,8 +43,27 @@ func NewControllers(ctx awscontext.Context, cloudProvider *cloudprovider.CloudPr
 	logging.FromContext(ctx).With("version", project.Version).Debugf("discovered version")
 
 	if settings.FromContext(ctx).InterruptionQueueName != "" {
-		controllers = append(controllers, interruption.NewController(ctx.KubeClient, ctx.Clock, ctx.EventRecorder, interruption.NewSQSProvider(sqs.New(ctx.Session)), ctx.UnavailableOfferingsCache))
+		controllers = append(controllers, interruption.NewController(ctx.KubeClient, ctx.KubernetesInterface, ctx.Clock, ctx.EventRecorder, interruption.NewSQSProvider(sqs.New(ctx.Session)), ctx.UnavailableOfferingsCache, ctx.SpotInterruptionHistory))
+	}
+	if settings.FromContext(ctx).PersistUnavailableOfferings {
+		controllers = append(controllers, unavailableofferings.NewController(ctx.KubernetesInterface, ctx.UnavailableOfferingsCache, ctx.StartAsync))
+	}
+	if settings.FromContext(ctx).PersistInstanceTypes {
+		controllers = append(controllers, instancetypesnapshot.NewController(ctx.KubernetesInterface, cloudProvider.InstanceTypeProvider(), ctx.StartAsync))
+	}
+	if settings.FromContext(ctx).LearnVMMemoryOverhead {
+		controllers = append(controllers, memoryoverhead.NewController(ctx.KubeClient, ctx.KubernetesInterface, ctx.MemoryOverhead))
 	}
 	controllers = append(controllers, nodetemplate.NewController(ctx.KubeClient, ctx.SubnetProvider, ctx.SecurityGroupProvider))
+	controllers = append(controllers, warmpool.NewController(ctx.KubeClient, ctx.EC2API, cloudProvider, ctx.Clock))
+	controllers = append(controllers, garbagecollection.NewController(ctx.KubeClient, ctx.EC2API, ctx.Clock))
+	controllers = append(controllers, launchtemplate.NewController(ctx.KubeClient, ctx.Clock, cloudProvider))
+	controllers = append(controllers, machinelabels.NewController(ctx.KubeClient, cloudProvider))
+	controllers = append(controllers, tagging.NewController(ctx.KubeClient, ctx.EC2API))
+	controllers = append(controllers, cost.NewController(ctx.KubeClient, cloudProvider.PricingProvider()))
+	controllers = append(controllers, priceoverrides.NewController(ctx.KubernetesInterface, cloudProvider.PricingProvider()))
+	if settings.FromContext(ctx).IsolatedVPC {
+		controllers = append(controllers, pricefile.NewController(ctx.KubernetesInterface, ctx.EC2API, cloudProvider.PricingProvider()))
+	}
 	return controllers
 }
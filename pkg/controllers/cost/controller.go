@@ -0,0 +1,141 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cost
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+const pollingPeriod = time.Minute
+
+// Controller reports the hourly cost of the nodes that Karpenter launched by their provisioner, node template, instance
+// type and capacity type. On-demand nodes are reported at the price that their machines were launched at, while spot
+// nodes are repriced as the spot prices are refreshed, as they're billed at the current spot price.
+type Controller struct {
+	kubeClient      client.Client
+	pricingProvider *cloudprovider.PricingProvider
+}
+
+func NewController(kubeClient client.Client, pricingProvider *cloudprovider.PricingProvider) corecontroller.Controller {
+	return &Controller{
+		kubeClient:      kubeClient,
+		pricingProvider: pricingProvider,
+	}
+}
+
+// costKey is the labels that the cost of nodes is reported by
+type costKey struct {
+	provisioner  string
+	nodeTemplate string
+	instanceType string
+	capacityType string
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	nodeList := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodeList, client.HasLabels{v1alpha5.ProvisionerNameLabelKey}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	machineList := &v1alpha5.MachineList{}
+	if err := c.kubeClient.List(ctx, machineList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing machines, %w", err)
+	}
+	provisionerList := &v1alpha5.ProvisionerList{}
+	if err := c.kubeClient.List(ctx, provisionerList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing provisioners, %w", err)
+	}
+	machines := lo.KeyBy(machineList.Items, func(machine v1alpha5.Machine) string { return machine.Status.ProviderID })
+	// Nodes that don't belong to machines are of the node template of their provisioner
+	nodeTemplates := lo.SliceToMap(provisionerList.Items, func(provisioner v1alpha5.Provisioner) (string, string) {
+		if provisioner.Spec.ProviderRef == nil {
+			return provisioner.Name, ""
+		}
+		return provisioner.Name, provisioner.Spec.ProviderRef.Name
+	})
+	costs := map[costKey]float64{}
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if node.Spec.ProviderID == "" {
+			continue
+		}
+		key := costKey{
+			provisioner:  node.Labels[v1alpha5.ProvisionerNameLabelKey],
+			nodeTemplate: nodeTemplates[node.Labels[v1alpha5.ProvisionerNameLabelKey]],
+			instanceType: node.Labels[v1.LabelInstanceTypeStable],
+			capacityType: node.Labels[v1alpha5.LabelCapacityType],
+		}
+		var machine *v1alpha5.Machine
+		if m, ok := machines[node.Spec.ProviderID]; ok {
+			machine = &m
+			if m.Spec.MachineTemplateRef != nil {
+				key.nodeTemplate = m.Spec.MachineTemplateRef.Name
+			}
+		}
+		if cost, ok := c.hourlyCost(node, machine); ok {
+			costs[key] += cost
+		}
+	}
+	nodeHourlyCost.Reset()
+	for key, cost := range costs {
+		nodeHourlyCost.With(prometheus.Labels{
+			provisionerLabel:  key.provisioner,
+			nodeTemplateLabel: key.nodeTemplate,
+			instanceTypeLabel: key.instanceType,
+			capacityTypeLabel: key.capacityType,
+		}).Set(cost)
+	}
+	return reconcile.Result{RequeueAfter: pollingPeriod}, nil
+}
+
+func (c *Controller) Name() string {
+	return "cost"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(m)
+}
+
+// hourlyCost returns the hourly cost of the node, which is the price that its machine was launched at unless it's a
+// spot node. Nodes whose machines weren't annotated when they were launched are priced with the current prices.
+func (c *Controller) hourlyCost(node *v1.Node, machine *v1alpha5.Machine) (float64, bool) {
+	instanceType := node.Labels[v1.LabelInstanceTypeStable]
+	if machine != nil {
+		if launched, ok := cloudprovider.MachineHourlyCost(machine); ok {
+			if launched.CapacityType != v1alpha5.CapacityTypeSpot {
+				return launched.Price, true
+			}
+			if current, ok := c.pricingProvider.HourlyCost(instanceType, launched.Zone, launched.CapacityType); ok {
+				return current.Price, true
+			}
+			return launched.Price, true
+		}
+	}
+	current, ok := c.pricingProvider.HourlyCost(instanceType, node.Labels[v1.LabelTopologyZone], node.Labels[v1alpha5.LabelCapacityType])
+	return current.Price, ok
+}
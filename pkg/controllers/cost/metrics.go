@@ -0,0 +1,46 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cost
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter-core/pkg/metrics"
+)
+
+const (
+	cloudProviderSubsystem = "cloudprovider"
+	provisionerLabel       = "provisioner"
+	nodeTemplateLabel      = "node_template"
+	instanceTypeLabel      = "instance_type"
+	capacityTypeLabel      = "capacity_type"
+)
+
+var (
+	nodeHourlyCost = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: cloudProviderSubsystem,
+			Name:      "node_hourly_cost",
+			Help:      "Hourly cost of the nodes that Karpenter launched in the currency of the prices of the region, which is Chinese yuan in the China regions and US dollars elsewhere, where spot nodes are priced at the current spot price. Labeled by provisioner, node template, instance type and capacity type.",
+		},
+		[]string{provisionerLabel, nodeTemplateLabel, instanceTypeLabel, capacityTypeLabel},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(nodeHourlyCost)
+}
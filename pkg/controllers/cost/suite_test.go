@@ -0,0 +1,156 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cost_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	coretest "github.com/aws/karpenter-core/pkg/test"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/cost"
+	"github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/test"
+)
+
+var ctx context.Context
+var env *coretest.Environment
+var pricingProvider *cloudprovider.PricingProvider
+var provisioner *v1alpha5.Provisioner
+var controller corecontroller.Controller
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cost")
+}
+
+var _ = BeforeSuite(func() {
+	env = coretest.NewEnvironment(scheme.Scheme, coretest.WithCRDs(apis.CRDs...))
+	// Pricing isn't updated in isolated VPCs, so nodes are priced with the initial prices
+	pricingProvider = cloudprovider.NewPricingProvider(ctx, &fake.PricingAPI{}, &fake.EC2API{}, "", true, cloudprovider.PricingOptions{}, make(chan struct{}))
+	controller = cost.NewController(env.Client, pricingProvider)
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = BeforeEach(func() {
+	ctx = settings.ToContext(ctx, test.Settings())
+	provisioner = test.Provisioner(coretest.ProvisionerOptions{ProviderRef: &v1alpha5.ProviderRef{Name: "default"}})
+	ExpectApplied(ctx, env.Client, provisioner)
+})
+
+var _ = AfterEach(func() {
+	ExpectCleanedUp(ctx, env.Client)
+})
+
+// node returns a node of the provisioner and, if the node was launched at a price, its machine annotated with the price
+func node(capacityType string, launchedPrice *float64) *v1.Node {
+	n := coretest.Node(coretest.NodeOptions{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+			v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+			v1.LabelInstanceTypeStable:       "m5.large",
+			v1.LabelTopologyZone:             "test-zone-1a",
+			v1alpha5.LabelCapacityType:       capacityType,
+		}},
+		ProviderID: fake.ProviderID(fake.InstanceID()),
+	})
+	ExpectApplied(ctx, env.Client, n)
+	if launchedPrice != nil {
+		ExpectApplied(ctx, env.Client, coretest.Machine(v1alpha5.Machine{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha1.HourlyCostAnnotationKey: string(lo.Must(json.Marshal(cloudprovider.HourlyCost{
+				Price:        *launchedPrice,
+				CapacityType: capacityType,
+				Zone:         "test-zone-1a",
+			})))}},
+			Spec:   v1alpha5.MachineSpec{MachineTemplateRef: &v1alpha5.ProviderRef{Name: "machine-template"}},
+			Status: v1alpha5.MachineStatus{ProviderID: n.Spec.ProviderID},
+		}))
+	}
+	return n
+}
+
+func expectHourlyCost(nodeTemplate string, capacityType string) float64 {
+	metric, ok := FindMetricWithLabelValues("karpenter_cloudprovider_node_hourly_cost", map[string]string{
+		"provisioner":   provisioner.Name,
+		"node_template": nodeTemplate,
+		"instance_type": "m5.large",
+		"capacity_type": capacityType,
+	})
+	Expect(ok).To(BeTrue())
+	return metric.GetGauge().GetValue()
+}
+
+var _ = Describe("Cost", func() {
+	It("should report on-demand nodes at the price that their machines were launched at", func() {
+		node(v1alpha5.CapacityTypeOnDemand, lo.ToPtr(1.5))
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(expectHourlyCost("machine-template", v1alpha5.CapacityTypeOnDemand)).To(Equal(1.5))
+	})
+	It("should reprice spot nodes with the current spot price", func() {
+		node(v1alpha5.CapacityTypeSpot, lo.ToPtr(1.5))
+		price, ok := pricingProvider.SpotPrice("m5.large", "test-zone-1a")
+		Expect(ok).To(BeTrue())
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(expectHourlyCost("machine-template", v1alpha5.CapacityTypeSpot)).To(Equal(price))
+	})
+	It("should price nodes without machines with the current prices and their provisioner's node template", func() {
+		node(v1alpha5.CapacityTypeOnDemand, nil)
+		price, ok := pricingProvider.OnDemandPrice("m5.large")
+		Expect(ok).To(BeTrue())
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(expectHourlyCost("default", v1alpha5.CapacityTypeOnDemand)).To(Equal(price))
+	})
+	It("should sum the cost of nodes with the same labels", func() {
+		node(v1alpha5.CapacityTypeOnDemand, lo.ToPtr(1.5))
+		node(v1alpha5.CapacityTypeOnDemand, lo.ToPtr(2.0))
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(expectHourlyCost("machine-template", v1alpha5.CapacityTypeOnDemand)).To(Equal(3.5))
+	})
+	It("should stop reporting the cost of nodes that were deleted", func() {
+		n := node(v1alpha5.CapacityTypeOnDemand, nil)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		expectHourlyCost("default", v1alpha5.CapacityTypeOnDemand)
+
+		ExpectDeleted(ctx, env.Client, n)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		_, ok := FindMetricWithLabelValues("karpenter_cloudprovider_node_hourly_cost", map[string]string{"provisioner": provisioner.Name})
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,203 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/clock"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils"
+)
+
+const (
+	pollingPeriod = 2 * time.Minute
+	// maxTerminatedInstances is the maximum number of instances of a TerminateInstances request
+	maxTerminatedInstances = 1000
+)
+
+// Controller terminates the instances that Karpenter launched for the cluster but that no machine or node tracks,
+// which happens when Karpenter restarts between launching an instance and creating its node, or when a machine or node
+// is removed without its termination finalizer. Instances are only terminated when dry run is disabled, and otherwise
+// only logged. Warm instances are tracked by their node template and provisioner instead, and they're terminated once
+// either of them is deleted.
+type Controller struct {
+	kubeClient client.Client
+	ec2api     ec2iface.EC2API
+	clk        clock.Clock
+}
+
+func NewController(kubeClient client.Client, ec2api ec2iface.EC2API, clk clock.Clock) corecontroller.Controller {
+	return &Controller{
+		kubeClient: kubeClient,
+		ec2api:     ec2api,
+		clk:        clk,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	// Instances are listed before machines and nodes so that those created in the meantime aren't missed
+	instances, err := c.listInstances(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	machineList := &v1alpha5.MachineList{}
+	if err = c.kubeClient.List(ctx, machineList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing machines, %w", err)
+	}
+	nodeList := &v1.NodeList{}
+	if err = c.kubeClient.List(ctx, nodeList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	nodeTemplateList := &v1alpha1.AWSNodeTemplateList{}
+	if err = c.kubeClient.List(ctx, nodeTemplateList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing node templates, %w", err)
+	}
+	provisionerList := &v1alpha5.ProvisionerList{}
+	if err = c.kubeClient.List(ctx, provisionerList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing provisioners, %w", err)
+	}
+	nodeTemplates := sets.NewString(lo.Map(nodeTemplateList.Items, func(nodeTemplate v1alpha1.AWSNodeTemplate, _ int) string { return nodeTemplate.Name })...)
+	provisioners := sets.NewString(lo.Map(provisionerList.Items, func(provisioner v1alpha5.Provisioner, _ int) string { return provisioner.Name })...)
+	machineNames := sets.NewString()
+	instanceIDs := sets.NewString()
+	for _, machine := range machineList.Items {
+		machineNames.Insert(machine.Name)
+		if id, err := utils.ParseInstanceID(machine.Status.ProviderID); err == nil {
+			instanceIDs.Insert(id)
+		}
+	}
+	for _, node := range nodeList.Items {
+		if id, err := utils.ParseInstanceID(node.Spec.ProviderID); err == nil {
+			instanceIDs.Insert(id)
+		}
+	}
+	orphans := lo.Filter(instances, func(instance *ec2.Instance, _ int) bool {
+		if c.clk.Since(aws.TimeValue(instance.LaunchTime)) < settings.FromContext(ctx).GarbageCollectionGracePeriod.Duration {
+			return false
+		}
+		if cloudprovider.IsWarmInstance(instance) {
+			return isOrphanedWarmInstance(instance, nodeTemplates, provisioners)
+		}
+		return isOrphaned(instance, machineNames, instanceIDs)
+	})
+	orphanedInstances.Set(float64(len(orphans)))
+	if len(orphans) == 0 {
+		return reconcile.Result{RequeueAfter: pollingPeriod}, nil
+	}
+	ids := lo.Map(orphans, func(instance *ec2.Instance, _ int) *string { return instance.InstanceId })
+	if settings.FromContext(ctx).GarbageCollectionDryRun {
+		logging.FromContext(ctx).With("ids", aws.StringValueSlice(ids)).Infof("found orphaned instances, skipping termination in dry run")
+		return reconcile.Result{RequeueAfter: pollingPeriod}, nil
+	}
+	if err = c.terminateInstances(ctx, ids); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: pollingPeriod}, nil
+}
+
+// terminateInstances terminates the instances in chunks, so that a chunk that fails doesn't prevent the remaining
+// chunks from being terminated
+func (c *Controller) terminateInstances(ctx context.Context, ids []*string) (errs error) {
+	for _, chunk := range lo.Chunk(ids, maxTerminatedInstances) {
+		if _, err := c.ec2api.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: chunk}); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("terminating orphaned instances, %w", err))
+			continue
+		}
+		terminatedInstances.Add(float64(len(chunk)))
+		logging.FromContext(ctx).With("ids", aws.StringValueSlice(chunk)).Infof("terminated orphaned instances")
+	}
+	return errs
+}
+
+func (c *Controller) Name() string {
+	return "garbagecollection"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(m)
+}
+
+// isOrphaned returns whether the instance is tracked by neither a machine, by its provider ID or machine name tag, nor
+// a node
+func isOrphaned(instance *ec2.Instance, machineNames, instanceIDs sets.String) bool {
+	if instanceIDs.Has(aws.StringValue(instance.InstanceId)) {
+		return false
+	}
+	if tag, ok := lo.Find(instance.Tags, func(tag *ec2.Tag) bool { return aws.StringValue(tag.Key) == v1alpha5.MachineNameLabelKey }); ok {
+		return !machineNames.Has(aws.StringValue(tag.Value))
+	}
+	return true
+}
+
+// isOrphanedWarmInstance returns whether the node template of the warm instance's pool, or the provisioner that it was
+// launched for, no longer exists. Warm instances that were launched without a provisioner are only tracked by their
+// node template.
+func isOrphanedWarmInstance(instance *ec2.Instance, nodeTemplates, provisioners sets.String) bool {
+	tags := lo.SliceToMap(instance.Tags, func(tag *ec2.Tag) (string, string) { return aws.StringValue(tag.Key), aws.StringValue(tag.Value) })
+	if !nodeTemplates.Has(tags[v1alpha1.WarmPoolTagKey]) {
+		return true
+	}
+	provisioner := tags[v1alpha5.ProvisionerNameLabelKey]
+	return provisioner != "" && !provisioners.Has(provisioner)
+}
+
+// listInstances returns the instances that Karpenter launched for the cluster
+func (c *Controller) listInstances(ctx context.Context) ([]*ec2.Instance, error) {
+	var instances []*ec2.Instance
+	if err := c.ec2api.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: aws.StringSlice([]string{v1alpha5.ProvisionerNameLabelKey}),
+			},
+			{
+				Name:   aws.String(fmt.Sprintf("tag:kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName)),
+				Values: aws.StringSlice([]string{"*"}),
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped}),
+			},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing instances, %w", err)
+	}
+	return instances, nil
+}
@@ -0,0 +1,49 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollection
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter-core/pkg/metrics"
+)
+
+const (
+	garbageCollectionSubsystem = "garbage_collection"
+)
+
+var (
+	orphanedInstances = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: garbageCollectionSubsystem,
+			Name:      "orphaned_instances",
+			Help:      "Number of instances of the cluster that aren't tracked by a machine or node beyond the grace period, as of the last garbage collection.",
+		},
+	)
+	terminatedInstances = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: garbageCollectionSubsystem,
+			Name:      "terminated_instances",
+			Help:      "Count of orphaned instances terminated by garbage collection.",
+		},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(orphanedInstances, terminatedInstances)
+}
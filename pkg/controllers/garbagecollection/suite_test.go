@@ -0,0 +1,181 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollection_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+	clock "k8s.io/utils/clock/testing"
+	. "knative.dev/pkg/logging/testing"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	coretest "github.com/aws/karpenter-core/pkg/test"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/controllers/garbagecollection"
+	"github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/test"
+)
+
+var ctx context.Context
+var env *coretest.Environment
+var fakeEC2API *fake.EC2API
+var fakeClock *clock.FakeClock
+var controller corecontroller.Controller
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "GarbageCollection")
+}
+
+var _ = BeforeSuite(func() {
+	env = coretest.NewEnvironment(scheme.Scheme, coretest.WithCRDs(apis.CRDs...))
+	fakeEC2API = &fake.EC2API{}
+	fakeClock = &clock.FakeClock{}
+	controller = garbagecollection.NewController(env.Client, fakeEC2API, fakeClock)
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = BeforeEach(func() {
+	ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{GarbageCollectionDryRun: lo.ToPtr(false)}))
+	fakeClock.SetTime(time.Now())
+	fakeEC2API.Reset()
+})
+
+var _ = AfterEach(func() {
+	ExpectCleanedUp(ctx, env.Client)
+})
+
+// instance returns an instance that Karpenter launched for the cluster, which the fake EC2 API lists
+func instance(launchTime time.Time, tags ...*ec2.Tag) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:   aws.String(fake.InstanceID()),
+		InstanceType: aws.String("m5.large"),
+		LaunchTime:   aws.Time(launchTime),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
+		State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		Tags:         append([]*ec2.Tag{{Key: aws.String(v1alpha5.ProvisionerNameLabelKey), Value: aws.String("default")}}, tags...),
+	}
+}
+
+func expectInstances(instances ...*ec2.Instance) {
+	fakeEC2API.DescribeInstancesBehavior.Output.Set(&ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: instances}},
+	})
+}
+
+func terminatedInstanceIDs() []string {
+	var ids []string
+	for fakeEC2API.TerminateInstancesBehavior.CalledWithInput.Len() > 0 {
+		ids = append(ids, aws.StringValueSlice(fakeEC2API.TerminateInstancesBehavior.CalledWithInput.Pop().InstanceIds)...)
+	}
+	return ids
+}
+
+var _ = Describe("GarbageCollection", func() {
+	It("should terminate instances that aren't tracked by a machine or node", func() {
+		orphan := instance(fakeClock.Now().Add(-time.Hour))
+		expectInstances(orphan)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(terminatedInstanceIDs()).To(ConsistOf(aws.StringValue(orphan.InstanceId)))
+	})
+	It("should not terminate instances that were launched within the grace period", func() {
+		expectInstances(instance(fakeClock.Now().Add(-time.Minute)))
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(BeZero())
+	})
+	It("should not terminate instances of nodes", func() {
+		tracked := instance(fakeClock.Now().Add(-time.Hour))
+		expectInstances(tracked)
+		ExpectApplied(ctx, env.Client, coretest.Node(coretest.NodeOptions{ProviderID: fake.ProviderID(aws.StringValue(tracked.InstanceId))}))
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(BeZero())
+	})
+	It("should not terminate instances of machines", func() {
+		tagged := instance(fakeClock.Now().Add(-time.Hour))
+		untagged := instance(fakeClock.Now().Add(-time.Hour))
+		machine := coretest.Machine(v1alpha5.Machine{Status: v1alpha5.MachineStatus{ProviderID: fake.ProviderID(aws.StringValue(untagged.InstanceId))}})
+		tagged.Tags = append(tagged.Tags, &ec2.Tag{Key: aws.String(v1alpha5.MachineNameLabelKey), Value: aws.String(machine.Name)})
+		ExpectApplied(ctx, env.Client, machine)
+		expectInstances(tagged, untagged)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(BeZero())
+	})
+	It("should not terminate instances that were launched within the configured grace period", func() {
+		ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{GarbageCollectionDryRun: lo.ToPtr(false), GarbageCollectionGracePeriod: lo.ToPtr(time.Hour)}))
+		expectInstances(instance(fakeClock.Now().Add(-10 * time.Minute)))
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(BeZero())
+	})
+	It("should not terminate warm instances of node templates and provisioners that exist", func() {
+		nodeTemplate := test.AWSNodeTemplate()
+		provisioner := test.Provisioner(coretest.ProvisionerOptions{ProviderRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name}})
+		ExpectApplied(ctx, env.Client, nodeTemplate, provisioner)
+		warm := instance(fakeClock.Now().Add(-time.Hour), &ec2.Tag{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String(nodeTemplate.Name)})
+		warm.Tags[0].Value = aws.String(provisioner.Name)
+		expectInstances(warm)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(BeZero())
+	})
+	It("should terminate warm instances of node templates or provisioners that no longer exist", func() {
+		nodeTemplate := test.AWSNodeTemplate()
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		withoutNodeTemplate := instance(fakeClock.Now().Add(-time.Hour), &ec2.Tag{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String("deleted")})
+		withoutProvisioner := instance(fakeClock.Now().Add(-time.Hour), &ec2.Tag{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String(nodeTemplate.Name)})
+		expectInstances(withoutNodeTemplate, withoutProvisioner)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(terminatedInstanceIDs()).To(ConsistOf(aws.StringValue(withoutNodeTemplate.InstanceId), aws.StringValue(withoutProvisioner.InstanceId)))
+	})
+	It("should terminate orphaned instances in chunks", func() {
+		orphans := lo.Times(1500, func(_ int) *ec2.Instance { return instance(fakeClock.Now().Add(-time.Hour)) })
+		expectInstances(orphans...)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(Equal(2))
+		Expect(terminatedInstanceIDs()).To(ConsistOf(lo.Map(orphans, func(orphan *ec2.Instance, _ int) string { return aws.StringValue(orphan.InstanceId) })))
+	})
+	It("should not terminate orphaned instances in dry run, which is the default", func() {
+		ctx = settings.ToContext(ctx, test.Settings())
+		expectInstances(instance(fakeClock.Now().Add(-time.Hour)))
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(BeZero())
+	})
+})
@@ -0,0 +1,149 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instancetypesnapshot
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/configmap"
+	"github.com/aws/karpenter/pkg/utils/leaderelection"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap in the system namespace that stores the instance type snapshot
+	ConfigMapName = "karpenter-instance-types"
+	// SnapshotKey is the ConfigMap binary data key of the gzip compressed JSON snapshot
+	SnapshotKey = "snapshot.json.gz"
+
+	syncPeriod = time.Minute
+	// maxSnapshotSize is the maximum size of a compressed snapshot, which leaves room within the 1MiB size limit of
+	// ConfigMaps
+	maxSnapshotSize = 900 * 1024
+)
+
+// Controller persists the last good instance types and zonal offerings to a ConfigMap, so that they're served
+// immediately after a restart or leader failover, and when the EC2 APIs fail, while they're refreshed. It runs on every
+// replica: each replica restores the snapshot until it's restored or instance types were already retrieved, so that
+// standby replicas are warm when they're elected, and only the leader persists the snapshot whenever the instance
+// types or offerings change.
+type Controller struct {
+	kubernetesInterface  kubernetes.Interface
+	instanceTypeProvider *cloudprovider.InstanceTypeProvider
+	elected              <-chan struct{}
+
+	restored        bool
+	persistedSeqNum uint64
+}
+
+func NewController(kubernetesInterface kubernetes.Interface, instanceTypeProvider *cloudprovider.InstanceTypeProvider, elected <-chan struct{}) corecontroller.Controller {
+	return &Controller{
+		kubernetesInterface:  kubernetesInterface,
+		instanceTypeProvider: instanceTypeProvider,
+		elected:              elected,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	if !c.restored {
+		restored, err := c.restore(ctx)
+		if err != nil {
+			// a snapshot that can't be restored is replaced once instance types are retrieved
+			logging.FromContext(ctx).Errorf("restoring instance type snapshot, %s", err)
+		}
+		// the leader only restores once, as it retrieves the instance types itself
+		c.restored = restored || leaderelection.IsLeader(c.elected)
+	}
+	if leaderelection.IsLeader(c.elected) {
+		if err := c.persist(ctx); err != nil {
+			return reconcile.Result{}, fmt.Errorf("persisting instance type snapshot, %w", err)
+		}
+	}
+	return reconcile.Result{RequeueAfter: syncPeriod}, nil
+}
+
+func (c *Controller) Name() string {
+	return "instancetypesnapshot"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(leaderelection.UnelectedManager{Manager: m})
+}
+
+// restore restores the persisted snapshot and returns whether the provider's instance types are known afterwards
+func (c *Controller) restore(ctx context.Context) (bool, error) {
+	if _, ok := c.instanceTypeProvider.Snapshot(); ok {
+		return true, nil
+	}
+	cm, err := c.kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	data, ok := cm.BinaryData[SnapshotKey]
+	if !ok {
+		return false, nil
+	}
+	snapshot, err := cloudprovider.DecodeInstanceTypeSnapshot(data)
+	if err != nil {
+		return false, err
+	}
+	if !c.instanceTypeProvider.RestoreSnapshot(snapshot) {
+		return false, nil
+	}
+	logging.FromContext(ctx).With(
+		"instance-type-count", len(snapshot.InstanceTypes),
+		"generated-at", snapshot.GeneratedAt.Format(time.RFC3339)).Infof("restored instance type snapshot")
+	return true, nil
+}
+
+func (c *Controller) persist(ctx context.Context) error {
+	seqNum := atomic.LoadUint64(&c.instanceTypeProvider.SnapshotSeqNum)
+	if seqNum == c.persistedSeqNum {
+		return nil
+	}
+	snapshot, ok := c.instanceTypeProvider.Snapshot()
+	if !ok {
+		return nil
+	}
+	data, err := snapshot.Encode()
+	if err != nil {
+		return err
+	}
+	if len(data) > maxSnapshotSize {
+		return fmt.Errorf("compressed snapshot of %d bytes exceeds the maximum of %d bytes", len(data), maxSnapshotSize)
+	}
+	if err := configmap.Persist(ctx, c.kubernetesInterface, ConfigMapName, nil, map[string][]byte{SnapshotKey: data}); err != nil {
+		return err
+	}
+	c.persistedSeqNum = seqNum
+	logging.FromContext(ctx).With("instance-type-count", len(snapshot.InstanceTypes), "bytes", len(data)).Debugf("persisted instance type snapshot")
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instancetypesnapshot_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecloudprovider "github.com/aws/karpenter-core/pkg/cloudprovider"
+	coreevents "github.com/aws/karpenter-core/pkg/events"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/instancetypesnapshot"
+	awsfake "github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/providers/quota"
+	"github.com/aws/karpenter/pkg/providers/subnet"
+	"github.com/aws/karpenter/pkg/test"
+)
+
+var ctx context.Context
+var kubernetesInterface *fake.Clientset
+var fakeEC2API *awsfake.EC2API
+var nodeTemplate *v1alpha1.AWSNodeTemplate
+var elected chan struct{}
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "InstanceTypeSnapshot")
+}
+
+var _ = BeforeEach(func() {
+	ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{IsolatedVPC: lo.ToPtr(true), PersistInstanceTypes: lo.ToPtr(true)}))
+	kubernetesInterface = fake.NewSimpleClientset()
+	fakeEC2API = &awsfake.EC2API{}
+	nodeTemplate = test.AWSNodeTemplate(v1alpha1.AWSNodeTemplateSpec{AWS: v1alpha1.AWS{SubnetSelector: map[string]string{"*": "*"}}})
+	elected = make(chan struct{})
+	close(elected)
+})
+
+func newInstanceTypeProvider() *cloudprovider.InstanceTypeProvider {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("test-region")}))
+	return cloudprovider.NewInstanceTypeProvider(ctx, sess, fakeEC2API, subnet.NewProvider(fakeEC2API), nil, awscache.NewUnavailableOfferings(), awscache.NewMemoryOverhead(),
+		quota.NewProvider(ctx, &awsfake.ServiceQuotasAPI{}, fakeEC2API, make(chan struct{})), coreevents.NewRecorder(&record.FakeRecorder{}), make(chan struct{}))
+}
+
+func expectReconciled(controller corecontroller.Controller) {
+	_, err := controller.Reconcile(ctx, reconcile.Request{})
+	Expect(err).ToNot(HaveOccurred())
+}
+
+func instanceTypeNames(provider *cloudprovider.InstanceTypeProvider) []string {
+	instanceTypes, err := provider.List(ctx, &v1alpha5.KubeletConfiguration{}, nodeTemplate)
+	Expect(err).ToNot(HaveOccurred())
+	return lo.Map(instanceTypes, func(instanceType *corecloudprovider.InstanceType, _ int) string { return instanceType.Name })
+}
+
+var _ = Describe("InstanceTypeSnapshot", func() {
+	It("should persist the instance types and offerings", func() {
+		provider := newInstanceTypeProvider()
+		controller := instancetypesnapshot.NewController(kubernetesInterface, provider, elected)
+		expectReconciled(controller)
+		_, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, instancetypesnapshot.ConfigMapName, metav1.GetOptions{})
+		Expect(err).To(HaveOccurred())
+
+		Expect(instanceTypeNames(provider)).To(ContainElement("m5.large"))
+		expectReconciled(controller)
+		cm, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, instancetypesnapshot.ConfigMapName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		snapshot, err := cloudprovider.DecodeInstanceTypeSnapshot(cm.BinaryData[instancetypesnapshot.SnapshotKey])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Region).To(Equal("test-region"))
+		Expect(snapshot.Offerings).To(HaveKeyWithValue("m5.large", ConsistOf("test-zone-1a", "test-zone-1b", "test-zone-1c")))
+		Expect(lo.Map(snapshot.InstanceTypes, func(instanceType *ec2.InstanceTypeInfo, _ int) string {
+			return aws.StringValue(instanceType.InstanceType)
+		})).To(ContainElement("m5.large"))
+	})
+	It("should serve the restored instance types while they're refreshed", func() {
+		provider := newInstanceTypeProvider()
+		instanceTypeNames(provider)
+		expectReconciled(instancetypesnapshot.NewController(kubernetesInterface, provider, elected))
+
+		// the restarted provider serves the snapshot until the refresh discovers the changed instance types
+		fakeEC2API.DescribeInstanceTypesOutput.Set(&ec2.DescribeInstanceTypesOutput{InstanceTypes: []*ec2.InstanceTypeInfo{}})
+		restarted := newInstanceTypeProvider()
+		expectReconciled(instancetypesnapshot.NewController(kubernetesInterface, restarted, elected))
+		Expect(instanceTypeNames(restarted)).To(ContainElement("m5.large"))
+		Eventually(func() []string { return instanceTypeNames(restarted) }).Should(BeEmpty())
+	})
+	It("should restore the snapshot on standby replicas without persisting it", func() {
+		standby := newInstanceTypeProvider()
+		standbyController := instancetypesnapshot.NewController(kubernetesInterface, standby, make(chan struct{}))
+		expectReconciled(standbyController)
+		_, ok := standby.Snapshot()
+		Expect(ok).To(BeFalse())
+
+		// the standby replica keeps restoring until the leader has persisted a snapshot
+		provider := newInstanceTypeProvider()
+		instanceTypeNames(provider)
+		expectReconciled(instancetypesnapshot.NewController(kubernetesInterface, provider, elected))
+		expectReconciled(standbyController)
+		snapshot, ok := standby.Snapshot()
+		Expect(ok).To(BeTrue())
+		Expect(snapshot.Offerings).To(HaveKey("m5.large"))
+	})
+	It("should not persist the snapshot on standby replicas", func() {
+		standby := newInstanceTypeProvider()
+		instanceTypeNames(standby)
+		expectReconciled(instancetypesnapshot.NewController(kubernetesInterface, standby, make(chan struct{})))
+		_, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, instancetypesnapshot.ConfigMapName, metav1.GetOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+	It("should not restore snapshots of other regions", func() {
+		provider := newInstanceTypeProvider()
+		snapshot := &cloudprovider.InstanceTypeSnapshot{
+			Region:        "other-region",
+			InstanceTypes: []*ec2.InstanceTypeInfo{{InstanceType: aws.String("m5.large")}},
+			Offerings:     map[string][]string{"m5.large": {"other-zone-1a"}},
+		}
+		Expect(provider.RestoreSnapshot(snapshot)).To(BeFalse())
+		_, ok := provider.Snapshot()
+		Expect(ok).To(BeFalse())
+	})
+})
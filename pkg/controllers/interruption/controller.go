@@ -24,6 +24,7 @@ import (
 	"github.com/samber/lo"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/utils/clock"
 	"knative.dev/pkg/logging"
@@ -62,12 +63,17 @@ type Controller struct {
 	recorder                  events.Recorder
 	sqsProvider               *SQSProvider
 	unavailableOfferingsCache *cache.UnavailableOfferings
+	spotInterruptions         *cache.SpotInterruptionHistory
+	kubernetesInterface       kubernetes.Interface
 	parser                    *EventParser
 	cm                        *pretty.ChangeMonitor
+
+	hydrated        bool
+	persistedSeqNum uint64
 }
 
-func NewController(kubeClient client.Client, clk clock.Clock, recorder events.Recorder,
-	sqsProvider *SQSProvider, unavailableOfferingsCache *cache.UnavailableOfferings) *Controller {
+func NewController(kubeClient client.Client, kubernetesInterface kubernetes.Interface, clk clock.Clock, recorder events.Recorder,
+	sqsProvider *SQSProvider, unavailableOfferingsCache *cache.UnavailableOfferings, spotInterruptions *cache.SpotInterruptionHistory) *Controller {
 
 	return &Controller{
 		kubeClient:                kubeClient,
@@ -75,6 +81,8 @@ func NewController(kubeClient client.Client, clk clock.Clock, recorder events.Re
 		recorder:                  recorder,
 		sqsProvider:               sqsProvider,
 		unavailableOfferingsCache: unavailableOfferingsCache,
+		spotInterruptions:         spotInterruptions,
+		kubernetesInterface:       kubernetesInterface,
 		parser:                    NewEventParser(DefaultParsers...),
 		cm:                        pretty.NewChangeMonitor(),
 	}
@@ -85,6 +93,10 @@ func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconc
 	if c.cm.HasChanged(settings.FromContext(ctx).InterruptionQueueName, nil) {
 		logging.FromContext(ctx).Debugf("watching interruption queue")
 	}
+	// The spot interruption history is persisted as of the previous reconcile, since the queue is long polled
+	if err := c.syncSpotInterruptionHistory(ctx); err != nil {
+		logging.FromContext(ctx).Errorf("syncing spot interruption history, %s", err)
+	}
 	sqsMessages, err := c.sqsProvider.GetSQSMessages(ctx)
 	if err != nil {
 		return reconcile.Result{}, fmt.Errorf("getting messages from queue, %w", err)
@@ -182,12 +194,14 @@ func (c *Controller) handleNode(ctx context.Context, msg messages.Message, node
 	c.notifyForMessage(msg, node)
 	actionsPerformed.WithLabelValues(string(action)).Inc()
 
-	// Mark the offering as unavailable in the ICE cache since we got a spot interruption warning
+	// Mark the offering as unavailable in the ICE cache since we got a spot interruption warning, and learn how often
+	// its pool is interrupted
 	if msg.Kind() == messages.SpotInterruptionKind {
 		zone := node.Labels[v1.LabelTopologyZone]
 		instanceType := node.Labels[v1.LabelInstanceTypeStable]
 		if zone != "" && instanceType != "" {
 			c.unavailableOfferingsCache.MarkUnavailable(ctx, string(msg.Kind()), instanceType, zone, v1alpha1.CapacityTypeSpot)
+			c.spotInterruptions.RecordInterruption(instanceType, zone, node.CreationTimestamp.Time)
 		}
 	}
 	if action != NoAction {
@@ -0,0 +1,123 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package interruption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/utils/configmap"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+)
+
+const (
+	// SpotInterruptionsConfigMapName is the name of the ConfigMap in the system namespace that stores the spot
+	// interruption history
+	SpotInterruptionsConfigMapName = "karpenter-spot-interruptions"
+	// spotInterruptionsKey is the ConfigMap data key of the JSON encoded hourly history of each spot pool
+	spotInterruptionsKey = "pools"
+	// launchesCountedUntilKey is the ConfigMap data key of the time that spot launches were counted until
+	launchesCountedUntilKey = "launchesCountedUntil"
+)
+
+// syncSpotInterruptionHistory rehydrates the spot interruption history once, so that it survives restarts and leader
+// failovers, counts the spot launches since, and then persists every change to it
+func (c *Controller) syncSpotInterruptionHistory(ctx context.Context) error {
+	if !c.hydrated {
+		cm, err := c.kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, SpotInterruptionsConfigMapName, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			entries := map[string]cache.SpotInterruptionRecord{}
+			if data, ok := cm.Data[spotInterruptionsKey]; ok {
+				if err := json.Unmarshal([]byte(data), &entries); err != nil {
+					return fmt.Errorf("decoding %s, %w", spotInterruptionsKey, err)
+				}
+			}
+			var launchesCountedUntil time.Time
+			if data, ok := cm.Data[launchesCountedUntilKey]; ok {
+				if launchesCountedUntil, err = time.Parse(time.RFC3339, data); err != nil {
+					return fmt.Errorf("decoding %s, %w", launchesCountedUntilKey, err)
+				}
+			}
+			if c.spotInterruptions.Restore(entries, launchesCountedUntil) {
+				logging.FromContext(ctx).With("pools", len(entries)).Debugf("restored spot interruption history")
+			}
+		}
+		c.hydrated = true
+	}
+	if err := c.recordSpotLaunches(ctx); err != nil {
+		return err
+	}
+	seqNum := atomic.LoadUint64(&c.spotInterruptions.SeqNum)
+	if seqNum == c.persistedSeqNum {
+		return nil
+	}
+	data, err := json.Marshal(c.spotInterruptions.Entries())
+	if err != nil {
+		return fmt.Errorf("encoding %s, %w", spotInterruptionsKey, err)
+	}
+	if err := configmap.Persist(ctx, c.kubernetesInterface, SpotInterruptionsConfigMapName, map[string]string{
+		spotInterruptionsKey:    string(data),
+		launchesCountedUntilKey: c.spotInterruptions.LaunchesCountedUntil().UTC().Format(time.RFC3339),
+	}, nil); err != nil {
+		return err
+	}
+	c.persistedSeqNum = seqNum
+	return nil
+}
+
+// recordSpotLaunches counts the spot nodes that were launched since launches were last counted. Launches are derived
+// from the nodes on the leader, rather than recorded by the replica that launched them, and the time that they were
+// counted until is persisted with the history, so that every launch is counted once across restarts and failovers.
+func (c *Controller) recordSpotLaunches(ctx context.Context) error {
+	nodeList := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodeList, client.HasLabels{v1alpha5.ProvisionerNameLabelKey}); err != nil {
+		return fmt.Errorf("listing nodes, %w", err)
+	}
+	// Creation timestamps have a precision of seconds, so launches are counted until the last second that has fully
+	// passed, which is also the precision that the time is persisted with
+	until := c.clk.Now().Truncate(time.Second).Add(-time.Second)
+	countedUntil := c.spotInterruptions.LaunchesCountedUntil()
+	launches := map[string][]time.Time{}
+	for _, node := range nodeList.Items {
+		launched := node.CreationTimestamp.Time
+		if node.Labels[v1alpha5.LabelCapacityType] != v1alpha1.CapacityTypeSpot || !launched.After(countedUntil) || launched.After(until) {
+			continue
+		}
+		key := cache.SpotPoolKey(node.Labels[v1.LabelInstanceTypeStable], node.Labels[v1.LabelTopologyZone])
+		launches[key] = append(launches[key], launched)
+	}
+	// The time that launches were counted until is only advanced with the launches, as no node that was launched
+	// before it can appear once it has passed
+	if len(launches) > 0 {
+		c.spotInterruptions.RecordLaunches(launches, until)
+	}
+	return nil
+}
@@ -36,6 +36,7 @@ import (
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/util/workqueue"
 	clock "k8s.io/utils/clock/testing"
 	"knative.dev/pkg/logging"
@@ -111,7 +112,7 @@ func benchmarkNotificationController(b *testing.B, messageCount int) {
 	unavailableOfferingsCache = awscache.NewUnavailableOfferings()
 
 	// Set-up the controllers
-	interruptionController := interruption.NewController(env.Client, fakeClock, recorder, providers.sqsProvider, unavailableOfferingsCache)
+	interruptionController := interruption.NewController(env.Client, k8sfake.NewSimpleClientset(), fakeClock, recorder, providers.sqsProvider, unavailableOfferingsCache, awscache.NewSpotInterruptionHistory())
 
 	messages, nodes := makeDiverseMessagesAndNodes(messageCount)
 	logging.FromContext(ctx).Infof("provisioning nodes")
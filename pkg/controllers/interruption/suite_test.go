@@ -31,9 +31,11 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/record"
 	clock "k8s.io/utils/clock/testing"
 	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/system"
 	_ "knative.dev/pkg/system/testing"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -69,6 +71,8 @@ var env *coretest.Environment
 var sqsapi *fake.SQSAPI
 var sqsProvider *interruption.SQSProvider
 var unavailableOfferingsCache *awscache.UnavailableOfferings
+var spotInterruptions *awscache.SpotInterruptionHistory
+var kubernetesInterface *k8sfake.Clientset
 var fakeClock *clock.FakeClock
 var controller *interruption.Controller
 
@@ -82,9 +86,13 @@ var _ = BeforeSuite(func() {
 	env = coretest.NewEnvironment(scheme.Scheme, coretest.WithCRDs(apis.CRDs...))
 	fakeClock = &clock.FakeClock{}
 	unavailableOfferingsCache = awscache.NewUnavailableOfferings()
+	spotInterruptions = awscache.NewSpotInterruptionHistory()
+	kubernetesInterface = k8sfake.NewSimpleClientset()
 	sqsapi = &fake.SQSAPI{}
 	sqsProvider = interruption.NewSQSProvider(sqsapi)
-	controller = interruption.NewController(env.Client, fakeClock, events.NewRecorder(&record.FakeRecorder{}), sqsProvider, unavailableOfferingsCache)
+	spotInterruptions.Flush()
+	kubernetesInterface = k8sfake.NewSimpleClientset()
+	controller = interruption.NewController(env.Client, kubernetesInterface, fakeClock, events.NewRecorder(&record.FakeRecorder{}), sqsProvider, unavailableOfferingsCache, spotInterruptions)
 })
 
 var _ = AfterSuite(func() {
@@ -93,7 +101,9 @@ var _ = AfterSuite(func() {
 
 var _ = BeforeEach(func() {
 	sqsProvider = interruption.NewSQSProvider(sqsapi)
-	controller = interruption.NewController(env.Client, fakeClock, events.NewRecorder(&record.FakeRecorder{}), sqsProvider, unavailableOfferingsCache)
+	spotInterruptions.Flush()
+	kubernetesInterface = k8sfake.NewSimpleClientset()
+	controller = interruption.NewController(env.Client, kubernetesInterface, fakeClock, events.NewRecorder(&record.FakeRecorder{}), sqsProvider, unavailableOfferingsCache, spotInterruptions)
 	ctx = coresettings.ToContext(ctx, coretest.Settings())
 	ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{
 		InterruptionQueueName: lo.ToPtr("test-cluster"),
@@ -261,6 +271,42 @@ var _ = Describe("AWSInterruption", func() {
 			// Expect a t3.large in coretest-zone-1a to be added to the ICE cache
 			Expect(unavailableOfferingsCache.IsUnavailable("t3.large", "coretest-zone-1a", v1alpha1.CapacityTypeSpot)).To(BeTrue())
 		})
+		It("should learn and persist the interruption rate of the pool when getting spot interruption warnings", func() {
+			var nodes []*v1.Node
+			for i := 0; i < 4; i++ {
+				instanceID := fmt.Sprintf("i-%017d", i)
+				nodes = append(nodes, coretest.Node(coretest.NodeOptions{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{
+							v1alpha5.ProvisionerNameLabelKey: "default",
+							v1.LabelTopologyZone:             "coretest-zone-1a",
+							v1.LabelInstanceTypeStable:       "t3.large",
+							v1alpha5.LabelCapacityType:       v1alpha1.CapacityTypeSpot,
+						},
+					},
+					ProviderID: fake.ProviderID(instanceID),
+				}))
+				// Half of the pool's launches are interrupted
+				if i < 2 {
+					ExpectMessagesCreated(spotInterruptionMessage(instanceID))
+				}
+			}
+			ExpectApplied(ctx, env.Client, nodes[0], nodes[1], nodes[2], nodes[3])
+			// Launches are counted once the second that the nodes were created in has passed
+			fakeClock.SetTime(time.Now().Add(time.Minute))
+
+			ExpectReconcileSucceeded(ctx, controller, types.NamespacedName{})
+			rate, ok := spotInterruptions.Rate("t3.large", "coretest-zone-1a")
+			Expect(ok).To(BeTrue())
+			Expect(rate).To(Equal(0.5))
+
+			// The history is persisted by the next reconcile
+			ExpectReconcileSucceeded(ctx, controller, types.NamespacedName{})
+			cm, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, interruption.SpotInterruptionsConfigMapName, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cm.Data["pools"]).To(ContainSubstring("t3.large:coretest-zone-1a"))
+			Expect(cm.Data).To(HaveKey("launchesCountedUntil"))
+		})
 	})
 	Context("Error Handling", func() {
 		It("should send an error on polling when QueueNotExists", func() {
@@ -0,0 +1,152 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package launchtemplate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/clock"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+const pollingPeriod = 5 * time.Minute
+
+// Controller garbage collects the launch templates that Karpenter created for the cluster but that are no longer
+// needed, and prunes the superseded versions of versioned launch templates. The launch templates that are needed are
+// the ones that the provisioners resolve now. Launch templates outside of that set are deleted once they're older than
+// the grace period, unless they were resolved for a launch within it, which only the leader knows as it's the only
+// replica that launches instances.
+type Controller struct {
+	kubeClient    client.Client
+	clk           clock.Clock
+	cloudProvider *cloudprovider.CloudProvider
+	// elected is the time of the first reconciliation, after which this replica has been the leader
+	elected time.Time
+}
+
+func NewController(kubeClient client.Client, clk clock.Clock, cloudProvider *cloudprovider.CloudProvider) corecontroller.Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		clk:           clk,
+		cloudProvider: cloudProvider,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	if c.elected.IsZero() {
+		c.elected = c.clk.Now()
+	}
+	// The launch templates that the previous leader resolved aren't known until they've been resolved again
+	if c.clk.Since(c.elected) < awscache.LaunchTemplateGracePeriod {
+		return reconcile.Result{RequeueAfter: awscache.LaunchTemplateGracePeriod - c.clk.Since(c.elected)}, nil
+	}
+	resolved, err := c.resolved(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	launchTemplates, err := c.cloudProvider.LaunchTemplateProvider().List(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	var errs error
+	for _, launchTemplate := range launchTemplates {
+		if c.clk.Since(aws.TimeValue(launchTemplate.CreateTime)) < awscache.LaunchTemplateGracePeriod {
+			continue
+		}
+		deleted, err := c.cloudProvider.LaunchTemplateProvider().DeleteUnused(ctx, launchTemplate, resolved)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		if deleted {
+			logging.FromContext(ctx).With(
+				"launch-template-name", aws.StringValue(launchTemplate.LaunchTemplateName),
+				"launch-template-id", aws.StringValue(launchTemplate.LaunchTemplateId)).Debugf("deleted launch template")
+			continue
+		}
+		errs = multierr.Append(errs, c.pruneVersions(ctx, launchTemplate, resolved))
+	}
+	return reconcile.Result{RequeueAfter: pollingPeriod}, errs
+}
+
+// resolved returns the names of the launch templates, and the versions of versioned launch templates, that the
+// provisioners resolve now. Provisioners whose node templates don't exist don't resolve any launch templates.
+func (c *Controller) resolved(ctx context.Context) (sets.String, error) {
+	provisioners := &v1alpha5.ProvisionerList{}
+	if err := c.kubeClient.List(ctx, provisioners); err != nil {
+		return nil, fmt.Errorf("listing provisioners, %w", err)
+	}
+	resolved := sets.NewString()
+	for i := range provisioners.Items {
+		names, err := c.cloudProvider.ResolveLaunchTemplates(ctx, &provisioners.Items[i])
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolving launch templates of provisioner %s, %w", provisioners.Items[i].Name, err)
+		}
+		resolved.Insert(names.UnsortedList()...)
+	}
+	return resolved, nil
+}
+
+// pruneVersions deletes the superseded versions of a versioned launch template that were created outside the grace
+// period and that the provisioners don't resolve now. Hash-named launch templates only have a single version, which is
+// their default.
+func (c *Controller) pruneVersions(ctx context.Context, launchTemplate *ec2.LaunchTemplate, resolved sets.String) error {
+	if aws.Int64Value(launchTemplate.LatestVersionNumber) <= 1 {
+		return nil
+	}
+	versions, err := c.cloudProvider.LaunchTemplateProvider().ListVersions(ctx, launchTemplate)
+	if err != nil {
+		return err
+	}
+	versions = lo.Filter(versions, func(version *ec2.LaunchTemplateVersion, _ int) bool {
+		return c.clk.Since(aws.TimeValue(version.CreateTime)) >= awscache.LaunchTemplateGracePeriod
+	})
+	deleted, err := c.cloudProvider.LaunchTemplateProvider().DeleteUnusedVersions(ctx, launchTemplate, versions, resolved)
+	if deleted > 0 {
+		logging.FromContext(ctx).With(
+			"launch-template-name", aws.StringValue(launchTemplate.LaunchTemplateName),
+			"launch-template-id", aws.StringValue(launchTemplate.LaunchTemplateId),
+			"count", deleted).Debugf("deleted launch template versions")
+	}
+	return err
+}
+
+func (c *Controller) Name() string {
+	return "launchtemplate"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(m)
+}
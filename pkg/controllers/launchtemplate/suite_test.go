@@ -0,0 +1,245 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package launchtemplate_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/awstesting/mock"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/record"
+	clock "k8s.io/utils/clock/testing"
+	. "knative.dev/pkg/logging/testing"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	coresettings "github.com/aws/karpenter-core/pkg/apis/settings"
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecloudprovider "github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/events"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	coretest "github.com/aws/karpenter-core/pkg/test"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	awscontext "github.com/aws/karpenter/pkg/context"
+	"github.com/aws/karpenter/pkg/controllers/launchtemplate"
+	"github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/providers/placementscore"
+	"github.com/aws/karpenter/pkg/providers/quota"
+	"github.com/aws/karpenter/pkg/providers/securitygroup"
+	"github.com/aws/karpenter/pkg/providers/subnet"
+	"github.com/aws/karpenter/pkg/test"
+)
+
+var ctx context.Context
+var env *coretest.Environment
+var fakeEC2API *fake.EC2API
+var fakeClock *clock.FakeClock
+var cloudProvider *cloudprovider.CloudProvider
+var controller corecontroller.Controller
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LaunchTemplate")
+}
+
+var _ = BeforeSuite(func() {
+	ctx = coresettings.ToContext(ctx, coretest.Settings())
+	ctx = settings.ToContext(ctx, test.Settings())
+	env = coretest.NewEnvironment(scheme.Scheme, coretest.WithCRDs(apis.CRDs...))
+	fakeEC2API = &fake.EC2API{}
+	fakeClock = &clock.FakeClock{}
+	cloudProvider = cloudprovider.New(awscontext.Context{
+		Context: corecloudprovider.Context{
+			Context:             ctx,
+			RESTConfig:          env.Config,
+			KubernetesInterface: env.KubernetesInterface,
+			KubeClient:          env.Client,
+			EventRecorder:       events.NewRecorder(&record.FakeRecorder{}),
+			Clock:               fakeClock,
+			StartAsync:          nil,
+		},
+		Session:                   mock.Session,
+		UnavailableOfferingsCache: awscache.NewUnavailableOfferings(),
+		SpotInterruptionHistory:   awscache.NewSpotInterruptionHistory(),
+		MemoryOverhead:            awscache.NewMemoryOverhead(),
+		EC2API:                    fakeEC2API,
+		SubnetProvider:            subnet.NewProvider(fakeEC2API),
+		SecurityGroupProvider:     securitygroup.NewProvider(fakeEC2API),
+		QuotaProvider:             quota.NewProvider(ctx, &fake.ServiceQuotasAPI{}, fakeEC2API, make(chan struct{})),
+		PlacementScoreProvider:    placementscore.NewProvider(ctx, fakeEC2API, "", make(chan struct{})),
+	})
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = BeforeEach(func() {
+	ctx = settings.ToContext(ctx, test.Settings())
+	fakeEC2API.Reset()
+	fakeClock.SetTime(time.Now())
+	controller = launchtemplate.NewController(env.Client, fakeClock, cloudProvider)
+})
+
+var _ = AfterEach(func() {
+	ExpectCleanedUp(ctx, env.Client)
+})
+
+// launchTemplate creates a launch template that was created at the time for the owner, or without an owner if it's empty
+func launchTemplate(createTime time.Time, owner string) *ec2.LaunchTemplate {
+	launchTemplate := &ec2.LaunchTemplate{
+		LaunchTemplateId:   aws.String(fake.LaunchTemplateID()),
+		LaunchTemplateName: aws.String(coretest.RandomName()),
+		CreateTime:         aws.Time(createTime),
+	}
+	if owner != "" {
+		launchTemplate.Tags = []*ec2.Tag{{Key: aws.String("karpenter.k8s.aws/launch-template-owner"), Value: aws.String(owner)}}
+	}
+	fakeEC2API.LaunchTemplates.Store(aws.StringValue(launchTemplate.LaunchTemplateName), launchTemplate)
+	return launchTemplate
+}
+
+// launchTemplateNamed creates a launch template with the name that was created at the time for the owner
+func launchTemplateNamed(name string, createTime time.Time, owner string) *ec2.LaunchTemplate {
+	launchTemplate := launchTemplate(createTime, owner)
+	fakeEC2API.LaunchTemplates.Delete(aws.StringValue(launchTemplate.LaunchTemplateName))
+	launchTemplate.LaunchTemplateName = aws.String(name)
+	fakeEC2API.LaunchTemplates.Store(name, launchTemplate)
+	return launchTemplate
+}
+
+// resolvingProvisioner returns a provisioner and its node template, which resolve launch templates with the AMI of the
+// fake EC2 API
+func resolvingProvisioner() (*v1alpha1.AWSNodeTemplate, *v1alpha5.Provisioner) {
+	fakeEC2API.DescribeImagesOutput.Set(&ec2.DescribeImagesOutput{Images: []*ec2.Image{{
+		ImageId:      aws.String("ami-123"),
+		Architecture: aws.String("x86_64"),
+		CreationDate: aws.String("2022-08-15T12:00:00Z"),
+	}}})
+	nodeTemplate := test.AWSNodeTemplate(v1alpha1.AWSNodeTemplateSpec{
+		AWS: v1alpha1.AWS{
+			SubnetSelector:        map[string]string{"*": "*"},
+			SecurityGroupSelector: map[string]string{"*": "*"},
+		},
+		AMISelector: map[string]string{"*": "*"},
+	})
+	return nodeTemplate, test.Provisioner(coretest.ProvisionerOptions{ProviderRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name}})
+}
+
+func expectReconciled() reconcile.Result {
+	result, err := controller.Reconcile(ctx, reconcile.Request{})
+	Expect(err).ToNot(HaveOccurred())
+	return result
+}
+
+func deletedLaunchTemplateIDs() []string {
+	var ids []string
+	for fakeEC2API.DeleteLaunchTemplateBehavior.CalledWithInput.Len() > 0 {
+		ids = append(ids, aws.StringValue(fakeEC2API.DeleteLaunchTemplateBehavior.CalledWithInput.Pop().LaunchTemplateId))
+	}
+	return ids
+}
+
+var _ = Describe("LaunchTemplate", func() {
+	It("should not delete launch templates until the grace period has passed since election", func() {
+		launchTemplate(fakeClock.Now().Add(-time.Hour), "")
+
+		result := expectReconciled()
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		Expect(fakeEC2API.DeleteLaunchTemplateBehavior.Calls()).To(BeZero())
+	})
+	It("should delete the launch templates of node templates and provisioners that no longer exist after the grace period", func() {
+		nodeTemplateOwned := launchTemplate(fakeClock.Now().Add(-time.Hour), "awsnodetemplate/deleted")
+		provisionerOwned := launchTemplate(fakeClock.Now().Add(-time.Hour), "provisioner/deleted")
+		expectReconciled()
+
+		fakeClock.Step(time.Hour)
+		expectReconciled()
+		Expect(deletedLaunchTemplateIDs()).To(ConsistOf(aws.StringValue(nodeTemplateOwned.LaunchTemplateId), aws.StringValue(provisionerOwned.LaunchTemplateId)))
+		_, ok := fakeEC2API.LaunchTemplates.Load(aws.StringValue(nodeTemplateOwned.LaunchTemplateName))
+		Expect(ok).To(BeFalse())
+	})
+	It("should delete launch templates without an owner after the grace period", func() {
+		unowned := launchTemplate(fakeClock.Now().Add(-time.Hour), "")
+		expectReconciled()
+
+		fakeClock.Step(time.Hour)
+		expectReconciled()
+		Expect(deletedLaunchTemplateIDs()).To(ConsistOf(aws.StringValue(unowned.LaunchTemplateId)))
+	})
+	It("should not delete the launch templates that provisioners resolve", func() {
+		nodeTemplate, provisioner := resolvingProvisioner()
+		ExpectApplied(ctx, env.Client, nodeTemplate, provisioner)
+		resolved, err := cloudProvider.ResolveLaunchTemplates(ctx, provisioner)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolved).ToNot(BeEmpty())
+		for _, name := range resolved.UnsortedList() {
+			launchTemplateNamed(name, fakeClock.Now().Add(-time.Hour), fmt.Sprintf("awsnodetemplate/%s", nodeTemplate.Name))
+		}
+		expectReconciled()
+
+		fakeClock.Step(time.Hour)
+		expectReconciled()
+		Expect(fakeEC2API.DeleteLaunchTemplateBehavior.Calls()).To(BeZero())
+	})
+	It("should delete the superseded launch templates of node templates that exist", func() {
+		nodeTemplate, provisioner := resolvingProvisioner()
+		ExpectApplied(ctx, env.Client, nodeTemplate, provisioner)
+		resolved, err := cloudProvider.ResolveLaunchTemplates(ctx, provisioner)
+		Expect(err).ToNot(HaveOccurred())
+		for _, name := range resolved.UnsortedList() {
+			launchTemplateNamed(name, fakeClock.Now().Add(-time.Hour), fmt.Sprintf("awsnodetemplate/%s", nodeTemplate.Name))
+		}
+		superseded := launchTemplate(fakeClock.Now().Add(-time.Hour), fmt.Sprintf("awsnodetemplate/%s", nodeTemplate.Name))
+		expectReconciled()
+
+		fakeClock.Step(time.Hour)
+		expectReconciled()
+		Expect(deletedLaunchTemplateIDs()).To(ConsistOf(aws.StringValue(superseded.LaunchTemplateId)))
+	})
+	It("should not delete launch templates that were created within the grace period", func() {
+		expectReconciled()
+		fakeClock.Step(time.Hour)
+		launchTemplate(fakeClock.Now().Add(-time.Minute), "awsnodetemplate/deleted")
+
+		expectReconciled()
+		Expect(fakeEC2API.DeleteLaunchTemplateBehavior.Calls()).To(BeZero())
+	})
+	It("should delete the remaining launch templates when a deletion fails", func() {
+		launchTemplate(fakeClock.Now().Add(-time.Hour), "")
+		launchTemplate(fakeClock.Now().Add(-time.Hour), "")
+		expectReconciled()
+		fakeClock.Step(time.Hour)
+
+		fakeEC2API.DeleteLaunchTemplateBehavior.Error.Set(fmt.Errorf("failed"), fake.MaxCalls(1))
+		_, err := controller.Reconcile(ctx, reconcile.Request{})
+		Expect(err).To(HaveOccurred())
+		Expect(fakeEC2API.DeleteLaunchTemplateBehavior.Calls()).To(Equal(2))
+	})
+})
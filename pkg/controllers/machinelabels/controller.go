@@ -0,0 +1,83 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machinelabels
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samber/lo"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecloudprovider "github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/operator/controller"
+)
+
+// Controller tags the instances of machines whose labels changed with the tags of their node templates that are
+// propagated from their labels or rendered from them, which are otherwise only applied when instances are launched or
+// hydrated
+type Controller struct {
+	kubeClient    client.Client
+	cloudProvider *cloudprovider.CloudProvider
+}
+
+func NewController(kubeClient client.Client, cloudProvider *cloudprovider.CloudProvider) controller.Controller {
+	return controller.Typed[*v1alpha5.Machine](kubeClient, &Controller{
+		kubeClient:    kubeClient,
+		cloudProvider: cloudProvider,
+	})
+}
+
+func (c *Controller) Name() string {
+	return "machinelabels"
+}
+
+func (c *Controller) Reconcile(ctx context.Context, machine *v1alpha5.Machine) (reconcile.Result, error) {
+	if machine.Status.ProviderID == "" || !machine.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("machine", machine.Name, "provider-id", machine.Status.ProviderID))
+	nodeTemplate, err := cloudprovider.NodeTemplate(ctx, c.kubeClient, machine)
+	if err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(fmt.Errorf("resolving node template, %w", err))
+	}
+	// The tags of instances only depend on the labels of their machines if they're propagated or rendered from them
+	if len(nodeTemplate.Spec.PropagateLabelsAsTags) == 0 && !lo.SomeBy(lo.Values(lo.Assign(settings.FromContext(ctx).Tags, nodeTemplate.Spec.Tags)), v1alpha1.IsTagTemplate) {
+		return reconcile.Result{}, nil
+	}
+	if err := c.cloudProvider.UpdateInstanceTags(ctx, machine); err != nil {
+		return reconcile.Result{}, corecloudprovider.IgnoreMachineNotFoundError(fmt.Errorf("updating instance tags, %w", err))
+	}
+	return reconcile.Result{}, nil
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) controller.Builder {
+	return controller.Adapt(controllerruntime.
+		NewControllerManagedBy(m).
+		For(&v1alpha5.Machine{}).
+		WithEventFilter(predicate.LabelChangedPredicate{}).
+		WithOptions(ctrl.Options{MaxConcurrentReconciles: 10}))
+}
@@ -0,0 +1,177 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machinelabels_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/awstesting/mock"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	clock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	. "knative.dev/pkg/logging/testing"
+
+	"github.com/aws/karpenter-core/pkg/events"
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	awscontext "github.com/aws/karpenter/pkg/context"
+	"github.com/aws/karpenter/pkg/controllers/machinelabels"
+	"github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/test"
+
+	"github.com/aws/karpenter-core/pkg/apis"
+	coresettings "github.com/aws/karpenter-core/pkg/apis/settings"
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecloudprovider "github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	coretest "github.com/aws/karpenter-core/pkg/test"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+)
+
+var ctx context.Context
+var env *coretest.Environment
+var ec2API *fake.EC2API
+var cloudProvider *cloudprovider.CloudProvider
+var labelsController controller.Controller
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MachineLabels")
+}
+
+var _ = BeforeSuite(func() {
+	ctx = coresettings.ToContext(ctx, coretest.Settings())
+	ctx = settings.ToContext(ctx, test.Settings())
+	env = coretest.NewEnvironment(scheme.Scheme, coretest.WithCRDs(apis.CRDs...))
+	ec2API = &fake.EC2API{}
+	cloudProvider = cloudprovider.New(awscontext.Context{
+		Context: corecloudprovider.Context{
+			Context:             ctx,
+			RESTConfig:          env.Config,
+			KubernetesInterface: env.KubernetesInterface,
+			KubeClient:          env.Client,
+			EventRecorder:       events.NewRecorder(&record.FakeRecorder{}),
+			Clock:               &clock.FakeClock{},
+			StartAsync:          nil,
+		},
+		Session:                   mock.Session,
+		UnavailableOfferingsCache: awscache.NewUnavailableOfferings(),
+		EC2API:                    ec2API,
+	})
+	labelsController = machinelabels.NewController(env.Client, cloudProvider)
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("MachineLabels", func() {
+	var instanceID string
+	var nodeTemplate *v1alpha1.AWSNodeTemplate
+	var machine *v1alpha5.Machine
+	BeforeEach(func() {
+		ec2API.Reset()
+		instanceID = fake.InstanceID()
+		nodeTemplate = test.AWSNodeTemplate(v1alpha1.AWSNodeTemplateSpec{
+			AWS: v1alpha1.AWS{
+				PropagateLabelsAsTags: []string{"team"},
+			},
+		})
+		machine = coretest.Machine(v1alpha5.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"team": "platform"},
+			},
+			Spec: v1alpha5.MachineSpec{
+				MachineTemplateRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name},
+			},
+			Status: v1alpha5.MachineStatus{
+				ProviderID: fake.ProviderID(instanceID),
+			},
+		})
+		ec2API.Instances.Store(instanceID, &ec2.Instance{
+			State:          &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			PrivateDnsName: aws.String(fake.PrivateDNSName()),
+			InstanceId:     aws.String(instanceID),
+			Tags: []*ec2.Tag{
+				{Key: aws.String(v1alpha5.MachineNameLabelKey), Value: aws.String(machine.Name)},
+				{Key: aws.String(fmt.Sprintf("kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName)), Value: aws.String("owned")},
+				{Key: aws.String("team"), Value: aws.String("infra")},
+			},
+		})
+	})
+	AfterEach(func() {
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	It("should tag the instance with the current values of the propagated labels", func() {
+		ExpectApplied(ctx, env.Client, nodeTemplate, machine)
+		ExpectReconcileSucceeded(ctx, labelsController, client.ObjectKeyFromObject(machine))
+
+		Expect(ec2API.CreateTagsBehavior.SuccessfulCalls()).To(Equal(1))
+		Expect(ExpectInstanceTag(ec2API, instanceID, "team")).To(Equal("platform"))
+	})
+	It("should not tag the instance if it already has the propagated labels as tags", func() {
+		machine.Labels["team"] = "infra"
+		ExpectApplied(ctx, env.Client, nodeTemplate, machine)
+		ExpectReconcileSucceeded(ctx, labelsController, client.ObjectKeyFromObject(machine))
+
+		Expect(ec2API.CreateTagsBehavior.Calls()).To(Equal(0))
+	})
+	It("should not tag the instance if its node template doesn't propagate or render labels", func() {
+		nodeTemplate.Spec.PropagateLabelsAsTags = nil
+		ExpectApplied(ctx, env.Client, nodeTemplate, machine)
+		ExpectReconcileSucceeded(ctx, labelsController, client.ObjectKeyFromObject(machine))
+
+		Expect(ec2API.CreateTagsBehavior.Calls()).To(Equal(0))
+		Expect(ExpectInstanceTag(ec2API, instanceID, "team")).To(Equal("infra"))
+	})
+	It("should not tag the instance of a machine that hasn't launched", func() {
+		machine.Status.ProviderID = ""
+		ExpectApplied(ctx, env.Client, nodeTemplate, machine)
+		ExpectReconcileSucceeded(ctx, labelsController, client.ObjectKeyFromObject(machine))
+
+		Expect(ec2API.CreateTagsBehavior.Calls()).To(Equal(0))
+	})
+	It("should ignore machines whose instances no longer exist", func() {
+		ec2API.Instances.Delete(instanceID)
+		ExpectApplied(ctx, env.Client, nodeTemplate, machine)
+		ExpectReconcileSucceeded(ctx, labelsController, client.ObjectKeyFromObject(machine))
+
+		Expect(ec2API.CreateTagsBehavior.Calls()).To(Equal(0))
+	})
+})
+
+func ExpectInstanceTag(api *fake.EC2API, instanceID string, key string) string {
+	raw, ok := api.Instances.Load(instanceID)
+	Expect(ok).To(BeTrue())
+	tag, ok := lo.Find(raw.(*ec2.Instance).Tags, func(t *ec2.Tag) bool {
+		return aws.StringValue(t.Key) == key
+	})
+	Expect(ok).To(BeTrue())
+	return aws.StringValue(tag.Value)
+}
@@ -0,0 +1,206 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memoryoverhead
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/configmap"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap in the system namespace that stores the learned memory overhead
+	ConfigMapName = "karpenter-memory-overhead"
+	// entriesKey is the ConfigMap data key of the JSON encoded memory overhead, in MiB, of each instance type and AMI
+	// family
+	entriesKey = "entries"
+
+	pollingPeriod = time.Minute
+	// maxOverheadFraction is the largest fraction of the memory of an instance type that's accepted as its overhead, so
+	// that nodes whose memory capacity is misreported don't skew the learned overhead
+	maxOverheadFraction = 0.25
+)
+
+// Controller learns the VM memory overhead of instance types from the memory capacity of the registered nodes that
+// Karpenter launched, by instance type and the AMI family of their node templates. The overhead of each instance type
+// is the largest overhead of its current nodes, and the overhead of instance types without current nodes is kept, so
+// that it's persisted to a ConfigMap and restored when the controller starts.
+type Controller struct {
+	kubeClient          client.Client
+	kubernetesInterface kubernetes.Interface
+	memoryOverhead      *awscache.MemoryOverhead
+
+	restored        bool
+	persistedSeqNum uint64
+}
+
+func NewController(kubeClient client.Client, kubernetesInterface kubernetes.Interface, memoryOverhead *awscache.MemoryOverhead) corecontroller.Controller {
+	return &Controller{
+		kubeClient:          kubeClient,
+		kubernetesInterface: kubernetesInterface,
+		memoryOverhead:      memoryOverhead,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	if !c.restored {
+		if err := c.restore(ctx); err != nil {
+			// overhead that can't be restored is learned again from the current nodes
+			logging.FromContext(ctx).Errorf("restoring memory overhead, %s", err)
+		}
+		c.restored = true
+	}
+	observed, err := c.observe(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if c.memoryOverhead.Update(observed) {
+		logging.FromContext(ctx).With("instance-types", len(observed)).Debugf("learned memory overhead")
+	}
+	if err := c.persist(ctx); err != nil {
+		return reconcile.Result{}, fmt.Errorf("persisting memory overhead, %w", err)
+	}
+	return reconcile.Result{RequeueAfter: pollingPeriod}, nil
+}
+
+func (c *Controller) Name() string {
+	return "memoryoverhead"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(m)
+}
+
+// observe returns the largest memory overhead, in MiB, of the registered nodes of each instance type and AMI family
+func (c *Controller) observe(ctx context.Context) (map[string]int64, error) {
+	nodeList := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodeList, client.HasLabels{v1alpha5.ProvisionerNameLabelKey}); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	machineList := &v1alpha5.MachineList{}
+	if err := c.kubeClient.List(ctx, machineList); err != nil {
+		return nil, fmt.Errorf("listing machines, %w", err)
+	}
+	machines := lo.KeyBy(machineList.Items, func(machine v1alpha5.Machine) string { return machine.Status.ProviderID })
+	observed := map[string]int64{}
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		overhead, ok := memoryOverhead(node)
+		if !ok {
+			continue
+		}
+		// Nodes whose machines don't record their node template were launched with the node template of their provisioner
+		machine, ok := machines[node.Spec.ProviderID]
+		if !ok {
+			machine = v1alpha5.Machine{ObjectMeta: metav1.ObjectMeta{Labels: node.Labels}}
+		}
+		nodeTemplate, err := cloudprovider.NodeTemplate(ctx, c.kubeClient, &machine)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			logging.FromContext(ctx).With("node", node.Name).Errorf("resolving node template, %s", err)
+			continue
+		}
+		amiFamily, ok := cloudprovider.MemoryOverheadAMIFamily(nodeTemplate)
+		if !ok {
+			continue
+		}
+		key := awscache.MemoryOverheadKey(node.Labels[v1.LabelInstanceTypeStable], amiFamily)
+		if current, ok := observed[key]; !ok || overhead > current {
+			observed[key] = overhead
+		}
+	}
+	return observed, nil
+}
+
+// memoryOverhead returns the memory overhead, in MiB, of a registered node, which is the memory of its instance type
+// less its memory capacity
+func memoryOverhead(node *v1.Node) (int64, bool) {
+	if node.Spec.ProviderID == "" || node.Labels[v1.LabelInstanceTypeStable] == "" {
+		return 0, false
+	}
+	instanceMemory, err := strconv.ParseInt(node.Labels[v1alpha1.LabelInstanceMemory], 10, 64)
+	if err != nil || instanceMemory <= 0 {
+		return 0, false
+	}
+	capacity, ok := node.Status.Capacity[v1.ResourceMemory]
+	if !ok || capacity.IsZero() {
+		return 0, false
+	}
+	overhead := instanceMemory - capacity.Value()/1024/1024
+	if overhead < 0 || float64(overhead) > float64(instanceMemory)*maxOverheadFraction {
+		return 0, false
+	}
+	return overhead, true
+}
+
+func (c *Controller) restore(ctx context.Context) error {
+	cm, err := c.kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	entries := map[string]int64{}
+	if data, ok := cm.Data[entriesKey]; ok {
+		if err := json.Unmarshal([]byte(data), &entries); err != nil {
+			return fmt.Errorf("decoding %s, %w", entriesKey, err)
+		}
+	}
+	if c.memoryOverhead.Restore(entries) {
+		logging.FromContext(ctx).With("instance-types", len(entries)).Debugf("restored memory overhead")
+	}
+	return nil
+}
+
+func (c *Controller) persist(ctx context.Context) error {
+	seqNum := atomic.LoadUint64(&c.memoryOverhead.SeqNum)
+	if seqNum == c.persistedSeqNum {
+		return nil
+	}
+	data, err := json.Marshal(c.memoryOverhead.Entries())
+	if err != nil {
+		return fmt.Errorf("encoding %s, %w", entriesKey, err)
+	}
+	if err := configmap.Persist(ctx, c.kubernetesInterface, ConfigMapName, map[string]string{entriesKey: string(data)}, nil); err != nil {
+		return err
+	}
+	c.persistedSeqNum = seqNum
+	return nil
+}
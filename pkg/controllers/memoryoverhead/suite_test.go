@@ -0,0 +1,156 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memoryoverhead_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	coretest "github.com/aws/karpenter-core/pkg/test"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/controllers/memoryoverhead"
+	awsfake "github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/test"
+)
+
+var ctx context.Context
+var env *coretest.Environment
+var kubernetesInterface *fake.Clientset
+var memoryOverhead *awscache.MemoryOverhead
+var nodeTemplate *v1alpha1.AWSNodeTemplate
+var provisioner *v1alpha5.Provisioner
+var controller corecontroller.Controller
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MemoryOverhead")
+}
+
+var _ = BeforeSuite(func() {
+	env = coretest.NewEnvironment(scheme.Scheme, coretest.WithCRDs(apis.CRDs...))
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = BeforeEach(func() {
+	ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{LearnVMMemoryOverhead: lo.ToPtr(true)}))
+	kubernetesInterface = fake.NewSimpleClientset()
+	memoryOverhead = awscache.NewMemoryOverhead()
+	controller = memoryoverhead.NewController(env.Client, kubernetesInterface, memoryOverhead)
+	nodeTemplate = test.AWSNodeTemplate(v1alpha1.AWSNodeTemplateSpec{AWS: v1alpha1.AWS{AMIFamily: lo.ToPtr(v1alpha1.AMIFamilyBottlerocket)}})
+	provisioner = test.Provisioner(coretest.ProvisionerOptions{ProviderRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name}})
+	ExpectApplied(ctx, env.Client, nodeTemplate, provisioner)
+})
+
+var _ = AfterEach(func() {
+	ExpectCleanedUp(ctx, env.Client)
+})
+
+// node returns a registered m5.large node of the provisioner with the memory capacity
+func node(capacity string) *v1.Node {
+	n := coretest.Node(coretest.NodeOptions{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+			v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+			v1.LabelInstanceTypeStable:       "m5.large",
+			v1alpha1.LabelInstanceMemory:     "8192",
+		}},
+		ProviderID: awsfake.ProviderID(awsfake.InstanceID()),
+		Capacity:   v1.ResourceList{v1.ResourceMemory: resource.MustParse(capacity)},
+	})
+	ExpectApplied(ctx, env.Client, n)
+	return n
+}
+
+var _ = Describe("MemoryOverhead", func() {
+	It("should learn the largest memory overhead of the nodes of an instance type and AMI family", func() {
+		node("7800Mi")
+		node("7850Mi")
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		overhead, ok := memoryOverhead.Get("m5.large", v1alpha1.AMIFamilyBottlerocket)
+		Expect(ok).To(BeTrue())
+		Expect(overhead).To(BeNumerically("==", 392))
+		_, ok = memoryOverhead.Get("m5.large", v1alpha1.AMIFamilyAL2)
+		Expect(ok).To(BeFalse())
+	})
+	It("should learn the memory overhead of nodes with the node template of their machines", func() {
+		machineTemplate := test.AWSNodeTemplate(v1alpha1.AWSNodeTemplateSpec{AWS: v1alpha1.AWS{AMIFamily: lo.ToPtr(v1alpha1.AMIFamilyUbuntu)}})
+		ExpectApplied(ctx, env.Client, machineTemplate)
+		n := node("7800Mi")
+		ExpectApplied(ctx, env.Client, coretest.Machine(v1alpha5.Machine{
+			Spec:   v1alpha5.MachineSpec{MachineTemplateRef: &v1alpha5.ProviderRef{Name: machineTemplate.Name}},
+			Status: v1alpha5.MachineStatus{ProviderID: n.Spec.ProviderID},
+		}))
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		_, ok := memoryOverhead.Get("m5.large", v1alpha1.AMIFamilyUbuntu)
+		Expect(ok).To(BeTrue())
+		_, ok = memoryOverhead.Get("m5.large", v1alpha1.AMIFamilyBottlerocket)
+		Expect(ok).To(BeFalse())
+	})
+	It("should ignore memory capacity that's larger than the instance type's memory or implausibly small", func() {
+		node("8200Mi")
+		node("4Gi")
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(memoryOverhead.Entries()).To(BeEmpty())
+	})
+	It("should not learn the memory overhead of custom AMIs", func() {
+		nodeTemplate.Spec.AMIFamily = lo.ToPtr(v1alpha1.AMIFamilyCustom)
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		node("7800Mi")
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(memoryOverhead.Entries()).To(BeEmpty())
+	})
+	It("should persist and restore the learned memory overhead", func() {
+		node("7800Mi")
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		_, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, memoryoverhead.ConfigMapName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		// a restarted controller restores the overhead of instance types that no longer have nodes
+		ExpectCleanedUp(ctx, env.Client)
+		ExpectApplied(ctx, env.Client, nodeTemplate, provisioner)
+		restored := awscache.NewMemoryOverhead()
+		ExpectReconcileSucceeded(ctx, memoryoverhead.NewController(env.Client, kubernetesInterface, restored), client.ObjectKey{})
+		overhead, ok := restored.Get("m5.large", v1alpha1.AMIFamilyBottlerocket)
+		Expect(ok).To(BeTrue())
+		Expect(overhead).To(BeNumerically("==", 392))
+	})
+})
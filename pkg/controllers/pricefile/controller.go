@@ -0,0 +1,143 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pricefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/utils/pretty"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap in the system namespace that the price file is read from when no
+	// price file path is configured
+	ConfigMapName = "karpenter-pricing"
+	// pricesKey is the ConfigMap data key of the price file
+	pricesKey = "prices.json"
+
+	syncPeriod = time.Minute
+	// maxLoggedMissing is the maximum number of instance types without prices that are logged
+	maxLoggedMissing = 20
+)
+
+// Controller loads the prices of a price file into the pricing provider in isolated VPCs, where prices can't be
+// retrieved from the pricing endpoints. The price file is read from the configured path, which is usually a mounted
+// ConfigMap, or from the karpenter-pricing ConfigMap, and is reloaded when it changes. Invalid price files are
+// rejected, and the previous prices are kept until they're corrected.
+type Controller struct {
+	kubernetesInterface kubernetes.Interface
+	ec2api              ec2iface.EC2API
+	pricingProvider     *cloudprovider.PricingProvider
+	cm                  *pretty.ChangeMonitor
+}
+
+func NewController(kubernetesInterface kubernetes.Interface, ec2api ec2iface.EC2API, pricingProvider *cloudprovider.PricingProvider) corecontroller.Controller {
+	return &Controller{
+		kubernetesInterface: kubernetesInterface,
+		ec2api:              ec2api,
+		pricingProvider:     pricingProvider,
+		cm:                  pretty.NewChangeMonitor(),
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	data, err := c.read(ctx)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("reading price file, %w", err)
+	}
+	if data == nil {
+		return reconcile.Result{RequeueAfter: syncPeriod}, nil
+	}
+	f, err := cloudprovider.ParsePriceFile(data)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := c.pricingProvider.SetPriceFile(ctx, f); err != nil {
+		return reconcile.Result{}, err
+	}
+	if c.cm.HasChanged("price-file", f) {
+		if err := c.validateCoverage(ctx, f); err != nil {
+			logging.FromContext(ctx).Errorf("validating price file coverage, %s", err)
+		}
+	}
+	return reconcile.Result{RequeueAfter: syncPeriod}, nil
+}
+
+func (c *Controller) Name() string {
+	return "pricefile"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(m)
+}
+
+// read returns the contents of the price file, or nil if there is no price file
+func (c *Controller) read(ctx context.Context) ([]byte, error) {
+	if path := settings.FromContext(ctx).PricingFile; path != "" {
+		return os.ReadFile(path)
+	}
+	cm, err := c.kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cm.Data[pricesKey]
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s", ConfigMapName, pricesKey)
+	}
+	return []byte(data), nil
+}
+
+// validateCoverage warns about the instance types of the region that have no prices in the price file, since their
+// offerings are unavailable
+func (c *Controller) validateCoverage(ctx context.Context, f *cloudprovider.PriceFile) error {
+	var instanceTypes []string
+	if err := c.ec2api.DescribeInstanceTypesPagesWithContext(ctx, &ec2.DescribeInstanceTypesInput{}, func(page *ec2.DescribeInstanceTypesOutput, _ bool) bool {
+		for _, instanceType := range page.InstanceTypes {
+			instanceTypes = append(instanceTypes, aws.StringValue(instanceType.InstanceType))
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("describing instance types, %w", err)
+	}
+	if missing := f.Missing(instanceTypes); len(missing) > 0 {
+		logging.FromContext(ctx).With(
+			"missing-count", len(missing),
+			"instance-type-count", len(instanceTypes),
+			"missing", lo.Slice(missing, 0, maxLoggedMissing)).Warnf("price file has no prices for some instance types")
+	}
+	return nil
+}
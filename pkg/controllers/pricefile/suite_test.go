@@ -0,0 +1,160 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pricefile_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/pricefile"
+	awsfake "github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/test"
+)
+
+var ctx context.Context
+var kubernetesInterface *fake.Clientset
+var pricingProvider *cloudprovider.PricingProvider
+var controller corecontroller.Controller
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PriceFile")
+}
+
+var _ = BeforeEach(func() {
+	ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{IsolatedVPC: lo.ToPtr(true)}))
+	kubernetesInterface = fake.NewSimpleClientset()
+	pricingProvider = cloudprovider.NewPricingProvider(ctx, &awsfake.PricingAPI{}, &awsfake.EC2API{}, "test-region", true, cloudprovider.PricingOptions{}, make(chan struct{}))
+	controller = pricefile.NewController(kubernetesInterface, &awsfake.EC2API{}, pricingProvider)
+})
+
+func expectPriceFile(prices string) {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: pricefile.ConfigMapName, Namespace: system.Namespace()},
+		Data:       map[string]string{"prices.json": prices},
+	}
+	if _, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		_, err = kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Create(ctx, cm, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+	}
+}
+
+func expectReconciled() {
+	_, err := controller.Reconcile(ctx, reconcile.Request{})
+	Expect(err).ToNot(HaveOccurred())
+}
+
+func expectOnDemandPrice(instanceType string, expected float64) {
+	price, ok := pricingProvider.OnDemandPrice(instanceType)
+	Expect(ok).To(BeTrue())
+	Expect(price).To(Equal(expected))
+}
+
+const prices = `{
+  "region": "test-region",
+  "generatedAt": "2023-02-01T00:00:00Z",
+  "onDemand": {"m5.large": 0.5, "c5.large": 0.4},
+  "spot": {"m5.large": {"test-zone-1a": 0.2, "test-zone-1b": 0.25}}
+}`
+
+var _ = Describe("PriceFile", func() {
+	It("should load the prices of the ConfigMap", func() {
+		expectPriceFile(prices)
+		expectReconciled()
+
+		expectOnDemandPrice("m5.large", 0.5)
+		expectOnDemandPrice("c5.large", 0.4)
+		price, ok := pricingProvider.SpotPrice("m5.large", "test-zone-1b")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(Equal(0.25))
+		_, ok = pricingProvider.SpotPrice("m5.large", "test-zone-1c")
+		Expect(ok).To(BeFalse())
+		_, ok = pricingProvider.SpotPrice("c5.large", "test-zone-1a")
+		Expect(ok).To(BeFalse())
+		_, ok = pricingProvider.OnDemandPrice("m5.xlarge")
+		Expect(ok).To(BeFalse())
+		Expect(pricingProvider.OnDemandLastUpdated()).To(Equal(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)))
+		Expect(pricingProvider.SpotLastUpdated()).To(Equal(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)))
+		Expect(pricingProvider.Source(v1alpha5.CapacityTypeOnDemand)).To(Equal(cloudprovider.PricingSourceFile))
+		Expect(pricingProvider.Source(v1alpha5.CapacityTypeSpot)).To(Equal(cloudprovider.PricingSourceFile))
+	})
+	It("should price spot offerings at their on-demand prices if there are no spot prices", func() {
+		expectPriceFile(`{"region": "test-region", "generatedAt": "2023-02-01T00:00:00Z", "onDemand": {"m5.large": 0.5}}`)
+		expectReconciled()
+
+		price, ok := pricingProvider.SpotPrice("m5.large", "test-zone-1a")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(Equal(0.5))
+	})
+	It("should load and reload the prices of the price file path", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "prices.json")
+		ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{IsolatedVPC: lo.ToPtr(true), PricingFile: lo.ToPtr(path)}))
+		Expect(os.WriteFile(path, []byte(prices), 0600)).To(Succeed())
+		expectReconciled()
+		expectOnDemandPrice("m5.large", 0.5)
+
+		Expect(os.WriteFile(path, []byte(`{"region": "test-region", "generatedAt": "2023-03-01T00:00:00Z", "onDemand": {"m5.large": 0.6}}`), 0600)).To(Succeed())
+		seqNum := pricingProvider.SeqNum
+		expectReconciled()
+		expectOnDemandPrice("m5.large", 0.6)
+		Expect(pricingProvider.SeqNum).To(BeNumerically(">", seqNum))
+	})
+	It("should keep the previous prices if the price file is invalid", func() {
+		expectPriceFile(prices)
+		expectReconciled()
+
+		for _, invalid := range []string{
+			`{"region": "test-region", "generatedAt": "2023-02-01T00:00:00Z", "onDemand": {}}`,
+			`{"region": "test-region", "onDemand": {"m5.large": 0.6}}`,
+			`{"region": "test-region", "generatedAt": "2023-02-01T00:00:00Z", "onDemand": {"m5.large": -1}}`,
+			`{"region": "test-region", "generatedAt": "2023-02-01T00:00:00Z", "onDemand": {"m5.large": 0.6}, "spot": {"m5.large": {"test-zone-1a": -1}}}`,
+			`{"region": "test-region", "generatedAt": "2023-02-01T00:00:00Z", "onDemandPrices": {"m5.large": 0.6}}`,
+			`{"region": "other-region", "generatedAt": "2023-02-01T00:00:00Z", "onDemand": {"m5.large": 0.6}}`,
+		} {
+			expectPriceFile(invalid)
+			_, err := controller.Reconcile(ctx, reconcile.Request{})
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+		expectOnDemandPrice("m5.large", 0.5)
+	})
+	It("should keep the compiled in prices if there is no price file", func() {
+		expectReconciled()
+		price, ok := pricingProvider.OnDemandPrice("m5.xlarge")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(BeNumerically(">", 0))
+		Expect(pricingProvider.Source(v1alpha5.CapacityTypeOnDemand)).To(Equal(cloudprovider.PricingSourceStatic))
+	})
+})
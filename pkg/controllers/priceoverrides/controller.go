@@ -0,0 +1,81 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priceoverrides
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/system"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap in the system namespace that overrides the prices of offerings
+	ConfigMapName = "karpenter-price-overrides"
+	// overridesKey is the ConfigMap data key of the YAML or JSON list of price overrides
+	overridesKey = "overrides"
+
+	syncPeriod = 30 * time.Second
+)
+
+// Controller loads the price overrides of the ConfigMap into the pricing provider, so that instance types are launched
+// and consolidated by their effective prices, such as the prices of instance families that are discounted by Savings
+// Plans. Invalid price overrides are rejected, and the previous price overrides are kept until they're corrected.
+type Controller struct {
+	kubernetesInterface kubernetes.Interface
+	pricingProvider     *cloudprovider.PricingProvider
+}
+
+func NewController(kubernetesInterface kubernetes.Interface, pricingProvider *cloudprovider.PricingProvider) corecontroller.Controller {
+	return &Controller{
+		kubernetesInterface: kubernetesInterface,
+		pricingProvider:     pricingProvider,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	cm, err := c.kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		c.pricingProvider.SetPriceOverrides(ctx, nil)
+		return reconcile.Result{RequeueAfter: syncPeriod}, nil
+	}
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("getting price overrides, %w", err)
+	}
+	overrides, err := cloudprovider.ParsePriceOverrides(cm.Data[overridesKey])
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("parsing %s, %w", overridesKey, err)
+	}
+	c.pricingProvider.SetPriceOverrides(ctx, overrides)
+	return reconcile.Result{RequeueAfter: syncPeriod}, nil
+}
+
+func (c *Controller) Name() string {
+	return "priceoverrides"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(m)
+}
@@ -0,0 +1,159 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priceoverrides_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/priceoverrides"
+	awsfake "github.com/aws/karpenter/pkg/fake"
+)
+
+var ctx context.Context
+var kubernetesInterface *fake.Clientset
+var pricingProvider *cloudprovider.PricingProvider
+var controller corecontroller.Controller
+var listPrice float64
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PriceOverrides")
+}
+
+var _ = BeforeEach(func() {
+	kubernetesInterface = fake.NewSimpleClientset()
+	// Pricing isn't updated in isolated VPCs, so spot offerings are priced at the initial on-demand prices
+	pricingProvider = cloudprovider.NewPricingProvider(ctx, &awsfake.PricingAPI{}, &awsfake.EC2API{}, "test-region", true, cloudprovider.PricingOptions{}, make(chan struct{}))
+	controller = priceoverrides.NewController(kubernetesInterface, pricingProvider)
+	var ok bool
+	listPrice, ok = pricingProvider.OnDemandListPrice("m5.large")
+	Expect(ok).To(BeTrue())
+})
+
+func expectOverrides(overrides string) {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: priceoverrides.ConfigMapName, Namespace: system.Namespace()},
+		Data:       map[string]string{"overrides": overrides},
+	}
+	if _, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		_, err = kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Create(ctx, cm, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+	}
+}
+
+func expectReconciled() {
+	_, err := controller.Reconcile(ctx, reconcile.Request{})
+	Expect(err).ToNot(HaveOccurred())
+}
+
+func expectPrices(onDemand float64, spot float64) {
+	price, ok := pricingProvider.OnDemandPrice("m5.large")
+	Expect(ok).To(BeTrue())
+	Expect(price).To(BeNumerically("~", onDemand, 1e-9))
+	price, ok = pricingProvider.SpotPrice("m5.large", "test-zone-1a")
+	Expect(ok).To(BeTrue())
+	Expect(price).To(BeNumerically("~", spot, 1e-9))
+}
+
+var _ = Describe("PriceOverrides", func() {
+	It("should discount the offerings of instance families", func() {
+		expectOverrides(`[{"instanceFamily": "m5", "discount": 0.4}]`)
+		expectReconciled()
+		expectPrices(listPrice*0.6, listPrice*0.6)
+
+		price, ok := pricingProvider.OnDemandPrice("c5.large")
+		Expect(ok).To(BeTrue())
+		otherListPrice, ok := pricingProvider.OnDemandListPrice("c5.large")
+		Expect(ok).To(BeTrue())
+		Expect(price).To(Equal(otherListPrice))
+	})
+	It("should prefer the most specific price override", func() {
+		expectOverrides(`
+- instanceFamily: m5
+  discount: 0.4
+- instanceType: m5.large
+  capacityType: on-demand
+  price: 0.01
+- discount: 0.1
+`)
+		expectReconciled()
+		expectPrices(0.01, listPrice*0.6)
+	})
+	It("should only override the prices of the region", func() {
+		expectOverrides(`[{"region": "other-region", "discount": 0.4}, {"region": "test-region", "capacityType": "spot", "discount": 0.5}]`)
+		expectReconciled()
+		expectPrices(listPrice, listPrice*0.5)
+	})
+	It("should keep the previous price overrides if the price overrides are invalid", func() {
+		expectOverrides(`[{"instanceFamily": "m5", "discount": 0.4}]`)
+		expectReconciled()
+
+		for _, invalid := range []string{
+			`[{"instanceFamily": "m5"}]`,
+			`[{"instanceFamily": "m5", "discount": 0.4, "price": 0.01}]`,
+			`[{"instanceFamily": "m5", "discount": 1.5}]`,
+			`[{"instanceFamily": "m5", "price": -1}]`,
+			`[{"capacityType": "reserved", "discount": 0.4}]`,
+			`[{"instanceFamilies": ["m5"], "discount": 0.4}]`,
+		} {
+			expectOverrides(invalid)
+			_, err := controller.Reconcile(ctx, reconcile.Request{})
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+		expectPrices(listPrice*0.6, listPrice*0.6)
+	})
+	It("should remove the price overrides when the ConfigMap is deleted", func() {
+		expectOverrides(`[{"instanceFamily": "m5", "discount": 0.4}]`)
+		expectReconciled()
+		Expect(kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Delete(ctx, priceoverrides.ConfigMapName, metav1.DeleteOptions{})).To(Succeed())
+
+		expectReconciled()
+		expectPrices(listPrice, listPrice)
+	})
+	It("should report the list and effective prices of the offerings whose prices are overridden", func() {
+		expectOverrides(`[{"instanceType": "m5.large", "discount": 0.4}]`)
+		expectReconciled()
+
+		metric, ok := FindMetricWithLabelValues("karpenter_cloudprovider_offering_price", map[string]string{
+			"instance_type": "m5.large", "capacity_type": "on-demand", "zone": "", "price": "list",
+		})
+		Expect(ok).To(BeTrue())
+		Expect(metric.GetGauge().GetValue()).To(Equal(listPrice))
+		metric, ok = FindMetricWithLabelValues("karpenter_cloudprovider_offering_price", map[string]string{
+			"instance_type": "m5.large", "capacity_type": "on-demand", "zone": "", "price": "effective",
+		})
+		Expect(ok).To(BeTrue())
+		Expect(metric.GetGauge().GetValue()).To(BeNumerically("~", listPrice*0.6, 1e-9))
+		_, ok = FindMetricWithLabelValues("karpenter_cloudprovider_offering_price", map[string]string{"instance_type": "c5.large"})
+		Expect(ok).To(BeFalse())
+	})
+})
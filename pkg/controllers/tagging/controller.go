@@ -0,0 +1,285 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tagging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils"
+)
+
+const (
+	pollingPeriod = 5 * time.Minute
+	// maxFilterValues is the maximum number of values of a filter of a describe request
+	maxFilterValues = 200
+	// maxTaggedResources is the maximum number of resources of a CreateTags or DeleteTags request
+	maxTaggedResources = 1000
+)
+
+// Controller reconciles the tags of the instances of machines, and of the volumes and network interfaces that are
+// deleted with them, with the tags of their node templates and the global tags, which are otherwise only applied when
+// instances are launched or hydrated. Tags with reserved keys are never changed, and only the tags that were previously
+// reconciled for a machine are deleted, so that the tags that other tools apply to the resources are kept.
+type Controller struct {
+	kubeClient client.Client
+	ec2api     ec2iface.EC2API
+}
+
+func NewController(kubeClient client.Client, ec2api ec2iface.EC2API) corecontroller.Controller {
+	return &Controller{
+		kubeClient: kubeClient,
+		ec2api:     ec2api,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	machineList := &v1alpha5.MachineList{}
+	if err := c.kubeClient.List(ctx, machineList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing machines, %w", err)
+	}
+	machines := map[string]*v1alpha5.Machine{}
+	for i := range machineList.Items {
+		if id, err := utils.ParseInstanceID(machineList.Items[i].Status.ProviderID); err == nil && machineList.Items[i].DeletionTimestamp.IsZero() {
+			machines[id] = &machineList.Items[i]
+		}
+	}
+	instances, err := c.describeInstances(ctx, lo.Keys(machines))
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	attachedTags, err := c.describeTags(ctx, lo.FlatMap(instances, func(instance *ec2.Instance, _ int) []string { return attachedResourceIDs(instance) }))
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	created := tagBatches{}
+	deleted := tagBatches{}
+	taggedKeys := map[*v1alpha5.Machine]string{}
+	for _, instance := range instances {
+		machine := machines[aws.StringValue(instance.InstanceId)]
+		desired, err := c.desiredTags(ctx, machine, instance)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			logging.FromContext(ctx).With("machine", machine.Name).Errorf("resolving tags, %s", err)
+			continue
+		}
+		previous := sets.NewString(strings.Split(machine.Annotations[v1alpha1.TaggedKeysAnnotationKey], ",")...)
+		resources := lo.Assign(map[string]map[string]string{aws.StringValue(instance.InstanceId): tagMap(instance.Tags)},
+			lo.PickByKeys(attachedTags, attachedResourceIDs(instance)))
+		for id, actual := range resources {
+			created.add(id, lo.MapToSlice(lo.OmitBy(desired, func(key string, value string) bool {
+				current, ok := actual[key]
+				return ok && current == value
+			}), func(key string, value string) *ec2.Tag {
+				return &ec2.Tag{Key: aws.String(key), Value: aws.String(value)}
+			}))
+			// Tags are deleted by their keys alone, as an empty value only deletes tags with empty values
+			deleted.add(id, lo.FilterMap(lo.Keys(actual), func(key string, _ int) (*ec2.Tag, bool) {
+				_, ok := desired[key]
+				return &ec2.Tag{Key: aws.String(key)}, previous.Has(key) && !ok && !v1alpha1.IsReservedTagKey(key)
+			}))
+		}
+		taggedKeys[machine] = strings.Join(sets.NewString(lo.Keys(desired)...).List(), ",")
+	}
+	if err = multierr.Combine(c.createTags(ctx, created), c.deleteTags(ctx, deleted)); err != nil {
+		return reconcile.Result{}, err
+	}
+	// Machines are annotated with the keys of their tags once the tags were applied, so that tags that failed to be
+	// deleted are deleted when the reconciliation is retried
+	for machine, keys := range taggedKeys {
+		if err = c.annotate(ctx, machine, keys); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	return reconcile.Result{RequeueAfter: pollingPeriod}, nil
+}
+
+func (c *Controller) Name() string {
+	return "tagging"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(m)
+}
+
+// desiredTags returns the tags that the resources of the machine's instance are expected to have, without the tags
+// with reserved keys
+func (c *Controller) desiredTags(ctx context.Context, machine *v1alpha5.Machine, instance *ec2.Instance) (map[string]string, error) {
+	nodeTemplate, err := cloudprovider.NodeTemplate(ctx, c.kubeClient, machine)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := cloudprovider.InstanceTags(ctx, nodeTemplate, machine, instance)
+	if err != nil {
+		return nil, err
+	}
+	return lo.OmitBy(tags, func(key string, _ string) bool { return v1alpha1.IsReservedTagKey(key) }), nil
+}
+
+func (c *Controller) annotate(ctx context.Context, machine *v1alpha5.Machine, keys string) error {
+	if machine.Annotations[v1alpha1.TaggedKeysAnnotationKey] == keys {
+		return nil
+	}
+	stored := machine.DeepCopy()
+	machine.Annotations = lo.Assign(machine.Annotations, map[string]string{v1alpha1.TaggedKeysAnnotationKey: keys})
+	if err := c.kubeClient.Patch(ctx, machine, client.MergeFrom(stored)); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("annotating machine %s, %w", machine.Name, err)
+	}
+	return nil
+}
+
+func (c *Controller) createTags(ctx context.Context, batches tagBatches) (errs error) {
+	for _, batch := range batches {
+		for _, resources := range lo.Chunk(batch.resources, maxTaggedResources) {
+			if _, err := c.ec2api.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{Resources: aws.StringSlice(resources), Tags: batch.tags}); err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("creating tags, %w", err))
+				continue
+			}
+			logging.FromContext(ctx).With("resources", resources, "tags", batch.keys()).Debugf("created tags")
+		}
+	}
+	return errs
+}
+
+func (c *Controller) deleteTags(ctx context.Context, batches tagBatches) (errs error) {
+	for _, batch := range batches {
+		for _, resources := range lo.Chunk(batch.resources, maxTaggedResources) {
+			if _, err := c.ec2api.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{Resources: aws.StringSlice(resources), Tags: batch.tags}); err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("deleting tags, %w", err))
+				continue
+			}
+			logging.FromContext(ctx).With("resources", resources, "tags", batch.keys()).Debugf("deleted tags")
+		}
+	}
+	return errs
+}
+
+// describeInstances returns the instances with the ids. The ids are filtered rather than requested, as a request for
+// an instance that no longer exists would fail for all of the instances.
+func (c *Controller) describeInstances(ctx context.Context, ids []string) ([]*ec2.Instance, error) {
+	var instances []*ec2.Instance
+	for _, chunk := range lo.Chunk(ids, maxFilterValues) {
+		if err := c.ec2api.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("instance-id"),
+					Values: aws.StringSlice(chunk),
+				},
+				{
+					Name:   aws.String("instance-state-name"),
+					Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped}),
+				},
+			},
+		}, func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+			for _, reservation := range page.Reservations {
+				instances = append(instances, reservation.Instances...)
+			}
+			return true
+		}); err != nil {
+			return nil, fmt.Errorf("describing instances, %w", err)
+		}
+	}
+	return instances, nil
+}
+
+// describeTags returns the tags of the resources with the ids, by their ids
+func (c *Controller) describeTags(ctx context.Context, ids []string) (map[string]map[string]string, error) {
+	tags := lo.SliceToMap(ids, func(id string) (string, map[string]string) { return id, map[string]string{} })
+	for _, chunk := range lo.Chunk(ids, maxFilterValues) {
+		if err := c.ec2api.DescribeTagsPagesWithContext(ctx, &ec2.DescribeTagsInput{
+			Filters: []*ec2.Filter{{Name: aws.String("resource-id"), Values: aws.StringSlice(chunk)}},
+		}, func(page *ec2.DescribeTagsOutput, _ bool) bool {
+			for _, tag := range page.Tags {
+				if resourceTags, ok := tags[aws.StringValue(tag.ResourceId)]; ok {
+					resourceTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
+			}
+			return true
+		}); err != nil {
+			return nil, fmt.Errorf("describing tags, %w", err)
+		}
+	}
+	return tags, nil
+}
+
+// attachedResourceIDs returns the ids of the volumes and network interfaces that are deleted with the instance. Those
+// that outlive the instance, such as the volumes of persistent volumes, don't belong to its machine and aren't tagged.
+func attachedResourceIDs(instance *ec2.Instance) []string {
+	var ids []string
+	for _, mapping := range instance.BlockDeviceMappings {
+		if mapping.Ebs != nil && aws.BoolValue(mapping.Ebs.DeleteOnTermination) {
+			ids = append(ids, aws.StringValue(mapping.Ebs.VolumeId))
+		}
+	}
+	for _, networkInterface := range instance.NetworkInterfaces {
+		if networkInterface.Attachment != nil && aws.BoolValue(networkInterface.Attachment.DeleteOnTermination) {
+			ids = append(ids, aws.StringValue(networkInterface.NetworkInterfaceId))
+		}
+	}
+	return ids
+}
+
+func tagMap(tags []*ec2.Tag) map[string]string {
+	return lo.SliceToMap(tags, func(tag *ec2.Tag) (string, string) { return aws.StringValue(tag.Key), aws.StringValue(tag.Value) })
+}
+
+// tagBatch is the resources that a request applies the same tags to
+type tagBatch struct {
+	tags      []*ec2.Tag
+	resources []string
+}
+
+func (b *tagBatch) keys() []string {
+	return lo.Map(b.tags, func(tag *ec2.Tag, _ int) string { return aws.StringValue(tag.Key) })
+}
+
+// tagBatches are the batches of resources by their tags, so that the resources that need the same tags, such as all
+// of the resources of a node template whose tags changed, are tagged with a single request
+type tagBatches map[string]*tagBatch
+
+func (b tagBatches) add(id string, tags []*ec2.Tag) {
+	if len(tags) == 0 {
+		return
+	}
+	sort.Slice(tags, func(i, j int) bool { return aws.StringValue(tags[i].Key) < aws.StringValue(tags[j].Key) })
+	key := string(lo.Must(json.Marshal(tags)))
+	if _, ok := b[key]; !ok {
+		b[key] = &tagBatch{tags: tags}
+	}
+	b[key].resources = append(b[key].resources, id)
+}
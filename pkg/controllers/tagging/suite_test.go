@@ -0,0 +1,196 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tagging_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	coretest "github.com/aws/karpenter-core/pkg/test"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/controllers/tagging"
+	"github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/test"
+)
+
+var ctx context.Context
+var env *coretest.Environment
+var fakeEC2API *fake.EC2API
+var nodeTemplate *v1alpha1.AWSNodeTemplate
+var controller corecontroller.Controller
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tagging")
+}
+
+var _ = BeforeSuite(func() {
+	env = coretest.NewEnvironment(scheme.Scheme, coretest.WithCRDs(apis.CRDs...))
+	fakeEC2API = &fake.EC2API{}
+	controller = tagging.NewController(env.Client, fakeEC2API)
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = BeforeEach(func() {
+	ctx = settings.ToContext(ctx, test.Settings())
+	fakeEC2API.Reset()
+	nodeTemplate = test.AWSNodeTemplate(v1alpha1.AWSNodeTemplateSpec{
+		AWS: v1alpha1.AWS{
+			SubnetSelector:        map[string]string{"*": "*"},
+			SecurityGroupSelector: map[string]string{"*": "*"},
+			Tags:                  map[string]string{"team": "a"},
+		},
+	})
+	ExpectApplied(ctx, env.Client, nodeTemplate)
+})
+
+var _ = AfterEach(func() {
+	ExpectCleanedUp(ctx, env.Client)
+})
+
+// machineWithInstance returns a machine of the node template and its instance, which has a root volume and a network
+// interface that are deleted with it, and a volume of a persistent volume that isn't
+func machineWithInstance(tags ...*ec2.Tag) (*v1alpha5.Machine, *ec2.Instance) {
+	instance := &ec2.Instance{
+		InstanceId:   aws.String(fake.InstanceID()),
+		InstanceType: aws.String("m5.large"),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
+		State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		Tags:         tags,
+		BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-" + coretest.RandomName()), DeleteOnTermination: aws.Bool(true)}},
+			{DeviceName: aws.String("/dev/xvdba"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-" + coretest.RandomName()), DeleteOnTermination: aws.Bool(false)}},
+		},
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{NetworkInterfaceId: aws.String("eni-" + coretest.RandomName()), Attachment: &ec2.InstanceNetworkInterfaceAttachment{DeleteOnTermination: aws.Bool(true)}},
+		},
+	}
+	fakeEC2API.Instances.Store(aws.StringValue(instance.InstanceId), instance)
+	machine := coretest.Machine(v1alpha5.Machine{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: "default"}},
+		Spec:       v1alpha5.MachineSpec{MachineTemplateRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name}},
+		Status:     v1alpha5.MachineStatus{ProviderID: fake.ProviderID(aws.StringValue(instance.InstanceId))},
+	})
+	ExpectApplied(ctx, env.Client, machine)
+	return machine, instance
+}
+
+func tagsOf(id string) map[string]string {
+	var tags []*ec2.Tag
+	if raw, ok := fakeEC2API.Instances.Load(id); ok {
+		tags = raw.(*ec2.Instance).Tags
+	}
+	if raw, ok := fakeEC2API.ResourceTags.Load(id); ok {
+		tags = raw.([]*ec2.Tag)
+	}
+	return lo.SliceToMap(tags, func(tag *ec2.Tag) (string, string) { return aws.StringValue(tag.Key), aws.StringValue(tag.Value) })
+}
+
+var _ = Describe("Tagging", func() {
+	It("should tag instances and the volumes and network interfaces that are deleted with them", func() {
+		_, instance := machineWithInstance()
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).To(HaveKeyWithValue("team", "a"))
+		Expect(tagsOf(aws.StringValue(instance.BlockDeviceMappings[0].Ebs.VolumeId))).To(HaveKeyWithValue("team", "a"))
+		Expect(tagsOf(aws.StringValue(instance.NetworkInterfaces[0].NetworkInterfaceId))).To(HaveKeyWithValue("team", "a"))
+		Expect(tagsOf(aws.StringValue(instance.BlockDeviceMappings[1].Ebs.VolumeId))).To(BeEmpty())
+	})
+	It("should tag the resources that need the same tags with a single request", func() {
+		machineWithInstance()
+		machineWithInstance()
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.CreateTagsBehavior.Calls()).To(Equal(1))
+		Expect(fakeEC2API.CreateTagsBehavior.CalledWithInput.Pop().Resources).To(HaveLen(6))
+	})
+	It("should update tags whose values changed", func() {
+		_, instance := machineWithInstance(&ec2.Tag{Key: aws.String("team"), Value: aws.String("b")})
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).To(HaveKeyWithValue("team", "a"))
+
+		ctx = settings.ToContext(ctx, test.Settings(test.SettingOptions{Tags: map[string]string{"cost-center": "{{ .Zone }}"}}))
+		nodeTemplate.Spec.Tags["team"] = "c"
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).To(HaveKeyWithValue("team", "c"))
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).To(HaveKeyWithValue("cost-center", "test-zone-1a"))
+	})
+	It("should delete tags that were reconciled and removed from the node template", func() {
+		machine, instance := machineWithInstance()
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(ExpectExists(ctx, env.Client, machine).Annotations).To(HaveKey(v1alpha1.TaggedKeysAnnotationKey))
+
+		nodeTemplate.Spec.Tags = nil
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).ToNot(HaveKey("team"))
+		Expect(tagsOf(aws.StringValue(instance.NetworkInterfaces[0].NetworkInterfaceId))).ToNot(HaveKey("team"))
+	})
+	It("should not delete tags that weren't reconciled", func() {
+		_, instance := machineWithInstance(&ec2.Tag{Key: aws.String("backup"), Value: aws.String("daily")})
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.DeleteTagsBehavior.Calls()).To(BeZero())
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).To(HaveKeyWithValue("backup", "daily"))
+	})
+	It("should not change tags with reserved keys", func() {
+		nodeTemplate.Spec.Tags[v1alpha5.Group+"/team"] = "a"
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		_, instance := machineWithInstance(&ec2.Tag{Key: aws.String(v1alpha5.MachineNameLabelKey), Value: aws.String("other")})
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).To(HaveKeyWithValue(v1alpha5.MachineNameLabelKey, "other"))
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).ToNot(HaveKey(v1alpha5.Group + "/team"))
+	})
+	It("should propagate labels of machines as tags", func() {
+		nodeTemplate.Spec.PropagateLabelsAsTags = []string{v1.LabelInstanceTypeStable}
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		_, instance := machineWithInstance()
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "m5.large"))
+	})
+	It("should not tag the instances of machines whose node templates were deleted", func() {
+		_, instance := machineWithInstance()
+		ExpectDeleted(ctx, env.Client, nodeTemplate)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.CreateTagsBehavior.Calls()).To(BeZero())
+		Expect(tagsOf(aws.StringValue(instance.InstanceId))).To(BeEmpty())
+	})
+})
@@ -0,0 +1,126 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package unavailableofferings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/utils/configmap"
+	"github.com/aws/karpenter/pkg/utils/leaderelection"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap in the system namespace that stores the unavailable offerings
+	ConfigMapName = "karpenter-unavailable-offerings"
+	// entriesKey is the ConfigMap data key of the JSON encoded cache keys and their expiration times
+	entriesKey = "entries"
+
+	syncPeriod = 10 * time.Second
+)
+
+// Controller persists the unavailable offerings cache to a ConfigMap so that insufficient capacity errors aren't
+// forgotten across restarts and leader failovers. It runs on every replica: the leader rehydrates the cache once when
+// it's elected and then persists every change, while standby replicas continuously restore the persisted entries so
+// that their instance type caches stay warm. Entries that are deleted on the leader expire on standby replicas.
+type Controller struct {
+	kubernetesInterface  kubernetes.Interface
+	unavailableOfferings *awscache.UnavailableOfferings
+	elected              <-chan struct{}
+
+	hydrated        bool
+	persistedSeqNum uint64
+}
+
+func NewController(kubernetesInterface kubernetes.Interface, unavailableOfferings *awscache.UnavailableOfferings, elected <-chan struct{}) corecontroller.Controller {
+	return &Controller{
+		kubernetesInterface:  kubernetesInterface,
+		unavailableOfferings: unavailableOfferings,
+		elected:              elected,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	if !leaderelection.IsLeader(c.elected) || !c.hydrated {
+		if err := c.restore(ctx); err != nil {
+			return reconcile.Result{}, fmt.Errorf("restoring unavailable offerings, %w", err)
+		}
+		c.hydrated = leaderelection.IsLeader(c.elected)
+	}
+	if leaderelection.IsLeader(c.elected) {
+		if err := c.persist(ctx); err != nil {
+			return reconcile.Result{}, fmt.Errorf("persisting unavailable offerings, %w", err)
+		}
+	}
+	return reconcile.Result{RequeueAfter: syncPeriod}, nil
+}
+
+func (c *Controller) Name() string {
+	return "unavailableofferings"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(leaderelection.UnelectedManager{Manager: m})
+}
+
+func (c *Controller) restore(ctx context.Context) error {
+	cm, err := c.kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	entries := map[string]time.Time{}
+	if data, ok := cm.Data[entriesKey]; ok {
+		if err := json.Unmarshal([]byte(data), &entries); err != nil {
+			return fmt.Errorf("decoding %s, %w", entriesKey, err)
+		}
+	}
+	if c.unavailableOfferings.Restore(entries) {
+		logging.FromContext(ctx).With("entries", len(entries)).Debugf("restored unavailable offerings")
+	}
+	return nil
+}
+
+func (c *Controller) persist(ctx context.Context) error {
+	seqNum := atomic.LoadUint64(&c.unavailableOfferings.SeqNum)
+	if seqNum == c.persistedSeqNum {
+		return nil
+	}
+	data, err := json.Marshal(c.unavailableOfferings.Entries())
+	if err != nil {
+		return fmt.Errorf("encoding %s, %w", entriesKey, err)
+	}
+	if err := configmap.Persist(ctx, c.kubernetesInterface, ConfigMapName, map[string]string{entriesKey: string(data)}, nil); err != nil {
+		return err
+	}
+	c.persistedSeqNum = seqNum
+	return nil
+}
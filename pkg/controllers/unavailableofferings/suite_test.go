@@ -0,0 +1,138 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package unavailableofferings_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/controllers/unavailableofferings"
+	awserrors "github.com/aws/karpenter/pkg/errors"
+)
+
+var ctx context.Context
+var kubernetesInterface *fake.Clientset
+var elected chan struct{}
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "UnavailableOfferings")
+}
+
+var _ = BeforeEach(func() {
+	kubernetesInterface = fake.NewSimpleClientset()
+	elected = make(chan struct{})
+})
+
+func newController(unavailableOfferings *awscache.UnavailableOfferings) corecontroller.Controller {
+	return unavailableofferings.NewController(kubernetesInterface, unavailableOfferings, elected)
+}
+
+func expectReconciled(controller corecontroller.Controller) {
+	_, err := controller.Reconcile(ctx, reconcile.Request{})
+	Expect(err).ToNot(HaveOccurred())
+}
+
+var _ = Describe("UnavailableOfferings", func() {
+	It("should persist the unavailable offerings on the leader", func() {
+		close(elected)
+		leaderCache := awscache.NewUnavailableOfferings()
+		controller := newController(leaderCache)
+		leaderCache.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expectReconciled(controller)
+
+		cm, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, unavailableofferings.ConfigMapName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cm.Data["entries"]).To(ContainSubstring("spot:m5.large:test-zone-1a"))
+
+		leaderCache.MarkScopeUnavailable(ctx, "VcpuLimitExceeded", awserrors.FamilyScope, 5*time.Minute, "p3.8xlarge", "test-zone-1a", v1alpha5.CapacityTypeOnDemand)
+		expectReconciled(controller)
+		cm, err = kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, unavailableofferings.ConfigMapName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cm.Data["entries"]).To(ContainSubstring("family:on-demand:p"))
+	})
+	It("should not persist the unavailable offerings on standby replicas", func() {
+		standbyCache := awscache.NewUnavailableOfferings()
+		standbyCache.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expectReconciled(newController(standbyCache))
+
+		_, err := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, unavailableofferings.ConfigMapName, metav1.GetOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+	It("should rehydrate a new leader with the remaining TTLs", func() {
+		close(elected)
+		previousCache := awscache.NewUnavailableOfferings()
+		previousCache.MarkScopeUnavailable(ctx, "VcpuLimitExceeded", awserrors.FamilyScope, 5*time.Minute, "m5.large", "test-zone-1a", v1alpha5.CapacityTypeOnDemand)
+		expectReconciled(newController(previousCache))
+
+		leaderCache := awscache.NewUnavailableOfferings()
+		expectReconciled(newController(leaderCache))
+		Expect(leaderCache.IsUnavailable("c5.xlarge", "test-zone-1b", v1alpha5.CapacityTypeOnDemand)).To(BeTrue())
+		Expect(leaderCache.IsUnavailable("c5.xlarge", "test-zone-1b", v1alpha5.CapacityTypeSpot)).To(BeFalse())
+		Expect(leaderCache.Entries()).To(HaveLen(1))
+		for _, expiration := range leaderCache.Entries() {
+			Expect(expiration).To(BeTemporally("~", previousCache.Entries()["family:on-demand:standard"], time.Second))
+		}
+	})
+	It("should keep standby replicas in sync with the leader", func() {
+		close(elected)
+		leaderCache := awscache.NewUnavailableOfferings()
+		leader := newController(leaderCache)
+		standbyCache := awscache.NewUnavailableOfferings()
+		standby := unavailableofferings.NewController(kubernetesInterface, standbyCache, make(chan struct{}))
+
+		leaderCache.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)
+		expectReconciled(leader)
+		seqNum := standbyCache.SeqNum
+		expectReconciled(standby)
+		Expect(standbyCache.IsUnavailable("m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)).To(BeTrue())
+		Expect(standbyCache.SeqNum).To(BeNumerically(">", seqNum))
+
+		// Unchanged entries don't invalidate the instance type caches of standby replicas
+		seqNum = standbyCache.SeqNum
+		expectReconciled(standby)
+		Expect(standbyCache.SeqNum).To(Equal(seqNum))
+
+		leaderCache.MarkUnavailable(ctx, "InsufficientInstanceCapacity", "m5.xlarge", "test-zone-1b", v1alpha5.CapacityTypeSpot)
+		expectReconciled(leader)
+		expectReconciled(standby)
+		Expect(standbyCache.IsUnavailable("m5.xlarge", "test-zone-1b", v1alpha5.CapacityTypeSpot)).To(BeTrue())
+	})
+	It("should not restore expired entries", func() {
+		unavailableOfferings := awscache.NewUnavailableOfferings()
+		Expect(unavailableOfferings.Restore(map[string]time.Time{
+			"spot:m5.large:test-zone-1a":  time.Now().Add(-time.Minute),
+			"spot:m5.xlarge:test-zone-1a": time.Now().Add(time.Minute),
+		})).To(BeTrue())
+		Expect(unavailableOfferings.IsUnavailable("m5.large", "test-zone-1a", v1alpha5.CapacityTypeSpot)).To(BeFalse())
+		Expect(unavailableOfferings.IsUnavailable("m5.xlarge", "test-zone-1a", v1alpha5.CapacityTypeSpot)).To(BeTrue())
+	})
+})
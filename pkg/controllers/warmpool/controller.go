@@ -0,0 +1,283 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmpool
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/clock"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+	nodeutils "github.com/aws/karpenter-core/pkg/utils/node"
+
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+)
+
+const (
+	// registrationTimeout is the time that a warm instance is given to register its node before it's stopped anyway
+	registrationTimeout = 10 * time.Minute
+
+	syncPeriod = 10 * time.Second
+	idlePeriod = 5 * time.Minute
+)
+
+// Controller maintains the warm pools of AWSNodeTemplates. Warm instances are launched by the controller for the
+// provisioners of node templates with warm pools, and by the instance provider when a machine is launched for such a
+// node template, and run until their node registers so that they're initialized when they're started again. Their
+// nodes register with the warm pool taint, and the controller then stops them and removes the node that they
+// registered. It also terminates the warm instances that exceed the size of their node template's warm pool, and
+// removes the warm pool taint from the nodes of instances that were started for machines.
+type Controller struct {
+	kubeClient    client.Client
+	ec2api        ec2iface.EC2API
+	cloudProvider *cloudprovider.CloudProvider
+	clk           clock.Clock
+}
+
+func NewController(kubeClient client.Client, ec2api ec2iface.EC2API, cloudProvider *cloudprovider.CloudProvider, clk clock.Clock) corecontroller.Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		ec2api:        ec2api,
+		cloudProvider: cloudProvider,
+		clk:           clk,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	nodeTemplateList := &v1alpha1.AWSNodeTemplateList{}
+	if err := c.kubeClient.List(ctx, nodeTemplateList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing node templates, %w", err)
+	}
+	sizes := map[string]int{}
+	counts := map[string]int{}
+	warmInstances.Reset()
+	for _, nodeTemplate := range nodeTemplateList.Items {
+		if nodeTemplate.Spec.WarmPool != nil {
+			sizes[nodeTemplate.Name] = int(nodeTemplate.Spec.WarmPool.Size)
+			counts[nodeTemplate.Name] = 0
+			warmInstances.With(map[string]string{nodeTemplateLabel: nodeTemplate.Name, stateLabel: ec2.InstanceStateNameStopped}).Set(0)
+		}
+	}
+	// Warm pools that were removed are still cleaned up, but less often
+	requeueAfter := lo.Ternary(len(sizes) > 0, syncPeriod, idlePeriod)
+	instances, err := c.listWarmInstances(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	nodeList := &v1.NodeList{}
+	if err = c.kubeClient.List(ctx, nodeList); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	nodes := lo.SliceToMap(nodeList.Items, func(node v1.Node) (string, v1.Node) { return node.Spec.ProviderID, node })
+
+	var errs error
+	for nodeTemplateName, pool := range lo.GroupBy(instances, func(instance *ec2.Instance) string { return warmPoolName(instance) }) {
+		ctx := logging.WithLogger(ctx, logging.FromContext(ctx).With("node-template", nodeTemplateName))
+		// The oldest instances are kept, as they're the most likely to be initialized
+		sort.Slice(pool, func(i, j int) bool {
+			return aws.TimeValue(pool[i].LaunchTime).Before(aws.TimeValue(pool[j].LaunchTime))
+		})
+		if surplus := pool[lo.Min([]int{sizes[nodeTemplateName], len(pool)}):]; len(surplus) > 0 {
+			if err := c.terminate(ctx, surplus); err != nil {
+				errs = multierr.Append(errs, err)
+			}
+			pool = pool[:len(pool)-len(surplus)]
+		}
+		counts[nodeTemplateName] = len(pool)
+		for _, instance := range pool {
+			state := aws.StringValue(instance.State.Name)
+			warmInstances.With(map[string]string{nodeTemplateLabel: nodeTemplateName, stateLabel: state}).Inc()
+			if state != ec2.InstanceStateNameRunning {
+				continue
+			}
+			node, ok := nodes[providerID(instance)]
+			if err := c.initialize(ctx, instance, lo.Ternary(ok, &node, nil)); err != nil {
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+	if err := c.removeWarmPoolTaints(ctx, nodeList.Items, instances); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	if err := c.fill(ctx, nodeTemplateList.Items, counts); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, errs
+}
+
+func (c *Controller) Name() string {
+	return "warmpool"
+}
+
+func (c *Controller) Builder(_ context.Context, m manager.Manager) corecontroller.Builder {
+	return corecontroller.NewSingletonManagedBy(m)
+}
+
+// initialize stops the running warm instance once its node is ready, which means that it's been bootstrapped, and
+// removes the node so that it isn't mistaken for a node that's down. The node is cordoned while it's initialized so
+// that pods aren't scheduled to it.
+func (c *Controller) initialize(ctx context.Context, instance *ec2.Instance, node *v1.Node) error {
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("id", aws.StringValue(instance.InstanceId)))
+	if node != nil && !node.Spec.Unschedulable {
+		stored := node.DeepCopy()
+		node.Spec.Unschedulable = true
+		if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
+			return client.IgnoreNotFound(fmt.Errorf("cordoning node, %w", err))
+		}
+	}
+	ready := node != nil && nodeutils.GetCondition(node, v1.NodeReady).Status == v1.ConditionTrue
+	if !ready && c.clk.Since(aws.TimeValue(instance.LaunchTime)) < registrationTimeout {
+		return nil
+	}
+	if _, err := c.ec2api.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{InstanceIds: []*string{instance.InstanceId}}); err != nil {
+		return fmt.Errorf("stopping warm instance, %w", err)
+	}
+	logging.FromContext(ctx).With("registered", node != nil).Debugf("stopped warm instance")
+	if node == nil {
+		return nil
+	}
+	// The termination finalizer is removed, as it terminates the node's instance
+	stored := node.DeepCopy()
+	node.Finalizers = lo.Without(node.Finalizers, v1alpha5.TerminationFinalizer)
+	if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
+		return client.IgnoreNotFound(fmt.Errorf("removing termination finalizer, %w", err))
+	}
+	if err := c.kubeClient.Delete(ctx, node); err != nil {
+		return client.IgnoreNotFound(fmt.Errorf("deleting node, %w", err))
+	}
+	return nil
+}
+
+// fill launches the instances that are missing from the warm pools of the node templates, from the launch templates
+// and instance types of the first provisioner of each node template, in name order, that launches on-demand machines
+func (c *Controller) fill(ctx context.Context, nodeTemplates []v1alpha1.AWSNodeTemplate, counts map[string]int) error {
+	if lo.EveryBy(nodeTemplates, func(nodeTemplate v1alpha1.AWSNodeTemplate) bool {
+		return nodeTemplate.Spec.WarmPool == nil || counts[nodeTemplate.Name] >= int(nodeTemplate.Spec.WarmPool.Size)
+	}) {
+		return nil
+	}
+	provisionerList := &v1alpha5.ProvisionerList{}
+	if err := c.kubeClient.List(ctx, provisionerList); err != nil {
+		return fmt.Errorf("listing provisioners, %w", err)
+	}
+	sort.Slice(provisionerList.Items, func(i, j int) bool { return provisionerList.Items[i].Name < provisionerList.Items[j].Name })
+	var errs error
+	for i := range nodeTemplates {
+		nodeTemplate := &nodeTemplates[i]
+		if nodeTemplate.Spec.WarmPool == nil || counts[nodeTemplate.Name] >= int(nodeTemplate.Spec.WarmPool.Size) {
+			continue
+		}
+		provisioner, ok := lo.Find(provisionerList.Items, func(provisioner v1alpha5.Provisioner) bool {
+			return provisioner.Spec.ProviderRef != nil && provisioner.Spec.ProviderRef.Name == nodeTemplate.Name &&
+				scheduling.NewNodeSelectorRequirements(provisioner.Spec.Requirements...).Get(v1alpha5.LabelCapacityType).Has(v1alpha5.CapacityTypeOnDemand)
+		})
+		if !ok {
+			continue
+		}
+		if err := c.cloudProvider.FillWarmPool(ctx, nodeTemplate, &provisioner); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("filling warm pool of node template %s, %w", nodeTemplate.Name, err))
+		}
+	}
+	return errs
+}
+
+// removeWarmPoolTaints removes the warm pool taint from the nodes of instances that are no longer in a warm pool, which
+// register with the taint if they're started for machines before their nodes are created
+func (c *Controller) removeWarmPoolTaints(ctx context.Context, nodes []v1.Node, instances []*ec2.Instance) error {
+	warmProviderIDs := sets.NewString(lo.Map(instances, func(instance *ec2.Instance, _ int) string { return providerID(instance) })...)
+	var errs error
+	for i := range nodes {
+		node := &nodes[i]
+		if warmProviderIDs.Has(node.Spec.ProviderID) || !lo.ContainsBy(node.Spec.Taints, isWarmPoolTaint) {
+			continue
+		}
+		stored := node.DeepCopy()
+		node.Spec.Taints = lo.Reject(node.Spec.Taints, func(taint v1.Taint, _ int) bool { return isWarmPoolTaint(taint) })
+		if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); client.IgnoreNotFound(err) != nil {
+			errs = multierr.Append(errs, fmt.Errorf("removing warm pool taint from node %s, %w", node.Name, err))
+			continue
+		}
+		logging.FromContext(ctx).With("node", node.Name).Debugf("removed warm pool taint")
+	}
+	return errs
+}
+
+func isWarmPoolTaint(taint v1.Taint) bool {
+	return taint.Key == v1alpha1.WarmPoolTaintKey
+}
+
+func (c *Controller) terminate(ctx context.Context, instances []*ec2.Instance) error {
+	ids := lo.Map(instances, func(instance *ec2.Instance, _ int) *string { return instance.InstanceId })
+	if _, err := c.ec2api.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: ids}); err != nil {
+		return fmt.Errorf("terminating surplus warm instances, %w", err)
+	}
+	logging.FromContext(ctx).With("ids", aws.StringValueSlice(ids)).Infof("terminated surplus warm instances")
+	return nil
+}
+
+func (c *Controller) listWarmInstances(ctx context.Context) ([]*ec2.Instance, error) {
+	var instances []*ec2.Instance
+	if err := c.ec2api.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: aws.StringSlice([]string{v1alpha1.WarmPoolTagKey}),
+			},
+			{
+				Name:   aws.String(fmt.Sprintf("tag:kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName)),
+				Values: aws.StringSlice([]string{"*"}),
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped}),
+			},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing warm instances, %w", err)
+	}
+	return instances, nil
+}
+
+func warmPoolName(instance *ec2.Instance) string {
+	tag, _ := lo.Find(instance.Tags, func(tag *ec2.Tag) bool { return aws.StringValue(tag.Key) == v1alpha1.WarmPoolTagKey })
+	return aws.StringValue(tag.Value)
+}
+
+func providerID(instance *ec2.Instance) string {
+	return fmt.Sprintf("aws:///%s/%s", aws.StringValue(instance.Placement.AvailabilityZone), aws.StringValue(instance.InstanceId))
+}
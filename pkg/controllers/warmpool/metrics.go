@@ -0,0 +1,44 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmpool
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter-core/pkg/metrics"
+)
+
+const (
+	warmPoolSubsystem = "warm_pool"
+	nodeTemplateLabel = "node_template"
+	stateLabel        = "state"
+)
+
+var (
+	warmInstances = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: warmPoolSubsystem,
+			Name:      "instances",
+			Help:      "Number of instances in the warm pool of a node template. Broken down by node template and instance state.",
+		},
+		[]string{nodeTemplateLabel, stateLabel},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(warmInstances)
+}
@@ -0,0 +1,294 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmpool_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/awstesting/mock"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	clock "k8s.io/utils/clock/testing"
+	. "knative.dev/pkg/logging/testing"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coresettings "github.com/aws/karpenter-core/pkg/apis/settings"
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	corecloudprovider "github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/events"
+	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
+	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	coretest "github.com/aws/karpenter-core/pkg/test"
+	. "github.com/aws/karpenter-core/pkg/test/expectations"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/apis/v1alpha1"
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	awscontext "github.com/aws/karpenter/pkg/context"
+	"github.com/aws/karpenter/pkg/controllers/warmpool"
+	"github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/providers/placementscore"
+	"github.com/aws/karpenter/pkg/providers/quota"
+	"github.com/aws/karpenter/pkg/providers/securitygroup"
+	"github.com/aws/karpenter/pkg/providers/subnet"
+	"github.com/aws/karpenter/pkg/test"
+)
+
+var ctx context.Context
+var env *coretest.Environment
+var fakeEC2API *fake.EC2API
+var fakeClock *clock.FakeClock
+var nodeTemplate *v1alpha1.AWSNodeTemplate
+var controller corecontroller.Controller
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "WarmPool")
+}
+
+var _ = BeforeSuite(func() {
+	ctx = coresettings.ToContext(ctx, coretest.Settings())
+	ctx = settings.ToContext(ctx, test.Settings())
+	env = coretest.NewEnvironment(scheme.Scheme, coretest.WithCRDs(apis.CRDs...))
+	fakeEC2API = &fake.EC2API{}
+	fakeClock = &clock.FakeClock{}
+	cloudProvider := cloudprovider.New(awscontext.Context{
+		Context: corecloudprovider.Context{
+			Context:             ctx,
+			RESTConfig:          env.Config,
+			KubernetesInterface: env.KubernetesInterface,
+			KubeClient:          env.Client,
+			EventRecorder:       events.NewRecorder(&record.FakeRecorder{}),
+			Clock:               fakeClock,
+			StartAsync:          nil,
+		},
+		Session:                   mock.Session,
+		UnavailableOfferingsCache: awscache.NewUnavailableOfferings(),
+		SpotInterruptionHistory:   awscache.NewSpotInterruptionHistory(),
+		MemoryOverhead:            awscache.NewMemoryOverhead(),
+		EC2API:                    fakeEC2API,
+		SubnetProvider:            subnet.NewProvider(fakeEC2API),
+		SecurityGroupProvider:     securitygroup.NewProvider(fakeEC2API),
+		QuotaProvider:             quota.NewProvider(ctx, &fake.ServiceQuotasAPI{}, fakeEC2API, make(chan struct{})),
+		PlacementScoreProvider:    placementscore.NewProvider(ctx, fakeEC2API, "", make(chan struct{})),
+	})
+	controller = warmpool.NewController(env.Client, fakeEC2API, cloudProvider, fakeClock)
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = BeforeEach(func() {
+	ctx = settings.ToContext(ctx, test.Settings())
+	fakeClock.SetTime(time.Now())
+	nodeTemplate = test.AWSNodeTemplate(v1alpha1.AWSNodeTemplateSpec{
+		AWS: v1alpha1.AWS{
+			SubnetSelector:        map[string]string{"*": "*"},
+			SecurityGroupSelector: map[string]string{"*": "*"},
+		},
+		WarmPool: &v1alpha1.WarmPool{Size: 2},
+	})
+	fakeEC2API.Reset()
+})
+
+var _ = AfterEach(func() {
+	ExpectCleanedUp(ctx, env.Client)
+})
+
+// warmInstance returns a warm instance of the node template's pool, which the fake EC2 API lists and updates
+func warmInstance(state string, launchTime time.Time) *ec2.Instance {
+	instance := &ec2.Instance{
+		InstanceId:   aws.String(fake.InstanceID()),
+		InstanceType: aws.String("m5.large"),
+		LaunchTime:   aws.Time(launchTime),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
+		State:        &ec2.InstanceState{Name: aws.String(state)},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String(nodeTemplate.Name)},
+		},
+	}
+	fakeEC2API.Instances.Store(aws.StringValue(instance.InstanceId), instance)
+	return instance
+}
+
+func expectWarmInstances(instances ...*ec2.Instance) {
+	fakeEC2API.DescribeInstancesBehavior.Output.Set(&ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: instances}},
+	})
+}
+
+func warmNode(instance *ec2.Instance, ready v1.ConditionStatus) *v1.Node {
+	return coretest.Node(coretest.NodeOptions{
+		ObjectMeta: metav1.ObjectMeta{
+			Finalizers: []string{v1alpha5.TerminationFinalizer},
+		},
+		ProviderID:  fmt.Sprintf("aws:///%s/%s", aws.StringValue(instance.Placement.AvailabilityZone), aws.StringValue(instance.InstanceId)),
+		ReadyStatus: ready,
+	})
+}
+
+func stoppedInstanceIDs() []string {
+	var ids []string
+	for fakeEC2API.StopInstancesBehavior.CalledWithInput.Len() > 0 {
+		ids = append(ids, aws.StringValueSlice(fakeEC2API.StopInstancesBehavior.CalledWithInput.Pop().InstanceIds)...)
+	}
+	return ids
+}
+
+func terminatedInstanceIDs() []string {
+	var ids []string
+	for fakeEC2API.TerminateInstancesBehavior.CalledWithInput.Len() > 0 {
+		ids = append(ids, aws.StringValueSlice(fakeEC2API.TerminateInstancesBehavior.CalledWithInput.Pop().InstanceIds)...)
+	}
+	return ids
+}
+
+var _ = Describe("WarmPool", func() {
+	It("should stop an initialized warm instance and delete its node", func() {
+		instance := warmInstance(ec2.InstanceStateNameRunning, fakeClock.Now())
+		expectWarmInstances(instance)
+		node := warmNode(instance, v1.ConditionTrue)
+		ExpectApplied(ctx, env.Client, nodeTemplate, node)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(stoppedInstanceIDs()).To(ConsistOf(aws.StringValue(instance.InstanceId)))
+		Expect(aws.StringValue(instance.State.Name)).To(Equal(ec2.InstanceStateNameStopping))
+		ExpectNotFound(ctx, env.Client, node)
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(BeZero())
+	})
+	It("should cordon the node of a warm instance that isn't initialized", func() {
+		instance := warmInstance(ec2.InstanceStateNameRunning, fakeClock.Now())
+		expectWarmInstances(instance)
+		node := warmNode(instance, v1.ConditionFalse)
+		ExpectApplied(ctx, env.Client, nodeTemplate, node)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(stoppedInstanceIDs()).To(BeEmpty())
+		node = ExpectExists(ctx, env.Client, node)
+		Expect(node.Spec.Unschedulable).To(BeTrue())
+	})
+	It("should stop a warm instance that doesn't register within the timeout", func() {
+		instance := warmInstance(ec2.InstanceStateNameRunning, fakeClock.Now())
+		expectWarmInstances(instance)
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(stoppedInstanceIDs()).To(BeEmpty())
+
+		fakeClock.Step(15 * time.Minute)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(stoppedInstanceIDs()).To(ConsistOf(aws.StringValue(instance.InstanceId)))
+	})
+	It("should not stop warm instances that are already stopped", func() {
+		expectWarmInstances(warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-time.Hour)))
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.StopInstancesBehavior.Calls()).To(BeZero())
+		Expect(fakeEC2API.TerminateInstancesBehavior.Calls()).To(BeZero())
+	})
+	It("should terminate the newest warm instances beyond the size of the pool", func() {
+		oldest := warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-3*time.Hour))
+		older := warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-2*time.Hour))
+		newest := warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-time.Hour))
+		expectWarmInstances(newest, oldest, older)
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(terminatedInstanceIDs()).To(ConsistOf(aws.StringValue(newest.InstanceId)))
+	})
+	It("should fill the warm pool for a provisioner of its node template before any launch", func() {
+		expectWarmInstances(warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-time.Hour)))
+		fakeEC2API.DescribeImagesOutput.Set(&ec2.DescribeImagesOutput{Images: []*ec2.Image{{
+			ImageId:      aws.String("ami-123"),
+			Architecture: aws.String("x86_64"),
+			CreationDate: aws.String("2022-08-15T12:00:00Z"),
+		}}})
+		nodeTemplate.Spec.AMISelector = map[string]string{"*": "*"}
+		provisioner := test.Provisioner(coretest.ProvisionerOptions{
+			ProviderRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name},
+		})
+		ExpectApplied(ctx, env.Client, nodeTemplate, provisioner)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.CreateFleetBehavior.Calls()).To(Equal(1))
+		createFleetInput := fakeEC2API.CreateFleetBehavior.CalledWithInput.Pop()
+		Expect(aws.Int64Value(createFleetInput.TargetCapacitySpecification.TotalTargetCapacity)).To(BeNumerically("==", 1))
+		Expect(aws.StringValue(createFleetInput.TargetCapacitySpecification.DefaultTargetCapacityType)).To(Equal(v1alpha5.CapacityTypeOnDemand))
+		Expect(createFleetInput.TagSpecifications[0].Tags).To(ContainElement(&ec2.Tag{Key: aws.String(v1alpha1.WarmPoolTagKey), Value: aws.String(nodeTemplate.Name)}))
+	})
+	It("should not fill a warm pool that is full or has no provisioners", func() {
+		expectWarmInstances(warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-time.Hour)))
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.CreateFleetBehavior.Calls()).To(BeZero())
+
+		expectWarmInstances(
+			warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-time.Hour)),
+			warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-time.Hour)),
+		)
+		provisioner := test.Provisioner(coretest.ProvisionerOptions{
+			ProviderRef: &v1alpha5.ProviderRef{Name: nodeTemplate.Name},
+		})
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(fakeEC2API.CreateFleetBehavior.Calls()).To(BeZero())
+	})
+	It("should remove the warm pool taint from the nodes of instances that were started for machines", func() {
+		instance := warmInstance(ec2.InstanceStateNameRunning, fakeClock.Now())
+		started := warmInstance(ec2.InstanceStateNameRunning, fakeClock.Now())
+		expectWarmInstances(instance)
+		warmPoolTaint := v1.Taint{Key: v1alpha1.WarmPoolTaintKey, Effect: v1.TaintEffectNoSchedule}
+		node := warmNode(instance, v1.ConditionFalse)
+		node.Spec.Taints = []v1.Taint{warmPoolTaint}
+		startedNode := warmNode(started, v1.ConditionTrue)
+		startedNode.Spec.Taints = []v1.Taint{warmPoolTaint, {Key: "test-taint", Effect: v1.TaintEffectNoSchedule}}
+		ExpectApplied(ctx, env.Client, nodeTemplate, node, startedNode)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(ExpectExists(ctx, env.Client, node).Spec.Taints).To(ContainElement(warmPoolTaint))
+		Expect(ExpectExists(ctx, env.Client, startedNode).Spec.Taints).To(ConsistOf(v1.Taint{Key: "test-taint", Effect: v1.TaintEffectNoSchedule}))
+		Expect(stoppedInstanceIDs()).To(BeEmpty())
+	})
+	It("should terminate the warm instances of a removed warm pool", func() {
+		instances := []*ec2.Instance{
+			warmInstance(ec2.InstanceStateNameStopped, fakeClock.Now().Add(-time.Hour)),
+			warmInstance(ec2.InstanceStateNameRunning, fakeClock.Now()),
+		}
+		expectWarmInstances(instances...)
+		nodeTemplate.Spec.WarmPool = nil
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKey{})
+		Expect(terminatedInstanceIDs()).To(ConsistOf(lo.Map(instances, func(instance *ec2.Instance, _ int) string {
+			return aws.StringValue(instance.InstanceId)
+		})))
+		Expect(fakeEC2API.StopInstancesBehavior.Calls()).To(BeZero())
+	})
+})
@@ -16,7 +16,9 @@ package errors
 
 import (
 	"errors"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/eventbridge"
@@ -25,7 +27,8 @@ import (
 )
 
 const (
-	launchTemplateNotFoundCode = "InvalidLaunchTemplateName.NotFoundException"
+	launchTemplateNotFoundCode        = "InvalidLaunchTemplateName.NotFoundException"
+	launchTemplateVersionNotFoundCode = "InvalidLaunchTemplateId.VersionNotFound"
 )
 
 var (
@@ -33,19 +36,50 @@ var (
 	notFoundErrorCodes = sets.NewString(
 		"InvalidInstanceID.NotFound",
 		launchTemplateNotFoundCode,
+		launchTemplateVersionNotFoundCode,
 		sqs.ErrCodeQueueDoesNotExist,
 		(&eventbridge.ResourceNotFoundException{}).Code(),
 	)
-	// unfulfillableCapacityErrorCodes signify that capacity is temporarily unable to be launched
-	unfulfillableCapacityErrorCodes = sets.NewString(
-		"InsufficientInstanceCapacity",
-		"MaxSpotInstanceCountExceeded",
-		"VcpuLimitExceeded",
-		"UnfulfillableCapacity",
-		"Unsupported",
-	)
+	// unfulfillableCapacityErrors signify that capacity is temporarily unable to be launched. Each error code is
+	// mapped to the offerings that it affects and how long they are expected to remain unavailable.
+	unfulfillableCapacityErrors = map[string]FleetErrorClassification{
+		"InsufficientInstanceCapacity": {Scope: OfferingScope, TTL: 3 * time.Minute},
+		"UnfulfillableCapacity":        {Scope: OfferingScope, TTL: 3 * time.Minute},
+		"Unsupported":                  {Scope: OfferingScope, TTL: 3 * time.Minute},
+		// Other subnets in the zone may still have free addresses, so only the subnet of the launch is excluded
+		"InsufficientFreeAddressesInSubnet": {Scope: SubnetScope, TTL: time.Minute},
+		// vCPU quotas are enforced per capacity type for classes of instance families in a region
+		"VcpuLimitExceeded": {Scope: FamilyScope, TTL: 5 * time.Minute},
+		// The spot instance count limit applies to all spot instances in a region
+		"MaxSpotInstanceCountExceeded": {Scope: CapacityTypeScope, TTL: 5 * time.Minute},
+		// The account can't launch any instances in the region
+		"InstanceLimitExceeded": {Scope: AccountScope, TTL: 5 * time.Minute},
+		"PendingVerification":   {Scope: AccountScope, TTL: 15 * time.Minute},
+	}
+)
+
+// UnavailabilityScope is the set of offerings that are unavailable after a fleet error
+type UnavailabilityScope string
+
+const (
+	// OfferingScope is the instance type in the zone for the capacity type
+	OfferingScope UnavailabilityScope = "offering"
+	// SubnetScope is the subnet of the launch, which is excluded from launches without making any offerings unavailable
+	SubnetScope UnavailabilityScope = "subnet"
+	// FamilyScope is every instance type that shares a vCPU quota with the instance type, in every zone for the capacity type
+	FamilyScope UnavailabilityScope = "family"
+	// CapacityTypeScope is every instance type in every zone for the capacity type
+	CapacityTypeScope UnavailabilityScope = "capacity-type"
+	// AccountScope is every offering
+	AccountScope UnavailabilityScope = "account"
 )
 
+// FleetErrorClassification describes the offerings that a fleet error makes unavailable and for how long
+type FleetErrorClassification struct {
+	Scope UnavailabilityScope
+	TTL   time.Duration
+}
+
 // IsNotFound returns true if the err is an AWS error (even if it's
 // wrapped) and is a known to mean "not found" (as opposed to a more
 // serious or unexpected error)
@@ -64,7 +98,15 @@ func IsNotFound(err error) bool {
 // capacity is temporarily unavailable for launching.
 // This could be due to account limits, insufficient ec2 capacity, etc.
 func IsUnfulfillableCapacity(err *ec2.CreateFleetError) bool {
-	return unfulfillableCapacityErrorCodes.Has(*err.ErrorCode)
+	_, ok := ClassifyFleetError(err)
+	return ok
+}
+
+// ClassifyFleetError returns the scope and duration of the capacity shortage signaled by the Fleet err. It returns
+// false if the err doesn't signal that capacity is temporarily unavailable.
+func ClassifyFleetError(err *ec2.CreateFleetError) (FleetErrorClassification, bool) {
+	classification, ok := unfulfillableCapacityErrors[aws.StringValue(err.ErrorCode)]
+	return classification, ok
 }
 
 func IsLaunchTemplateNotFound(err error) bool {
@@ -73,7 +115,7 @@ func IsLaunchTemplateNotFound(err error) bool {
 	}
 	var awsError awserr.Error
 	if errors.As(err, &awsError) {
-		return awsError.Code() == launchTemplateNotFoundCode
+		return awsError.Code() == launchTemplateNotFoundCode || awsError.Code() == launchTemplateVersionNotFoundCode
 	}
 	return false
 }
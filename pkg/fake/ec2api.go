@@ -18,7 +18,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/aws-sdk-go/aws"
@@ -39,30 +41,47 @@ type CapacityPool struct {
 	CapacityType string
 	InstanceType string
 	Zone         string
+	// ErrorCode is the fleet error returned for the pool, defaulting to InsufficientInstanceCapacity
+	ErrorCode string
 }
 
 // EC2Behavior must be reset between tests otherwise tests will
 // pollute each other.
 type EC2Behavior struct {
-	DescribeImagesOutput                AtomicPtr[ec2.DescribeImagesOutput]
-	DescribeLaunchTemplatesOutput       AtomicPtr[ec2.DescribeLaunchTemplatesOutput]
-	DescribeSubnetsOutput               AtomicPtr[ec2.DescribeSubnetsOutput]
-	DescribeSecurityGroupsOutput        AtomicPtr[ec2.DescribeSecurityGroupsOutput]
-	DescribeInstanceTypesOutput         AtomicPtr[ec2.DescribeInstanceTypesOutput]
-	DescribeInstanceTypeOfferingsOutput AtomicPtr[ec2.DescribeInstanceTypeOfferingsOutput]
-	DescribeAvailabilityZonesOutput     AtomicPtr[ec2.DescribeAvailabilityZonesOutput]
-	DescribeSpotPriceHistoryInput       AtomicPtr[ec2.DescribeSpotPriceHistoryInput]
-	DescribeSpotPriceHistoryOutput      AtomicPtr[ec2.DescribeSpotPriceHistoryOutput]
-	CreateFleetBehavior                 MockedFunction[ec2.CreateFleetInput, ec2.CreateFleetOutput]
-	TerminateInstancesBehavior          MockedFunction[ec2.TerminateInstancesInput, ec2.TerminateInstancesOutput]
-	DescribeInstancesBehavior           MockedFunction[ec2.DescribeInstancesInput, ec2.DescribeInstancesOutput]
-	CreateTagsBehavior                  MockedFunction[ec2.CreateTagsInput, ec2.CreateTagsOutput]
-	CalledWithCreateLaunchTemplateInput AtomicPtrSlice[ec2.CreateLaunchTemplateInput]
-	CalledWithDescribeImagesInput       AtomicPtrSlice[ec2.DescribeImagesInput]
-	Instances                           sync.Map
-	LaunchTemplates                     sync.Map
-	InsufficientCapacityPools           atomic.Slice[CapacityPool]
-	NextError                           AtomicError
+	DescribeImagesOutput                       AtomicPtr[ec2.DescribeImagesOutput]
+	DescribeLaunchTemplatesOutput              AtomicPtr[ec2.DescribeLaunchTemplatesOutput]
+	DescribeSubnetsOutput                      AtomicPtr[ec2.DescribeSubnetsOutput]
+	DescribeSecurityGroupsOutput               AtomicPtr[ec2.DescribeSecurityGroupsOutput]
+	DescribeInstanceTypesOutput                AtomicPtr[ec2.DescribeInstanceTypesOutput]
+	DescribeInstanceTypeOfferingsOutput        AtomicPtr[ec2.DescribeInstanceTypeOfferingsOutput]
+	DescribeAvailabilityZonesOutput            AtomicPtr[ec2.DescribeAvailabilityZonesOutput]
+	DescribeSpotPriceHistoryInput              AtomicPtr[ec2.DescribeSpotPriceHistoryInput]
+	DescribeSpotPriceHistoryOutput             AtomicPtr[ec2.DescribeSpotPriceHistoryOutput]
+	CreateFleetBehavior                        MockedFunction[ec2.CreateFleetInput, ec2.CreateFleetOutput]
+	TerminateInstancesBehavior                 MockedFunction[ec2.TerminateInstancesInput, ec2.TerminateInstancesOutput]
+	DescribeInstancesBehavior                  MockedFunction[ec2.DescribeInstancesInput, ec2.DescribeInstancesOutput]
+	CreateTagsBehavior                         MockedFunction[ec2.CreateTagsInput, ec2.CreateTagsOutput]
+	DeleteTagsBehavior                         MockedFunction[ec2.DeleteTagsInput, ec2.DeleteTagsOutput]
+	DescribeTagsBehavior                       MockedFunction[ec2.DescribeTagsInput, ec2.DescribeTagsOutput]
+	StartInstancesBehavior                     MockedFunction[ec2.StartInstancesInput, ec2.StartInstancesOutput]
+	StopInstancesBehavior                      MockedFunction[ec2.StopInstancesInput, ec2.StopInstancesOutput]
+	GetSpotPlacementScoresBehavior             MockedFunction[ec2.GetSpotPlacementScoresInput, ec2.GetSpotPlacementScoresOutput]
+	DeleteLaunchTemplateBehavior               MockedFunction[ec2.DeleteLaunchTemplateInput, ec2.DeleteLaunchTemplateOutput]
+	DeleteLaunchTemplateVersionsBehavior       MockedFunction[ec2.DeleteLaunchTemplateVersionsInput, ec2.DeleteLaunchTemplateVersionsOutput]
+	CalledWithCreateLaunchTemplateInput        AtomicPtrSlice[ec2.CreateLaunchTemplateInput]
+	CalledWithCreateLaunchTemplateVersionInput AtomicPtrSlice[ec2.CreateLaunchTemplateVersionInput]
+	CalledWithDescribeImagesInput              AtomicPtrSlice[ec2.DescribeImagesInput]
+	Instances                                  sync.Map
+	LaunchTemplates                            sync.Map
+	// LaunchTemplateVersions maps launch template names to their versions
+	LaunchTemplateVersions    sync.Map
+	InsufficientCapacityPools atomic.Slice[CapacityPool]
+	// SpotPlacementScores maps instance types to their spot placement scores by zone id. Instance types that
+	// aren't set aren't scored, and a request for several instance types scores each zone by its best instance type.
+	SpotPlacementScores sync.Map
+	// ResourceTags maps the ids of resources other than instances, such as volumes and network interfaces, to their tags
+	ResourceTags sync.Map
+	NextError    AtomicError
 }
 
 type EC2API struct {
@@ -86,7 +105,16 @@ func (e *EC2API) Reset() {
 	e.CreateFleetBehavior.Reset()
 	e.TerminateInstancesBehavior.Reset()
 	e.DescribeInstancesBehavior.Reset()
+	e.CreateTagsBehavior.Reset()
+	e.DeleteTagsBehavior.Reset()
+	e.DescribeTagsBehavior.Reset()
+	e.StartInstancesBehavior.Reset()
+	e.StopInstancesBehavior.Reset()
+	e.GetSpotPlacementScoresBehavior.Reset()
+	e.DeleteLaunchTemplateBehavior.Reset()
+	e.DeleteLaunchTemplateVersionsBehavior.Reset()
 	e.CalledWithCreateLaunchTemplateInput.Reset()
+	e.CalledWithCreateLaunchTemplateVersionInput.Reset()
 	e.CalledWithDescribeImagesInput.Reset()
 	e.DescribeSpotPriceHistoryInput.Reset()
 	e.DescribeSpotPriceHistoryOutput.Reset()
@@ -98,6 +126,18 @@ func (e *EC2API) Reset() {
 		e.LaunchTemplates.Delete(k)
 		return true
 	})
+	e.LaunchTemplateVersions.Range(func(k, v any) bool {
+		e.LaunchTemplateVersions.Delete(k)
+		return true
+	})
+	e.SpotPlacementScores.Range(func(k, v any) bool {
+		e.SpotPlacementScores.Delete(k)
+		return true
+	})
+	e.ResourceTags.Range(func(k, v any) bool {
+		e.ResourceTags.Delete(k)
+		return true
+	})
 	e.InsufficientCapacityPools.Reset()
 	e.NextError.Reset()
 }
@@ -112,6 +152,7 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 	}
 	var instanceIds []*string
 	var skippedPools []CapacityPool
+	var skippedSubnets []*string
 	var spotInstanceRequestID *string
 
 	if aws.StringValue(input.TargetCapacitySpecification.DefaultTargetCapacityType) == v1alpha5.CapacityTypeSpot {
@@ -126,6 +167,7 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 					pool.Zone == aws.StringValue(override.AvailabilityZone) &&
 					pool.CapacityType == aws.StringValue(input.TargetCapacitySpecification.DefaultTargetCapacityType) {
 					skippedPools = append(skippedPools, pool)
+					skippedSubnets = append(skippedSubnets, override.SubnetId)
 					skipInstance = true
 					return false
 				}
@@ -153,16 +195,35 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 						Name: &instanceState,
 					},
 				}
+				if spec, ok := lo.Find(input.TagSpecifications, func(spec *ec2.TagSpecification) bool {
+					return aws.StringValue(spec.ResourceType) == ec2.ResourceTypeInstance
+				}); ok {
+					instance.Tags = append([]*ec2.Tag{}, spec.Tags...)
+				}
 				e.Instances.Store(*instance.InstanceId, instance)
 				instanceIds = append(instanceIds, instance.InstanceId)
 			}
 		}
 	}
 
-	result := &ec2.CreateFleetOutput{Instances: []*ec2.CreateFleetInstance{{InstanceIds: instanceIds}}}
-	for _, pool := range skippedPools {
+	result := &ec2.CreateFleetOutput{Instances: []*ec2.CreateFleetInstance{{
+		InstanceIds: instanceIds,
+		LaunchTemplateAndOverrides: &ec2.LaunchTemplateAndOverridesResponse{
+			LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecification{
+				LaunchTemplateId:   input.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateId,
+				LaunchTemplateName: input.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName,
+				Version:            input.LaunchTemplateConfigs[0].LaunchTemplateSpecification.Version,
+			},
+			Overrides: &ec2.FleetLaunchTemplateOverrides{
+				InstanceType:     input.LaunchTemplateConfigs[0].Overrides[0].InstanceType,
+				SubnetId:         input.LaunchTemplateConfigs[0].Overrides[0].SubnetId,
+				AvailabilityZone: input.LaunchTemplateConfigs[0].Overrides[0].AvailabilityZone,
+			},
+		},
+	}}}
+	for i, pool := range skippedPools {
 		result.Errors = append(result.Errors, &ec2.CreateFleetError{
-			ErrorCode: aws.String("InsufficientInstanceCapacity"),
+			ErrorCode: aws.String(lo.Ternary(pool.ErrorCode != "", pool.ErrorCode, "InsufficientInstanceCapacity")),
 			LaunchTemplateAndOverrides: &ec2.LaunchTemplateAndOverridesResponse{
 				LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecification{
 					LaunchTemplateId:   input.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateId,
@@ -170,6 +231,7 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 				},
 				Overrides: &ec2.FleetLaunchTemplateOverrides{
 					InstanceType:     aws.String(pool.InstanceType),
+					SubnetId:         skippedSubnets[i],
 					AvailabilityZone: aws.String(pool.Zone),
 				},
 			},
@@ -203,34 +265,197 @@ func (e *EC2API) CreateLaunchTemplateWithContext(_ context.Context, input *ec2.C
 		return nil, e.NextError.Get()
 	}
 	e.CalledWithCreateLaunchTemplateInput.Add(input)
-	launchTemplate := &ec2.LaunchTemplate{LaunchTemplateName: input.LaunchTemplateName}
-	e.LaunchTemplates.Store(input.LaunchTemplateName, launchTemplate)
+	launchTemplate := &ec2.LaunchTemplate{
+		LaunchTemplateId:     aws.String(LaunchTemplateID()),
+		LaunchTemplateName:   input.LaunchTemplateName,
+		CreateTime:           aws.Time(time.Now()),
+		DefaultVersionNumber: aws.Int64(1),
+		LatestVersionNumber:  aws.Int64(1),
+	}
+	for _, tagSpecification := range input.TagSpecifications {
+		launchTemplate.Tags = append(launchTemplate.Tags, tagSpecification.Tags...)
+	}
+	e.LaunchTemplates.Store(aws.StringValue(input.LaunchTemplateName), launchTemplate)
+	e.LaunchTemplateVersions.Store(aws.StringValue(input.LaunchTemplateName), []*ec2.LaunchTemplateVersion{{
+		LaunchTemplateId:   launchTemplate.LaunchTemplateId,
+		LaunchTemplateName: launchTemplate.LaunchTemplateName,
+		VersionNumber:      aws.Int64(1),
+		VersionDescription: input.VersionDescription,
+		DefaultVersion:     aws.Bool(true),
+		CreateTime:         launchTemplate.CreateTime,
+	}})
 	return &ec2.CreateLaunchTemplateOutput{LaunchTemplate: launchTemplate}, nil
 }
 
+func (e *EC2API) CreateLaunchTemplateVersionWithContext(_ context.Context, input *ec2.CreateLaunchTemplateVersionInput, _ ...request.Option) (*ec2.CreateLaunchTemplateVersionOutput, error) {
+	if !e.NextError.IsNil() {
+		defer e.NextError.Reset()
+		return nil, e.NextError.Get()
+	}
+	e.CalledWithCreateLaunchTemplateVersionInput.Add(input)
+	value, ok := e.LaunchTemplates.Load(aws.StringValue(input.LaunchTemplateName))
+	if !ok {
+		return nil, awserr.New("InvalidLaunchTemplateName.NotFoundException", "not found", nil)
+	}
+	launchTemplate := value.(*ec2.LaunchTemplate)
+	launchTemplate.LatestVersionNumber = aws.Int64(aws.Int64Value(launchTemplate.LatestVersionNumber) + 1)
+	version := &ec2.LaunchTemplateVersion{
+		LaunchTemplateId:   launchTemplate.LaunchTemplateId,
+		LaunchTemplateName: launchTemplate.LaunchTemplateName,
+		VersionNumber:      launchTemplate.LatestVersionNumber,
+		VersionDescription: input.VersionDescription,
+		DefaultVersion:     aws.Bool(false),
+		CreateTime:         aws.Time(time.Now()),
+	}
+	versions, _ := e.LaunchTemplateVersions.Load(aws.StringValue(input.LaunchTemplateName))
+	e.LaunchTemplateVersions.Store(aws.StringValue(input.LaunchTemplateName), append(versions.([]*ec2.LaunchTemplateVersion), version))
+	return &ec2.CreateLaunchTemplateVersionOutput{LaunchTemplateVersion: version}, nil
+}
+
+// DescribeLaunchTemplateVersionsPagesWithContext returns the versions of the launch template with the name in the input
+func (e *EC2API) DescribeLaunchTemplateVersionsPagesWithContext(_ context.Context, input *ec2.DescribeLaunchTemplateVersionsInput, fn func(*ec2.DescribeLaunchTemplateVersionsOutput, bool) bool, _ ...request.Option) error {
+	if !e.NextError.IsNil() {
+		defer e.NextError.Reset()
+		return e.NextError.Get()
+	}
+	versions, ok := e.LaunchTemplateVersions.Load(aws.StringValue(input.LaunchTemplateName))
+	if !ok {
+		return awserr.New("InvalidLaunchTemplateName.NotFoundException", "not found", nil)
+	}
+	fn(&ec2.DescribeLaunchTemplateVersionsOutput{LaunchTemplateVersions: versions.([]*ec2.LaunchTemplateVersion)}, false)
+	return nil
+}
+
+func (e *EC2API) DeleteLaunchTemplateVersionsWithContext(_ context.Context, input *ec2.DeleteLaunchTemplateVersionsInput, _ ...request.Option) (*ec2.DeleteLaunchTemplateVersionsOutput, error) {
+	if !e.DeleteLaunchTemplateVersionsBehavior.Error.IsNil() {
+		return e.DeleteLaunchTemplateVersionsBehavior.Invoke(input)
+	}
+	if versions, ok := e.LaunchTemplateVersions.Load(aws.StringValue(input.LaunchTemplateName)); ok {
+		e.LaunchTemplateVersions.Store(aws.StringValue(input.LaunchTemplateName), lo.Reject(versions.([]*ec2.LaunchTemplateVersion), func(version *ec2.LaunchTemplateVersion, _ int) bool {
+			return lo.Contains(aws.StringValueSlice(input.Versions), fmt.Sprint(aws.Int64Value(version.VersionNumber)))
+		}))
+	}
+	return e.DeleteLaunchTemplateVersionsBehavior.Invoke(input)
+}
+
+func (e *EC2API) DeleteLaunchTemplateWithContext(_ context.Context, input *ec2.DeleteLaunchTemplateInput, _ ...request.Option) (*ec2.DeleteLaunchTemplateOutput, error) {
+	if !e.DeleteLaunchTemplateBehavior.Error.IsNil() {
+		return e.DeleteLaunchTemplateBehavior.Invoke(input)
+	}
+	e.LaunchTemplates.Range(func(key, value interface{}) bool {
+		if aws.StringValue(value.(*ec2.LaunchTemplate).LaunchTemplateId) == aws.StringValue(input.LaunchTemplateId) {
+			e.LaunchTemplates.Delete(key)
+			e.LaunchTemplateVersions.Delete(key)
+		}
+		return true
+	})
+	return e.DeleteLaunchTemplateBehavior.Invoke(input)
+}
+
 func (e *EC2API) CreateTagsWithContext(_ context.Context, input *ec2.CreateTagsInput, _ ...request.Option) (*ec2.CreateTagsOutput, error) {
-	// Update passed in instances with the passed tags
+	// Update passed in instances and other resources with the passed tags
+	newTagKeys := sets.New[string](lo.Map(input.Tags, func(t *ec2.Tag, _ int) string { return aws.StringValue(t.Key) })...)
 	for _, id := range input.Resources {
 		raw, ok := e.Instances.Load(aws.StringValue(id))
+		// Instances that are launched by the fake aren't named with the instance id prefix
+		if !ok && !strings.HasPrefix(aws.StringValue(id), "i-") {
+			raw, _ := e.ResourceTags.LoadOrStore(aws.StringValue(id), []*ec2.Tag{})
+			tags := lo.Reject(raw.([]*ec2.Tag), func(t *ec2.Tag, _ int) bool { return newTagKeys.Has(aws.StringValue(t.Key)) })
+			e.ResourceTags.Store(aws.StringValue(id), append(tags, input.Tags...))
+			continue
+		}
 		if !ok {
 			return nil, fmt.Errorf("instance with id '%s' does not exist", aws.StringValue(id))
 		}
 		instance := raw.(*ec2.Instance)
 
 		// Upsert any tags that have the same key
-		newTagKeys := sets.New[string](lo.Map(input.Tags, func(t *ec2.Tag, _ int) string { return aws.StringValue(t.Key) })...)
-		instance.Tags = lo.Filter(input.Tags, func(t *ec2.Tag, _ int) bool { return newTagKeys.Has(aws.StringValue(t.Key)) })
+		instance.Tags = lo.Reject(instance.Tags, func(t *ec2.Tag, _ int) bool { return newTagKeys.Has(aws.StringValue(t.Key)) })
 		instance.Tags = append(instance.Tags, input.Tags...)
 	}
 	return e.CreateTagsBehavior.Invoke(input)
 }
 
+func (e *EC2API) DeleteTagsWithContext(_ context.Context, input *ec2.DeleteTagsInput, _ ...request.Option) (*ec2.DeleteTagsOutput, error) {
+	if !e.DeleteTagsBehavior.Error.IsNil() {
+		return e.DeleteTagsBehavior.Invoke(input)
+	}
+	// Remove the passed tag keys from the passed in instances
+	deletedTagKeys := sets.New[string](lo.Map(input.Tags, func(t *ec2.Tag, _ int) string { return aws.StringValue(t.Key) })...)
+	for _, id := range input.Resources {
+		if raw, ok := e.Instances.Load(aws.StringValue(id)); ok {
+			instance := raw.(*ec2.Instance)
+			instance.Tags = lo.Reject(instance.Tags, func(t *ec2.Tag, _ int) bool { return deletedTagKeys.Has(aws.StringValue(t.Key)) })
+		}
+		if raw, ok := e.ResourceTags.Load(aws.StringValue(id)); ok {
+			e.ResourceTags.Store(aws.StringValue(id), lo.Reject(raw.([]*ec2.Tag), func(t *ec2.Tag, _ int) bool { return deletedTagKeys.Has(aws.StringValue(t.Key)) }))
+		}
+	}
+	return e.DeleteTagsBehavior.Invoke(input)
+}
+
+func (e *EC2API) DescribeTagsPagesWithContext(_ context.Context, input *ec2.DescribeTagsInput, fn func(*ec2.DescribeTagsOutput, bool) bool, _ ...request.Option) error {
+	output := &ec2.DescribeTagsOutput{}
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) != "resource-id" {
+			continue
+		}
+		for _, id := range filter.Values {
+			var tags []*ec2.Tag
+			if raw, ok := e.Instances.Load(aws.StringValue(id)); ok {
+				tags = raw.(*ec2.Instance).Tags
+			}
+			if raw, ok := e.ResourceTags.Load(aws.StringValue(id)); ok {
+				tags = raw.([]*ec2.Tag)
+			}
+			for _, tag := range tags {
+				output.Tags = append(output.Tags, &ec2.TagDescription{ResourceId: id, Key: tag.Key, Value: tag.Value})
+			}
+		}
+	}
+	output, err := e.DescribeTagsBehavior.WithDefault(output).Invoke(input)
+	if err != nil {
+		return err
+	}
+	fn(output, false)
+	return nil
+}
+
+func (e *EC2API) StartInstancesWithContext(_ context.Context, input *ec2.StartInstancesInput, _ ...request.Option) (*ec2.StartInstancesOutput, error) {
+	if !e.StartInstancesBehavior.Error.IsNil() || !e.StartInstancesBehavior.Output.IsNil() {
+		return e.StartInstancesBehavior.Invoke(input)
+	}
+	e.setInstanceStates(input.InstanceIds, ec2.InstanceStateNamePending)
+	return e.StartInstancesBehavior.Invoke(input)
+}
+
+func (e *EC2API) StopInstancesWithContext(_ context.Context, input *ec2.StopInstancesInput, _ ...request.Option) (*ec2.StopInstancesOutput, error) {
+	if !e.StopInstancesBehavior.Error.IsNil() || !e.StopInstancesBehavior.Output.IsNil() {
+		return e.StopInstancesBehavior.Invoke(input)
+	}
+	e.setInstanceStates(input.InstanceIds, ec2.InstanceStateNameStopping)
+	return e.StopInstancesBehavior.Invoke(input)
+}
+
+func (e *EC2API) setInstanceStates(ids []*string, state string) {
+	for _, id := range ids {
+		if raw, ok := e.Instances.Load(aws.StringValue(id)); ok {
+			raw.(*ec2.Instance).State = &ec2.InstanceState{Name: aws.String(state)}
+		}
+	}
+}
+
 func (e *EC2API) DescribeInstancesWithContext(_ context.Context, input *ec2.DescribeInstancesInput, _ ...request.Option) (*ec2.DescribeInstancesOutput, error) {
 	if !e.DescribeInstancesBehavior.Error.IsNil() || !e.DescribeInstancesBehavior.Output.IsNil() {
 		return e.DescribeInstancesBehavior.Invoke(input)
 	}
 	var instances []*ec2.Instance
-	for _, instanceID := range input.InstanceIds {
+	instanceIDs := input.InstanceIds
+	// Instances can also be described by their ids with a filter, which ignores the ids of instances that don't exist
+	if filter, ok := lo.Find(input.Filters, func(f *ec2.Filter) bool { return aws.StringValue(f.Name) == "instance-id" }); ok {
+		instanceIDs = append(instanceIDs, filter.Values...)
+	}
+	for _, instanceID := range instanceIDs {
 		instance, _ := e.Instances.Load(*instanceID)
 		if instance == nil {
 			continue
@@ -313,6 +538,25 @@ func (e *EC2API) DescribeLaunchTemplatesWithContext(_ context.Context, input *ec
 	return output, nil
 }
 
+// DescribeLaunchTemplatesPagesWithContext returns every launch template, as the fake doesn't tag them
+func (e *EC2API) DescribeLaunchTemplatesPagesWithContext(_ context.Context, _ *ec2.DescribeLaunchTemplatesInput, fn func(*ec2.DescribeLaunchTemplatesOutput, bool) bool, _ ...request.Option) error {
+	if !e.NextError.IsNil() {
+		defer e.NextError.Reset()
+		return e.NextError.Get()
+	}
+	if !e.DescribeLaunchTemplatesOutput.IsNil() {
+		fn(e.DescribeLaunchTemplatesOutput.Clone(), false)
+		return nil
+	}
+	output := &ec2.DescribeLaunchTemplatesOutput{}
+	e.LaunchTemplates.Range(func(_, value interface{}) bool {
+		output.LaunchTemplates = append(output.LaunchTemplates, value.(*ec2.LaunchTemplate))
+		return true
+	})
+	fn(output, false)
+	return nil
+}
+
 func (e *EC2API) DescribeSubnetsWithContext(ctx context.Context, input *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
 	if !e.NextError.IsNil() {
 		defer e.NextError.Reset()
@@ -409,6 +653,41 @@ func (e *EC2API) DescribeAvailabilityZonesWithContext(context.Context, *ec2.Desc
 	}}, nil
 }
 
+func (e *EC2API) GetSpotPlacementScoresPagesWithContext(_ context.Context, input *ec2.GetSpotPlacementScoresInput, fn func(*ec2.GetSpotPlacementScoresOutput, bool) bool, _ ...request.Option) error {
+	if !e.GetSpotPlacementScoresBehavior.Error.IsNil() || !e.GetSpotPlacementScoresBehavior.Output.IsNil() {
+		output, err := e.GetSpotPlacementScoresBehavior.Invoke(input)
+		if err != nil {
+			return err
+		}
+		fn(output, false)
+		return nil
+	}
+	zonalScores := map[string]int64{}
+	for _, instanceType := range input.InstanceTypes {
+		scores, ok := e.SpotPlacementScores.Load(aws.StringValue(instanceType))
+		if !ok {
+			continue
+		}
+		for zoneID, score := range scores.(map[string]int64) {
+			zonalScores[zoneID] = lo.Max([]int64{zonalScores[zoneID], score})
+		}
+	}
+	output := &ec2.GetSpotPlacementScoresOutput{}
+	for zoneID, score := range zonalScores {
+		output.SpotPlacementScores = append(output.SpotPlacementScores, &ec2.SpotPlacementScore{
+			AvailabilityZoneId: aws.String(zoneID),
+			Region:             input.RegionNames[0],
+			Score:              aws.Int64(score),
+		})
+	}
+	output, err := e.GetSpotPlacementScoresBehavior.WithDefault(output).Invoke(input)
+	if err != nil {
+		return err
+	}
+	fn(output, false)
+	return nil
+}
+
 func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2.DescribeInstanceTypesInput, fn func(*ec2.DescribeInstanceTypesOutput, bool) bool, _ ...request.Option) error {
 	if !e.NextError.IsNil() {
 		defer e.NextError.Reset()
@@ -17,6 +17,7 @@ package fake
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -29,19 +30,37 @@ type PricingAPI struct {
 	PricingBehavior
 }
 type PricingBehavior struct {
-	NextError         AtomicError
-	GetProductsOutput AtomicPtr[pricing.GetProductsOutput]
+	NextError                  AtomicError
+	GetProductsOutput          AtomicPtr[pricing.GetProductsOutput]
+	CalledWithGetProductsInput AtomicPtrSlice[pricing.GetProductsInput]
+	// OperatingSystemErrors maps the values of the operatingSystem filter to the errors that requests with the filter
+	// fail with
+	OperatingSystemErrors sync.Map
 }
 
 func (p *PricingAPI) Reset() {
 	p.NextError.Reset()
 	p.GetProductsOutput.Reset()
+	p.CalledWithGetProductsInput.Reset()
+	p.OperatingSystemErrors.Range(func(k, _ any) bool {
+		p.OperatingSystemErrors.Delete(k)
+		return true
+	})
 }
 
 func (p *PricingAPI) GetProductsPagesWithContext(_ aws.Context, inp *pricing.GetProductsInput, fn func(*pricing.GetProductsOutput, bool) bool, opts ...request.Option) error {
+	p.CalledWithGetProductsInput.Add(inp)
 	if !p.NextError.IsNil() {
 		return p.NextError.Get()
 	}
+	for _, filter := range inp.Filters {
+		if aws.StringValue(filter.Field) != "operatingSystem" {
+			continue
+		}
+		if err, ok := p.OperatingSystemErrors.Load(aws.StringValue(filter.Value)); ok {
+			return err.(error)
+		}
+	}
 	if !p.GetProductsOutput.IsNil() {
 		fn(p.GetProductsOutput.Clone(), false)
 		return nil
@@ -0,0 +1,89 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/aws/aws-sdk-go/service/servicequotas/servicequotasiface"
+)
+
+type ServiceQuotasAPI struct {
+	servicequotasiface.ServiceQuotasAPI
+	ServiceQuotasBehavior
+}
+
+// ServiceQuotasBehavior must be reset between tests otherwise tests will
+// pollute each other.
+type ServiceQuotasBehavior struct {
+	NextError AtomicError
+	// AppliedQuotas and DefaultQuotas map quota codes to their values. Quotas that aren't set don't exist.
+	AppliedQuotas sync.Map
+	DefaultQuotas sync.Map
+}
+
+func (s *ServiceQuotasAPI) Reset() {
+	s.NextError.Reset()
+	s.AppliedQuotas.Range(func(k, _ any) bool {
+		s.AppliedQuotas.Delete(k)
+		return true
+	})
+	s.DefaultQuotas.Range(func(k, _ any) bool {
+		s.DefaultQuotas.Delete(k)
+		return true
+	})
+}
+
+func (s *ServiceQuotasAPI) GetServiceQuotaWithContext(_ aws.Context, input *servicequotas.GetServiceQuotaInput, _ ...request.Option) (*servicequotas.GetServiceQuotaOutput, error) {
+	if !s.NextError.IsNil() {
+		defer s.NextError.Reset()
+		return nil, s.NextError.Get()
+	}
+	value, ok := s.AppliedQuotas.Load(aws.StringValue(input.QuotaCode))
+	if !ok {
+		return nil, noSuchQuota(input.ServiceCode, input.QuotaCode)
+	}
+	return &servicequotas.GetServiceQuotaOutput{Quota: &servicequotas.ServiceQuota{
+		ServiceCode: input.ServiceCode,
+		QuotaCode:   input.QuotaCode,
+		Value:       aws.Float64(value.(float64)),
+	}}, nil
+}
+
+func (s *ServiceQuotasAPI) GetAWSDefaultServiceQuotaWithContext(_ aws.Context, input *servicequotas.GetAWSDefaultServiceQuotaInput, _ ...request.Option) (*servicequotas.GetAWSDefaultServiceQuotaOutput, error) {
+	if !s.NextError.IsNil() {
+		defer s.NextError.Reset()
+		return nil, s.NextError.Get()
+	}
+	value, ok := s.DefaultQuotas.Load(aws.StringValue(input.QuotaCode))
+	if !ok {
+		return nil, noSuchQuota(input.ServiceCode, input.QuotaCode)
+	}
+	return &servicequotas.GetAWSDefaultServiceQuotaOutput{Quota: &servicequotas.ServiceQuota{
+		ServiceCode: input.ServiceCode,
+		QuotaCode:   input.QuotaCode,
+		Value:       aws.Float64(value.(float64)),
+	}}, nil
+}
+
+func noSuchQuota(serviceCode, quotaCode *string) error {
+	return awserr.New(servicequotas.ErrCodeNoSuchResourceException,
+		fmt.Sprintf("The request failed because the specified service %s quota %s doesn't exist", aws.StringValue(serviceCode), aws.StringValue(quotaCode)), nil)
+}
@@ -36,6 +36,10 @@ func ProviderID(id string) string {
 	return fmt.Sprintf("aws:///%s/%s", defaultRegion, id)
 }
 
+func LaunchTemplateID() string {
+	return fmt.Sprintf("lt-%s", randomdata.Alphanumeric(17))
+}
+
 func ImageID() string {
 	return fmt.Sprintf("ami-%s", randomdata.Alphanumeric(17))
 }
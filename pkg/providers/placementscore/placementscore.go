@@ -0,0 +1,217 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package placementscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+
+	awscache "github.com/aws/karpenter/pkg/cache"
+)
+
+// updatePeriod is how often we score the tracked configurations whose scores have expired
+const updatePeriod = time.Minute
+
+const (
+	// maxConfigurations bounds the distinct request configurations that are tracked at once, so that they stay within
+	// the configurations that GetSpotPlacementScores permits per day
+	maxConfigurations = 10
+	// maxConfigurationInstanceTypes bounds the instance types of a configuration to the cheapest candidates, which
+	// spot launches are most likely to be fulfilled with
+	maxConfigurationInstanceTypes = 10
+)
+
+// backoffErrorCodes are returned when the account has scored too many distinct request configurations recently or
+// the API is throttled, after which no configurations are scored until the backoff has passed
+var backoffErrorCodes = sets.NewString("MaxConfigLimitExceeded", "RequestLimitExceeded", "Throttling")
+
+// Provider scores the likelihood of a spot request succeeding by zone for the provisioners that recently launched spot
+// instances. GetSpotPlacementScores only permits a small number of distinct request configurations per day, so rather
+// than scoring the candidates of each launch, which change from launch to launch, each provisioner is scored as one
+// stable configuration of its cheapest candidates when it was first tracked. The instance types of a configuration
+// aren't changed while it's tracked, and at most maxConfigurations provisioners are tracked at once, so the
+// configurations only change as provisioners stop launching spot instances and their configurations expire. Scores
+// range from 1 to 10 and are only retrieved while a minimum spot placement score is configured, so provisioners stop
+// being scored once the setting is disabled.
+type Provider struct {
+	sync.Mutex
+	ec2api ec2iface.EC2API
+	region string
+	// key: provisioner name, value: map[zone]int64
+	scores *cache.Cache
+	// key: provisioner name, value: []string of instance types
+	tracked *cache.Cache
+	// trackingMu serializes tracking configurations without waiting for updates
+	trackingMu sync.Mutex
+	// key: zone id, value: zone name
+	zoneNames map[string]string
+	// backoff is the time that scoring was last backed off for, which doubles on each consecutive backoff
+	backoff      time.Duration
+	backoffUntil time.Time
+}
+
+func NewProvider(ctx context.Context, ec2api ec2iface.EC2API, region string, startAsync <-chan struct{}) *Provider {
+	p := &Provider{
+		ec2api:    ec2api,
+		region:    region,
+		scores:    cache.New(awscache.SpotPlacementScoresTTL, awscache.DefaultCleanupInterval),
+		tracked:   cache.New(awscache.SpotPlacementScoresTrackingTTL, awscache.DefaultCleanupInterval),
+		zoneNames: map[string]string{},
+	}
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named("placementscore"))
+	go func() {
+		// wait for leader election or to be signaled to exit
+		select {
+		case <-startAsync:
+		case <-ctx.Done():
+			return
+		}
+		for {
+			if err := p.Update(ctx); err != nil {
+				logging.FromContext(ctx).Errorf("updating spot placement scores, %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(updatePeriod):
+			}
+		}
+	}()
+	return p
+}
+
+// Scores returns the known spot placement scores by zone of the provisioner. A provisioner that isn't tracked yet is
+// tracked with the cheapest of its candidate instance types so that it's scored by subsequent updates, unless the
+// maximum number of configurations is already tracked. The configuration of a tracked provisioner is kept as is.
+func (p *Provider) Scores(provisionerName string, instanceTypes ...string) map[string]int64 {
+	p.trackingMu.Lock()
+	defer p.trackingMu.Unlock()
+	if item, ok := p.tracked.Get(provisionerName); ok {
+		// refresh the expiration of the configuration without changing it
+		p.tracked.SetDefault(provisionerName, item)
+	} else if len(instanceTypes) > 0 && len(p.tracked.Items()) < maxConfigurations {
+		if len(instanceTypes) > maxConfigurationInstanceTypes {
+			instanceTypes = instanceTypes[:maxConfigurationInstanceTypes]
+		}
+		p.tracked.SetDefault(provisionerName, sets.NewString(instanceTypes...).List())
+		// scores of a previous configuration of the provisioner don't describe the new one
+		p.scores.Delete(provisionerName)
+	}
+	if scores, ok := p.scores.Get(provisionerName); ok {
+		return scores.(map[string]int64)
+	}
+	return nil
+}
+
+// Update scores the tracked configurations whose scores have expired, unless scoring is backed off
+func (p *Provider) Update(ctx context.Context) error {
+	p.Lock()
+	defer p.Unlock()
+	if time.Now().Before(p.backoffUntil) {
+		return nil
+	}
+	var errs error
+	for provisionerName, item := range p.tracked.Items() {
+		if _, ok := p.scores.Get(provisionerName); ok {
+			continue
+		}
+		instanceTypes := item.Object.([]string)
+		scores, err := p.getScores(ctx, instanceTypes)
+		if err != nil {
+			var aerr awserr.Error
+			if errors.As(err, &aerr) && backoffErrorCodes.Has(aerr.Code()) {
+				p.backoff = lo.Clamp(2*p.backoff, updatePeriod, awscache.SpotPlacementScoresMaxBackoff)
+				p.backoffUntil = time.Now().Add(p.backoff)
+				logging.FromContext(ctx).With("backoff", p.backoff).Debugf("backing off spot placement scores, %s", aerr.Code())
+				return multierr.Append(errs, err)
+			}
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		p.backoff = 0
+		p.scores.SetDefault(provisionerName, scores)
+		logging.FromContext(ctx).With("provisioner", provisionerName, "instance-types", instanceTypes, "scores", scores).Debugf("discovered spot placement scores")
+	}
+	return errs
+}
+
+func (p *Provider) Reset() {
+	p.Lock()
+	defer p.Unlock()
+	p.scores.Flush()
+	p.tracked.Flush()
+	p.zoneNames = map[string]string{}
+	p.backoff = 0
+	p.backoffUntil = time.Time{}
+}
+
+// getScores returns the spot placement scores by zone of launching any of the instance types
+func (p *Provider) getScores(ctx context.Context, instanceTypes []string) (map[string]int64, error) {
+	scores := map[string]int64{}
+	if err := p.ec2api.GetSpotPlacementScoresPagesWithContext(ctx, &ec2.GetSpotPlacementScoresInput{
+		InstanceTypes:          aws.StringSlice(instanceTypes),
+		RegionNames:            aws.StringSlice([]string{p.region}),
+		SingleAvailabilityZone: aws.Bool(true),
+		TargetCapacity:         aws.Int64(1),
+	}, func(page *ec2.GetSpotPlacementScoresOutput, _ bool) bool {
+		for _, score := range page.SpotPlacementScores {
+			scores[aws.StringValue(score.AvailabilityZoneId)] = aws.Int64Value(score.Score)
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("getting spot placement scores for %d instance types, %w", len(instanceTypes), err)
+	}
+	zonalScores := map[string]int64{}
+	for zoneID, score := range scores {
+		zone, err := p.zoneName(ctx, zoneID)
+		if err != nil {
+			return nil, err
+		}
+		zonalScores[zone] = score
+	}
+	return zonalScores, nil
+}
+
+// zoneName returns the name of the zone with the provided id, as spot placement scores are reported by zone id
+func (p *Provider) zoneName(ctx context.Context, zoneID string) (string, error) {
+	if zone, ok := p.zoneNames[zoneID]; ok {
+		return zone, nil
+	}
+	output, err := p.ec2api.DescribeAvailabilityZonesWithContext(ctx, &ec2.DescribeAvailabilityZonesInput{})
+	if err != nil {
+		return "", fmt.Errorf("describing availability zones, %w", err)
+	}
+	for _, zone := range output.AvailabilityZones {
+		p.zoneNames[aws.StringValue(zone.ZoneId)] = aws.StringValue(zone.ZoneName)
+	}
+	zone, ok := p.zoneNames[zoneID]
+	if !ok {
+		return "", fmt.Errorf("zone id %s not found", zoneID)
+	}
+	return zone, nil
+}
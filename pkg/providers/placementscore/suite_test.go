@@ -0,0 +1,153 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package placementscore_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+
+	"github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/providers/placementscore"
+)
+
+var ctx context.Context
+var stop context.CancelFunc
+var fakeEC2API *fake.EC2API
+var placementScoreProvider *placementscore.Provider
+
+func TestAWS(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PlacementScore")
+}
+
+var _ = BeforeSuite(func() {
+	ctx, stop = context.WithCancel(ctx)
+	fakeEC2API = &fake.EC2API{}
+	placementScoreProvider = placementscore.NewProvider(ctx, fakeEC2API, "test-region", make(chan struct{}))
+})
+
+var _ = AfterSuite(func() {
+	stop()
+})
+
+var _ = BeforeEach(func() {
+	fakeEC2API.Reset()
+	placementScoreProvider.Reset()
+})
+
+var _ = Describe("PlacementScore", func() {
+	It("should score the candidates of a provisioner with a single request", func() {
+		fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 3, "testzone1b": 9})
+		fakeEC2API.SpotPlacementScores.Store("c5.large", map[string]int64{"testzone1a": 7})
+		Expect(placementScoreProvider.Scores("default", "m5.large", "c5.large")).To(BeEmpty())
+
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		Expect(placementScoreProvider.Scores("default", "m5.large", "c5.large")).To(Equal(map[string]int64{"test-zone-1a": 7, "test-zone-1b": 9}))
+		Expect(fakeEC2API.GetSpotPlacementScoresBehavior.Calls()).To(Equal(1))
+		input := fakeEC2API.GetSpotPlacementScoresBehavior.CalledWithInput.Pop()
+		Expect(aws.StringValueSlice(input.InstanceTypes)).To(Equal([]string{"c5.large", "m5.large"}))
+		Expect(aws.StringValueSlice(input.RegionNames)).To(ConsistOf("test-region"))
+		Expect(aws.BoolValue(input.SingleAvailabilityZone)).To(BeTrue())
+	})
+	It("should score each provisioner separately", func() {
+		fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 3})
+		fakeEC2API.SpotPlacementScores.Store("c5.large", map[string]int64{"testzone1a": 7})
+		placementScoreProvider.Scores("default", "m5.large")
+		placementScoreProvider.Scores("compute", "c5.large")
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		Expect(fakeEC2API.GetSpotPlacementScoresBehavior.Calls()).To(Equal(2))
+		Expect(placementScoreProvider.Scores("default")).To(Equal(map[string]int64{"test-zone-1a": 3}))
+		Expect(placementScoreProvider.Scores("compute")).To(Equal(map[string]int64{"test-zone-1a": 7}))
+	})
+	It("should only score the cheapest candidates of a provisioner", func() {
+		instanceTypes := lo.Times(20, func(i int) string { return fmt.Sprintf("m5.%02dxlarge", i) })
+		placementScoreProvider.Scores("default", instanceTypes...)
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		input := fakeEC2API.GetSpotPlacementScoresBehavior.CalledWithInput.Pop()
+		Expect(aws.StringValueSlice(input.InstanceTypes)).To(Equal(instanceTypes[:10]))
+	})
+	It("should not change the configuration of a tracked provisioner", func() {
+		fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 3})
+		placementScoreProvider.Scores("default", "m5.large")
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		Expect(placementScoreProvider.Scores("default", "m5.xlarge", "c5.large")).To(Equal(map[string]int64{"test-zone-1a": 3}))
+
+		placementScoreProvider.Reset()
+		placementScoreProvider.Scores("default", "m5.large")
+		placementScoreProvider.Scores("default", "m5.xlarge")
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		input := fakeEC2API.GetSpotPlacementScoresBehavior.CalledWithInput.Pop()
+		Expect(aws.StringValueSlice(input.InstanceTypes)).To(Equal([]string{"m5.large"}))
+	})
+	It("should not track more than the maximum number of configurations", func() {
+		for i := 0; i < 15; i++ {
+			placementScoreProvider.Scores(fmt.Sprintf("provisioner-%d", i), "m5.large")
+		}
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		Expect(fakeEC2API.GetSpotPlacementScoresBehavior.Calls()).To(Equal(10))
+	})
+	It("should not score provisioners that aren't tracked", func() {
+		fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 3})
+		placementScoreProvider.Scores("default")
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		Expect(fakeEC2API.GetSpotPlacementScoresBehavior.Calls()).To(BeZero())
+	})
+	It("should cache the scores", func() {
+		fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 3})
+		placementScoreProvider.Scores("default", "m5.large")
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		placementScoreProvider.Scores("default", "m5.large")
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		Expect(fakeEC2API.GetSpotPlacementScoresBehavior.Calls()).To(Equal(1))
+	})
+	It("should retry configurations that failed to be scored", func() {
+		fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 3})
+		fakeEC2API.GetSpotPlacementScoresBehavior.Error.Set(fmt.Errorf("internal error"))
+		placementScoreProvider.Scores("default", "m5.large")
+		Expect(placementScoreProvider.Update(ctx)).ToNot(Succeed())
+		Expect(placementScoreProvider.Scores("default")).To(BeEmpty())
+
+		fakeEC2API.GetSpotPlacementScoresBehavior.Error.Reset()
+		Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+		Expect(placementScoreProvider.Scores("default")).To(HaveKeyWithValue("test-zone-1a", BeNumerically("==", 3)))
+	})
+	DescribeTable("should back off after the request configuration limit is exceeded or the API is throttled",
+		func(code string) {
+			fakeEC2API.SpotPlacementScores.Store("m5.large", map[string]int64{"testzone1a": 3})
+			fakeEC2API.GetSpotPlacementScoresBehavior.Error.Set(awserr.New(code, "", nil))
+			placementScoreProvider.Scores("default", "m5.large")
+			placementScoreProvider.Scores("compute", "c5.large")
+			Expect(placementScoreProvider.Update(ctx)).ToNot(Succeed())
+			Expect(fakeEC2API.GetSpotPlacementScoresBehavior.Calls()).To(Equal(1))
+
+			fakeEC2API.GetSpotPlacementScoresBehavior.Error.Reset()
+			Expect(placementScoreProvider.Update(ctx)).To(Succeed())
+			Expect(fakeEC2API.GetSpotPlacementScoresBehavior.Calls()).To(Equal(1))
+			Expect(placementScoreProvider.Scores("default")).To(BeEmpty())
+		},
+		Entry("MaxConfigLimitExceeded", "MaxConfigLimitExceeded"),
+		Entry("RequestLimitExceeded", "RequestLimitExceeded"),
+	)
+})
@@ -0,0 +1,53 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter-core/pkg/metrics"
+)
+
+const (
+	quotaSubsystem           = "service_quotas"
+	capacityTypeLabel        = "capacity_type"
+	instanceFamilyClassLabel = "instance_family_class"
+)
+
+var (
+	vCPULimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: quotaSubsystem,
+			Name:      "vcpu_limit",
+			Help:      "The EC2 vCPU quota. Labeled by capacity type and instance family class.",
+		},
+		[]string{capacityTypeLabel, instanceFamilyClassLabel},
+	)
+	vCPUHeadroom = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: quotaSubsystem,
+			Name:      "vcpu_headroom",
+			Help:      "The vCPUs that can still be launched before exceeding the EC2 vCPU quota, given the instances launched by Karpenter. Labeled by capacity type and instance family class.",
+		},
+		[]string{capacityTypeLabel, instanceFamilyClassLabel},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(vCPULimit, vCPUHeadroom)
+}
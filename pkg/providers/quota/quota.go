@@ -0,0 +1,363 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/aws/aws-sdk-go/service/servicequotas/servicequotasiface"
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/utils/pretty"
+	"github.com/aws/karpenter/pkg/apis/settings"
+	awscache "github.com/aws/karpenter/pkg/cache"
+	"github.com/aws/karpenter/pkg/utils"
+)
+
+const (
+	serviceCode = "ec2"
+	limitsKey   = "limits"
+	usageKey    = "usage"
+
+	// updatePeriod is how often we refresh the quotas and usage whose refresh is due
+	updatePeriod = time.Minute
+)
+
+// vCPUQuotaCodes are the Service Quotas codes of the EC2 vCPU quotas for each capacity type and instance family class
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-resource-limits.html
+var vCPUQuotaCodes = map[string]map[string]string{
+	v1alpha5.CapacityTypeOnDemand: {
+		"standard": "L-1216C47A",
+		"f":        "L-74FC7D96",
+		"g":        "L-DB2E81BA",
+		"inf":      "L-1945791B",
+		"p":        "L-417A185B",
+		"x":        "L-7295265B",
+		"dl":       "L-6E869C2A",
+		"trn":      "L-2C3B7624",
+		"hpc":      "L-F7808C92",
+		"u":        "L-43DA4232",
+	},
+	v1alpha5.CapacityTypeSpot: {
+		"standard": "L-34B43A08",
+		"f":        "L-88CF9481",
+		"g":        "L-3819A6DF",
+		"inf":      "L-B5D1601B",
+		"p":        "L-7212CCBC",
+		"x":        "L-E3A00192",
+		"dl":       "L-85EED4F7",
+		"trn":      "L-6B0D517C",
+	},
+}
+
+// bucket is a set of instance types that share a vCPU quota
+type bucket struct {
+	capacityType string
+	class        string
+}
+
+// Provider tracks the EC2 vCPU quotas of the account and the vCPUs of the instances launched by Karpenter so that
+// launches that would exceed a quota can be avoided. Only the cluster's own instances count towards the usage, so
+// the headroom is an upper bound if other workloads share the account. The quotas and usage are refreshed in the
+// background, so that reading the headroom never waits on Service Quotas or EC2.
+type Provider struct {
+	sync.RWMutex
+	servicequotasapi servicequotasiface.ServiceQuotasAPI
+	ec2api           ec2iface.EC2API
+	// cache tracks when the limits (key: limitsKey) and usage (key: usageKey) were last refreshed
+	cache    *cache.Cache
+	cm       *pretty.ChangeMonitor
+	limits   map[bucket]int64
+	usage    map[bucket]int64
+	inflight map[bucket]int64
+	// thresholds tracks the vCPUs of the instance types that were checked against the headroom of each bucket
+	thresholds map[bucket]sets.Int64
+	// SeqNum is a monotonically increasing change counter that is incremented whenever a quota becomes known or unknown,
+	// or its headroom crosses the vCPUs of an instance type that was checked against it, which are the only changes
+	// that change whether an instance type exceeds a quota
+	SeqNum uint64
+}
+
+func NewProvider(ctx context.Context, servicequotasapi servicequotasiface.ServiceQuotasAPI, ec2api ec2iface.EC2API, startAsync <-chan struct{}) *Provider {
+	p := &Provider{
+		servicequotasapi: servicequotasapi,
+		ec2api:           ec2api,
+		cache:            cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval),
+		cm:               pretty.NewChangeMonitor(),
+		limits:           map[bucket]int64{},
+		usage:            map[bucket]int64{},
+		inflight:         map[bucket]int64{},
+		thresholds:       map[bucket]sets.Int64{},
+	}
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named("quota"))
+	go func() {
+		// wait for leader election or to be signaled to exit
+		select {
+		case <-startAsync:
+		case <-ctx.Done():
+			return
+		}
+		for {
+			// Quotas are best effort, so offerings are only limited by the quotas that could be retrieved
+			if err := p.Update(ctx); err != nil && p.cm.HasChanged("error", err.Error()) {
+				logging.FromContext(ctx).Errorf("updating vcpu quotas, %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(updatePeriod):
+			}
+		}
+	}()
+	return p
+}
+
+// Update refreshes the vCPU quotas and the vCPUs of the running instances if they haven't been refreshed recently. The
+// APIs are called without holding the lock, so that the headroom can be read while they're refreshed.
+func (p *Provider) Update(ctx context.Context) error {
+	var errs error
+	if _, ok := p.cache.Get(limitsKey); !ok {
+		// Failures are retried after the default TTL rather than on every call, as the account may not permit Service Quotas lookups
+		p.cache.SetDefault(limitsKey, struct{}{})
+		if limits, err := p.getLimits(ctx); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("getting vcpu quotas, %w", err))
+		} else {
+			p.cache.Set(limitsKey, struct{}{}, awscache.ServiceQuotasTTL)
+			if p.cm.HasChanged(limitsKey, limits) {
+				logging.FromContext(ctx).With("quotas", len(limits)).Debugf("discovered vcpu quotas")
+			}
+			p.Lock()
+			before := p.headrooms()
+			p.limits = limits
+			changed := p.changed(before, p.headrooms())
+			p.Unlock()
+			if changed {
+				atomic.AddUint64(&p.SeqNum, 1)
+			}
+		}
+	}
+	if _, ok := p.cache.Get(usageKey); !ok {
+		p.cache.SetDefault(usageKey, struct{}{})
+		p.RLock()
+		inflight := lo.Assign(p.inflight)
+		p.RUnlock()
+		if usage, err := p.getUsage(ctx); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("getting vcpu usage, %w", err))
+		} else {
+			p.Lock()
+			before := p.headrooms()
+			// Freshly described instances already account for the launches that were in-flight before they were
+			// described, but not for those reserved while they were described
+			p.usage = usage
+			for b, vcpus := range inflight {
+				p.inflight[b] -= vcpus
+			}
+			changed := p.changed(before, p.headrooms())
+			p.Unlock()
+			if changed {
+				atomic.AddUint64(&p.SeqNum, 1)
+			}
+		}
+	}
+	p.RLock()
+	p.updateMetrics()
+	p.RUnlock()
+	return errs
+}
+
+// Headroom returns the number of vCPUs of the instance type's class that can still be launched for the capacity type.
+// It returns false if the quota is unknown.
+func (p *Provider) Headroom(capacityType, instanceType string) (int64, bool) {
+	p.RLock()
+	defer p.RUnlock()
+	return p.headroom(bucket{capacityType: capacityType, class: utils.InstanceFamilyClass(instanceType)})
+}
+
+// Exceeds returns the number of vCPUs of the instance type's class that can still be launched for the capacity type,
+// and whether launching the vCPUs of the instance type would exceed it. It returns false if the quota is unknown.
+func (p *Provider) Exceeds(capacityType, instanceType string, vcpus int64) (int64, bool) {
+	p.Lock()
+	defer p.Unlock()
+	b := bucket{capacityType: capacityType, class: utils.InstanceFamilyClass(instanceType)}
+	if _, ok := p.thresholds[b]; !ok {
+		p.thresholds[b] = sets.NewInt64()
+	}
+	p.thresholds[b].Insert(vcpus)
+	headroom, ok := p.headroom(b)
+	return headroom, ok && vcpus > headroom
+}
+
+// Reserve records the vCPUs of a launched instance until the usage is refreshed
+func (p *Provider) Reserve(capacityType, instanceType string, vcpus int64) {
+	p.Lock()
+	defer p.Unlock()
+	before := p.headrooms()
+	p.inflight[bucket{capacityType: capacityType, class: utils.InstanceFamilyClass(instanceType)}] += vcpus
+	if p.changed(before, p.headrooms()) {
+		atomic.AddUint64(&p.SeqNum, 1)
+	}
+	p.updateMetrics()
+}
+
+func (p *Provider) Reset() {
+	p.Lock()
+	defer p.Unlock()
+	p.cache.Flush()
+	p.limits = map[bucket]int64{}
+	p.usage = map[bucket]int64{}
+	p.inflight = map[bucket]int64{}
+	p.thresholds = map[bucket]sets.Int64{}
+}
+
+func (p *Provider) headroom(b bucket) (int64, bool) {
+	limit, ok := p.limits[b]
+	if !ok {
+		return 0, false
+	}
+	return limit - p.usage[b] - p.inflight[b], true
+}
+
+// headrooms returns the headroom of each bucket whose quota is known
+func (p *Provider) headrooms() map[bucket]int64 {
+	return lo.MapValues(p.limits, func(_ int64, b bucket) int64 {
+		headroom, _ := p.headroom(b)
+		return headroom
+	})
+}
+
+// changed returns whether a quota became known or unknown between the headrooms, or whether an instance type that was
+// checked against a quota fits into one of its headrooms but not into the other
+func (p *Provider) changed(before, after map[bucket]int64) bool {
+	for _, b := range lo.Union(lo.Keys(before), lo.Keys(after)) {
+		from, fromOK := before[b]
+		to, toOK := after[b]
+		if fromOK != toOK {
+			return true
+		}
+		low, high := lo.Min([]int64{from, to}), lo.Max([]int64{from, to})
+		if lo.SomeBy(p.thresholds[b].UnsortedList(), func(vcpus int64) bool { return vcpus > low && vcpus <= high }) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Provider) updateMetrics() {
+	for b, limit := range p.limits {
+		headroom, _ := p.headroom(b)
+		vCPULimit.WithLabelValues(b.capacityType, b.class).Set(float64(limit))
+		vCPUHeadroom.WithLabelValues(b.capacityType, b.class).Set(float64(headroom))
+	}
+}
+
+func (p *Provider) getLimits(ctx context.Context) (map[bucket]int64, error) {
+	limits := map[bucket]int64{}
+	for capacityType, codes := range vCPUQuotaCodes {
+		for class, code := range codes {
+			value, ok, err := p.getQuotaValue(ctx, code)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				limits[bucket{capacityType: capacityType, class: class}] = int64(value)
+			}
+		}
+	}
+	return limits, nil
+}
+
+// getQuotaValue returns the applied value of the quota, or its default value if it hasn't been changed for the account
+func (p *Provider) getQuotaValue(ctx context.Context, code string) (float64, bool, error) {
+	out, err := p.servicequotasapi.GetServiceQuotaWithContext(ctx, &servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String(serviceCode),
+		QuotaCode:   aws.String(code),
+	})
+	if err == nil {
+		return aws.Float64Value(out.Quota.Value), out.Quota.Value != nil, nil
+	}
+	if !isNoSuchResource(err) {
+		return 0, false, fmt.Errorf("getting service quota %s, %w", code, err)
+	}
+	defaultOut, err := p.servicequotasapi.GetAWSDefaultServiceQuotaWithContext(ctx, &servicequotas.GetAWSDefaultServiceQuotaInput{
+		ServiceCode: aws.String(serviceCode),
+		QuotaCode:   aws.String(code),
+	})
+	if err != nil {
+		if isNoSuchResource(err) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("getting default service quota %s, %w", code, err)
+	}
+	return aws.Float64Value(defaultOut.Quota.Value), defaultOut.Quota.Value != nil, nil
+}
+
+func (p *Provider) getUsage(ctx context.Context) (map[bucket]int64, error) {
+	usage := map[bucket]int64{}
+	if err := p.ec2api.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: aws.StringSlice([]string{v1alpha5.ProvisionerNameLabelKey}),
+			},
+			{
+				Name:   aws.String(fmt.Sprintf("tag:kubernetes.io/cluster/%s", settings.FromContext(ctx).ClusterName)),
+				Values: aws.StringSlice([]string{"*"}),
+			},
+			{
+				// Stopped instances don't count towards vCPU quotas
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning}),
+			},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if instance.CpuOptions == nil {
+					continue
+				}
+				capacityType := v1alpha5.CapacityTypeOnDemand
+				if aws.StringValue(instance.InstanceLifecycle) == ec2.InstanceLifecycleTypeSpot {
+					capacityType = v1alpha5.CapacityTypeSpot
+				}
+				usage[bucket{capacityType: capacityType, class: utils.InstanceFamilyClass(aws.StringValue(instance.InstanceType))}] +=
+					aws.Int64Value(instance.CpuOptions.CoreCount) * aws.Int64Value(instance.CpuOptions.ThreadsPerCore)
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing ec2 instances, %w", err)
+	}
+	return usage, nil
+}
+
+func isNoSuchResource(err error) bool {
+	var awsError awserr.Error
+	return errors.As(err, &awsError) && awsError.Code() == servicequotas.ErrCodeNoSuchResourceException
+}
@@ -0,0 +1,145 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+
+	awssettings "github.com/aws/karpenter/pkg/apis/settings"
+	"github.com/aws/karpenter/pkg/fake"
+	"github.com/aws/karpenter/pkg/providers/quota"
+	"github.com/aws/karpenter/pkg/test"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+)
+
+var ctx context.Context
+var fakeEC2API *fake.EC2API
+var fakeServiceQuotasAPI *fake.ServiceQuotasAPI
+var quotaProvider *quota.Provider
+
+func TestAWS(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Quota")
+}
+
+var _ = BeforeSuite(func() {
+	ctx = awssettings.ToContext(ctx, test.Settings())
+	fakeEC2API = &fake.EC2API{}
+	fakeServiceQuotasAPI = &fake.ServiceQuotasAPI{}
+	quotaProvider = quota.NewProvider(ctx, fakeServiceQuotasAPI, fakeEC2API, make(chan struct{}))
+})
+
+var _ = BeforeEach(func() {
+	fakeEC2API.Reset()
+	fakeServiceQuotasAPI.Reset()
+	quotaProvider.Reset()
+})
+
+var _ = Describe("Quota", func() {
+	It("should not report headroom when no quotas could be retrieved", func() {
+		Expect(quotaProvider.Update(ctx)).To(Succeed())
+		_, ok := quotaProvider.Headroom(v1alpha5.CapacityTypeOnDemand, "m5.large")
+		Expect(ok).To(BeFalse())
+	})
+	It("should use the applied quota of the instance family class", func() {
+		fakeServiceQuotasAPI.AppliedQuotas.Store("L-1216C47A", float64(64))
+		fakeServiceQuotasAPI.AppliedQuotas.Store("L-DB2E81BA", float64(8))
+		Expect(quotaProvider.Update(ctx)).To(Succeed())
+		headroom, ok := quotaProvider.Headroom(v1alpha5.CapacityTypeOnDemand, "m5.large")
+		Expect(ok).To(BeTrue())
+		Expect(headroom).To(BeNumerically("==", 64))
+		headroom, ok = quotaProvider.Headroom(v1alpha5.CapacityTypeOnDemand, "g4dn.xlarge")
+		Expect(ok).To(BeTrue())
+		Expect(headroom).To(BeNumerically("==", 8))
+		_, ok = quotaProvider.Headroom(v1alpha5.CapacityTypeSpot, "m5.large")
+		Expect(ok).To(BeFalse())
+	})
+	It("should fall back to the default quota when the quota hasn't been applied", func() {
+		fakeServiceQuotasAPI.DefaultQuotas.Store("L-34B43A08", float64(5))
+		Expect(quotaProvider.Update(ctx)).To(Succeed())
+		headroom, ok := quotaProvider.Headroom(v1alpha5.CapacityTypeSpot, "c5.large")
+		Expect(ok).To(BeTrue())
+		Expect(headroom).To(BeNumerically("==", 5))
+	})
+	It("should subtract the vCPUs of running instances", func() {
+		fakeServiceQuotasAPI.AppliedQuotas.Store("L-1216C47A", float64(64))
+		fakeServiceQuotasAPI.AppliedQuotas.Store("L-34B43A08", float64(64))
+		fakeEC2API.DescribeInstancesBehavior.Output.Set(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{
+			{
+				InstanceType: aws.String("m5.xlarge"),
+				CpuOptions:   &ec2.CpuOptions{CoreCount: aws.Int64(2), ThreadsPerCore: aws.Int64(2)},
+			},
+			{
+				InstanceType:      aws.String("c5.large"),
+				InstanceLifecycle: aws.String(ec2.InstanceLifecycleTypeSpot),
+				CpuOptions:        &ec2.CpuOptions{CoreCount: aws.Int64(1), ThreadsPerCore: aws.Int64(2)},
+			},
+		}}}})
+		Expect(quotaProvider.Update(ctx)).To(Succeed())
+		headroom, _ := quotaProvider.Headroom(v1alpha5.CapacityTypeOnDemand, "m5.large")
+		Expect(headroom).To(BeNumerically("==", 60))
+		headroom, _ = quotaProvider.Headroom(v1alpha5.CapacityTypeSpot, "m5.large")
+		Expect(headroom).To(BeNumerically("==", 62))
+	})
+	It("should subtract reserved vCPUs until the usage is refreshed", func() {
+		fakeServiceQuotasAPI.AppliedQuotas.Store("L-1216C47A", float64(64))
+		Expect(quotaProvider.Update(ctx)).To(Succeed())
+		quotaProvider.Reserve(v1alpha5.CapacityTypeOnDemand, "m5.4xlarge", 16)
+		headroom, _ := quotaProvider.Headroom(v1alpha5.CapacityTypeOnDemand, "r5.large")
+		Expect(headroom).To(BeNumerically("==", 48))
+	})
+	It("should report whether the vCPUs of an instance type exceed the headroom", func() {
+		fakeServiceQuotasAPI.AppliedQuotas.Store("L-1216C47A", float64(16))
+		Expect(quotaProvider.Update(ctx)).To(Succeed())
+		_, exceeded := quotaProvider.Exceeds(v1alpha5.CapacityTypeOnDemand, "m5.4xlarge", 16)
+		Expect(exceeded).To(BeFalse())
+		headroom, exceeded := quotaProvider.Exceeds(v1alpha5.CapacityTypeOnDemand, "m5.8xlarge", 32)
+		Expect(exceeded).To(BeTrue())
+		Expect(headroom).To(BeNumerically("==", 16))
+		_, exceeded = quotaProvider.Exceeds(v1alpha5.CapacityTypeSpot, "m5.8xlarge", 32)
+		Expect(exceeded).To(BeFalse())
+	})
+	It("should only increment the sequence number when the headroom crosses the vCPUs of a checked instance type", func() {
+		fakeServiceQuotasAPI.AppliedQuotas.Store("L-1216C47A", float64(64))
+		seqNum := quotaProvider.SeqNum
+		Expect(quotaProvider.Update(ctx)).To(Succeed())
+		Expect(quotaProvider.SeqNum).To(BeNumerically(">", seqNum))
+		quotaProvider.Exceeds(v1alpha5.CapacityTypeOnDemand, "m5.16xlarge", 64)
+
+		seqNum = quotaProvider.SeqNum
+		quotaProvider.Reserve(v1alpha5.CapacityTypeOnDemand, "m5.4xlarge", 16)
+		Expect(quotaProvider.SeqNum).To(BeNumerically(">", seqNum))
+		seqNum = quotaProvider.SeqNum
+		quotaProvider.Reserve(v1alpha5.CapacityTypeOnDemand, "m5.4xlarge", 16)
+		Expect(quotaProvider.SeqNum).To(Equal(seqNum))
+	})
+	It("should return an error when the quotas can't be retrieved", func() {
+		fakeServiceQuotasAPI.NextError.Set(fmt.Errorf("access denied"))
+		Expect(quotaProvider.Update(ctx)).ToNot(Succeed())
+		_, ok := quotaProvider.Headroom(v1alpha5.CapacityTypeOnDemand, "m5.large")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
 	"knative.dev/pkg/logging"
 
 	"github.com/aws/karpenter/pkg/apis/v1alpha1"
@@ -40,6 +42,15 @@ type Provider struct {
 	ec2api ec2iface.EC2API
 	cache  *cache.Cache
 	cm     *pretty.ChangeMonitor
+	// availableIPs tracks the AvailableIpAddressCount of each subnet as of its latest description by any selector, so
+	// that subnets cached for other selectors don't report counts that predate it (key: subnet id, value: count)
+	availableIPs map[string]int64
+	// inflightIPs tracks the IP addresses that are predicted to be consumed by launches that aren't yet reflected in the
+	// latest described AvailableIpAddressCount of the subnets (key: subnet id, value: reserved IP addresses)
+	inflightIPs map[string]int64
+	// exhausted tracks the subnets that recently failed launches for lack of free IP addresses, which are treated as
+	// having no available IP addresses until they expire (key: subnet id, value: struct{}{})
+	exhausted *cache.Cache
 }
 
 const TTL = 5 * time.Minute
@@ -50,7 +61,10 @@ func NewProvider(ec2api ec2iface.EC2API) *Provider {
 		cm:     pretty.NewChangeMonitor(),
 		// TODO: Remove cache for v1beta1, utilize resolved subnet from the AWSNodeTemplate.status
 		// Subnets are sorted on AvailableIpAddressCount, descending order
-		cache: cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval),
+		cache:        cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval),
+		availableIPs: map[string]int64{},
+		inflightIPs:  map[string]int64{},
+		exhausted:    cache.New(awscache.DefaultTTL, awscache.DefaultCleanupInterval),
 	}
 }
 
@@ -70,6 +84,11 @@ func (p *Provider) List(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTempl
 		return nil, fmt.Errorf("describing subnets %s, %w", pretty.Concise(filters), err)
 	}
 	p.cache.SetDefault(fmt.Sprint(hash), output.Subnets)
+	// Freshly described subnets already account for the IPs consumed by previous launches
+	for _, subnet := range output.Subnets {
+		p.availableIPs[aws.StringValue(subnet.SubnetId)] = aws.Int64Value(subnet.AvailableIpAddressCount)
+		delete(p.inflightIPs, aws.StringValue(subnet.SubnetId))
+	}
 	subnetLog := Pretty(output.Subnets)
 	if p.cm.HasChanged("subnets", subnetLog) {
 		logging.FromContext(ctx).With("subnets", subnetLog).Debugf("discovered subnets")
@@ -77,6 +96,57 @@ func (p *Provider) List(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTempl
 	return output.Subnets, nil
 }
 
+// ZonalSubnets returns the subnets matched by the node template grouped by zone. The subnets in each zone are sorted by
+// their available IP addresses, less the IPs reserved by in-flight launches, in descending order.
+func (p *Provider) ZonalSubnets(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate) (map[string][]*ec2.Subnet, error) {
+	subnets, err := p.List(ctx, nodeTemplate)
+	if err != nil {
+		return nil, err
+	}
+	zonalSubnets := map[string][]*ec2.Subnet{}
+	for _, subnet := range subnets {
+		zonalSubnets[aws.StringValue(subnet.AvailabilityZone)] = append(zonalSubnets[aws.StringValue(subnet.AvailabilityZone)], subnet)
+	}
+	for _, subnets := range zonalSubnets {
+		sort.SliceStable(subnets, func(i, j int) bool {
+			return p.AvailableIPAddressCount(subnets[i]) > p.AvailableIPAddressCount(subnets[j])
+		})
+	}
+	return zonalSubnets, nil
+}
+
+// AvailableIPAddressCount returns the number of IP addresses in the subnet that aren't in use or reserved by in-flight
+// launches, as of the latest description of the subnet by any selector. Subnets that are marked exhausted have no
+// available IP addresses.
+func (p *Provider) AvailableIPAddressCount(subnet *ec2.Subnet) int64 {
+	p.Lock()
+	defer p.Unlock()
+	subnetID := aws.StringValue(subnet.SubnetId)
+	if _, exhausted := p.exhausted.Get(subnetID); exhausted {
+		return 0
+	}
+	available, ok := p.availableIPs[subnetID]
+	if !ok {
+		available = aws.Int64Value(subnet.AvailableIpAddressCount)
+	}
+	return lo.Max([]int64{available - p.inflightIPs[subnetID], 0})
+}
+
+// ReserveIPs records IP addresses that are predicted to be consumed by a launch into the subnet. Reservations are
+// released once the subnet is described again, since its AvailableIpAddressCount then reflects the launch.
+func (p *Provider) ReserveIPs(subnetID string, count int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.inflightIPs[subnetID] += count
+}
+
+// MarkExhausted records that a launch into the subnet failed for lack of free IP addresses, so that the subnet isn't
+// launched into for the ttl even if its last described AvailableIpAddressCount suggests otherwise
+func (p *Provider) MarkExhausted(ctx context.Context, subnetID string, ttl time.Duration) {
+	logging.FromContext(ctx).With("subnet", subnetID, "ttl", ttl).Debugf("excluding subnet without free IP addresses")
+	p.exhausted.Set(subnetID, struct{}{}, ttl)
+}
+
 func (p *Provider) LivenessProbe(req *http.Request) error {
 	p.Lock()
 	//nolint: staticcheck
@@ -117,5 +187,10 @@ func Pretty(subnets []*ec2.Subnet) []string {
 }
 
 func (p *Provider) Reset() {
+	p.Lock()
+	defer p.Unlock()
 	p.cache.Flush()
+	p.availableIPs = map[string]int64{}
+	p.inflightIPs = map[string]int64{}
+	p.exhausted.Flush()
 }
@@ -17,10 +17,13 @@ package subnet_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/samber/lo"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
@@ -183,4 +186,68 @@ var _ = Describe("Security Group Provider", func() {
 			"subnet-test2 (test-zone-1b)",
 		))
 	})
+	Context("Available IP Addresses", func() {
+		BeforeEach(func() {
+			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("subnet-test1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(10)},
+				{SubnetId: aws.String("subnet-test2"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(100)},
+				{SubnetId: aws.String("subnet-test3"), AvailabilityZone: aws.String("test-zone-1b"), AvailableIpAddressCount: aws.Int64(50)},
+			}})
+		})
+		It("should group subnets by zone ordered by available IP addresses", func() {
+			zonalSubnets, err := subnetProvider.ZonalSubnets(ctx, nodeTemplate)
+			Expect(err).To(BeNil())
+			Expect(zonalSubnets).To(HaveLen(2))
+			Expect(lo.Map(zonalSubnets["test-zone-1a"], func(s *ec2.Subnet, _ int) string { return *s.SubnetId })).To(Equal([]string{"subnet-test2", "subnet-test1"}))
+			Expect(lo.Map(zonalSubnets["test-zone-1b"], func(s *ec2.Subnet, _ int) string { return *s.SubnetId })).To(Equal([]string{"subnet-test3"}))
+		})
+		It("should subtract reserved IP addresses from the available count", func() {
+			zonalSubnets, err := subnetProvider.ZonalSubnets(ctx, nodeTemplate)
+			Expect(err).To(BeNil())
+			subnet1 := zonalSubnets["test-zone-1a"][1]
+			subnetProvider.ReserveIPs("subnet-test1", 4)
+			Expect(subnetProvider.AvailableIPAddressCount(subnet1)).To(BeNumerically("==", 6))
+			subnetProvider.ReserveIPs("subnet-test1", 20)
+			Expect(subnetProvider.AvailableIPAddressCount(subnet1)).To(BeNumerically("==", 0))
+		})
+		It("should order subnets by available IP addresses net of reservations", func() {
+			subnetProvider.ReserveIPs("subnet-test2", 95)
+			zonalSubnets, err := subnetProvider.ZonalSubnets(ctx, nodeTemplate)
+			Expect(err).To(BeNil())
+			Expect(lo.Map(zonalSubnets["test-zone-1a"], func(s *ec2.Subnet, _ int) string { return *s.SubnetId })).To(Equal([]string{"subnet-test1", "subnet-test2"}))
+		})
+		It("should treat exhausted subnets as having no available IP addresses until they expire", func() {
+			zonalSubnets, err := subnetProvider.ZonalSubnets(ctx, nodeTemplate)
+			Expect(err).To(BeNil())
+			subnet1 := zonalSubnets["test-zone-1a"][1]
+			subnetProvider.MarkExhausted(ctx, "subnet-test1", 100*time.Millisecond)
+			Expect(subnetProvider.AvailableIPAddressCount(subnet1)).To(BeNumerically("==", 0))
+			Eventually(func() int64 { return subnetProvider.AvailableIPAddressCount(subnet1) }).Should(BeNumerically("==", 10))
+		})
+		It("should clear reservations when subnets are refreshed", func() {
+			_, err := subnetProvider.List(ctx, nodeTemplate)
+			Expect(err).To(BeNil())
+			subnetProvider.ReserveIPs("subnet-test1", 4)
+			nodeTemplate.Spec.SubnetSelector = map[string]string{"aws-ids": "subnet-test1"}
+			subnets, err := subnetProvider.List(ctx, nodeTemplate)
+			Expect(err).To(BeNil())
+			Expect(subnetProvider.AvailableIPAddressCount(subnets[0])).To(BeNumerically("==", 10))
+		})
+		It("should report the latest described count of subnets cached for other selectors", func() {
+			cached, err := subnetProvider.List(ctx, nodeTemplate)
+			Expect(err).To(BeNil())
+			subnet1, ok := lo.Find(cached, func(s *ec2.Subnet) bool { return aws.StringValue(s.SubnetId) == "subnet-test1" })
+			Expect(ok).To(BeTrue())
+			subnetProvider.ReserveIPs("subnet-test1", 4)
+
+			// Another selector describes the subnet after the launch consumed its IPs
+			fakeEC2API.DescribeSubnetsOutput.Set(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("subnet-test1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(6)},
+			}})
+			nodeTemplate.Spec.SubnetSelector = map[string]string{"aws-ids": "subnet-test1"}
+			_, err = subnetProvider.List(ctx, nodeTemplate)
+			Expect(err).To(BeNil())
+			Expect(subnetProvider.AvailableIPAddressCount(subnet1)).To(BeNumerically("==", 6))
+		})
+	})
 })
@@ -16,24 +16,51 @@ package test
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/imdario/mergo"
 	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	awssettings "github.com/aws/karpenter/pkg/apis/settings"
 )
 
 type SettingOptions struct {
-	ClusterName                *string
-	ClusterEndpoint            *string
-	DefaultInstanceProfile     *string
-	EnablePodENI               *bool
-	EnableENILimitedPodDensity *bool
-	IsolatedVPC                *bool
-	NodeNameConvention         *awssettings.NodeNameConvention
-	VMMemoryOverheadPercent    *float64
-	InterruptionQueueName      *string
-	Tags                       map[string]string
+	ClusterName                   *string
+	ClusterEndpoint               *string
+	DefaultInstanceProfile        *string
+	EnablePodENI                  *bool
+	EnableENILimitedPodDensity    *bool
+	EnablePrefixDelegation        *bool
+	EnableCustomNetworking        *bool
+	IsolatedVPC                   *bool
+	NodeNameConvention            *awssettings.NodeNameConvention
+	VMMemoryOverheadPercent       *float64
+	InterruptionQueueName         *string
+	Tags                          map[string]string
+	MaxInstanceTypes              *int
+	PersistUnavailableOfferings   *bool
+	MinSpotPlacementScore         *int64
+	SpotInterruptionRateThreshold *float64
+	GarbageCollectionDryRun       *bool
+	GarbageCollectionGracePeriod  *time.Duration
+	EnableLaunchTemplateVersions  *bool
+	PricingFile                   *string
+	PricingUpdatePeriod           *time.Duration
+	SpotPricingUpdatePeriod       *time.Duration
+	SpotPriceHistoryWindow        *time.Duration
+	SpotPriceStatistic            *awssettings.SpotPriceStatistic
+	PricingStalenessThreshold     *time.Duration
+	PersistInstanceTypes          *bool
+	AllowedInstanceFamilies       []string
+	DeniedInstanceFamilies        []string
+	AllowedInstanceCategories     []string
+	DeniedInstanceCategories      []string
+	AllowedInstanceGenerations    []string
+	DeniedInstanceGenerations     []string
+	ExcludePreviousGeneration     *bool
+	ExoticInstanceTypes           *awssettings.ExoticInstanceTypes
+	LearnVMMemoryOverhead         *bool
 }
 
 func Settings(overrides ...SettingOptions) *awssettings.Settings {
@@ -44,15 +71,40 @@ func Settings(overrides ...SettingOptions) *awssettings.Settings {
 		}
 	}
 	return &awssettings.Settings{
-		ClusterName:                lo.FromPtrOr(options.ClusterName, "test-cluster"),
-		ClusterEndpoint:            lo.FromPtrOr(options.ClusterEndpoint, "https://test-cluster"),
-		DefaultInstanceProfile:     lo.FromPtrOr(options.DefaultInstanceProfile, "test-instance-profile"),
-		EnablePodENI:               lo.FromPtrOr(options.EnablePodENI, true),
-		EnableENILimitedPodDensity: lo.FromPtrOr(options.EnableENILimitedPodDensity, true),
-		IsolatedVPC:                lo.FromPtrOr(options.IsolatedVPC, false),
-		NodeNameConvention:         lo.FromPtrOr(options.NodeNameConvention, awssettings.IPName),
-		VMMemoryOverheadPercent:    lo.FromPtrOr(options.VMMemoryOverheadPercent, 0.075),
-		InterruptionQueueName:      lo.FromPtrOr(options.InterruptionQueueName, ""),
-		Tags:                       options.Tags,
+		ClusterName:                   lo.FromPtrOr(options.ClusterName, "test-cluster"),
+		ClusterEndpoint:               lo.FromPtrOr(options.ClusterEndpoint, "https://test-cluster"),
+		DefaultInstanceProfile:        lo.FromPtrOr(options.DefaultInstanceProfile, "test-instance-profile"),
+		EnablePodENI:                  lo.FromPtrOr(options.EnablePodENI, true),
+		EnableENILimitedPodDensity:    lo.FromPtrOr(options.EnableENILimitedPodDensity, true),
+		EnablePrefixDelegation:        lo.FromPtrOr(options.EnablePrefixDelegation, false),
+		EnableCustomNetworking:        lo.FromPtrOr(options.EnableCustomNetworking, false),
+		IsolatedVPC:                   lo.FromPtrOr(options.IsolatedVPC, false),
+		NodeNameConvention:            lo.FromPtrOr(options.NodeNameConvention, awssettings.IPName),
+		VMMemoryOverheadPercent:       lo.FromPtrOr(options.VMMemoryOverheadPercent, 0.075),
+		InterruptionQueueName:         lo.FromPtrOr(options.InterruptionQueueName, ""),
+		Tags:                          options.Tags,
+		MaxInstanceTypes:              lo.FromPtrOr(options.MaxInstanceTypes, 60),
+		PersistUnavailableOfferings:   lo.FromPtrOr(options.PersistUnavailableOfferings, false),
+		MinSpotPlacementScore:         lo.FromPtrOr(options.MinSpotPlacementScore, 0),
+		SpotInterruptionRateThreshold: lo.FromPtrOr(options.SpotInterruptionRateThreshold, 0),
+		GarbageCollectionDryRun:       lo.FromPtrOr(options.GarbageCollectionDryRun, true),
+		GarbageCollectionGracePeriod:  metav1.Duration{Duration: lo.FromPtrOr(options.GarbageCollectionGracePeriod, 5*time.Minute)},
+		EnableLaunchTemplateVersions:  lo.FromPtrOr(options.EnableLaunchTemplateVersions, false),
+		PricingFile:                   lo.FromPtrOr(options.PricingFile, ""),
+		PricingUpdatePeriod:           metav1.Duration{Duration: lo.FromPtrOr(options.PricingUpdatePeriod, 12*time.Hour)},
+		SpotPricingUpdatePeriod:       metav1.Duration{Duration: lo.FromPtrOr(options.SpotPricingUpdatePeriod, time.Hour)},
+		SpotPriceHistoryWindow:        metav1.Duration{Duration: lo.FromPtrOr(options.SpotPriceHistoryWindow, 0)},
+		SpotPriceStatistic:            lo.FromPtrOr(options.SpotPriceStatistic, awssettings.SpotPriceLatest),
+		PricingStalenessThreshold:     metav1.Duration{Duration: lo.FromPtrOr(options.PricingStalenessThreshold, 0)},
+		PersistInstanceTypes:          lo.FromPtrOr(options.PersistInstanceTypes, false),
+		AllowedInstanceFamilies:       options.AllowedInstanceFamilies,
+		DeniedInstanceFamilies:        options.DeniedInstanceFamilies,
+		AllowedInstanceCategories:     options.AllowedInstanceCategories,
+		DeniedInstanceCategories:      options.DeniedInstanceCategories,
+		AllowedInstanceGenerations:    options.AllowedInstanceGenerations,
+		DeniedInstanceGenerations:     options.DeniedInstanceGenerations,
+		ExcludePreviousGeneration:     lo.FromPtrOr(options.ExcludePreviousGeneration, false),
+		ExoticInstanceTypes:           lo.FromPtrOr(options.ExoticInstanceTypes, awssettings.ExoticInstanceTypesDeprioritize),
+		LearnVMMemoryOverhead:         lo.FromPtrOr(options.LearnVMMemoryOverhead, false),
 	}
 }
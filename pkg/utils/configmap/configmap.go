@@ -0,0 +1,44 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configmap
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/system"
+)
+
+// Persist creates the ConfigMap in the system namespace with the data, or replaces the data of the existing ConfigMap
+func Persist(ctx context.Context, kubernetesInterface kubernetes.Interface, name string, data map[string]string, binaryData map[string][]byte) error {
+	configMaps := kubernetesInterface.CoreV1().ConfigMaps(system.Namespace())
+	cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		_, err = configMaps.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: system.Namespace()},
+			Data:       data,
+			BinaryData: binaryData,
+		}, metav1.CreateOptions{})
+	case err == nil:
+		cm.Data = data
+		cm.BinaryData = binaryData
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderelection
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// UnelectedManager registers runnables that run on every replica rather than only on the leader
+type UnelectedManager struct {
+	manager.Manager
+}
+
+func (m UnelectedManager) Add(r manager.Runnable) error {
+	return m.Manager.Add(unelectedRunnable{Runnable: r})
+}
+
+type unelectedRunnable struct {
+	manager.Runnable
+}
+
+func (unelectedRunnable) NeedLeaderElection() bool {
+	return false
+}
+
+// IsLeader returns whether the replica has been elected, given the channel that's closed once it's elected
+func IsLeader(elected <-chan struct{}) bool {
+	select {
+	case <-elected:
+		return true
+	default:
+		return false
+	}
+}
@@ -15,8 +15,10 @@ limitations under the License.
 package utils
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"time"
 )
 
 var (
@@ -37,3 +39,39 @@ func ParseInstanceID(providerID string) (string, error) {
 	}
 	return "", fmt.Errorf("parsing instance id %s", providerID)
 }
+
+var (
+	instanceFamilyPrefixRegex = regexp.MustCompile(`^[a-z]+`)
+	// standardInstanceFamilyPrefixes share the vCPU quota of the "standard" instance family class
+	standardInstanceFamilyPrefixes = map[string]struct{}{
+		"a": {}, "c": {}, "d": {}, "h": {}, "i": {}, "im": {}, "is": {}, "m": {}, "r": {}, "t": {}, "z": {},
+	}
+)
+
+// InstanceFamilyClass returns the class of instance families that share a vCPU quota with the instance type.
+// Instance families that don't belong to the "standard" class are identified by their prefix, e.g. "g" for G and VT.
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-resource-limits.html
+func InstanceFamilyClass(instanceType string) string {
+	prefix := instanceFamilyPrefixRegex.FindString(instanceType)
+	if _, ok := standardInstanceFamilyPrefixes[prefix]; ok {
+		return "standard"
+	}
+	if prefix == "vt" {
+		return "g"
+	}
+	return prefix
+}
+
+// WithoutCancel returns a context with the values of the parent that isn't canceled with it, so that background work
+// started by a reconciliation outlives it. It stands in for context.WithoutCancel until go.mod reaches go 1.21.
+func WithoutCancel(parent context.Context) context.Context {
+	return uncanceledContext{Context: parent}
+}
+
+type uncanceledContext struct {
+	context.Context
+}
+
+func (uncanceledContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (uncanceledContext) Done() <-chan struct{}       { return nil }
+func (uncanceledContext) Err() error                  { return nil }
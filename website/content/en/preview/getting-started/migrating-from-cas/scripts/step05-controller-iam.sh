@@ -31,16 +31,26 @@ echo '{
                 "ec2:DescribeSubnets",
                 "ec2:DescribeSecurityGroups",
                 "ec2:DescribeLaunchTemplates",
+                "ec2:DescribeLaunchTemplateVersions",
                 "ec2:DescribeInstances",
                 "ec2:DescribeInstanceTypes",
                 "ec2:DescribeInstanceTypeOfferings",
                 "ec2:DescribeAvailabilityZones",
+                "ec2:DescribeTags",
                 "ec2:DeleteLaunchTemplate",
+                "ec2:DeleteLaunchTemplateVersions",
                 "ec2:CreateTags",
+                "ec2:DeleteTags",
+                "ec2:StartInstances",
+                "ec2:StopInstances",
                 "ec2:CreateLaunchTemplate",
+                "ec2:CreateLaunchTemplateVersion",
                 "ec2:CreateFleet",
                 "ec2:DescribeSpotPriceHistory",
-                "pricing:GetProducts"
+                "ec2:GetSpotPlacementScores",
+                "pricing:GetProducts",
+                "servicequotas:GetServiceQuota",
+                "servicequotas:GetAWSDefaultServiceQuota"
             ],
             "Effect": "Allow",
             "Resource": "*",